@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"go.uber.org/zap"
+
+	"gigaview/internal/cache"
+	"gigaview/internal/config"
+	"gigaview/internal/image_list"
+	"gigaview/internal/library"
+	"gigaview/internal/logger"
+	"gigaview/internal/render"
+)
+
+// app is what every command shares: the configuration, libvips and the
+// scanner, cache and renderer on top of it
+type app struct {
+	cfg       *config.Config
+	log       *zap.Logger
+	logLevel  zap.AtomicLevel
+	scanner   *image_list.Scanner
+	tileCache cache.Cache
+	renderer  render.TileRenderer
+
+	// closers run in reverse order on close
+	closers []func()
+}
+
+// newBaseApp only loads the configuration and sets up logging, for commands
+// that don't touch images, exiting on errors
+func newBaseApp(configFile string, override func(*config.Config)) *app {
+	cfg, err := loadConfig(configFile, override)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	logLevel := zap.NewAtomicLevelAt(logger.ParseLevel(cfg.LogLevel))
+	log, err := logger.New(logger.Options{
+		Level:  logLevel,
+		Format: cfg.LogFormat,
+		Output: cfg.LogOutput,
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to initialize logger: %v\n", err)
+		os.Exit(2)
+	}
+	a := &app{cfg: cfg, log: log, logLevel: logLevel}
+	a.closers = append(a.closers, func() { log.Sync() })
+	return a
+}
+
+// newApp loads the configuration and sets everything up, exiting on
+// errors. override, if set, adjusts the configuration for one command
+// before it is validated.
+func newApp(configFile string, override func(*config.Config)) *app {
+	a := newBaseApp(configFile, override)
+	lib, err := library.Open(a.cfg, a.log)
+	if err != nil {
+		a.log.Fatal("Failed to set up the library", zap.Error(err))
+	}
+	a.closers = append(a.closers, lib.Close)
+	a.scanner, a.tileCache, a.renderer = lib.Scanner, lib.Cache, lib.Renderer
+	return a
+}
+
+// openCache sets up the configured tile cache alone, exiting on errors
+func (a *app) openCache() {
+	tileCache, err := library.OpenCache(a.cfg, a.log)
+	if err != nil {
+		a.log.Fatal("Failed to initialize cache", zap.Error(err))
+	}
+	if closer, ok := tileCache.(io.Closer); ok {
+		a.closers = append(a.closers, func() { closer.Close() })
+	}
+	a.tileCache = tileCache
+}
+
+// close releases what newApp set up, libvips and the logger last
+func (a *app) close() {
+	for i := len(a.closers) - 1; i >= 0; i-- {
+		a.closers[i]()
+	}
+}
+
+// loadConfig reads the configuration from the environment and, if path is
+// set, the config file, applies override and validates it
+func loadConfig(path string, override func(*config.Config)) (*config.Config, error) {
+	var cfg *config.Config
+	if path == "" {
+		cfg = config.Load()
+	} else {
+		var err error
+		if cfg, err = config.LoadFile(path); err != nil {
+			return nil, err
+		}
+	}
+	if override != nil {
+		override(cfg)
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid configuration:\n%w", err)
+	}
+	return cfg, nil
+}
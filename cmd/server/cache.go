@@ -0,0 +1,83 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gigaview/internal/cache"
+)
+
+// runCache dispatches the cache maintenance commands
+func runCache(args []string) {
+	if len(args) == 0 || args[0] != "purge" {
+		fmt.Fprintln(os.Stderr, "usage: gigaview cache purge [--image ID] [--older-than AGE]")
+		os.Exit(2)
+	}
+	runCachePurge(args[1:])
+}
+
+// runCachePurge deletes tiles from the configured cache backend directly,
+// without a running server: all of them, those of one image or those
+// written before a given age
+func runCachePurge(args []string) {
+	flags := flag.NewFlagSet("cache purge", flag.ExitOnError)
+	configFile := flags.String("config", "", "YAML config file (environment variables take precedence)")
+	imageID := flags.String("image", "", "only delete the tiles of this image, including comparisons")
+	olderThan := flags.String("older-than", "", "only delete tiles written longer ago, e.g. 30d or 12h")
+	flags.Parse(args)
+
+	if *imageID != "" && *olderThan != "" {
+		fmt.Fprintln(os.Stderr, "cache purge takes either --image or --older-than")
+		os.Exit(2)
+	}
+	var age time.Duration
+	if *olderThan != "" {
+		var err error
+		if age, err = parseAge(*olderThan); err != nil {
+			fmt.Fprintf(os.Stderr, "--older-than: %v\n", err)
+			os.Exit(2)
+		}
+	}
+
+	a := newBaseApp(*configFile, nil)
+	defer a.close()
+	// Tiles in memory are gone with the process that holds them
+	if a.cfg.CacheType == "memory" || a.cfg.CacheType == "disabled" {
+		fmt.Fprintf(os.Stderr, "the %s cache has no tiles outside the server\n", a.cfg.CacheType)
+		a.close()
+		os.Exit(2)
+	}
+	a.openCache()
+
+	switch {
+	case *imageID != "":
+		a.tileCache.DeleteImage(*imageID)
+		fmt.Printf("deleted the tiles of %s\n", *imageID)
+	case age > 0:
+		removed, _ := cache.RemoveOlder(a.tileCache, time.Now().Add(-age))
+		fmt.Printf("deleted %d tiles older than %s\n", removed, *olderThan)
+	default:
+		a.tileCache.Clear()
+		fmt.Println("deleted all tiles")
+	}
+}
+
+// parseAge parses a duration, also accepting whole days ("30d")
+func parseAge(value string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid age %q", value)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	age, err := time.ParseDuration(value)
+	if err != nil || age < 0 {
+		return 0, fmt.Errorf("invalid age %q", value)
+	}
+	return age, nil
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"gigaview/internal/cache"
+	"gigaview/internal/image_list"
+)
+
+// runConvert rewrites images without a pyramid of their own as pyramidal
+// TIFFs, so existing libraries render as fast as uploads of such TIFFs
+func runConvert(args []string) {
+	flags := flag.NewFlagSet("convert", flag.ExitOnError)
+	configFile := flags.String("config", "", "YAML config file (environment variables take precedence)")
+	all := flags.Bool("all", false, "convert every image that needs it")
+	imageID := flags.String("image", "", "convert one image")
+	minSize := flags.Int("min-size", 4096, "skip images whose longest side is at most this many pixels")
+	quality := flags.Int("quality", 90, "JPEG quality of the tiles")
+	lossless := flags.Bool("lossless", false, "compress tiles with deflate instead of JPEG")
+	discard := flags.Bool("discard-originals", false, "delete the sources instead of keeping them under raw/")
+	dryRun := flags.Bool("dry-run", false, "only list the images that would be converted")
+	flags.Parse(args)
+
+	if *all == (*imageID != "") {
+		fmt.Fprintln(os.Stderr, "convert needs either --all or --image")
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	a := newApp(*configFile, nil)
+	defer a.close()
+	if a.cfg.PreserveFilenames {
+		fmt.Fprintln(os.Stderr, "convert renames files, which PRESERVE_FILENAMES rules out")
+		a.close()
+		os.Exit(2)
+	}
+	if a.cfg.ReadOnly && !*dryRun {
+		fmt.Fprintln(os.Stderr, "convert rewrites files, which READ_ONLY rules out")
+		a.close()
+		os.Exit(2)
+	}
+
+	if err := a.scanner.Scan(); err != nil {
+		fmt.Fprintf(os.Stderr, "scan failed: %v\n", err)
+		a.close()
+		os.Exit(1)
+	}
+
+	options := image_list.ConvertOptions{
+		MinDimension:    *minSize,
+		Quality:         *quality,
+		Lossless:        *lossless,
+		DiscardOriginal: *discard,
+	}
+	var images []image_list.ImageInfo
+	if *imageID != "" {
+		img := a.scanner.GetImageByID(*imageID)
+		if img == nil {
+			fmt.Fprintf(os.Stderr, "unknown image %s\n", *imageID)
+			a.close()
+			os.Exit(1)
+		}
+		// An explicitly named image is converted whatever its size
+		options.MinDimension = 0
+		images = append(images, *img)
+	} else {
+		images = a.scanner.GetImages()
+	}
+
+	converted, failed := 0, 0
+	for _, img := range images {
+		if !a.scanner.NeedsPyramid(&img, options) {
+			if *imageID != "" {
+				fmt.Fprintf(os.Stderr, "%s: %v\n", img.ID, image_list.ErrNotConvertible)
+				failed++
+			}
+			continue
+		}
+		if *dryRun {
+			fmt.Printf("%s\t%s\t%d×%d\n", img.ID, img.CurrentFilename, img.Width, img.Height)
+			converted++
+			continue
+		}
+		if err := a.scanner.ConvertToPyramid(img.ID, options); err != nil {
+			if !errors.Is(err, image_list.ErrNotConvertible) {
+				fmt.Printf("%s\t%s\t%v\n", img.ID, img.CurrentFilename, err)
+				failed++
+			}
+			continue
+		}
+		fmt.Printf("%s\t%s\tconverted\n", img.ID, img.CurrentFilename)
+		converted++
+	}
+
+	if converted > 0 && !*dryRun {
+		// Read the new files and drop the tiles rendered from the old ones
+		if err := a.scanner.Scan(); err != nil {
+			fmt.Fprintf(os.Stderr, "scan failed: %v\n", err)
+			failed++
+		} else {
+			cache.RemoveOrphans(a.tileCache, a.renderer.IsCurrent)
+		}
+	}
+
+	verb := "converted"
+	if *dryRun {
+		verb = "to convert"
+	}
+	fmt.Printf("%d images %s, %d failed\n", converted, verb, failed)
+	if failed > 0 {
+		a.close()
+		os.Exit(1)
+	}
+}
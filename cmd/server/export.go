@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gigaview/internal/image_renderer"
+	"gigaview/internal/render"
+)
+
+// runExport writes a static pyramid of one image plus its meta.json, so it
+// can be hosted on S3 or a CDN with no server at all
+func runExport(args []string) {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	configFile := flags.String("config", "", "YAML config file (environment variables take precedence)")
+	imageID := flags.String("image", "", "image ID (required)")
+	out := flags.String("out", "", "output directory (required)")
+	layouts := flags.String("layout", image_renderer.ExportXYZ, "comma-separated layouts: xyz, dzi")
+	format := flags.String("format", render.DefaultTileOptions.Format, "tile format: jpeg, webp or png")
+	flags.Parse(args)
+
+	if *imageID == "" || *out == "" {
+		fmt.Fprintln(os.Stderr, "export needs --image and --out")
+		flags.Usage()
+		os.Exit(2)
+	}
+	if *format == "jpg" {
+		*format = "jpeg"
+	}
+
+	a := newApp(*configFile, nil)
+	defer a.close()
+
+	// Exporting from the index is enough, scan if there is none
+	if a.cfg.IndexFile == "" {
+		if err := a.scanner.Scan(); err != nil {
+			fmt.Fprintf(os.Stderr, "scan failed: %v\n", err)
+			a.close()
+			os.Exit(1)
+		}
+	}
+
+	meta, err := a.renderer.GetImageMeta(*imageID, 0)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		a.close()
+		os.Exit(1)
+	}
+	extension := map[string]string{"jpeg": "jpg", "webp": "webp", "png": "png"}[*format]
+	exported := map[string]string{}
+	for _, layout := range strings.Split(*layouts, ",") {
+		layout = strings.TrimSpace(layout)
+		if err := a.renderer.ExportPyramid(context.Background(), *imageID, *out, layout, *format); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			a.close()
+			os.Exit(1)
+		}
+		switch layout {
+		case image_renderer.ExportXYZ:
+			exported[layout] = "tiles/{z}/{y}/{x}." + extension
+		case image_renderer.ExportDZI:
+			exported[layout] = "image.dzi"
+		}
+	}
+
+	// meta.json is the image metadata the server would answer with, plus
+	// where the tiles are relative to it
+	meta["format"] = *format
+	// Static tiles stop at the full resolution
+	meta["maxOverzoom"] = meta["maxZoom"]
+	meta["layouts"] = exported
+	data, err := json.MarshalIndent(meta, "", "  ")
+	if err == nil {
+		err = os.WriteFile(filepath.Join(*out, "meta.json"), data, 0644)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to write meta.json: %v\n", err)
+		a.close()
+		os.Exit(1)
+	}
+	fmt.Printf("exported %s to %s\n", *imageID, *out)
+}
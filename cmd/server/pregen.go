@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"runtime"
+	"syscall"
+
+	"gigaview/internal/config"
+	"gigaview/internal/image_list"
+	"gigaview/internal/image_renderer"
+	"gigaview/internal/library"
+	"gigaview/internal/render"
+)
+
+// runPregen scans DATA_DIR and renders the first zoom levels of all or one
+// image into the configured cache, or into a directory in the file cache
+// layout, so a library is warm before it goes live
+func runPregen(args []string) {
+	flags := flag.NewFlagSet("pregen", flag.ExitOnError)
+	configFile := flags.String("config", "", "YAML config file (environment variables take precedence)")
+	imageID := flags.String("image", "all", `image ID, or "all"`)
+	levels := flags.Int("levels", 0, "zoom levels to render (default WARMUP_LEVELS)")
+	zoomRange := flags.String("zoom", "", `zoom range to render, e.g. "0-3" or "deepest-2..deepest" (default WARMUP_ZOOM)`)
+	format := flags.String("format", render.DefaultTileOptions.Format, "tile format: jpeg, webp or png")
+	workers := flags.Int("workers", runtime.NumCPU(), "tiles rendered at once")
+	out := flags.String("out", "", "write tiles to this directory instead of the configured cache")
+	flags.Parse(args)
+
+	if *format == "jpg" {
+		*format = "jpeg"
+	}
+	if *format != "jpeg" && *format != "webp" && *format != "png" {
+		fmt.Fprintf(os.Stderr, "unknown format %q (supported: jpeg, webp, png)\n", *format)
+		os.Exit(2)
+	}
+
+	a := newApp(*configFile, func(cfg *config.Config) {
+		if *out != "" {
+			cfg.CacheType = "file"
+			cfg.CacheFileDir = *out
+			cfg.CacheFileMaxMB = 0
+			cfg.CacheTTL = 0
+		}
+	})
+	defer a.close()
+
+	switch a.cfg.CacheType {
+	case "memory", "disabled":
+		fmt.Fprintf(os.Stderr, "CACHE=%s keeps nothing after pregen exits, use --out or a file, tiered, bolt or s3 cache\n", a.cfg.CacheType)
+		a.close()
+		os.Exit(2)
+	}
+	var zoom image_renderer.WarmupZoom
+	var err error
+	switch {
+	case *zoomRange != "":
+		zoom, err = image_renderer.ParseWarmupZoom(*zoomRange)
+	case *levels > 0:
+		zoom = image_renderer.WarmupLevels(*levels)
+	default:
+		zoom, err = library.WarmupZoom(a.cfg)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		a.close()
+		os.Exit(2)
+	}
+
+	if err := a.scanner.Scan(); err != nil {
+		fmt.Fprintf(os.Stderr, "scan failed: %v\n", err)
+		a.close()
+		os.Exit(1)
+	}
+
+	images := a.scanner.GetImages()
+	if *imageID != "all" {
+		img := a.scanner.GetImageByID(*imageID)
+		if img == nil {
+			fmt.Fprintf(os.Stderr, "image %s not found\n", *imageID)
+			a.close()
+			os.Exit(1)
+		}
+		images = []image_list.ImageInfo{*img}
+	}
+
+	opts := render.DefaultTileOptions
+	opts.Format = *format
+	warmer := image_renderer.NewWarmer(a.renderer, image_renderer.WarmupOptions{
+		Zoom:        zoom,
+		Workers:     *workers,
+		TileOptions: opts,
+	}, a.log)
+	// Interrupting stops the renders cleanly; cached tiles are kept
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	failed := warmer.Run(ctx, images)
+	interrupted := ctx.Err() != nil
+	stop()
+	if interrupted {
+		fmt.Fprintln(os.Stderr, "interrupted")
+		a.close()
+		os.Exit(1)
+	}
+	fmt.Printf("%d images, %d tiles failed\n", len(images), failed)
+	if failed > 0 {
+		a.close()
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+)
+
+// runScan scans DATA_DIR once, updating the index, sidecars and thumbnails
+// like the server does on startup, and prints a summary
+func runScan(args []string) {
+	flags := flag.NewFlagSet("scan", flag.ExitOnError)
+	configFile := flags.String("config", "", "YAML config file (environment variables take precedence)")
+	flags.Parse(args)
+
+	a := newApp(*configFile, nil)
+	defer a.close()
+
+	if err := a.scanner.Scan(); err != nil {
+		fmt.Fprintf(os.Stderr, "scan failed: %v\n", err)
+		a.close()
+		os.Exit(1)
+	}
+
+	status := a.scanner.ScanStatus()
+	fmt.Printf("%d images, %d files scanned, %d failed in %s\n",
+		status.Images, status.Processed, status.Failed, time.Duration(status.LastScanDurationMs)*time.Millisecond)
+	if status.Failed > 0 {
+		a.close()
+		os.Exit(1)
+	}
+}
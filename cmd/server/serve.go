@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"go.uber.org/zap"
+
+	"gigaview/pkg/gigaview"
+)
+
+// runServe starts the HTTP server and runs until SIGINT or SIGTERM
+func runServe(args []string) {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	configFile := flags.String("config", "", "YAML config file (environment variables take precedence)")
+	flags.Parse(args)
+
+	a := newBaseApp(*configFile, nil)
+	defer a.close()
+	cfg, log := a.cfg, a.log
+
+	log.Info("Starting Gigaview server",
+		zap.Int("port", cfg.Port),
+		zap.String("data_dir", cfg.DataDir),
+	)
+
+	handler, err := gigaview.New(cfg, gigaview.Options{Logger: log, LogLevel: &a.logLevel})
+	if err != nil {
+		log.Fatal("Failed to start", zap.Error(err))
+	}
+
+	server := &http.Server{
+		Addr:    fmt.Sprintf(":%d", cfg.Port),
+		Handler: handler,
+	}
+
+	go func() {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatal("Server failed", zap.Error(err))
+		}
+	}()
+
+	log.Info("Server started", zap.Int("port", cfg.Port))
+
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+	go func() {
+		for range reload {
+			next, err := loadConfig(*configFile, nil)
+			if err != nil {
+				log.Error("Failed to reload configuration, keeping the running one", zap.Error(err))
+				continue
+			}
+			changes := handler.Reload(next)
+			for _, change := range changes {
+				log.Info("Setting changed", zap.String("setting", change.Setting), zap.String("old", change.Old), zap.String("new", change.New))
+			}
+			log.Info("Configuration reloaded", zap.Int("changes", len(changes)))
+		}
+	}()
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	<-quit
+
+	log.Info("Shutting down server...")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := server.Shutdown(ctx); err != nil {
+		log.Error("Server forced to shutdown", zap.Error(err))
+	}
+	handler.Close()
+
+	log.Info("Server stopped")
+}
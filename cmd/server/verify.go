@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"gigaview/internal/cache"
+	"gigaview/internal/image_list"
+)
+
+// runVerify checks the data directory: that every image opens, its sidecar
+// matches the file and its ID, and that the tile cache only holds tiles of
+// current images. It prints one line per problem and a summary, and exits
+// with status 1 if problems are left.
+func runVerify(args []string) {
+	flags := flag.NewFlagSet("verify", flag.ExitOnError)
+	configFile := flags.String("config", "", "YAML config file (environment variables take precedence)")
+	repair := flags.Bool("repair", false, "fix sidecar dimensions and remove orphaned cache directories")
+	quarantine := flags.Bool("quarantine", false, "move images that can't be opened to the quarantine directory")
+	flags.Parse(args)
+
+	a := newApp(*configFile, nil)
+	defer a.close()
+	if a.cfg.ReadOnly && (*repair || *quarantine) {
+		fmt.Fprintln(os.Stderr, "--repair and --quarantine change the library, which READ_ONLY rules out")
+		a.close()
+		os.Exit(2)
+	}
+
+	// Without an index nothing is known until a scan
+	if a.cfg.IndexFile == "" {
+		if err := a.scanner.Scan(); err != nil {
+			fmt.Fprintf(os.Stderr, "scan failed: %v\n", err)
+			a.close()
+			os.Exit(1)
+		}
+	}
+
+	report, err := a.scanner.Verify(context.Background(), image_list.VerifyOptions{
+		Repair:     *repair,
+		Quarantine: *quarantine,
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "verify failed: %v\n", err)
+		a.close()
+		os.Exit(1)
+	}
+	for _, problem := range report.Problems {
+		fmt.Printf("%s\t%s\t%s\t%s\t%s\n", problem.Kind, problem.ImageID, problem.Path, problem.Detail, problem.Action)
+	}
+	unresolved := report.Unresolved()
+
+	orphans, ok := cache.Orphans(a.tileCache, a.renderer.IsCurrent)
+	if ok {
+		action := ""
+		if *repair && len(orphans) > 0 {
+			cache.RemoveOrphans(a.tileCache, a.renderer.IsCurrent)
+			action = "removed"
+		} else {
+			unresolved += len(orphans)
+		}
+		for _, dir := range orphans {
+			fmt.Printf("orphan\t\t%s\tno current image\t%s\n", dir, action)
+		}
+	}
+
+	fmt.Printf("%d images checked, %d skipped, %d problems, %d orphaned cache directories, %d unresolved\n",
+		report.Checked, report.Skipped, len(report.Problems), len(orphans), unresolved)
+	if unresolved > 0 {
+		a.close()
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,310 @@
+// Package analytics counts how often images are viewed and which of their
+// regions are explored, as a heatmap of tile requests per zoom level
+package analytics
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// heatmapDepth bounds each heatmap to 2^heatmapDepth cells per side:
+	// deeper levels count their tiles in the cell of their ancestor
+	heatmapDepth = 6
+	// maxZoom ignores requests for levels no image has
+	maxZoom = 30
+)
+
+// Summary is the view count of one image
+type Summary struct {
+	ImageID    string     `json:"image_id"`
+	Views      uint64     `json:"views"`
+	Tiles      uint64     `json:"tiles"`
+	LastViewed *time.Time `json:"last_viewed,omitempty"`
+}
+
+// Report is the view count of an image with its heatmap, by zoom level
+type Report struct {
+	Summary
+	Levels []Level `json:"levels"`
+}
+
+// Level is the heatmap of one zoom level. Cells cover CellSize×CellSize
+// tiles of the level, starting at tile X*CellSize, Y*CellSize; they are
+// listed most requested first.
+type Level struct {
+	Zoom     int    `json:"zoom"`
+	Tiles    uint64 `json:"tiles"`
+	CellSize int    `json:"cell_size"`
+	Cells    []Cell `json:"cells"`
+}
+
+// Cell is one region of a heatmap
+type Cell struct {
+	X     int    `json:"x"`
+	Y     int    `json:"y"`
+	Count uint64 `json:"count"`
+}
+
+type cell struct{ x, y int }
+
+type imageStats struct {
+	views      uint64
+	tiles      uint64
+	lastViewed time.Time
+	levels     map[int]map[cell]uint64
+}
+
+// Recorder keeps the counters in memory and writes them to a JSON file
+// periodically. A nil *Recorder discards everything, so callers don't need
+// to check whether analytics are enabled.
+type Recorder struct {
+	path string
+	log  *zap.Logger
+	// flushMu keeps writes of the file in order
+	flushMu sync.Mutex
+
+	mu     sync.Mutex
+	images map[string]*imageStats
+	dirty  bool
+}
+
+// New loads the counters saved in path ("" = kept in memory only)
+func New(path string, log *zap.Logger) (*Recorder, error) {
+	r := &Recorder{path: path, log: log, images: make(map[string]*imageStats)}
+	if path == "" {
+		return r, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return r, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read analytics: %w", err)
+	}
+	var reports []Report
+	if err := json.Unmarshal(data, &reports); err != nil {
+		return nil, fmt.Errorf("failed to parse analytics: %w", err)
+	}
+	for _, report := range reports {
+		stats := &imageStats{views: report.Views, tiles: report.Tiles, levels: make(map[int]map[cell]uint64)}
+		if report.LastViewed != nil {
+			stats.lastViewed = *report.LastViewed
+		}
+		for _, level := range report.Levels {
+			cells := make(map[cell]uint64, len(level.Cells))
+			for _, c := range level.Cells {
+				cells[cell{c.X, c.Y}] = c.Count
+			}
+			stats.levels[level.Zoom] = cells
+		}
+		r.images[report.ImageID] = stats
+	}
+	return r, nil
+}
+
+// stats returns the counters of an image, creating them; the caller holds mu
+func (r *Recorder) stats(imageID string) *imageStats {
+	stats, ok := r.images[imageID]
+	if !ok {
+		stats = &imageStats{levels: make(map[int]map[cell]uint64)}
+		r.images[imageID] = stats
+	}
+	return stats
+}
+
+// View counts one view of an image
+func (r *Recorder) View(imageID string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stats := r.stats(imageID)
+	stats.views++
+	stats.lastViewed = time.Now()
+	r.dirty = true
+}
+
+// Tile counts a request for tile z/x/y of an image. Coordinates no image
+// can have are ignored.
+func (r *Recorder) Tile(imageID string, z, x, y int) {
+	if r == nil || z < 0 || z > maxZoom || x < 0 || y < 0 || x >= 1<<z || y >= 1<<z {
+		return
+	}
+	shift := max(0, z-heatmapDepth)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stats := r.stats(imageID)
+	stats.tiles++
+	cells, ok := stats.levels[z]
+	if !ok {
+		cells = make(map[cell]uint64)
+		stats.levels[z] = cells
+	}
+	cells[cell{x >> shift, y >> shift}]++
+	r.dirty = true
+}
+
+// Forget drops the counters of a deleted image
+func (r *Recorder) Forget(imageID string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.images[imageID]; ok {
+		delete(r.images, imageID)
+		r.dirty = true
+	}
+}
+
+// Summaries lists the view counts of all images, most viewed first
+func (r *Recorder) Summaries() []Summary {
+	summaries := []Summary{}
+	if r == nil {
+		return summaries
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for id, stats := range r.images {
+		summaries = append(summaries, stats.summary(id))
+	}
+	sort.Slice(summaries, func(i, j int) bool {
+		if summaries[i].Views != summaries[j].Views {
+			return summaries[i].Views > summaries[j].Views
+		}
+		return summaries[i].ImageID < summaries[j].ImageID
+	})
+	return summaries
+}
+
+// Report returns the counters and heatmap of an image, empty for images
+// without any
+func (r *Recorder) Report(imageID string) *Report {
+	if r == nil {
+		return &Report{Summary: Summary{ImageID: imageID}, Levels: []Level{}}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stats, ok := r.images[imageID]
+	if !ok {
+		return &Report{Summary: Summary{ImageID: imageID}, Levels: []Level{}}
+	}
+	return stats.report(imageID)
+}
+
+func (s *imageStats) summary(imageID string) Summary {
+	summary := Summary{ImageID: imageID, Views: s.views, Tiles: s.tiles}
+	if !s.lastViewed.IsZero() {
+		lastViewed := s.lastViewed
+		summary.LastViewed = &lastViewed
+	}
+	return summary
+}
+
+func (s *imageStats) report(imageID string) *Report {
+	report := &Report{Summary: s.summary(imageID), Levels: []Level{}}
+	for z, cells := range s.levels {
+		level := Level{Zoom: z, CellSize: 1 << max(0, z-heatmapDepth), Cells: make([]Cell, 0, len(cells))}
+		for c, count := range cells {
+			level.Cells = append(level.Cells, Cell{X: c.x, Y: c.y, Count: count})
+			level.Tiles += count
+		}
+		sort.Slice(level.Cells, func(i, j int) bool {
+			a, b := level.Cells[i], level.Cells[j]
+			if a.Count != b.Count {
+				return a.Count > b.Count
+			}
+			if a.Y != b.Y {
+				return a.Y < b.Y
+			}
+			return a.X < b.X
+		})
+		report.Levels = append(report.Levels, level)
+	}
+	sort.Slice(report.Levels, func(i, j int) bool { return report.Levels[i].Zoom < report.Levels[j].Zoom })
+	return report
+}
+
+// Flush writes the counters if they changed since the last write. Writes go
+// through a temporary file and a rename.
+func (r *Recorder) Flush() error {
+	if r == nil || r.path == "" {
+		return nil
+	}
+	r.flushMu.Lock()
+	defer r.flushMu.Unlock()
+	r.mu.Lock()
+	if !r.dirty {
+		r.mu.Unlock()
+		return nil
+	}
+	reports := make([]*Report, 0, len(r.images))
+	for id, stats := range r.images {
+		reports = append(reports, stats.report(id))
+	}
+	r.dirty = false
+	r.mu.Unlock()
+
+	sort.Slice(reports, func(i, j int) bool { return reports[i].ImageID < reports[j].ImageID })
+	data, err := json.Marshal(reports)
+	if err == nil {
+		err = writeFile(r.path, data)
+	}
+	if err != nil {
+		// Try again with the next flush
+		r.mu.Lock()
+		r.dirty = true
+		r.mu.Unlock()
+		return fmt.Errorf("failed to write analytics: %w", err)
+	}
+	return nil
+}
+
+func writeFile(path string, data []byte) error {
+	temp, err := os.CreateTemp(filepath.Dir(path), ".analytics-*.json")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+	if _, err := temp.Write(data); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(temp.Name(), path)
+}
+
+// Run flushes the counters every interval until ctx is canceled. The last
+// changes are left to a final Flush.
+func (r *Recorder) Run(ctx context.Context, interval time.Duration) {
+	if r == nil || r.path == "" {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := r.Flush(); err != nil {
+				r.log.Warn("Failed to save analytics", zap.Error(err))
+			}
+		}
+	}
+}
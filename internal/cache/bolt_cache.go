@@ -0,0 +1,466 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+	"go.uber.org/zap"
+)
+
+// tilesBucket holds a bucket per tile directory of the file cache layout,
+// each with the tiles keyed by the rest of their path
+var tilesBucket = []byte("tiles")
+
+// boltHeaderSize is the write time (Unix nanoseconds) stored before the tile
+const boltHeaderSize = 8
+
+// The database file is compacted once it is compactRatio times larger than
+// its live tiles (or the cap) and at least compactMinSize. bbolt reuses
+// freed pages but never shrinks the file.
+const (
+	compactRatio   = 2
+	compactMinSize = 64 << 20
+)
+
+// BoltCache keeps tiles in a single bbolt database file, for filesystems
+// and network volumes that handle millions of small files poorly
+type BoltCache struct {
+	// mu is held exclusively only while compaction swaps the database
+	mu   sync.RWMutex
+	db   *bolt.DB
+	path string
+	// maxAge expires tiles by write time (0 = never)
+	maxAge   time.Duration
+	maxBytes int64
+
+	entries atomic.Int64
+	bytes   atomic.Int64
+	// accessed records when tiles were last read, as in FileCache
+	accessMu sync.Mutex
+	accessed map[string]time.Time
+	// wake has maintain prune or compact after tiles were added or removed
+	wake chan struct{}
+}
+
+// NewBoltCache opens or creates the database at path. Unless maxAge is 0,
+// tiles expire that long after they were written; unless maxBytes is 0, the
+// least recently used tiles are pruned once they take more than maxBytes.
+func NewBoltCache(path string, maxAge time.Duration, maxBytes int64) (*BoltCache, error) {
+	c := &BoltCache{
+		path:     path,
+		maxAge:   maxAge,
+		maxBytes: maxBytes,
+		accessed: make(map[string]time.Time),
+		wake:     make(chan struct{}, 1),
+	}
+	if err := c.open(); err != nil {
+		return nil, err
+	}
+
+	// Count what a previous run left
+	err := c.db.View(func(tx *bolt.Tx) error {
+		return c.forEachTile(tx, func(dir *bolt.Bucket, dirName, name, value []byte) error {
+			c.entries.Add(1)
+			c.bytes.Add(int64(len(value) - boltHeaderSize))
+			return nil
+		})
+	})
+	if err != nil {
+		c.db.Close()
+		return nil, fmt.Errorf("failed to read tile cache: %w", err)
+	}
+	c.checkSize()
+	return c, nil
+}
+
+func (c *BoltCache) open() error {
+	db, err := bolt.Open(c.path, 0644, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return fmt.Errorf("failed to open tile cache: %w", err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(tilesBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return fmt.Errorf("failed to initialize tile cache: %w", err)
+	}
+	c.db = db
+	return nil
+}
+
+// Close closes the database file
+func (c *BoltCache) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.db.Close()
+}
+
+// boltKey splits the tile path into its directory and the rest
+func boltKey(key TileKey) (dir, name []byte) {
+	d, n, _ := strings.Cut(tilePath(key), "/")
+	return []byte(d), []byte(n)
+}
+
+// forEachTile calls fn with every tile and its header
+func (c *BoltCache) forEachTile(tx *bolt.Tx, fn func(dir *bolt.Bucket, dirName, name, value []byte) error) error {
+	tiles := tx.Bucket(tilesBucket)
+	return tiles.ForEachBucket(func(dirName []byte) error {
+		dir := tiles.Bucket(dirName)
+		return dir.ForEach(func(name, value []byte) error {
+			return fn(dir, dirName, name, value)
+		})
+	})
+}
+
+func (c *BoltCache) expired(value []byte) bool {
+	if c.maxAge <= 0 || len(value) < boltHeaderSize {
+		return false
+	}
+	written := time.Unix(0, int64(binary.BigEndian.Uint64(value)))
+	return time.Since(written) > c.maxAge
+}
+
+func (c *BoltCache) checkSize() {
+	if c.maxBytes > 0 && c.bytes.Load() > c.maxBytes {
+		c.wakeUp()
+	}
+}
+
+func (c *BoltCache) wakeUp() {
+	select {
+	case c.wake <- struct{}{}:
+	default:
+	}
+}
+
+// lookup reads a tile, nil if missing or expired. The value is only valid
+// within tx.
+func (c *BoltCache) lookup(tx *bolt.Tx, key TileKey) []byte {
+	dirName, name := boltKey(key)
+	dir := tx.Bucket(tilesBucket).Bucket(dirName)
+	if dir == nil {
+		return nil
+	}
+	value := dir.Get(name)
+	if len(value) < boltHeaderSize || c.expired(value) {
+		return nil
+	}
+	return value
+}
+
+func (c *BoltCache) Has(ctx context.Context, key TileKey) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	found := false
+	c.db.View(func(tx *bolt.Tx) error {
+		found = c.lookup(tx, key) != nil
+		return nil
+	})
+	return found
+}
+
+func (c *BoltCache) Get(ctx context.Context, key TileKey) ([]byte, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	var data []byte
+	c.db.View(func(tx *bolt.Tx) error {
+		if value := c.lookup(tx, key); value != nil {
+			data = bytes.Clone(value[boltHeaderSize:])
+		}
+		return nil
+	})
+	if data == nil {
+		return nil, false
+	}
+
+	if c.maxBytes > 0 {
+		c.accessMu.Lock()
+		c.accessed[tilePath(key)] = time.Now()
+		c.accessMu.Unlock()
+	}
+	return data, true
+}
+
+func (c *BoltCache) Set(ctx context.Context, key TileKey, value []byte) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	record := make([]byte, boltHeaderSize+len(value))
+	binary.BigEndian.PutUint64(record, uint64(time.Now().UnixNano()))
+	copy(record[boltHeaderSize:], value)
+
+	dirName, name := boltKey(key)
+	var entries, size int64
+	// Batch commits concurrent writes together, one fsync for all
+	err := c.db.Batch(func(tx *bolt.Tx) error {
+		entries, size = 1, int64(len(value))
+		dir, err := tx.Bucket(tilesBucket).CreateBucketIfNotExists(dirName)
+		if err != nil {
+			return err
+		}
+		if existing := dir.Get(name); existing != nil {
+			entries, size = 0, size-int64(len(existing)-boltHeaderSize)
+		}
+		return dir.Put(name, record)
+	})
+	if err != nil {
+		return
+	}
+	c.entries.Add(entries)
+	c.bytes.Add(size)
+	c.checkSize()
+}
+
+func (c *BoltCache) Clear() {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	err := c.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.DeleteBucket(tilesBucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucket(tilesBucket)
+		return err
+	})
+	if err != nil {
+		return
+	}
+	c.entries.Store(0)
+	c.bytes.Store(0)
+	c.accessMu.Lock()
+	c.accessed = make(map[string]time.Time)
+	c.accessMu.Unlock()
+	c.wakeUp()
+}
+
+// DeleteImage deletes the tile directories of an image
+func (c *BoltCache) DeleteImage(imageID string) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	var entries, size int64
+	err := c.db.Update(func(tx *bolt.Tx) error {
+		entries, size = 0, 0
+		tiles := tx.Bucket(tilesBucket)
+		var stale [][]byte
+		err := tiles.ForEachBucket(func(dirName []byte) error {
+			if !tileDirFrom(string(dirName), imageID) {
+				return nil
+			}
+			stale = append(stale, bytes.Clone(dirName))
+			return tiles.Bucket(dirName).ForEach(func(name, value []byte) error {
+				entries++
+				size += int64(len(value) - boltHeaderSize)
+				return nil
+			})
+		})
+		if err != nil {
+			return err
+		}
+		for _, dirName := range stale {
+			if err := tiles.DeleteBucket(dirName); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return
+	}
+	c.entries.Add(-entries)
+	c.bytes.Add(-size)
+	c.wakeUp()
+}
+
+// boltTile locates a tile for Sweep and Prune
+type boltTile struct {
+	dir, name []byte
+	size      int64
+	used      time.Time
+}
+
+// remove deletes tiles in one transaction and returns how many
+func (c *BoltCache) remove(tiles []boltTile) int {
+	if len(tiles) == 0 {
+		return 0
+	}
+	var entries, size int64
+	err := c.db.Update(func(tx *bolt.Tx) error {
+		entries, size = 0, 0
+		root := tx.Bucket(tilesBucket)
+		for _, tile := range tiles {
+			dir := root.Bucket(tile.dir)
+			if dir == nil || dir.Get(tile.name) == nil {
+				continue
+			}
+			if err := dir.Delete(tile.name); err != nil {
+				return err
+			}
+			entries++
+			size += tile.size
+		}
+		return nil
+	})
+	if err != nil {
+		return 0
+	}
+	c.entries.Add(-entries)
+	c.bytes.Add(-size)
+
+	c.accessMu.Lock()
+	for _, tile := range tiles {
+		delete(c.accessed, string(tile.dir)+"/"+string(tile.name))
+	}
+	c.accessMu.Unlock()
+	return int(entries)
+}
+
+// Sweep removes expired tiles
+func (c *BoltCache) Sweep() int {
+	if c.maxAge <= 0 {
+		return 0
+	}
+	return c.RemoveOlder(time.Now().Add(-c.maxAge))
+}
+
+// RemoveOlder removes tiles written before cutoff
+func (c *BoltCache) RemoveOlder(cutoff time.Time) int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	var expired []boltTile
+	c.db.View(func(tx *bolt.Tx) error {
+		return c.forEachTile(tx, func(dir *bolt.Bucket, dirName, name, value []byte) error {
+			if len(value) >= boltHeaderSize && time.Unix(0, int64(binary.BigEndian.Uint64(value))).Before(cutoff) {
+				expired = append(expired, boltTile{dir: bytes.Clone(dirName), name: bytes.Clone(name), size: int64(len(value) - boltHeaderSize)})
+			}
+			return nil
+		})
+	})
+	return c.remove(expired)
+}
+
+// Prune removes the least recently used tiles until the cache is back below
+// pruneTarget of its cap
+func (c *BoltCache) Prune() int {
+	if c.maxBytes <= 0 || c.bytes.Load() <= c.maxBytes {
+		return 0
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	c.accessMu.Lock()
+	accessed := make(map[string]time.Time, len(c.accessed))
+	for path, last := range c.accessed {
+		accessed[path] = last
+	}
+	c.accessMu.Unlock()
+
+	var tiles []boltTile
+	c.db.View(func(tx *bolt.Tx) error {
+		return c.forEachTile(tx, func(dir *bolt.Bucket, dirName, name, value []byte) error {
+			used := time.Unix(0, int64(binary.BigEndian.Uint64(value)))
+			if last, ok := accessed[string(dirName)+"/"+string(name)]; ok && last.After(used) {
+				used = last
+			}
+			tiles = append(tiles, boltTile{dir: bytes.Clone(dirName), name: bytes.Clone(name), size: int64(len(value) - boltHeaderSize), used: used})
+			return nil
+		})
+	})
+
+	sort.Slice(tiles, func(i, j int) bool { return tiles[i].used.Before(tiles[j].used) })
+	excess := c.bytes.Load() - int64(float64(c.maxBytes)*pruneTarget)
+	n := 0
+	for ; n < len(tiles) && excess > 0; n++ {
+		excess -= tiles[n].size
+	}
+	return c.remove(tiles[:n])
+}
+
+// Compact rewrites the database into a new file without its free pages.
+// Reads and writes wait meanwhile.
+func (c *BoltCache) Compact() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	compactPath := c.path + ".compact"
+	os.Remove(compactPath)
+	dst, err := bolt.Open(compactPath, 0644, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return fmt.Errorf("failed to compact tile cache: %w", err)
+	}
+	if err := bolt.Compact(dst, c.db, 64<<20); err != nil {
+		dst.Close()
+		os.Remove(compactPath)
+		return fmt.Errorf("failed to compact tile cache: %w", err)
+	}
+	dst.Close()
+
+	c.db.Close()
+	if err := os.Rename(compactPath, c.path); err != nil {
+		os.Remove(compactPath)
+		if openErr := c.open(); openErr != nil {
+			return openErr
+		}
+		return fmt.Errorf("failed to compact tile cache: %w", err)
+	}
+	return c.open()
+}
+
+// compactDue reports whether the database file outgrew its live tiles
+func (c *BoltCache) compactDue() bool {
+	info, err := os.Stat(c.path)
+	return err == nil && info.Size() > compactMinSize && info.Size() > compactRatio*max(c.bytes.Load(), c.maxBytes)
+}
+
+// maintain sweeps expired tiles every half TTL, prunes when the cache grows
+// over its cap and compacts the file after either freed enough space
+func (c *BoltCache) maintain(ctx context.Context, log *zap.Logger) {
+	var sweep <-chan time.Time
+	if c.maxAge > 0 {
+		ticker := time.NewTicker(sweepInterval(c.maxAge))
+		defer ticker.Stop()
+		sweep = ticker.C
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-sweep:
+			if removed := c.Sweep(); removed > 0 {
+				log.Debug("Removed expired tiles", zap.Int("tiles", removed))
+			}
+		case <-c.wake:
+			if removed := c.Prune(); removed > 0 {
+				log.Debug("Pruned least recently used tiles", zap.Int("tiles", removed), zap.Int64("bytes", c.bytes.Load()))
+			}
+		}
+		if c.compactDue() {
+			if err := c.Compact(); err != nil {
+				log.Warn("Failed to compact tile cache", zap.Error(err))
+			}
+		}
+	}
+}
+
+func (c *BoltCache) Stats() Stats {
+	return Stats{
+		Type:     "bolt",
+		Entries:  int(c.entries.Load()),
+		Bytes:    c.bytes.Load(),
+		MaxBytes: c.maxBytes,
+	}
+}
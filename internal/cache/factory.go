@@ -1,24 +1,211 @@
 package cache
 
 import (
+	"context"
 	"fmt"
+	"time"
 
 	"go.uber.org/zap"
+
+	"gigaview/internal/storage"
 )
 
+// Options configures the tile cache
+type Options struct {
+	// Type is "memory", "file", "tiered" (memory in front of file), "bolt",
+	// "s3" or "disabled"
+	Type string
+	// FileDir is the directory of the file cache
+	FileDir string
+	// MemoryTiles and MemoryBytes bound the memory cache; MemoryBytes 0
+	// means tiles only
+	MemoryTiles int
+	MemoryBytes int64
+	// TTL expires tiles that long after they were stored (0 = never)
+	TTL time.Duration
+	// FileBytes caps the file and bolt caches, pruning least recently used
+	// tiles (0 = unlimited)
+	FileBytes int64
+	// BoltPath is the database file of the bolt cache
+	BoltPath string
+	// S3 is the endpoint, credentials, bucket and key prefix of the s3 cache
+	S3 storage.Options
+}
+
 // NewCache creates a cache instance based on the cache type
-func NewCache(cacheType, cacheFileDir string, cacheMemoryTiles int, log *zap.Logger) (Cache, error) {
-	switch cacheType {
+func NewCache(options Options, log *zap.Logger) (Cache, error) {
+	switch options.Type {
 	case "memory":
-		log.Info("Using memory cache", zap.Int("max_tiles", cacheMemoryTiles))
-		return NewMemoryCache(cacheMemoryTiles), nil
+		log.Info("Using memory cache",
+			zap.Int("max_tiles", options.MemoryTiles),
+			zap.Int64("max_bytes", options.MemoryBytes),
+			zap.Duration("ttl", options.TTL))
+		return NewMemoryCache(options.MemoryTiles, options.MemoryBytes, options.TTL), nil
 	case "file":
-		log.Info("Using file cache", zap.String("cache_dir", cacheFileDir))
-		return NewFileCache(cacheFileDir)
+		log.Info("Using file cache",
+			zap.String("cache_dir", options.FileDir),
+			zap.Int64("max_bytes", options.FileBytes),
+			zap.Duration("ttl", options.TTL))
+		return NewFileCache(options.FileDir, options.TTL, options.FileBytes)
+	case "tiered":
+		file, err := NewFileCache(options.FileDir, options.TTL, options.FileBytes)
+		if err != nil {
+			return nil, err
+		}
+		log.Info("Using tiered cache",
+			zap.Int("max_tiles", options.MemoryTiles),
+			zap.Int64("max_bytes", options.MemoryBytes),
+			zap.String("cache_dir", options.FileDir),
+			zap.Int64("max_file_bytes", options.FileBytes),
+			zap.Duration("ttl", options.TTL))
+		return NewTieredCache(NewMemoryCache(options.MemoryTiles, options.MemoryBytes, options.TTL), file), nil
+	case "bolt":
+		log.Info("Using bolt cache",
+			zap.String("path", options.BoltPath),
+			zap.Int64("max_bytes", options.FileBytes),
+			zap.Duration("ttl", options.TTL))
+		return NewBoltCache(options.BoltPath, options.TTL, options.FileBytes)
+	case "s3":
+		client, err := storage.NewS3Client(options.S3)
+		if err != nil {
+			return nil, err
+		}
+		prefix := storage.S3Prefix(options.S3.S3Prefix)
+		log.Info("Using s3 cache",
+			zap.String("bucket", options.S3.S3Bucket),
+			zap.String("prefix", prefix),
+			zap.Duration("ttl", options.TTL))
+		return NewS3Cache(client, options.S3.S3Bucket, prefix, options.TTL, log)
 	case "disabled":
 		log.Info("Cache disabled")
 		return NewNoopCache(), nil
 	default:
-		return nil, fmt.Errorf("unknown cache type: %s (supported: memory, file, disabled)", cacheType)
+		return nil, fmt.Errorf("unknown cache type: %s (supported: memory, file, tiered, bolt, s3, disabled)", options.Type)
+	}
+}
+
+// maintainer is implemented by caches with background upkeep, like
+// removing expired tiles
+type maintainer interface {
+	maintain(ctx context.Context, log *zap.Logger)
+}
+
+// Maintain runs the background upkeep of a cache until ctx is canceled. It
+// returns at once for caches without any.
+func Maintain(ctx context.Context, c Cache, log *zap.Logger) {
+	if m, ok := c.(maintainer); ok {
+		m.maintain(ctx, log)
+	}
+}
+
+// sweepInterval is how often caches look for expired tiles. Reads honor the
+// TTL anyway, sweeping only frees the space.
+func sweepInterval(ttl time.Duration) time.Duration {
+	return max(ttl/2, time.Minute)
+}
+
+// snapshotter is implemented by caches that lose their tiles on restart
+// unless they are saved
+type snapshotter interface {
+	SaveSnapshot(path string) error
+	LoadSnapshot(path string) (int, error)
+}
+
+// SaveSnapshot saves the tiles of a memory cache to path; other caches have
+// nothing to save
+func SaveSnapshot(c Cache, path string) error {
+	if s, ok := c.(snapshotter); ok {
+		return s.SaveSnapshot(path)
+	}
+	return nil
+}
+
+// LoadSnapshot loads tiles saved by SaveSnapshot and returns how many
+func LoadSnapshot(c Cache, path string) (int, error) {
+	if s, ok := c.(snapshotter); ok {
+		return s.LoadSnapshot(path)
+	}
+	return 0, nil
+}
+
+// resizer is implemented by caches that keep tiles in memory
+type resizer interface {
+	Resize(maxTiles int, maxBytes int64)
+}
+
+// Resize changes the limits of the tiles a running cache keeps in memory
+// (MemoryTiles and MemoryBytes of Options). It reports false for caches
+// without a memory tier, whose limits need a restart to change.
+func Resize(c Cache, maxTiles int, maxBytes int64) bool {
+	r, ok := c.(resizer)
+	if ok {
+		r.Resize(maxTiles, maxBytes)
+	}
+	return ok
+}
+
+// ager is implemented by caches that keep tiles across restarts and know
+// when they were written
+type ager interface {
+	RemoveOlder(cutoff time.Time) int
+}
+
+// RemoveOlder removes the tiles written before cutoff and returns how many.
+// ok is false for caches that only live as long as the process.
+func RemoveOlder(c Cache, cutoff time.Time) (removed int, ok bool) {
+	a, ok := c.(ager)
+	if !ok {
+		return 0, false
+	}
+	return a.RemoveOlder(cutoff), true
+}
+
+// orphanRemover is implemented by caches that keep tiles across restarts,
+// which may outlive their images
+type orphanRemover interface {
+	Orphans(current func(imageID, fingerprint string) bool) []string
+	RemoveOrphans(current func(imageID, fingerprint string) bool) (dirs, temps int)
+}
+
+// Orphans lists the tile directories of images that are gone or changed, as
+// reported by current. ok is false for caches without persistent tiles.
+func Orphans(c Cache, current func(imageID, fingerprint string) bool) (orphans []string, ok bool) {
+	remover, ok := c.(orphanRemover)
+	if !ok {
+		return nil, false
+	}
+	return remover.Orphans(current), true
+}
+
+// RemoveOrphans deletes orphaned tile directories and leftover temporary
+// files once, like a round of RunJanitor
+func RemoveOrphans(c Cache, current func(imageID, fingerprint string) bool) (dirs, temps int) {
+	if remover, ok := c.(orphanRemover); ok {
+		return remover.RemoveOrphans(current)
+	}
+	return 0, 0
+}
+
+// RunJanitor removes orphaned tiles every interval until ctx is canceled,
+// starting right away. current reports whether tiles of an image ID and
+// fingerprint are still valid. It returns at once for caches without
+// persistent tiles.
+func RunJanitor(ctx context.Context, c Cache, interval time.Duration, current func(imageID, fingerprint string) bool, log *zap.Logger) {
+	remover, ok := c.(orphanRemover)
+	if !ok || interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if dirs, temps := remover.RemoveOrphans(current); dirs+temps > 0 {
+			log.Info("Removed orphaned cache files", zap.Int("tile_dirs", dirs), zap.Int("temp_files", temps))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
 	}
 }
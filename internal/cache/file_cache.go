@@ -1,64 +1,154 @@
 package cache
 
 import (
+	"context"
 	"fmt"
+	"hash/fnv"
+	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
 )
 
+// setLockStripes is the number of locks writes of a tile are serialized by
+const setLockStripes = 64
+
+// pruneTarget is the share of the size cap pruning frees up to, so the
+// next prune isn't due after a few more tiles
+const pruneTarget = 0.9
+
 // FileCache implements file-based cache
 // Structure: {cacheDir}/{imageID}_{tileSize}_{maxZoom}/{z}/{x}_{y}.jpg
+//
+// Tiles are written to a temporary file and renamed into place, so reads
+// need no lock: they see the old tile, the new one or none. mu only keeps
+// writes out of directories that Clear and DeleteImage are removing.
 type FileCache struct {
 	mu       sync.RWMutex
 	cacheDir string
+	// maxAge expires tiles by file modification time (0 = never)
+	maxAge time.Duration
+
+	// maxBytes caps the size of all tiles (0 = unlimited). bytes is only
+	// tracked with a cap.
+	maxBytes int64
+	bytes    atomic.Int64
+	// setLocks serialize the writes of one tile from replacing it to
+	// counting its size, so two writers of a new tile don't both count it
+	setLocks [setLockStripes]sync.Mutex
+	// accessed records when tiles were last read; tiles not in it count as
+	// last used when written
+	accessMu sync.Mutex
+	accessed map[string]time.Time
+	// pruneNeeded wakes the pruner once bytes exceed maxBytes
+	pruneNeeded chan struct{}
 }
 
-func NewFileCache(cacheDir string) (*FileCache, error) {
+// NewFileCache creates a file cache in cacheDir. Unless maxAge is 0, tiles
+// expire that long after they were written; unless maxBytes is 0, the least
+// recently used tiles are pruned once they take more than maxBytes.
+func NewFileCache(cacheDir string, maxAge time.Duration, maxBytes int64) (*FileCache, error) {
 	if err := os.MkdirAll(cacheDir, 0755); err != nil {
 		return nil, fmt.Errorf("failed to create cache directory: %w", err)
 	}
 
-	return &FileCache{
-		cacheDir: cacheDir,
-	}, nil
+	c := &FileCache{
+		cacheDir:    cacheDir,
+		maxAge:      maxAge,
+		maxBytes:    maxBytes,
+		accessed:    make(map[string]time.Time),
+		pruneNeeded: make(chan struct{}, 1),
+	}
+	if maxBytes > 0 {
+		c.bytes.Store(dirSize(cacheDir))
+		c.checkSize()
+	}
+	return c, nil
+}
+
+// dirSize sums the sizes of the files below dir
+func dirSize(dir string) int64 {
+	var size int64
+	filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		if info, err := d.Info(); err == nil {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
+
+// expired reports whether a tile written at modTime is past the TTL
+func (c *FileCache) expired(modTime time.Time) bool {
+	return c.maxAge > 0 && time.Since(modTime) > c.maxAge
+}
+
+// checkSize wakes the pruner if the cache is over its cap
+func (c *FileCache) checkSize() {
+	if c.maxBytes > 0 && c.bytes.Load() > c.maxBytes {
+		select {
+		case c.pruneNeeded <- struct{}{}:
+		default:
+		}
+	}
 }
 
 // buildFilePath builds file path from tile key
-// Structure: {cacheDir}/{imageID}_{tileSize}_{maxZoom}/{z}/{x}_{y}.{format}
+// Structure: {cacheDir}/{imageID}_{tileSize}_{maxZoom}[_f{fingerprint}][_{variant}]/{z}/{x}_{y}.{format}
 func (c *FileCache) buildFilePath(key TileKey) string {
-	dirName := fmt.Sprintf("%s_%d_%d", key.ImageID, key.TileSize, key.MaxZoom)
-	dir := filepath.Join(c.cacheDir, dirName, fmt.Sprintf("%d", key.Z))
-	fileName := fmt.Sprintf("%d_%d.%s", key.X, key.Y, key.Format)
-	return filepath.Join(dir, fileName)
+	return filepath.Join(c.cacheDir, filepath.FromSlash(tilePath(key)))
 }
 
-func (c *FileCache) Has(key TileKey) bool {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
-
+func (c *FileCache) Has(ctx context.Context, key TileKey) bool {
 	filePath := c.buildFilePath(key)
-	_, err := os.Stat(filePath)
-	return err == nil
+	info, err := os.Stat(filePath)
+	return err == nil && !c.expired(info.ModTime())
 }
 
-func (c *FileCache) Get(key TileKey) ([]byte, bool) {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
-
+func (c *FileCache) Get(ctx context.Context, key TileKey) ([]byte, bool) {
 	filePath := c.buildFilePath(key)
 
-	data, err := os.ReadFile(filePath)
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, false
+	}
+	defer file.Close()
+
+	// Expired files are left to Sweep
+	if c.maxAge > 0 {
+		info, err := file.Stat()
+		if err != nil || c.expired(info.ModTime()) {
+			return nil, false
+		}
+	}
+
+	data, err := io.ReadAll(file)
 	if err != nil {
 		return nil, false
 	}
 
+	if c.maxBytes > 0 {
+		c.accessMu.Lock()
+		c.accessed[filePath] = time.Now()
+		c.accessMu.Unlock()
+	}
 	return data, true
 }
 
-func (c *FileCache) Set(key TileKey, value []byte) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
+func (c *FileCache) Set(ctx context.Context, key TileKey, value []byte) {
+	// Writes of different tiles, or even the same one, don't conflict
+	c.mu.RLock()
+	defer c.mu.RUnlock()
 
 	filePath := c.buildFilePath(key)
 	dir := filepath.Dir(filePath)
@@ -66,16 +156,53 @@ func (c *FileCache) Set(key TileKey, value []byte) {
 		return
 	}
 
-	// Write atomically
-	tmpPath := filePath + ".tmp"
-	if err := os.WriteFile(tmpPath, value, 0644); err != nil {
+	// Write atomically, under a name of its own for concurrent writes
+	tmp, err := os.CreateTemp(dir, ".tile-*.tmp")
+	if err != nil {
+		return
+	}
+	_, err = tmp.Write(value)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		// CreateTemp creates files only the owner can read
+		err = os.Chmod(tmp.Name(), 0644)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return
+	}
+	if c.maxBytes == 0 {
+		if err := os.Rename(tmp.Name(), filePath); err != nil {
+			os.Remove(tmp.Name())
+		}
 		return
 	}
 
-	if err := os.Rename(tmpPath, filePath); err != nil {
-		os.Remove(tmpPath)
+	lock := c.setLock(filePath)
+	lock.Lock()
+	var replaced int64
+	if info, err := os.Stat(filePath); err == nil {
+		replaced = info.Size()
+	}
+	err = os.Rename(tmp.Name(), filePath)
+	if err == nil {
+		c.bytes.Add(int64(len(value)) - replaced)
+	}
+	lock.Unlock()
+	if err != nil {
+		os.Remove(tmp.Name())
 		return
 	}
+	c.checkSize()
+}
+
+// setLock returns the lock serializing writes of the tile at filePath
+func (c *FileCache) setLock(filePath string) *sync.Mutex {
+	hash := fnv.New32a()
+	hash.Write([]byte(filePath))
+	return &c.setLocks[hash.Sum32()%setLockStripes]
 }
 
 func (c *FileCache) Clear() {
@@ -85,6 +212,234 @@ func (c *FileCache) Clear() {
 	if err := os.RemoveAll(c.cacheDir); err != nil {
 		return
 	}
+	c.bytes.Store(0)
+	c.accessMu.Lock()
+	c.accessed = make(map[string]time.Time)
+	c.accessMu.Unlock()
 
 	os.MkdirAll(c.cacheDir, 0755)
 }
+
+// DeleteImage removes the tile directories of an image
+func (c *FileCache) DeleteImage(imageID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entries, err := os.ReadDir(c.cacheDir)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		if tileDirFrom(entry.Name(), imageID) {
+			dir := filepath.Join(c.cacheDir, entry.Name())
+			if c.maxBytes > 0 {
+				c.bytes.Add(-dirSize(dir))
+			}
+			os.RemoveAll(dir)
+		}
+	}
+}
+
+// tempMaxAge is how old temporary files get before RemoveOrphans takes
+// them for leftovers of interrupted writes
+const tempMaxAge = time.Hour
+
+// RemoveOrphans deletes the tile directories of images that are gone or
+// whose file changed since, as reported by current, and temporary files of
+// interrupted writes. Directories not named like tile directories are left
+// alone.
+func (c *FileCache) RemoveOrphans(current func(imageID, fingerprint string) bool) (dirs, temps int) {
+	for _, name := range c.Orphans(current) {
+		dir := filepath.Join(c.cacheDir, name)
+		// Like DeleteImage, keep writes out while the directory goes
+		c.mu.Lock()
+		if c.maxBytes > 0 {
+			c.bytes.Add(-dirSize(dir))
+		}
+		if os.RemoveAll(dir) == nil {
+			dirs++
+		}
+		c.mu.Unlock()
+	}
+
+	filepath.WalkDir(c.cacheDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !strings.HasSuffix(d.Name(), ".tmp") {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil || time.Since(info.ModTime()) < tempMaxAge {
+			return nil
+		}
+		if c.removeFile(path) {
+			temps++
+		}
+		return nil
+	})
+	return dirs, temps
+}
+
+// Orphans lists the tile directories RemoveOrphans would delete
+func (c *FileCache) Orphans(current func(imageID, fingerprint string) bool) []string {
+	entries, err := os.ReadDir(c.cacheDir)
+	if err != nil {
+		return nil
+	}
+	var orphans []string
+	for _, entry := range entries {
+		imageID, fingerprint, ok := parseTileDir(entry.Name())
+		if entry.IsDir() && ok && !current(imageID, fingerprint) {
+			orphans = append(orphans, entry.Name())
+		}
+	}
+	return orphans
+}
+
+// removeFile deletes one tile and forgets its size and last access. The size
+// is read under the tile's write lock, since a write may have replaced the
+// tile after the caller looked at it.
+func (c *FileCache) removeFile(path string) bool {
+	if c.maxBytes == 0 {
+		return os.Remove(path) == nil
+	}
+	lock := c.setLock(path)
+	lock.Lock()
+	info, err := os.Stat(path)
+	if err == nil {
+		err = os.Remove(path)
+	}
+	if err == nil {
+		c.bytes.Add(-info.Size())
+	}
+	lock.Unlock()
+	if err != nil {
+		return false
+	}
+	c.accessMu.Lock()
+	delete(c.accessed, path)
+	c.accessMu.Unlock()
+	return true
+}
+
+// Sweep removes expired tiles
+func (c *FileCache) Sweep() int {
+	if c.maxAge <= 0 {
+		return 0
+	}
+	return c.RemoveOlder(time.Now().Add(-c.maxAge))
+}
+
+// RemoveOlder removes tiles written before cutoff. The walk runs without the
+// lock so reads go on meanwhile; a tile rewritten during the walk is at
+// worst rendered again.
+func (c *FileCache) RemoveOlder(cutoff time.Time) int {
+	removed := 0
+	filepath.WalkDir(c.cacheDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil || !info.ModTime().Before(cutoff) {
+			return nil
+		}
+		if c.removeFile(path) {
+			removed++
+		}
+		return nil
+	})
+	return removed
+}
+
+// Prune removes the least recently used tiles until the cache is back below
+// its cap. Like Sweep, it runs without the lock.
+func (c *FileCache) Prune() int {
+	if c.maxBytes <= 0 || c.bytes.Load() <= c.maxBytes {
+		return 0
+	}
+
+	type tile struct {
+		path string
+		size int64
+		used time.Time
+	}
+	c.accessMu.Lock()
+	accessed := c.accessed
+	c.accessed = make(map[string]time.Time)
+	c.accessMu.Unlock()
+
+	var tiles []tile
+	var total int64
+	filepath.WalkDir(c.cacheDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		used := info.ModTime()
+		if last, ok := accessed[path]; ok && last.After(used) {
+			used = last
+		}
+		tiles = append(tiles, tile{path: path, size: info.Size(), used: used})
+		total += info.Size()
+		return nil
+	})
+	// The walk is the actual size, correcting drift from races with writes
+	c.bytes.Store(total)
+
+	sort.Slice(tiles, func(i, j int) bool { return tiles[i].used.Before(tiles[j].used) })
+	target := int64(float64(c.maxBytes) * pruneTarget)
+	removed := 0
+	for n, tile := range tiles {
+		if c.bytes.Load() <= target {
+			// Put back the reads of kept tiles, reads since the snapshot win
+			c.accessMu.Lock()
+			for _, kept := range tiles[n:] {
+				if last, ok := accessed[kept.path]; ok && c.accessed[kept.path].Before(last) {
+					c.accessed[kept.path] = last
+				}
+			}
+			c.accessMu.Unlock()
+			break
+		}
+		if c.removeFile(tile.path) {
+			removed++
+		}
+	}
+	return removed
+}
+
+// maintain sweeps expired tiles every half TTL and prunes when the cache
+// grows over its cap
+func (c *FileCache) maintain(ctx context.Context, log *zap.Logger) {
+	if c.maxAge <= 0 && c.maxBytes <= 0 {
+		return
+	}
+
+	var sweep <-chan time.Time
+	if c.maxAge > 0 {
+		ticker := time.NewTicker(sweepInterval(c.maxAge))
+		defer ticker.Stop()
+		sweep = ticker.C
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-sweep:
+			if removed := c.Sweep(); removed > 0 {
+				log.Debug("Removed expired tiles", zap.Int("tiles", removed))
+			}
+		case <-c.pruneNeeded:
+			if removed := c.Prune(); removed > 0 {
+				log.Debug("Pruned least recently used tiles", zap.Int("tiles", removed), zap.Int64("bytes", c.bytes.Load()))
+			}
+		}
+	}
+}
+
+// Stats reports the size of the cache when it is capped; counting it
+// otherwise would mean walking the cache directory
+func (c *FileCache) Stats() Stats {
+	return Stats{Type: "file", Bytes: c.bytes.Load(), MaxBytes: c.maxBytes}
+}
@@ -1,5 +1,13 @@
 package cache
 
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
 // TileKey represents the parameters for a tile cache key
 type TileKey struct {
 	ImageID  string
@@ -9,11 +17,119 @@ type TileKey struct {
 	X        int
 	Y        int
 	Format   string
+	// Variant encodes render options that change tile pixels (e.g. visual
+	// adjustments). Empty for plain tiles. Must be filesystem-safe.
+	Variant string
+	// Fingerprint identifies the source file version, so tiles of a replaced
+	// file are never served from cache. Must be filesystem-safe.
+	Fingerprint string
 }
 
+// Cache stores rendered tiles. The context carries request cancellation for
+// backends that perform I/O; local backends may ignore it.
 type Cache interface {
-	Get(key TileKey) ([]byte, bool)
-	Set(key TileKey, value []byte)
-	Has(key TileKey) bool // Check if tile exists without reading it (lightweight check)
+	Get(ctx context.Context, key TileKey) ([]byte, bool)
+	Set(ctx context.Context, key TileKey, value []byte)
+	// Has checks whether a tile is cached without reading it, e.g. to skip
+	// tiles during warmup
+	Has(ctx context.Context, key TileKey) bool
 	Clear()
+	// DeleteImage drops every tile rendered from an image, including
+	// comparison tiles it is part of
+	DeleteImage(imageID string)
+	// Stats reports the current usage; backends fill in what they track
+	Stats() Stats
+}
+
+// Stats is a snapshot of cache usage. Max fields are 0 when unlimited.
+type Stats struct {
+	Type       string `json:"type"`
+	Entries    int    `json:"entries"`
+	Bytes      int64  `json:"bytes"`
+	MaxEntries int    `json:"max_entries,omitempty"`
+	MaxBytes   int64  `json:"max_bytes,omitempty"`
+}
+
+// ComparePairID is the ImageID of tiles rendered from two images
+func ComparePairID(imageA, imageB string) string {
+	return imageA + "_vs_" + imageB
+}
+
+// renderedFrom reports whether tiles stored under keyImageID were rendered
+// from imageID, alone or as part of a comparison
+func renderedFrom(keyImageID, imageID string) bool {
+	return keyImageID == imageID ||
+		strings.HasPrefix(keyImageID, imageID+"_vs_") ||
+		strings.HasSuffix(keyImageID, "_vs_"+imageID)
+}
+
+// tilePath is the slash-separated location of a tile below the root of the
+// file and object storage caches:
+// {imageID}_{tileSize}_{maxZoom}[_f{fingerprint}][_{variant}]/{z}/{x}_{y}.{format}
+func tilePath(key TileKey) string {
+	dirName := fmt.Sprintf("%s_%d_%d", key.ImageID, key.TileSize, key.MaxZoom)
+	if key.Fingerprint != "" {
+		dirName += "_f" + key.Fingerprint
+	}
+	if key.Variant != "" {
+		dirName += "_" + key.Variant
+	}
+	return fmt.Sprintf("%s/%d/%d_%d.%s", dirName, key.Z, key.X, key.Y, key.Format)
+}
+
+// tileDirPattern splits a tile directory name. Image IDs are matched lazily,
+// so comparison IDs ("a_vs_b") stay whole; comparison fingerprints are both
+// images' joined; variants may contain underscores.
+var tileDirPattern = regexp.MustCompile(`^(.+?)_(\d+)_(\d+)(?:_f([0-9a-f]{24}|[0-9a-f]{12}))?(?:_(.+))?$`)
+
+// tileNamePattern splits the file name of a tile
+var tileNamePattern = regexp.MustCompile(`^(\d+)_(\d+)\.([a-z]+)$`)
+
+// ParseTilePath is the inverse of the cache layout, for tile sets copied
+// from a file cache. It reports false for paths that aren't tiles.
+func ParseTilePath(path string) (TileKey, bool) {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if len(parts) != 3 {
+		return TileKey{}, false
+	}
+	dir := tileDirPattern.FindStringSubmatch(parts[0])
+	name := tileNamePattern.FindStringSubmatch(parts[2])
+	z, err := strconv.Atoi(parts[1])
+	if dir == nil || name == nil || err != nil {
+		return TileKey{}, false
+	}
+
+	key := TileKey{
+		ImageID:     dir[1],
+		Fingerprint: dir[4],
+		Variant:     dir[5],
+		Z:           z,
+		Format:      name[3],
+	}
+	key.TileSize, _ = strconv.Atoi(dir[2])
+	key.MaxZoom, _ = strconv.Atoi(dir[3])
+	key.X, _ = strconv.Atoi(name[1])
+	key.Y, _ = strconv.Atoi(name[2])
+	// Round trip, so a path that only looks like a tile isn't stored
+	// under a key the renderer would never ask for
+	if tilePath(key) != strings.Join(parts, "/") {
+		return TileKey{}, false
+	}
+	return key, true
+}
+
+// parseTileDir returns the image ID and fingerprint of a tile directory
+func parseTileDir(name string) (imageID, fingerprint string, ok bool) {
+	match := tileDirPattern.FindStringSubmatch(name)
+	if match == nil {
+		return "", "", false
+	}
+	return match[1], match[4], true
+}
+
+// tileDirFrom reports whether a top-level tile directory holds tiles
+// rendered from imageID. Names start with the ImageID, comparison tiles hold
+// both IDs.
+func tileDirFrom(name, imageID string) bool {
+	return strings.HasPrefix(name, imageID+"_") || strings.Contains(name, "_vs_"+imageID+"_")
 }
@@ -1,45 +1,85 @@
 package cache
 
 import (
+	"bufio"
 	"container/list"
+	"context"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
 	"sync"
+	"time"
+
+	"go.uber.org/zap"
 )
 
 type entry struct {
 	key   TileKey
 	value []byte
+	// expires is zero without a TTL
+	expires time.Time
 }
 
-// MemoryCache implements in-memory LRU cache
+// MemoryCache implements in-memory LRU cache, bounded by a number of tiles
+// and optionally by the bytes they take, whichever is reached first
 type MemoryCache struct {
-	mu      sync.RWMutex
-	maxSize int
-	items   map[TileKey]*list.Element
-	lruList *list.List
+	mu       sync.Mutex
+	maxSize  int
+	maxBytes int64
+	maxAge   time.Duration
+	bytes    int64
+	items    map[TileKey]*list.Element
+	lruList  *list.List
 }
 
-// NewMemoryCache creates a new in-memory LRU cache
-func NewMemoryCache(maxSize int) *MemoryCache {
+// NewMemoryCache creates a new in-memory LRU cache holding up to maxSize
+// tiles and, unless maxBytes is 0, up to maxBytes of tile data. Unless
+// maxAge is 0, tiles expire that long after they were stored.
+func NewMemoryCache(maxSize int, maxBytes int64, maxAge time.Duration) *MemoryCache {
 	return &MemoryCache{
-		maxSize: maxSize,
-		items:   make(map[TileKey]*list.Element),
-		lruList: list.New(),
+		maxSize:  maxSize,
+		maxBytes: maxBytes,
+		maxAge:   maxAge,
+		items:    make(map[TileKey]*list.Element),
+		lruList:  list.New(),
 	}
 }
 
-func (c *MemoryCache) Has(key TileKey) bool {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
+// lookup returns the live entry of a key, dropping it if it expired; the
+// caller holds mu
+func (c *MemoryCache) lookup(key TileKey) (*list.Element, bool) {
+	elem, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	if c.expired(elem.Value.(*entry), time.Now()) {
+		c.remove(elem)
+		return nil, false
+	}
+	return elem, true
+}
+
+func (c *MemoryCache) expired(ent *entry, now time.Time) bool {
+	return !ent.expires.IsZero() && now.After(ent.expires)
+}
+
+func (c *MemoryCache) Has(ctx context.Context, key TileKey) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
-	_, ok := c.items[key]
+	_, ok := c.lookup(key)
 	return ok
 }
 
-func (c *MemoryCache) Get(key TileKey) ([]byte, bool) {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
+func (c *MemoryCache) Get(ctx context.Context, key TileKey) ([]byte, bool) {
+	// Moving the entry to the front writes the list
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
-	elem, ok := c.items[key]
+	elem, ok := c.lookup(key)
 	if !ok {
 		return nil, false
 	}
@@ -48,27 +88,67 @@ func (c *MemoryCache) Get(key TileKey) ([]byte, bool) {
 	return elem.Value.(*entry).value, true
 }
 
-func (c *MemoryCache) Set(key TileKey, value []byte) {
+func (c *MemoryCache) Set(ctx context.Context, key TileKey, value []byte) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
+	// A tile over the whole budget would only evict everything else
+	if c.maxBytes > 0 && int64(len(value)) > c.maxBytes {
+		if elem, ok := c.items[key]; ok {
+			c.remove(elem)
+		}
+		return
+	}
+
+	var expires time.Time
+	if c.maxAge > 0 {
+		expires = time.Now().Add(c.maxAge)
+	}
+
 	if elem, ok := c.items[key]; ok {
-		elem.Value.(*entry).value = value
+		ent := elem.Value.(*entry)
+		c.bytes += int64(len(value) - len(ent.value))
+		ent.value = value
+		ent.expires = expires
 		c.lruList.MoveToFront(elem)
-		return
+	} else {
+		elem := c.lruList.PushFront(&entry{key: key, value: value, expires: expires})
+		c.items[key] = elem
+		c.bytes += int64(len(value))
 	}
 
-	if c.lruList.Len() >= c.maxSize {
+	c.evict()
+}
+
+// evict drops least recently used entries until the cache is within its
+// limits; the caller holds mu
+func (c *MemoryCache) evict() {
+	for c.lruList.Len() > c.maxSize || (c.maxBytes > 0 && c.bytes > c.maxBytes) {
 		oldest := c.lruList.Back()
-		if oldest != nil {
-			delete(c.items, oldest.Value.(*entry).key)
-			c.lruList.Remove(oldest)
+		if oldest == nil {
+			break
 		}
+		c.remove(oldest)
 	}
+}
 
-	ent := &entry{key: key, value: value}
-	elem := c.lruList.PushFront(ent)
-	c.items[key] = elem
+// Resize changes the limits of a running cache, evicting the least recently
+// used tiles if they are lowered
+func (c *MemoryCache) Resize(maxSize int, maxBytes int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.maxSize = maxSize
+	c.maxBytes = maxBytes
+	c.evict()
+}
+
+// remove drops one entry; the caller holds mu
+func (c *MemoryCache) remove(elem *list.Element) {
+	ent := elem.Value.(*entry)
+	delete(c.items, ent.key)
+	c.lruList.Remove(elem)
+	c.bytes -= int64(len(ent.value))
 }
 
 func (c *MemoryCache) Clear() {
@@ -77,4 +157,163 @@ func (c *MemoryCache) Clear() {
 
 	c.items = make(map[TileKey]*list.Element)
 	c.lruList = list.New()
+	c.bytes = 0
+}
+
+func (c *MemoryCache) DeleteImage(imageID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key, elem := range c.items {
+		if renderedFrom(key.ImageID, imageID) {
+			c.remove(elem)
+		}
+	}
+}
+
+// Sweep removes expired tiles, which would otherwise only leave the cache
+// when read or evicted
+func (c *MemoryCache) Sweep() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	removed := 0
+	for _, elem := range c.items {
+		if c.expired(elem.Value.(*entry), now) {
+			c.remove(elem)
+			removed++
+		}
+	}
+	return removed
+}
+
+// maintain sweeps expired tiles every half TTL
+func (c *MemoryCache) maintain(ctx context.Context, log *zap.Logger) {
+	if c.maxAge <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(sweepInterval(c.maxAge))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if removed := c.Sweep(); removed > 0 {
+				log.Debug("Removed expired tiles", zap.Int("tiles", removed))
+			}
+		}
+	}
+}
+
+func (c *MemoryCache) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return Stats{
+		Type:       "memory",
+		Entries:    c.lruList.Len(),
+		Bytes:      c.bytes,
+		MaxEntries: c.maxSize,
+		MaxBytes:   c.maxBytes,
+	}
+}
+
+// snapshotVersion is written first in snapshots, which are dropped when it
+// changes
+const snapshotVersion = 1
+
+// snapshotEntry is one tile in a snapshot file
+type snapshotEntry struct {
+	Key     TileKey
+	Value   []byte
+	Expires time.Time
+}
+
+// SaveSnapshot writes all tiles to path, most recently used first, so a
+// restarted server can start with them
+func (c *MemoryCache) SaveSnapshot(path string) error {
+	temp, err := os.CreateTemp(filepath.Dir(path), ".cache-snapshot-*")
+	if err != nil {
+		return fmt.Errorf("failed to write cache snapshot: %w", err)
+	}
+	defer os.Remove(temp.Name())
+
+	writer := bufio.NewWriter(temp)
+	encoder := gob.NewEncoder(writer)
+	err = encoder.Encode(snapshotVersion)
+
+	c.mu.Lock()
+	for elem := c.lruList.Front(); elem != nil && err == nil; elem = elem.Next() {
+		ent := elem.Value.(*entry)
+		err = encoder.Encode(snapshotEntry{Key: ent.key, Value: ent.value, Expires: ent.expires})
+	}
+	c.mu.Unlock()
+
+	if err == nil {
+		err = writer.Flush()
+	}
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(temp.Name(), path)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write cache snapshot: %w", err)
+	}
+	return nil
+}
+
+// LoadSnapshot adds the tiles of a snapshot written by SaveSnapshot, up to
+// the limits of the cache, and returns how many. A missing file loads
+// nothing. Tiles of replaced images have other keys and are simply never
+// read again.
+func (c *MemoryCache) LoadSnapshot(path string) (int, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to read cache snapshot: %w", err)
+	}
+	defer file.Close()
+
+	decoder := gob.NewDecoder(bufio.NewReader(file))
+	var version int
+	if err := decoder.Decode(&version); err != nil || version != snapshotVersion {
+		return 0, errors.New("failed to read cache snapshot: unknown format")
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	loaded := 0
+	for {
+		var snapshot snapshotEntry
+		if err := decoder.Decode(&snapshot); err != nil {
+			if errors.Is(err, io.EOF) {
+				return loaded, nil
+			}
+			return loaded, fmt.Errorf("failed to read cache snapshot: %w", err)
+		}
+		// Most recently used come first, so stop at the first one that
+		// doesn't fit rather than evicting them
+		if c.lruList.Len() >= c.maxSize || (c.maxBytes > 0 && c.bytes+int64(len(snapshot.Value)) > c.maxBytes) {
+			return loaded, nil
+		}
+		if _, ok := c.items[snapshot.Key]; ok {
+			continue
+		}
+		ent := &entry{key: snapshot.Key, value: snapshot.Value, expires: snapshot.Expires}
+		if c.expired(ent, now) {
+			continue
+		}
+		c.items[snapshot.Key] = c.lruList.PushBack(ent)
+		c.bytes += int64(len(snapshot.Value))
+		loaded++
+	}
 }
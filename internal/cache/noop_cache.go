@@ -1,21 +1,30 @@
 package cache
 
+import "context"
+
 type NoopCache struct{}
 
 func NewNoopCache() *NoopCache {
 	return &NoopCache{}
 }
 
-func (c *NoopCache) Get(key TileKey) ([]byte, bool) {
+func (c *NoopCache) Get(ctx context.Context, key TileKey) ([]byte, bool) {
 	return nil, false
 }
 
-func (c *NoopCache) Set(key TileKey, value []byte) {
+func (c *NoopCache) Set(ctx context.Context, key TileKey, value []byte) {
 }
 
-func (c *NoopCache) Has(key TileKey) bool {
+func (c *NoopCache) Has(ctx context.Context, key TileKey) bool {
 	return false
 }
 
 func (c *NoopCache) Clear() {
 }
+
+func (c *NoopCache) DeleteImage(imageID string) {
+}
+
+func (c *NoopCache) Stats() Stats {
+	return Stats{Type: "disabled"}
+}
@@ -0,0 +1,163 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"mime"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"go.uber.org/zap"
+)
+
+// S3Cache keeps tiles in an S3 bucket, so they survive restarts of
+// stateless deployments. Keys follow the file cache layout, which lets a CDN
+// serve the bucket directly.
+// Structure: s3://{bucket}/{prefix}{imageID}_{tileSize}_{maxZoom}[_f{fingerprint}][_{variant}]/{z}/{x}_{y}.{format}
+type S3Cache struct {
+	client *minio.Client
+	bucket string
+	prefix string
+	// maxAge hides tiles stored longer ago (0 = never); deleting them is
+	// left to a lifecycle rule of the bucket
+	maxAge time.Duration
+	logger *zap.Logger
+}
+
+// s3Timeout bounds operations that don't come with a request context
+const s3Timeout = 5 * time.Minute
+
+// NewS3Cache stores tiles under prefix, which should be a "directory" of its
+// own: Clear deletes everything below it
+func NewS3Cache(client *minio.Client, bucket, prefix string, maxAge time.Duration, logger *zap.Logger) (*S3Cache, error) {
+	if bucket == "" {
+		return nil, errors.New("s3 cache needs a bucket")
+	}
+	return &S3Cache{client: client, bucket: bucket, prefix: prefix, maxAge: maxAge, logger: logger}, nil
+}
+
+func (c *S3Cache) objectKey(key TileKey) string {
+	return c.prefix + tilePath(key)
+}
+
+func (c *S3Cache) expired(modTime time.Time) bool {
+	return c.maxAge > 0 && time.Since(modTime) > c.maxAge
+}
+
+func (c *S3Cache) Has(ctx context.Context, key TileKey) bool {
+	info, err := c.client.StatObject(ctx, c.bucket, c.objectKey(key), minio.StatObjectOptions{})
+	return err == nil && !c.expired(info.LastModified)
+}
+
+func (c *S3Cache) Get(ctx context.Context, key TileKey) ([]byte, bool) {
+	object, err := c.client.GetObject(ctx, c.bucket, c.objectKey(key), minio.GetObjectOptions{})
+	if err != nil {
+		return nil, false
+	}
+	defer object.Close()
+
+	// The request is sent on first use, so a missing tile fails here
+	info, err := object.Stat()
+	if err != nil {
+		if code := minio.ToErrorResponse(err).Code; code != minio.NoSuchKey && ctx.Err() == nil {
+			c.logger.Warn("Failed to read tile from s3", zap.String("key", c.objectKey(key)), zap.Error(err))
+		}
+		return nil, false
+	}
+	if c.expired(info.LastModified) {
+		return nil, false
+	}
+
+	data, err := io.ReadAll(object)
+	if err != nil {
+		return nil, false
+	}
+	return data, true
+}
+
+func (c *S3Cache) Set(ctx context.Context, key TileKey, value []byte) {
+	// A tile that was rendered is worth keeping even if its request is gone
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), s3Timeout)
+	defer cancel()
+
+	contentType := mime.TypeByExtension("." + key.Format)
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	_, err := c.client.PutObject(ctx, c.bucket, c.objectKey(key), bytes.NewReader(value), int64(len(value)),
+		minio.PutObjectOptions{
+			ContentType: contentType,
+			// Keys contain the file fingerprint, so a tile never changes
+			CacheControl: "public, max-age=31536000, immutable",
+		})
+	if err != nil {
+		c.logger.Warn("Failed to write tile to s3", zap.String("key", c.objectKey(key)), zap.Error(err))
+	}
+}
+
+// removeBelow deletes every object under a key prefix
+func (c *S3Cache) removeBelow(ctx context.Context, prefix string) {
+	objects := c.client.ListObjects(ctx, c.bucket, minio.ListObjectsOptions{Prefix: prefix, Recursive: true})
+	for result := range c.client.RemoveObjects(ctx, c.bucket, objects, minio.RemoveObjectsOptions{}) {
+		c.logger.Warn("Failed to delete tile from s3", zap.String("key", result.ObjectName), zap.Error(result.Err))
+	}
+}
+
+func (c *S3Cache) Clear() {
+	ctx, cancel := context.WithTimeout(context.Background(), s3Timeout)
+	defer cancel()
+	c.removeBelow(ctx, c.prefix)
+}
+
+// DeleteImage deletes the tile "directories" of an image
+func (c *S3Cache) DeleteImage(imageID string) {
+	ctx, cancel := context.WithTimeout(context.Background(), s3Timeout)
+	defer cancel()
+
+	// Listing without recursion returns the directories as common prefixes
+	for object := range c.client.ListObjects(ctx, c.bucket, minio.ListObjectsOptions{Prefix: c.prefix}) {
+		if object.Err != nil {
+			c.logger.Warn("Failed to list tiles in s3", zap.Error(object.Err))
+			return
+		}
+		name := path.Base(strings.TrimPrefix(object.Key, c.prefix))
+		if strings.HasSuffix(object.Key, "/") && tileDirFrom(name, imageID) {
+			c.removeBelow(ctx, object.Key)
+		}
+	}
+}
+
+// RemoveOlder deletes the tiles written before cutoff. It lists the whole
+// bucket prefix, so it is meant for maintenance jobs rather than the server.
+func (c *S3Cache) RemoveOlder(cutoff time.Time) int {
+	ctx := context.Background()
+	old := make(chan minio.ObjectInfo)
+	removed := 0
+	go func() {
+		defer close(old)
+		for object := range c.client.ListObjects(ctx, c.bucket, minio.ListObjectsOptions{Prefix: c.prefix, Recursive: true}) {
+			if object.Err != nil {
+				c.logger.Warn("Failed to list tiles in s3", zap.Error(object.Err))
+				return
+			}
+			if object.LastModified.Before(cutoff) {
+				removed++
+				old <- object
+			}
+		}
+	}()
+	for result := range c.client.RemoveObjects(ctx, c.bucket, old, minio.RemoveObjectsOptions{}) {
+		c.logger.Warn("Failed to delete tile from s3", zap.String("key", result.ObjectName), zap.Error(result.Err))
+		removed--
+	}
+	return removed
+}
+
+// Stats reports no usage; counting would mean listing the bucket
+func (c *S3Cache) Stats() Stats {
+	return Stats{Type: "s3"}
+}
@@ -0,0 +1,90 @@
+package cache
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// TieredCache keeps recently used tiles in memory in front of the file
+// cache. Tiles are written to both; tiles read from disk are promoted back
+// into memory.
+type TieredCache struct {
+	memory *MemoryCache
+	file   *FileCache
+}
+
+func NewTieredCache(memory *MemoryCache, file *FileCache) *TieredCache {
+	return &TieredCache{memory: memory, file: file}
+}
+
+func (c *TieredCache) Has(ctx context.Context, key TileKey) bool {
+	return c.memory.Has(ctx, key) || c.file.Has(ctx, key)
+}
+
+func (c *TieredCache) Get(ctx context.Context, key TileKey) ([]byte, bool) {
+	if data, ok := c.memory.Get(ctx, key); ok {
+		return data, true
+	}
+	data, ok := c.file.Get(ctx, key)
+	if ok {
+		c.memory.Set(ctx, key, data)
+	}
+	return data, ok
+}
+
+func (c *TieredCache) Set(ctx context.Context, key TileKey, value []byte) {
+	c.memory.Set(ctx, key, value)
+	c.file.Set(ctx, key, value)
+}
+
+func (c *TieredCache) Clear() {
+	c.memory.Clear()
+	c.file.Clear()
+}
+
+func (c *TieredCache) DeleteImage(imageID string) {
+	c.memory.DeleteImage(imageID)
+	c.file.DeleteImage(imageID)
+}
+
+func (c *TieredCache) maintain(ctx context.Context, log *zap.Logger) {
+	go c.memory.maintain(ctx, log)
+	c.file.maintain(ctx, log)
+}
+
+// Stats reports the memory tier, the file tier isn't counted
+func (c *TieredCache) Stats() Stats {
+	stats := c.memory.Stats()
+	stats.Type = "tiered"
+	return stats
+}
+
+// SaveSnapshot saves the memory tier; the file tier persists anyway
+func (c *TieredCache) SaveSnapshot(path string) error {
+	return c.memory.SaveSnapshot(path)
+}
+
+func (c *TieredCache) LoadSnapshot(path string) (int, error) {
+	return c.memory.LoadSnapshot(path)
+}
+
+// Resize changes the limits of the memory tier
+func (c *TieredCache) Resize(maxSize int, maxBytes int64) {
+	c.memory.Resize(maxSize, maxBytes)
+}
+
+func (c *TieredCache) RemoveOrphans(current func(imageID, fingerprint string) bool) (dirs, temps int) {
+	return c.file.RemoveOrphans(current)
+}
+
+// RemoveOlder removes old tiles from the file tier; the memory tier only
+// lives as long as the process
+func (c *TieredCache) RemoveOlder(cutoff time.Time) int {
+	return c.file.RemoveOlder(cutoff)
+}
+
+func (c *TieredCache) Orphans(current func(imageID, fingerprint string) bool) []string {
+	return c.file.Orphans(current)
+}
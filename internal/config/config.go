@@ -1,10 +1,10 @@
 package config
 
 import (
-	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 )
 
 type Config struct {
@@ -12,6 +12,9 @@ type Config struct {
 	DataDir          string
 	WarmupLevels     int
 	WarmupWorkers    int
+	WarmupRate       int
+	WarmupZoom       string
+	WarmupImages     []string
 	CacheType        string
 	CacheMemoryTiles int
 	CacheFileDir     string
@@ -20,59 +23,340 @@ type Config struct {
 	LogLevel         string
 	UploadToken      string
 	MaxUploadSize    int64
+	// UploadQuotaBytes and UploadQuotaFiles limit each client IP and each
+	// upload token within UploadQuotaWindow (0 = unlimited)
+	UploadQuotaBytes  int64
+	UploadQuotaFiles  int
+	UploadQuotaWindow time.Duration
+	// AdminToken guards the admin API (empty = disabled)
+	AdminToken string
+	// ShareSecret signs share links (empty = disabled); ShareTTL is their
+	// default and longest lifetime
+	ShareSecret      string
+	ShareTTL         time.Duration
 	AllowedOrigin    string
 	PublicBaseURL    string
+	StaticDir        string
+	MaxDeadlineMs    int
+	PlaceholderTile  string
+	RenderBackend    string
+	RenderTimeout    time.Duration
+	SlowTileLog      time.Duration
+	OverzoomLevels   int
+	PaddingColor     string
+	PyramidOnUpload  bool
+	AllowDuplicates  bool
+	PyramidDir       string
+	PDFDPI           float64
+	EnableHEIF       bool
+	EnableJXL        bool
+	SVGTargetSize    int
+	EnableRAW        bool
+	AutoDisplayRange bool
+	ThumbnailSize    int
+	ThumbnailCrop    string
+	ScanRecursive    bool
+	WatchDataDir     bool
+	WatchDebounce    time.Duration
+	IndexFile        string
+	ScanWorkers      int
+	AlbumsFile       string
+	// AnalyticsFile keeps the view counters ("" = analytics disabled)
+	AnalyticsFile string
+	// MaxImagePixels and MaxImageDimension bound the images accepted on
+	// upload and scan (0 = unlimited)
+	MaxImagePixels    int64
+	MaxImageDimension int
+	BatchMaxTiles     int
+	BatchWorkers      int
+
+	CacheControlTiles   string
+	CacheControlMeta    string
+	CacheControlListing string
+
+	AccessLogExclude    []string
+	AccessLogTileSample int
+	AccessLogFile       string
+	AccessLogMaxSizeMB  int
+	AccessLogMaxBackups int
+
+	AuditLogFile string
+
+	// StorageBackend keeps the originals of uploads outside DATA_DIR:
+	// "none", "local" (StorageDir) or "s3"
+	StorageBackend   string
+	StorageDir       string
+	S3Endpoint       string
+	S3Region         string
+	S3Bucket         string
+	S3Prefix         string
+	S3AccessKey      string
+	S3SecretKey      string
+	StagingMaxSizeMB int
+
+	// RemoteURLPrefixes are the URL prefixes images may be registered from
+	// (empty = registering remote images is disabled)
+	RemoteURLPrefixes []string
+	RemoteTimeout     time.Duration
+
+	// MetadataDir holds everything the server writes itself (sidecars,
+	// thumbnails, caches, index), so DATA_DIR can be shared or read-only
+	MetadataDir string
+	// PreserveFilenames never renames or moves files found in DATA_DIR
+	PreserveFilenames bool
+	// ReadOnly rejects changes through the API and scans without writing to
+	// DATA_DIR or METADATA_DIR, for replicas of a shared library
+	ReadOnly bool
+
+	// CacheMemoryMB bounds the memory cache by tile bytes (0 = tiles only)
+	CacheMemoryMB int
+	// CacheTTL expires cached tiles that long after rendering (0 = never)
+	CacheTTL time.Duration
+	// CacheS3Bucket and CacheS3Prefix locate tiles of the s3 cache, which
+	// shares endpoint and credentials with the storage backend
+	CacheS3Bucket string
+	CacheS3Prefix string
+
+	// CacheFileMaxMB caps the file cache (0 = unlimited)
+	CacheFileMaxMB int
+	// CacheBoltFile is the database of the bolt cache
+	CacheBoltFile string
+
+	// RenderErrorTTL is how long an image whose file failed to open is answered
+	// without rendering again (0 = always retry)
+	RenderErrorTTL time.Duration
+
+	// CacheMemorySnapshot saves the memory cache there on shutdown and loads
+	// it on startup ("" = disabled)
+	CacheMemorySnapshot string
+
+	// CachePeers are the base URLs of all nodes of a cluster, CachePeerSelf
+	// the one of this node among them; CachePeerSecret authenticates the
+	// requests they forward to each other
+	CachePeers      []string
+	CachePeerSelf   string
+	CachePeerSecret string
+
+	// CacheJanitorInterval is how often the file cache is cleared of tiles
+	// of deleted or changed images (0 = never)
+	CacheJanitorInterval time.Duration
+
+	// LogFormat is "json" or "console"; LogOutput lists where the application
+	// log goes ("stdout", "stderr" or file paths)
+	LogFormat string
+	LogOutput []string
+
+	// VipsStatsInterval is how often libvips memory and threads are sampled
+	// for the log and metrics (0 = never)
+	VipsStatsInterval time.Duration
+
+	// invalid lists the settings whose values couldn't be parsed, which
+	// Validate reports
+	invalid []string
 }
 
+// Load reads the configuration from environment variables
 func Load() *Config {
+	loadMu.Lock()
+	defer loadMu.Unlock()
+	return load()
+}
+
+func load() *Config {
+	invalidSettings = nil
 	dataDir := getEnv("DATA_DIR", "/data")
+	metadataDir := getEnv("METADATA_DIR", dataDir)
 	cacheType := getEnv("CACHE", "memory")
 
 	cfg := &Config{
-		Port:             getEnvInt("PORT", 8080),
-		DataDir:          dataDir,
-		WarmupLevels:     getEnvInt("WARMUP_LEVELS", 1),
-		WarmupWorkers:    getEnvInt("WARMUP_WORKERS", 1),
-		CacheType:        cacheType,
-		CacheMemoryTiles: getEnvInt("CACHE_MEMORY_TILES", 2000),
-		CacheFileDir:     getEnv("CACHE_FILE_DIR", filepath.Join(dataDir, "cache")),
-		VipsMaxCacheMB:   getEnvInt("VIPS_MAX_CACHE_MB", 256),
-		VipsConcurrency:  getEnvInt("VIPS_CONCURRENCY", 1),
-		LogLevel:         getEnv("LOG_LEVEL", "info"),
-		UploadToken:      getEnv("UPLOAD_TOKEN", ""),
-		MaxUploadSize:    getEnvInt64("MAX_UPLOAD_SIZE", 4294967296), // 4GB default
-		AllowedOrigin:    getEnv("ALLOWED_ORIGIN", ""),
-		PublicBaseURL:    getEnv("PUBLIC_BASE_URL", "http://localhost:8080"),
+		Port:              getEnvInt("PORT", 8080),
+		DataDir:           dataDir,
+		WarmupLevels:      getEnvInt("WARMUP_LEVELS", 1),
+		WarmupWorkers:     getEnvInt("WARMUP_WORKERS", 1),
+		WarmupRate:        getEnvInt("WARMUP_RATE", 0),
+		WarmupZoom:        getEnv("WARMUP_ZOOM", ""),
+		WarmupImages:      getEnvList("WARMUP_IMAGES", nil),
+		CacheType:         cacheType,
+		CacheMemoryTiles:  getEnvInt("CACHE_MEMORY_TILES", 2000),
+		CacheFileDir:      getEnv("CACHE_FILE_DIR", filepath.Join(metadataDir, "cache")),
+		VipsMaxCacheMB:    getEnvInt("VIPS_MAX_CACHE_MB", 256),
+		VipsConcurrency:   getEnvInt("VIPS_CONCURRENCY", 1),
+		LogLevel:          getEnv("LOG_LEVEL", "info"),
+		UploadToken:       getEnv("UPLOAD_TOKEN", ""),
+		MaxUploadSize:     getEnvInt64("MAX_UPLOAD_SIZE", 4294967296), // 4GB default
+		UploadQuotaBytes:  getEnvInt64("UPLOAD_QUOTA_BYTES", 0),
+		UploadQuotaFiles:  getEnvInt("UPLOAD_QUOTA_FILES", 0),
+		UploadQuotaWindow: getEnvDuration("UPLOAD_QUOTA_WINDOW", 24*time.Hour),
+		AdminToken:        getEnv("ADMIN_TOKEN", ""),
+		ShareSecret:       getEnv("SHARE_SECRET", ""),
+		ShareTTL:          getEnvDuration("SHARE_TTL", 7*24*time.Hour),
+		AllowedOrigin:     getEnv("ALLOWED_ORIGIN", ""),
+		PublicBaseURL:     getEnv("PUBLIC_BASE_URL", "http://localhost:8080"),
+		StaticDir:         getEnv("STATIC_DIR", ""),
+		MaxDeadlineMs:     getEnvInt("MAX_DEADLINE_MS", 30000),
+		PlaceholderTile:   getEnv("PLACEHOLDER_TILE", ""),
+		RenderBackend:     getEnv("RENDER_BACKEND", "vips"),
+		RenderTimeout:     getEnvDuration("RENDER_TIMEOUT", 30*time.Second),
+		SlowTileLog:       getEnvDuration("SLOW_TILE_LOG", 0),
+		OverzoomLevels:    getEnvInt("OVERZOOM_LEVELS", 2),
+		PaddingColor:      getEnv("PADDING_COLOR", "#dddddd"),
+		PyramidOnUpload:   getEnvBool("PYRAMID_ON_UPLOAD", false),
+		AllowDuplicates:   getEnvBool("ALLOW_DUPLICATE_UPLOADS", false),
+		PyramidDir:        getEnv("PYRAMID_DIR", filepath.Join(metadataDir, "pyramids")),
+		PDFDPI:            float64(getEnvInt("PDF_DPI", 300)),
+		EnableHEIF:        getEnvBool("ENABLE_HEIF", true),
+		EnableJXL:         getEnvBool("ENABLE_JXL", true),
+		SVGTargetSize:     getEnvInt("SVG_TARGET_SIZE", 16384),
+		EnableRAW:         getEnvBool("ENABLE_RAW", false),
+		AutoDisplayRange:  getEnvBool("AUTO_DISPLAY_RANGE", true),
+		ThumbnailSize:     getEnvInt("THUMBNAIL_SIZE", 256),
+		ThumbnailCrop:     getEnv("THUMBNAIL_CROP", "attention"),
+		ScanRecursive:     getEnvBool("SCAN_RECURSIVE", true),
+		WatchDataDir:      getEnvBool("WATCH_DATA_DIR", true),
+		WatchDebounce:     getEnvDuration("WATCH_DEBOUNCE", 2*time.Second),
+		IndexFile:         getEnv("INDEX_FILE", filepath.Join(metadataDir, "index.db")),
+		ScanWorkers:       getEnvInt("SCAN_WORKERS", 4),
+		AlbumsFile:        getEnv("ALBUMS_FILE", filepath.Join(metadataDir, "albums.json")),
+		AnalyticsFile:     getEnv("ANALYTICS_FILE", filepath.Join(metadataDir, "analytics.json")),
+		MaxImagePixels:    getEnvInt64("MAX_IMAGE_PIXELS", 0),
+		MaxImageDimension: getEnvInt("MAX_IMAGE_DIMENSION", 0),
+		BatchMaxTiles:     getEnvInt("BATCH_MAX_TILES", 64),
+		BatchWorkers:      getEnvInt("BATCH_WORKERS", 4),
+
+		CacheControlTiles:   getEnv("CACHE_CONTROL_TILES", "public, max-age=31536000"),
+		CacheControlMeta:    getEnv("CACHE_CONTROL_META", "no-cache"),
+		CacheControlListing: getEnv("CACHE_CONTROL_LISTING", "no-cache"),
+
+		AccessLogExclude:    getEnvList("ACCESS_LOG_EXCLUDE", nil),
+		AccessLogTileSample: getEnvInt("ACCESS_LOG_TILE_SAMPLE", 1),
+		AccessLogFile:       getEnv("ACCESS_LOG_FILE", ""),
+		AccessLogMaxSizeMB:  getEnvInt("ACCESS_LOG_MAX_SIZE_MB", 100),
+		AccessLogMaxBackups: getEnvInt("ACCESS_LOG_MAX_BACKUPS", 5),
+
+		AuditLogFile: getEnv("AUDIT_LOG_FILE", ""),
+
+		StorageBackend:   getEnv("STORAGE_BACKEND", "none"),
+		StorageDir:       getEnv("STORAGE_DIR", ""),
+		S3Endpoint:       getEnv("S3_ENDPOINT", ""),
+		S3Region:         getEnv("S3_REGION", ""),
+		S3Bucket:         getEnv("S3_BUCKET", ""),
+		S3Prefix:         getEnv("S3_PREFIX", ""),
+		S3AccessKey:      getEnv("S3_ACCESS_KEY", ""),
+		S3SecretKey:      getEnv("S3_SECRET_KEY", ""),
+		StagingMaxSizeMB: getEnvInt("STAGING_MAX_SIZE_MB", 10240),
+
+		RemoteURLPrefixes: getEnvList("REMOTE_URL_PREFIXES", nil),
+		RemoteTimeout:     getEnvDuration("REMOTE_TIMEOUT", 30*time.Minute),
+
+		MetadataDir:       metadataDir,
+		PreserveFilenames: getEnvBool("PRESERVE_FILENAMES", false),
+		ReadOnly:          getEnvBool("READ_ONLY", false),
+
+		CacheMemoryMB: getEnvInt("CACHE_MEMORY_MB", 0),
+		CacheTTL:      getEnvDuration("CACHE_TTL", 0),
+		CacheS3Bucket: getEnv("CACHE_S3_BUCKET", getEnv("S3_BUCKET", "")),
+		CacheS3Prefix: getEnv("CACHE_S3_PREFIX", "tiles/"),
+
+		CacheFileMaxMB: getEnvInt("CACHE_FILE_MAX_MB", 0),
+		CacheBoltFile:  getEnv("CACHE_BOLT_FILE", filepath.Join(metadataDir, "tiles.db")),
+
+		RenderErrorTTL: getEnvDuration("RENDER_ERROR_TTL", 30*time.Second),
+
+		CacheMemorySnapshot: getEnv("CACHE_MEMORY_SNAPSHOT", ""),
+
+		CachePeers:      getEnvList("CACHE_PEERS", nil),
+		CachePeerSelf:   getEnv("CACHE_PEER_SELF", ""),
+		CachePeerSecret: getEnv("CACHE_PEER_SECRET", ""),
+
+		CacheJanitorInterval: getEnvDuration("CACHE_JANITOR_INTERVAL", time.Hour),
+
+		LogFormat: getEnv("LOG_FORMAT", "json"),
+		LogOutput: getEnvList("LOG_OUTPUT", []string{"stdout"}),
+
+		VipsStatsInterval: getEnvDuration("VIPS_STATS_INTERVAL", 30*time.Second),
 	}
 
+	// "none" disables the persistent index and analytics, an empty value
+	// means the default
+	if cfg.IndexFile == "none" {
+		cfg.IndexFile = ""
+	}
+	if cfg.AnalyticsFile == "none" {
+		cfg.AnalyticsFile = ""
+	}
+	cfg.invalid = invalidSettings
+
 	return cfg
 }
 
 func getEnv(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
+	if value := lookup(key); value != "" {
 		return value
 	}
 	return defaultValue
 }
 
 func getEnvInt(key string, defaultValue int) int {
-	if value := os.Getenv(key); value != "" {
+	if value := lookup(key); value != "" {
 		if intValue, err := strconv.Atoi(value); err == nil {
 			return intValue
 		}
+		invalidSetting(key, value)
 	}
 	return defaultValue
 }
 
 func getEnvInt64(key string, defaultValue int64) int64 {
-	if value := os.Getenv(key); value != "" {
+	if value := lookup(key); value != "" {
 		if intValue, err := strconv.ParseInt(value, 10, 64); err == nil {
 			return intValue
 		}
+		invalidSetting(key, value)
+	}
+	return defaultValue
+}
+
+func getEnvBool(key string, defaultValue bool) bool {
+	if value := lookup(key); value != "" {
+		if boolValue, err := strconv.ParseBool(value); err == nil {
+			return boolValue
+		}
+		invalidSetting(key, value)
 	}
 	return defaultValue
 }
 
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	if value := lookup(key); value != "" {
+		if duration, err := time.ParseDuration(value); err == nil {
+			return duration
+		}
+		invalidSetting(key, value)
+	}
+	return defaultValue
+}
+
+// getEnvList parses a comma-separated list, dropping empty items
+func getEnvList(key string, defaultValue []string) []string {
+	value := lookup(key)
+	if value == "" {
+		return defaultValue
+	}
+
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// WarmupEnabled reports whether the server warms up tiles at all
+func (c *Config) WarmupEnabled() bool {
+	return c.WarmupLevels > 0 || c.WarmupZoom != ""
+}
+
 func (c *Config) IsUploadPublic() bool {
 	return strings.TrimSpace(c.UploadToken) == ""
 }
@@ -0,0 +1,135 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"go.yaml.in/yaml/v3"
+)
+
+var (
+	// loadMu guards the state of the configuration being loaded
+	loadMu          sync.Mutex
+	fileSettings    map[string]string
+	fileUsed        map[string]bool
+	invalidSettings []string
+)
+
+// lookup returns the value of a setting, from the environment or else the
+// config file being loaded. Either may instead name a file holding the
+// value with {key}_FILE, as Docker and Kubernetes secrets are mounted.
+func lookup(key string) string {
+	if fileUsed != nil {
+		fileUsed[key] = true
+		fileUsed[key+"_FILE"] = true
+	}
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	path := os.Getenv(key + "_FILE")
+	if path == "" {
+		path = fileSettings[key+"_FILE"]
+	}
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			invalidSettings = append(invalidSettings, fmt.Sprintf("%s_FILE: %v", key, err))
+			return ""
+		}
+		// Files written by editors and echo end with a newline
+		return strings.TrimRight(string(data), "\r\n")
+	}
+	return fileSettings[key]
+}
+
+// invalidSetting records a value that couldn't be parsed, so its default
+// is not used silently
+func invalidSetting(key, value string) {
+	invalidSettings = append(invalidSettings, fmt.Sprintf("%s: invalid value %q", key, value))
+}
+
+// LoadFile loads the configuration from a YAML file, with environment
+// variables taking precedence. Keys are the environment variable names,
+// either flat or nested by their underscore-separated parts, e.g.
+//
+//	cache:
+//	  type: tiered
+//	  memory_mb: 512
+//	  file:
+//	    max_mb: 20480
+//
+// sets CACHE, CACHE_MEMORY_MB and CACHE_FILE_MAX_MB. Lists may be given as
+// YAML sequences. Unknown settings are an error.
+func LoadFile(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+	var root map[string]any
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+	settings := make(map[string]string)
+	if err := flattenSettings("", root, settings); err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+
+	loadMu.Lock()
+	defer loadMu.Unlock()
+	fileSettings, fileUsed = settings, make(map[string]bool)
+	defer func() { fileSettings, fileUsed = nil, nil }()
+
+	cfg := load()
+
+	var unknown []string
+	for key := range settings {
+		if !fileUsed[key] {
+			unknown = append(unknown, key)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return nil, fmt.Errorf("unknown settings in config file %s: %s", path, strings.Join(unknown, ", "))
+	}
+	return cfg, nil
+}
+
+// flattenSettings turns nested sections into environment variable names. A
+// "type" key names the setting of its section itself (cache.type is CACHE).
+func flattenSettings(prefix string, values map[string]any, settings map[string]string) error {
+	for name, value := range values {
+		key := strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+		switch {
+		case prefix != "" && key == "TYPE":
+			key = prefix
+		case prefix != "":
+			key = prefix + "_" + key
+		}
+
+		if nested, ok := value.(map[string]any); ok {
+			if err := flattenSettings(key, nested, settings); err != nil {
+				return err
+			}
+			continue
+		}
+		if _, ok := settings[key]; ok {
+			return fmt.Errorf("%s is set twice", key)
+		}
+		switch value := value.(type) {
+		case []any:
+			items := make([]string, len(value))
+			for i, item := range value {
+				items[i] = fmt.Sprint(item)
+			}
+			settings[key] = strings.Join(items, ",")
+		case nil:
+			settings[key] = ""
+		default:
+			settings[key] = fmt.Sprint(value)
+		}
+	}
+	return nil
+}
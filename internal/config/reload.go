@@ -0,0 +1,48 @@
+package config
+
+import "fmt"
+
+// Change is a setting that differs after a reload
+type Change struct {
+	Setting string
+	Old     string
+	New     string
+}
+
+// reloadable lists the settings a running server applies on SIGHUP; all
+// others need a restart
+var reloadable = []struct {
+	name  string
+	value func(*Config) any
+}{
+	{"LOG_LEVEL", func(c *Config) any { return c.LogLevel }},
+	{"ALLOWED_ORIGIN", func(c *Config) any { return c.AllowedOrigin }},
+	{"UPLOAD_QUOTA_BYTES", func(c *Config) any { return c.UploadQuotaBytes }},
+	{"UPLOAD_QUOTA_FILES", func(c *Config) any { return c.UploadQuotaFiles }},
+	{"UPLOAD_QUOTA_WINDOW", func(c *Config) any { return c.UploadQuotaWindow }},
+	{"CACHE_MEMORY_TILES", func(c *Config) any { return c.CacheMemoryTiles }},
+	{"CACHE_MEMORY_MB", func(c *Config) any { return c.CacheMemoryMB }},
+}
+
+// Reload returns a copy of c with the reloadable settings taken from next,
+// and the ones among them that changed. c itself is left as it is, since
+// requests may still be reading it.
+func (c *Config) Reload(next *Config) (*Config, []Change) {
+	updated := *c
+	updated.LogLevel = next.LogLevel
+	updated.AllowedOrigin = next.AllowedOrigin
+	updated.UploadQuotaBytes = next.UploadQuotaBytes
+	updated.UploadQuotaFiles = next.UploadQuotaFiles
+	updated.UploadQuotaWindow = next.UploadQuotaWindow
+	updated.CacheMemoryTiles = next.CacheMemoryTiles
+	updated.CacheMemoryMB = next.CacheMemoryMB
+
+	var changes []Change
+	for _, setting := range reloadable {
+		old, new := fmt.Sprint(setting.value(c)), fmt.Sprint(setting.value(&updated))
+		if old != new {
+			changes = append(changes, Change{Setting: setting.name, Old: old, New: new})
+		}
+	}
+	return &updated, changes
+}
@@ -0,0 +1,187 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+var (
+	cacheTypes      = []string{"memory", "file", "tiered", "bolt", "s3", "disabled"}
+	storageBackends = []string{"none", "local", "s3"}
+	renderBackends  = []string{"vips"}
+)
+
+// Validate checks the configuration before the server starts, so mistakes
+// fail at once rather than on the first request that needs the setting. It
+// creates the directories the server writes to and reports every problem
+// found, one per line.
+func (c *Config) Validate() error {
+	var errs []error
+	fail := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+	for _, invalid := range c.invalid {
+		errs = append(errs, errors.New(invalid))
+	}
+
+	if c.Port < 1 || c.Port > 65535 {
+		fail("PORT: %d is not a valid port", c.Port)
+	}
+	if c.LogFormat != "json" && c.LogFormat != "console" {
+		fail("LOG_FORMAT: unknown format %q (supported: json, console)", c.LogFormat)
+	}
+	for _, output := range c.LogOutput {
+		// zap also takes URLs of registered sinks, which aren't files
+		if output == "stdout" || output == "stderr" || strings.Contains(output, "://") {
+			continue
+		}
+		if err := checkAppendable(output); err != nil {
+			fail("LOG_OUTPUT: %w", err)
+		}
+	}
+
+	// With preserved file names and metadata elsewhere, only uploads and
+	// deletions write to DATA_DIR, so it may be mounted read-only
+	readOnlyData := c.ReadOnly || c.PreserveFilenames && c.MetadataDir != c.DataDir
+	if info, err := os.Stat(c.DataDir); err != nil {
+		fail("DATA_DIR: %w", err)
+	} else if !info.IsDir() {
+		fail("DATA_DIR: %s is not a directory", c.DataDir)
+	} else if !readOnlyData {
+		if err := checkWritable(c.DataDir); err != nil {
+			fail("DATA_DIR: %w (mount it read-only only with READ_ONLY=true, or PRESERVE_FILENAMES=true and a separate METADATA_DIR)", err)
+		}
+	}
+	if c.MetadataDir != c.DataDir && !c.ReadOnly {
+		if err := checkWritable(c.MetadataDir); err != nil {
+			fail("METADATA_DIR: %w", err)
+		}
+	}
+	if readOnlyData && !c.ReadOnly && c.EnableRAW {
+		fail("ENABLE_RAW: camera RAW files need a writable DATA_DIR, which PRESERVE_FILENAMES with a separate METADATA_DIR rules out")
+	}
+
+	if c.StaticDir != "" {
+		if info, err := os.Stat(c.StaticDir); err != nil {
+			fail("STATIC_DIR: %w", err)
+		} else if !info.IsDir() {
+			fail("STATIC_DIR: %s is not a directory", c.StaticDir)
+		}
+	}
+
+	if !slices.Contains(cacheTypes, c.CacheType) {
+		fail("CACHE: unknown cache type %q (supported: memory, file, tiered, bolt, s3, disabled)", c.CacheType)
+	}
+	switch c.CacheType {
+	case "file", "tiered":
+		if err := checkWritable(c.CacheFileDir); err != nil {
+			fail("CACHE_FILE_DIR: %w", err)
+		}
+	case "bolt":
+		if err := checkWritable(filepath.Dir(c.CacheBoltFile)); err != nil {
+			fail("CACHE_BOLT_FILE: %w", err)
+		}
+	case "s3":
+		if c.CacheS3Bucket == "" {
+			fail("CACHE_S3_BUCKET: the s3 cache needs a bucket (or S3_BUCKET)")
+		}
+	}
+	if c.CacheMemorySnapshot != "" && c.CacheType != "memory" && c.CacheType != "tiered" {
+		fail("CACHE_MEMORY_SNAPSHOT: only the memory and tiered cache keep tiles in memory, not %q", c.CacheType)
+	}
+	if c.CacheMemoryTiles < 1 && (c.CacheType == "memory" || c.CacheType == "tiered") {
+		fail("CACHE_MEMORY_TILES: must be at least 1")
+	}
+	if c.CacheMemoryMB < 0 || c.CacheFileMaxMB < 0 || c.CacheTTL < 0 {
+		fail("CACHE_MEMORY_MB, CACHE_FILE_MAX_MB and CACHE_TTL must not be negative")
+	}
+	if len(c.CachePeers) > 0 && c.CachePeerSelf == "" {
+		fail("CACHE_PEER_SELF: required with CACHE_PEERS, so the node knows which tiles it owns")
+	}
+	if c.CachePeerSelf != "" && !slices.ContainsFunc(c.CachePeers, func(peer string) bool {
+		return strings.TrimSuffix(peer, "/") == strings.TrimSuffix(c.CachePeerSelf, "/")
+	}) {
+		fail("CACHE_PEER_SELF: %s is not one of CACHE_PEERS", c.CachePeerSelf)
+	}
+	if len(c.CachePeers) > 0 && len(c.CachePeerSecret) < 16 {
+		fail("CACHE_PEER_SECRET: at least 16 characters required with CACHE_PEERS, so clients can't pass for a peer")
+	}
+
+	for _, prefix := range c.RemoteURLPrefixes {
+		parsed, err := url.Parse(prefix)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" || parsed.User != nil {
+			fail("REMOTE_URL_PREFIXES: %q is not an http or https URL with a host and no user info", prefix)
+		} else if !strings.HasSuffix(parsed.Path, "/") {
+			fail("REMOTE_URL_PREFIXES: %q must end in / (e.g. https://images.example.org/masters/)", prefix)
+		}
+	}
+
+	if !slices.Contains(renderBackends, c.RenderBackend) {
+		fail("RENDER_BACKEND: unknown backend %q (supported: vips)", c.RenderBackend)
+	}
+	if !slices.Contains(storageBackends, c.StorageBackend) {
+		fail("STORAGE_BACKEND: unknown backend %q (supported: none, local, s3)", c.StorageBackend)
+	}
+	switch c.StorageBackend {
+	case "local":
+		if c.StorageDir == "" {
+			fail("STORAGE_DIR: required with STORAGE_BACKEND=local")
+		} else if err := checkWritable(c.StorageDir); err != nil {
+			fail("STORAGE_DIR: %w", err)
+		}
+	case "s3":
+		if c.S3Bucket == "" {
+			fail("S3_BUCKET: required with STORAGE_BACKEND=s3")
+		}
+	}
+	if (c.S3AccessKey == "") != (c.S3SecretKey == "") {
+		fail("S3_ACCESS_KEY and S3_SECRET_KEY must be set together")
+	}
+
+	if c.VipsConcurrency < 0 || c.VipsMaxCacheMB < 0 {
+		fail("VIPS_CONCURRENCY and VIPS_MAX_CACHE_MB must not be negative")
+	}
+	if c.WarmupRate < 0 {
+		fail("WARMUP_RATE: must not be negative")
+	}
+	if c.ShareSecret != "" && len(c.ShareSecret) < 16 {
+		fail("SHARE_SECRET: must be at least 16 characters")
+	}
+	if c.ShareTTL <= 0 {
+		fail("SHARE_TTL: must be positive")
+	}
+	if c.MaxUploadSize < 1 {
+		fail("MAX_UPLOAD_SIZE: must be at least 1 byte")
+	}
+
+	return errors.Join(errs...)
+}
+
+// checkWritable creates dir if needed and checks that files can be
+// created in it
+func checkWritable(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(dir, ".gigaview-check-*")
+	if err != nil {
+		return fmt.Errorf("%s is not writable: %w", dir, err)
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// checkAppendable creates the file at path if needed and checks that it can
+// be appended to, the way the logger opens it
+func checkAppendable(path string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,43 @@
+package http
+
+import (
+	"strings"
+	"sync/atomic"
+)
+
+// accessLogFilter decides which requests make it into the access log
+type accessLogFilter struct {
+	exclude    []string
+	tileSample uint64
+	tileCount  atomic.Uint64
+}
+
+func newAccessLogFilter(exclude []string, tileSample int) *accessLogFilter {
+	if tileSample < 1 {
+		tileSample = 1
+	}
+	return &accessLogFilter{
+		exclude:    exclude,
+		tileSample: uint64(tileSample),
+	}
+}
+
+// shouldLog drops excluded paths and samples successful tile requests 1-in-N.
+// Failed requests are always logged so sampling never hides errors.
+func (f *accessLogFilter) shouldLog(path string, status int) bool {
+	for _, prefix := range f.exclude {
+		if strings.HasPrefix(path, prefix) {
+			return false
+		}
+	}
+
+	if status >= 400 || f.tileSample == 1 || !isTilePath(path) {
+		return true
+	}
+
+	return f.tileCount.Add(1)%f.tileSample == 1
+}
+
+func isTilePath(path string) bool {
+	return (strings.HasPrefix(path, "/api/images/") || strings.HasPrefix(path, "/api/compare/")) && strings.Contains(path, "/tiles/")
+}
@@ -0,0 +1,278 @@
+package http
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+
+	"gigaview/internal/audit"
+	"gigaview/internal/image_list"
+	"gigaview/internal/logger"
+)
+
+// maxImportSize bounds metadata dumps, which carry a placeholder per image
+const maxImportSize = 1 << 30
+
+// authorizeAdmin checks the admin token. Without ADMIN_TOKEN the admin API
+// doesn't exist, so it answers 404 rather than falling back to the upload
+// token.
+func (h *Handlers) authorizeAdmin(w http.ResponseWriter, r *http.Request) bool {
+	if h.settings().AdminToken == "" {
+		http.NotFound(w, r)
+		return false
+	}
+	if subtle.ConstantTimeCompare([]byte(h.requestToken(r)), []byte(h.settings().AdminToken)) != 1 {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+// HandleAdminUploads reports the upload quota usage of every client within
+// the current window (GET /api/admin/uploads)
+func (h *Handlers) HandleAdminUploads(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !h.authorizeAdmin(w, r) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"enabled":   h.quota.enabled(),
+		"window":    h.settings().UploadQuotaWindow.String(),
+		"max_bytes": h.settings().UploadQuotaBytes,
+		"max_files": h.settings().UploadQuotaFiles,
+		"clients":   h.quota.snapshot(),
+	})
+}
+
+// HandleAdminCache reports the usage of the tile cache (GET /api/admin/cache)
+func (h *Handlers) HandleAdminCache(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !h.authorizeAdmin(w, r) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(h.renderer.CacheStats())
+}
+
+// HandleAdminLogLevel reports (GET) or changes (PUT {"level": "debug"}) the
+// level of the application log, until the next restart or SIGHUP
+func (h *Handlers) HandleAdminLogLevel(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead && r.Method != http.MethodPut {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !h.authorizeAdmin(w, r) {
+		return
+	}
+	if h.logLevel == nil {
+		http.Error(w, "Log level can't be changed", http.StatusNotImplemented)
+		return
+	}
+
+	if r.Method == http.MethodPut {
+		var request struct {
+			Level string `json:"level"`
+		}
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1024)).Decode(&request); err != nil {
+			http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+			return
+		}
+		var level zapcore.Level
+		switch request.Level {
+		case "debug", "info", "warn", "error":
+			level = logger.ParseLevel(request.Level)
+		default:
+			http.Error(w, "level must be debug, info, warn or error", http.StatusBadRequest)
+			return
+		}
+		before := h.logLevel.Level().String()
+		h.logLevel.SetLevel(level)
+		h.recordAudit(r, audit.ActionLogLevel, "", before, level.String())
+		// Logged at warn so the change shows up at any level
+		h.log(r).Warn("Log level changed", zap.String("from", before), zap.String("to", level.String()))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(map[string]string{"level": h.logLevel.Level().String()})
+}
+
+// HandleAdminMetrics serves the metrics of the server in the Prometheus text
+// format (GET /api/admin/metrics)
+func (h *Handlers) HandleAdminMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !h.authorizeAdmin(w, r) {
+		return
+	}
+	if h.metrics == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := h.metrics.Write(w); err != nil {
+		h.log(r).Debug("Failed to write metrics", zap.Error(err))
+	}
+}
+
+// HandleAdminAnalytics reports the view counters: of all images, most
+// viewed first (GET /api/admin/analytics), or of one image with the heatmap
+// of its tile requests by zoom level (GET /api/admin/analytics/{id})
+func (h *Handlers) HandleAdminAnalytics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !h.authorizeAdmin(w, r) {
+		return
+	}
+	if h.analytics == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	var report interface{}
+	if ref := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/admin/analytics"), "/"); ref != "" {
+		imageID := h.scanner.ResolveID(ref)
+		if h.scanner.GetImageByID(imageID) == nil {
+			http.Error(w, "Image not found", http.StatusNotFound)
+			return
+		}
+		report = h.analytics.Report(imageID)
+	} else {
+		report = h.analytics.Summaries()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(report)
+}
+
+// HandleAdminWarmupStatus reports the progress of the tile warmup (GET
+// /api/admin/warmup/status)
+func (h *Handlers) HandleAdminWarmupStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !h.authorizeAdmin(w, r) {
+		return
+	}
+	if h.warmer == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(h.warmer.Status())
+}
+
+// HandleAdminWarmupControl pauses (POST /api/admin/warmup/pause) or
+// resumes (POST /api/admin/warmup/resume) the tile warmup and reports its
+// status, e.g. to keep the CPU for a traffic peak
+func (h *Handlers) HandleAdminWarmupControl(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !h.authorizeAdmin(w, r) {
+		return
+	}
+	if h.warmer == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	before := h.warmer.Status().Paused
+	if strings.HasSuffix(r.URL.Path, "/pause") {
+		h.warmer.Pause()
+		if !before {
+			h.recordAudit(r, audit.ActionWarmupPause, "", nil, nil)
+		}
+	} else {
+		h.warmer.Resume()
+		if before {
+			h.recordAudit(r, audit.ActionWarmupResume, "", nil, nil)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(h.warmer.Status())
+}
+
+// HandleAdminExport downloads the metadata of all images, albums and
+// collections as JSON (GET /api/admin/export), for backups and moving to
+// another instance
+func (h *Handlers) HandleAdminExport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !h.authorizeAdmin(w, r) {
+		return
+	}
+
+	export := h.scanner.Export()
+	filename := fmt.Sprintf("gigaview-export-%s.json", export.ExportedAt.UTC().Format("20060102-150405"))
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+	json.NewEncoder(w).Encode(export)
+}
+
+// HandleAdminImport restores a dump made by HandleAdminExport onto the
+// images of this instance (POST /api/admin/import) and reports what was
+// restored
+func (h *Handlers) HandleAdminImport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !h.authorizeAdmin(w, r) {
+		return
+	}
+
+	var export image_list.Export
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxImportSize)).Decode(&export); err != nil {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+
+	result, err := h.scanner.Import(r.Context(), &export)
+	switch {
+	case errors.Is(err, image_list.ErrInvalidExport):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case err != nil:
+		h.log(r).Error("Failed to import metadata", zap.Error(err))
+		http.Error(w, "Failed to import metadata", http.StatusInternalServerError)
+		return
+	}
+	h.recordAudit(r, audit.ActionImport, "", nil, result)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
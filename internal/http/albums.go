@@ -0,0 +1,209 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"gigaview/internal/audit"
+	"gigaview/internal/image_list"
+)
+
+// maxAlbumRequestSize bounds album request bodies, which list image IDs
+const maxAlbumRequestSize = 1 << 20
+
+// albumRequest is the body of album changes. Pointers tell absent fields
+// from empty ones.
+type albumRequest struct {
+	Name        *string  `json:"name"`
+	Description *string  `json:"description"`
+	Images      []string `json:"images"`
+	// Position is where POST .../images inserts (default: append)
+	Position *int `json:"position"`
+}
+
+// HandleAlbums serves the album API:
+//
+//	GET    /api/albums                list albums
+//	POST   /api/albums                create {"name", "description", "images"}
+//	GET    /api/albums/{id}           album with its images resolved
+//	PATCH  /api/albums/{id}           rename {"name", "description"}
+//	DELETE /api/albums/{id}           delete the album (not its images)
+//	PUT    /api/albums/{id}/images    replace and reorder {"images"}
+//	POST   /api/albums/{id}/images    add {"images", "position"}
+//	DELETE /api/albums/{id}/images    remove {"images"} or ?image=
+//
+// Changes need the upload token.
+func (h *Handlers) HandleAlbums(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/albums"), "/")
+	parts := strings.Split(path, "/")
+
+	switch {
+	case path == "":
+		h.handleAlbumList(w, r)
+	case len(parts) == 1:
+		h.handleAlbum(w, r, parts[0])
+	case len(parts) == 2 && parts[1] == "images":
+		h.handleAlbumImages(w, r, parts[0])
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (h *Handlers) handleAlbumList(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-cache")
+		json.NewEncoder(w).Encode(h.scanner.Albums())
+	case http.MethodPost:
+		request, ok := h.readAlbumRequest(w, r)
+		if !ok {
+			return
+		}
+		var name, description string
+		if request.Name != nil {
+			name = *request.Name
+		}
+		if request.Description != nil {
+			description = *request.Description
+		}
+		album, err := h.scanner.CreateAlbum(name, description, request.Images)
+		if !h.albumError(w, r, err) {
+			return
+		}
+		h.recordAudit(r, audit.ActionAlbumCreate, "", nil, album)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(album)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *Handlers) handleAlbum(w http.ResponseWriter, r *http.Request, albumID string) {
+	before := h.scanner.GetAlbum(albumID)
+	if before == nil {
+		http.Error(w, "Album not found", http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-cache")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"album":  before,
+			"images": h.scanner.AlbumImages(before),
+		})
+	case http.MethodPatch:
+		request, ok := h.readAlbumRequest(w, r)
+		if !ok {
+			return
+		}
+		album, err := h.scanner.UpdateAlbum(albumID, request.Name, request.Description)
+		if !h.albumError(w, r, err) {
+			return
+		}
+		h.recordAudit(r, audit.ActionAlbumUpdate, "", before, album)
+		writeAlbum(w, album)
+	case http.MethodDelete:
+		if !h.authorizeAlbumChange(w, r) {
+			return
+		}
+		if !h.albumError(w, r, h.scanner.DeleteAlbum(albumID)) {
+			return
+		}
+		h.recordAudit(r, audit.ActionAlbumDelete, "", before, nil)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *Handlers) handleAlbumImages(w http.ResponseWriter, r *http.Request, albumID string) {
+	if r.Method != http.MethodPut && r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	before := h.scanner.GetAlbum(albumID)
+	if before == nil {
+		http.Error(w, "Album not found", http.StatusNotFound)
+		return
+	}
+	request, ok := h.readAlbumRequest(w, r)
+	if !ok {
+		return
+	}
+
+	var album *image_list.Album
+	var err error
+	switch r.Method {
+	case http.MethodPut:
+		album, err = h.scanner.SetAlbumImages(albumID, request.Images)
+	case http.MethodPost:
+		position := -1
+		if request.Position != nil {
+			position = *request.Position
+		}
+		album, err = h.scanner.AddAlbumImages(albumID, request.Images, position)
+	case http.MethodDelete:
+		album, err = h.scanner.RemoveAlbumImages(albumID, append(request.Images, r.URL.Query()["image"]...))
+	}
+	if !h.albumError(w, r, err) {
+		return
+	}
+	h.recordAudit(r, audit.ActionAlbumUpdate, "", before, album)
+	writeAlbum(w, album)
+}
+
+// authorizeAlbumChange checks the upload token and answers 401 without it
+func (h *Handlers) authorizeAlbumChange(w http.ResponseWriter, r *http.Request) bool {
+	if !h.settings().IsUploadPublic() && h.requestToken(r) != h.settings().UploadToken {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+// readAlbumRequest authorizes a change and decodes its body, which may be
+// empty
+func (h *Handlers) readAlbumRequest(w http.ResponseWriter, r *http.Request) (albumRequest, bool) {
+	var request albumRequest
+	if !h.authorizeAlbumChange(w, r) {
+		return request, false
+	}
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAlbumRequestSize)).Decode(&request)
+	if err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		return request, false
+	}
+	return request, true
+}
+
+// albumError answers failed album changes and reports whether err was nil
+func (h *Handlers) albumError(w http.ResponseWriter, r *http.Request, err error) bool {
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, image_list.ErrAlbumNotFound):
+		http.Error(w, "Album not found", http.StatusNotFound)
+	case errors.Is(err, image_list.ErrInvalidAlbum):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		h.log(r).Error("Failed to save album", zap.Error(err))
+		http.Error(w, "Failed to save album", http.StatusInternalServerError)
+	}
+	return false
+}
+
+func writeAlbum(w http.ResponseWriter, album *image_list.Album) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(album)
+}
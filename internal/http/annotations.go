@@ -0,0 +1,157 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"gigaview/internal/audit"
+	"gigaview/internal/image_list"
+)
+
+// maxAnnotationRequestSize bounds annotation bodies, which may carry
+// polygons with thousands of points
+const maxAnnotationRequestSize = 1 << 20
+
+// annotationContentType is the media type of the Web Annotation protocol
+const annotationContentType = `application/ld+json; profile="` + image_list.AnnotationContext + `"`
+
+// handleAnnotations serves the annotations of an image:
+//
+//	GET    /api/images/{id}/annotations          AnnotationPage of all annotations
+//	POST   /api/images/{id}/annotations          create one
+//	GET    /api/images/{id}/annotations/{aid}    one annotation
+//	PUT    /api/images/{id}/annotations/{aid}    replace creator, body and target
+//	DELETE /api/images/{id}/annotations/{aid}    delete it
+//
+// Changes need the upload token.
+func (h *Handlers) handleAnnotations(w http.ResponseWriter, r *http.Request, imageID, annotationID string) {
+	switch {
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+	case r.Method == http.MethodPost && annotationID == "":
+	case (r.Method == http.MethodPut || r.Method == http.MethodDelete) && annotationID != "":
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		if annotationID == "" {
+			annotations, err := h.scanner.Annotations(imageID)
+			if !h.annotationError(w, r, err) {
+				return
+			}
+			items := make([]*image_list.Annotation, len(annotations))
+			for n, annotation := range annotations {
+				items[n] = h.annotationIRIs(imageID, annotation, false)
+			}
+			writeAnnotation(w, http.StatusOK, map[string]interface{}{
+				"@context": image_list.AnnotationContext,
+				"id":       h.annotationsURL(imageID),
+				"type":     "AnnotationPage",
+				"items":    items,
+			})
+			return
+		}
+		annotation, err := h.scanner.GetAnnotation(imageID, annotationID)
+		if !h.annotationError(w, r, err) {
+			return
+		}
+		writeAnnotation(w, http.StatusOK, h.annotationIRIs(imageID, annotation, true))
+		return
+	}
+
+	if !h.settings().IsUploadPublic() && h.requestToken(r) != h.settings().UploadToken {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if r.Method == http.MethodDelete {
+		before, err := h.scanner.GetAnnotation(imageID, annotationID)
+		if err == nil {
+			err = h.scanner.DeleteAnnotation(imageID, annotationID)
+		}
+		if !h.annotationError(w, r, err) {
+			return
+		}
+		h.recordAudit(r, audit.ActionAnnotationDelete, imageID, before, nil)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	var request image_list.Annotation
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAnnotationRequestSize)).Decode(&request); err != nil {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		annotation, err := h.scanner.CreateAnnotation(imageID, &request)
+		if !h.annotationError(w, r, err) {
+			return
+		}
+		h.recordAudit(r, audit.ActionAnnotationCreate, imageID, nil, annotation)
+		w.Header().Set("Location", h.annotationsURL(imageID)+"/"+annotation.ID)
+		writeAnnotation(w, http.StatusCreated, h.annotationIRIs(imageID, annotation, true))
+		return
+	}
+
+	before, err := h.scanner.GetAnnotation(imageID, annotationID)
+	if !h.annotationError(w, r, err) {
+		return
+	}
+	annotation, err := h.scanner.UpdateAnnotation(imageID, annotationID, &request)
+	if !h.annotationError(w, r, err) {
+		return
+	}
+	h.recordAudit(r, audit.ActionAnnotationUpdate, imageID, before, annotation)
+	writeAnnotation(w, http.StatusOK, h.annotationIRIs(imageID, annotation, true))
+}
+
+// annotationsURL is the IRI of the annotation collection of an image
+func (h *Handlers) annotationsURL(imageID string) string {
+	return strings.TrimSuffix(h.settings().PublicBaseURL, "/") + "/api/images/" + imageID + "/annotations"
+}
+
+// annotationIRIs returns a copy of a stored annotation with its ID and
+// target turned into URLs, and the context for top-level annotations
+func (h *Handlers) annotationIRIs(imageID string, annotation *image_list.Annotation, context bool) *image_list.Annotation {
+	copied := *annotation
+	copied.ID = h.annotationsURL(imageID) + "/" + annotation.ID
+	copied.Target.Source = strings.TrimSuffix(h.settings().PublicBaseURL, "/") + "/api/images/" + imageID
+	if context {
+		copied.Context = image_list.AnnotationContext
+	}
+	return &copied
+}
+
+// annotationError answers failed annotation requests and reports whether
+// err was nil
+func (h *Handlers) annotationError(w http.ResponseWriter, r *http.Request, err error) bool {
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, image_list.ErrImageNotFound):
+		http.Error(w, "Image not found", http.StatusNotFound)
+	case errors.Is(err, image_list.ErrAnnotationNotFound):
+		http.Error(w, "Annotation not found", http.StatusNotFound)
+	case errors.Is(err, image_list.ErrInvalidAnnotation):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, image_list.ErrAnnotationsUnavailable):
+		http.Error(w, "Annotations need the image index (INDEX_FILE)", http.StatusServiceUnavailable)
+	default:
+		h.log(r).Error("Failed to access annotations", zap.Error(err))
+		http.Error(w, "Failed to access annotations", http.StatusInternalServerError)
+	}
+	return false
+}
+
+func writeAnnotation(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", annotationContentType)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
@@ -0,0 +1,179 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"sync"
+
+	"go.uber.org/zap"
+
+	"gigaview/internal/render"
+)
+
+type batchTileRequest struct {
+	Tiles []batchTileCoord `json:"tiles"`
+	// Prefetch only fills the cache: cached tiles are skipped without being
+	// read and the response is a batchPrefetchSummary instead of the tiles
+	Prefetch bool `json:"prefetch"`
+}
+
+type batchTileCoord struct {
+	Z int `json:"z"`
+	X int `json:"x"`
+	Y int `json:"y"`
+}
+
+type batchTileResult struct {
+	coord  batchTileCoord
+	status int
+	tile   *render.TileResult
+	cached bool
+}
+
+type batchPrefetchSummary struct {
+	Cached   int `json:"cached"`
+	Rendered int `json:"rendered"`
+	Failed   int `json:"failed"`
+}
+
+// handleTileBatch renders a list of tiles in parallel and streams them back as
+// multipart/mixed, one part per tile in completion order. Each part carries
+// X-Tile-Z/X/Y and X-Tile-Status headers; failed tiles have an empty body.
+// With "prefetch" it renders the tiles missing from the cache and only
+// reports counts.
+func (h *Handlers) handleTileBatch(w http.ResponseWriter, r *http.Request, imageID string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req batchTileRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if len(req.Tiles) == 0 {
+		http.Error(w, "No tiles requested", http.StatusBadRequest)
+		return
+	}
+	if len(req.Tiles) > h.settings().BatchMaxTiles {
+		http.Error(w, fmt.Sprintf("Too many tiles (max %d)", h.settings().BatchMaxTiles), http.StatusBadRequest)
+		return
+	}
+	for _, coord := range req.Tiles {
+		if coord.Z < 0 || coord.X < 0 || coord.Y < 0 {
+			http.Error(w, "Coordinates must be non-negative", http.StatusBadRequest)
+			return
+		}
+	}
+
+	opts, err := parseTileOptions(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if h.scanner.GetImageByID(imageID) == nil {
+		http.Error(w, "Image not found", http.StatusNotFound)
+		return
+	}
+
+	workers := h.settings().BatchWorkers
+	if workers <= 0 {
+		workers = 1
+	}
+
+	coords := make(chan batchTileCoord)
+	results := make(chan batchTileResult)
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for coord := range coords {
+				if req.Prefetch && h.renderer.IsTileCached(r.Context(), imageID, coord.Z, coord.X, coord.Y, opts) {
+					results <- batchTileResult{coord: coord, status: http.StatusOK, cached: true}
+					continue
+				}
+				tile, err := h.renderTileWithDeadline(r, imageID, coord.Z, coord.X, coord.Y, opts)
+				if err != nil && !errors.Is(err, context.Canceled) && tileErrorStatus(err) == http.StatusInternalServerError {
+					h.log(r).Error("Failed to render batch tile", zap.Error(err))
+				}
+				results <- batchTileResult{coord: coord, status: tileErrorStatus(err), tile: tile}
+			}
+		}()
+	}
+
+	go func() {
+		defer close(coords)
+		for _, coord := range req.Tiles {
+			select {
+			case coords <- coord:
+			case <-r.Context().Done():
+				return
+			}
+		}
+	}()
+
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	if req.Prefetch {
+		var summary batchPrefetchSummary
+		for res := range results {
+			switch {
+			case res.cached:
+				summary.Cached++
+			case res.status == http.StatusOK:
+				summary.Rendered++
+			default:
+				summary.Failed++
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(summary)
+		return
+	}
+
+	mw := multipart.NewWriter(w)
+	w.Header().Set("Content-Type", "multipart/mixed; boundary="+mw.Boundary())
+	w.WriteHeader(http.StatusOK)
+
+	flusher, _ := w.(http.Flusher)
+
+	for res := range results {
+		header := textproto.MIMEHeader{}
+		header.Set("X-Tile-Z", fmt.Sprintf("%d", res.coord.Z))
+		header.Set("X-Tile-X", fmt.Sprintf("%d", res.coord.X))
+		header.Set("X-Tile-Y", fmt.Sprintf("%d", res.coord.Y))
+		header.Set("X-Tile-Status", fmt.Sprintf("%d", res.status))
+		if res.tile != nil {
+			header.Set("Content-Type", "image/jpeg")
+			header.Set("Content-Length", fmt.Sprintf("%d", res.tile.Size))
+			header.Set("ETag", `"`+res.tile.ETag+`"`)
+		}
+
+		part, err := mw.CreatePart(header)
+		if err != nil {
+			// Client is gone, drain remaining results so workers can exit
+			continue
+		}
+		if res.tile != nil {
+			part.Write(res.tile.Data)
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+
+	mw.Close()
+}
@@ -0,0 +1,140 @@
+package http
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	pathpkg "path"
+	"path/filepath"
+
+	"go.uber.org/zap"
+
+	"gigaview/internal/audit"
+	"gigaview/internal/cache"
+	"gigaview/internal/render"
+)
+
+// maxSeedTileSize skips files too large to be tiles
+const maxSeedTileSize = 16 << 20
+
+// cacheImportResult counts the tiles of an import
+type cacheImportResult struct {
+	Imported int `json:"imported"`
+	// Stale tiles belong to unknown images or other versions of their file
+	Stale int `json:"stale"`
+	// Invalid files aren't named like tiles or are too large
+	Invalid int `json:"invalid"`
+}
+
+// HandleAdminCacheImport seeds the tile cache with tiles rendered elsewhere
+// (POST /api/admin/cache/import). The body is a tar archive, optionally
+// gzipped, or ?dir= names a directory on the server; either holds tiles in
+// the file cache layout, e.g. a copy of another instance's CACHE_FILE_DIR.
+func (h *Handlers) HandleAdminCacheImport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !h.authorizeAdmin(w, r) {
+		return
+	}
+
+	var result cacheImportResult
+	seed := func(path string, size int64, read func() ([]byte, error)) error {
+		key, ok := cache.ParseTilePath(pathpkg.Clean(filepath.ToSlash(path)))
+		if !ok || size > maxSeedTileSize {
+			result.Invalid++
+			return nil
+		}
+		data, err := read()
+		if err != nil {
+			return err
+		}
+		err = h.renderer.SeedTile(r.Context(), key, data)
+		switch {
+		case errors.Is(err, render.ErrImageNotFound):
+			result.Stale++
+		case err != nil:
+			return err
+		default:
+			result.Imported++
+		}
+		return nil
+	}
+
+	var err error
+	if dir := r.URL.Query().Get("dir"); dir != "" {
+		err = seedFromDir(dir, seed)
+	} else {
+		err = seedFromTar(r.Body, seed)
+	}
+	if err != nil {
+		h.log(r).Warn("Tile import stopped", zap.Error(err), zap.Int("imported", result.Imported))
+		http.Error(w, "Failed to import tiles: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	h.recordAudit(r, audit.ActionCacheImport, "", nil, result)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// seedFromDir passes every file below dir to seed, by path relative to dir
+func seedFromDir(dir string, seed func(path string, size int64, read func() ([]byte, error)) error) error {
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		relative, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		return seed(relative, info.Size(), func() ([]byte, error) { return os.ReadFile(path) })
+	})
+}
+
+// seedFromTar passes every regular file of a tar archive to seed
+func seedFromTar(body io.Reader, seed func(path string, size int64, read func() ([]byte, error)) error) error {
+	buffered := bufio.NewReader(body)
+	var archive io.Reader = buffered
+	// gzip streams start with 1f 8b
+	if magic, _ := buffered.Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(buffered)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		archive = gz
+	}
+
+	reader := tar.NewReader(archive)
+	for {
+		header, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		err = seed(header.Name, header.Size, func() ([]byte, error) { return io.ReadAll(reader) })
+		if err != nil {
+			return err
+		}
+	}
+}
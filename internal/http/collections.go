@@ -0,0 +1,68 @@
+package http
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// HandleCollections serves the collection tree (/api/collections) and single
+// collections with their images (/api/collections/{path}?recursive=1)
+func (h *Handlers) HandleCollections(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	collectionPath := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/collections"), "/")
+	recursive, err := parseRecursive(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	version, modifiedAt := h.scanner.State()
+	setCacheControl(w, h.settings().CacheControlListing)
+
+	if collectionPath == "" {
+		etag := fmt.Sprintf(`W/"collections-%d"`, version)
+		writeConditionalJSON(w, r, etag, modifiedAt, h.scanner.Collections())
+		return
+	}
+
+	collection := h.scanner.GetCollection(collectionPath)
+	if collection == nil {
+		http.Error(w, "Collection not found", http.StatusNotFound)
+		return
+	}
+
+	response := map[string]interface{}{
+		"collection": collection,
+		"images":     h.scanner.GetImagesInCollection(collectionPath, recursive),
+	}
+	etag := fmt.Sprintf(`W/"collection-%d-%s"`, version, listingKey(collectionPath, recursive))
+	writeConditionalJSON(w, r, etag, modifiedAt, response)
+}
+
+// parseRecursive reads ?recursive=, which includes nested collections
+func parseRecursive(r *http.Request) (bool, error) {
+	raw := r.URL.Query().Get("recursive")
+	if raw == "" {
+		return false, nil
+	}
+	recursive, err := strconv.ParseBool(raw)
+	if err != nil {
+		return false, fmt.Errorf("invalid recursive")
+	}
+	return recursive, nil
+}
+
+// listingKey condenses a collection filter into an entity tag component,
+// since directory names may contain characters not allowed there
+func listingKey(collectionPath string, recursive bool) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s|%t", collectionPath, recursive)))
+	return hex.EncodeToString(sum[:])[:12]
+}
@@ -0,0 +1,98 @@
+package http
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"gigaview/internal/render"
+)
+
+// HandleCompare serves the shared pyramid of two images of the same size
+// (/api/compare/{idA}/{idB}/meta) and their difference or blend as tiles
+// (/api/compare/{idA}/{idB}/tiles/{z}/{x}/{y}.{ext}?mode=diff|blend)
+func (h *Handlers) HandleCompare(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/api/compare/")
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if len(parts) >= 2 {
+		parts[0], parts[1] = h.scanner.ResolveID(parts[0]), h.scanner.ResolveID(parts[1])
+	}
+
+	switch {
+	case len(parts) == 3 && parts[2] == "meta":
+		h.handleCompareMeta(w, r, parts[0], parts[1])
+	case len(parts) >= 6 && parts[2] == "tiles":
+		h.handleCompareTile(w, r, parts[0], parts[1], parts[3:])
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (h *Handlers) handleCompareMeta(w http.ResponseWriter, r *http.Request, imageA, imageB string) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	page, err := parsePage(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	meta, err := h.renderer.CompareMeta(imageA, imageB, page)
+	if errors.Is(err, render.ErrInvalidOptions) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	version, modifiedAt := h.scanner.State()
+	etag := fmt.Sprintf(`W/"compare-%s-%s-%d-p%d"`, imageA, imageB, version, page)
+	setCacheControl(w, h.settings().CacheControlMeta)
+
+	writeConditionalJSON(w, r, etag, modifiedAt, meta)
+}
+
+func (h *Handlers) handleCompareTile(w http.ResponseWriter, r *http.Request, imageA, imageB string, tileParts []string) {
+	z, x, y, opts, ok := h.parseTileRequest(w, r, tileParts)
+	if !ok {
+		return
+	}
+
+	compare, err := parseCompareOptions(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := h.deadlineContext(r)
+	defer cancel()
+
+	result, err := h.renderer.RenderCompareTile(ctx, imageA, imageB, z, x, y, opts, compare)
+	h.writeTile(w, r, imageA+"/"+imageB, z, x, y, opts.Format, result, err)
+}
+
+// parseCompareOptions reads ?mode=diff|blend and the blend ?opacity= (0-1)
+func parseCompareOptions(r *http.Request) (render.CompareOptions, error) {
+	compare := render.DefaultCompareOptions
+	query := r.URL.Query()
+
+	if mode := query.Get("mode"); mode != "" {
+		compare.Mode = mode
+	}
+	if raw := query.Get("opacity"); raw != "" {
+		opacity, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return compare, fmt.Errorf("invalid opacity")
+		}
+		compare.Opacity = render.RoundAdjustment(opacity)
+	}
+
+	return compare, compare.Validate()
+}
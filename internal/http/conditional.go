@@ -0,0 +1,61 @@
+package http
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// setCacheControl applies a configured Cache-Control policy. The value is used
+// verbatim (e.g. "no-store" or "public, max-age=60, stale-while-revalidate=600"),
+// "none" omits the header entirely.
+func setCacheControl(w http.ResponseWriter, policy string) {
+	if policy == "" || policy == "none" {
+		return
+	}
+	w.Header().Set("Cache-Control", policy)
+}
+
+// writeConditionalJSON writes payload as JSON with ETag/Last-Modified validators,
+// answering 304 when the client's copy is still current and skipping the body for HEAD
+func writeConditionalJSON(w http.ResponseWriter, r *http.Request, etag string, modifiedAt time.Time, payload interface{}) {
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Last-Modified", modifiedAt.UTC().Format(http.TimeFormat))
+
+	if isNotModified(r, etag, modifiedAt) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method == http.MethodHead {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	json.NewEncoder(w).Encode(payload)
+}
+
+// isNotModified evaluates If-None-Match, falling back to If-Modified-Since
+// only when no entity tag was sent (RFC 9110 section 13.2.2)
+func isNotModified(r *http.Request, etag string, modifiedAt time.Time) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		for _, candidate := range strings.Split(inm, ",") {
+			candidate = strings.TrimSpace(candidate)
+			if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+		}
+		return false
+	}
+
+	if ims := r.Header.Get("If-Modified-Since"); ims != "" {
+		if since, err := http.ParseTime(ims); err == nil {
+			return !modifiedAt.Truncate(time.Second).After(since)
+		}
+	}
+
+	return false
+}
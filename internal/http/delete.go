@@ -0,0 +1,53 @@
+package http
+
+import (
+	"errors"
+	"net/http"
+
+	"go.uber.org/zap"
+
+	"gigaview/internal/audit"
+	"gigaview/internal/image_list"
+)
+
+// handleImage handles DELETE /api/images/{id}, which removes the image with
+// its metadata, thumbnail and everything rendered from it
+func (h *Handlers) handleImage(w http.ResponseWriter, r *http.Request, imageID string) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !h.settings().IsUploadPublic() && h.requestToken(r) != h.settings().UploadToken {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	before := h.scanner.GetImageByID(imageID)
+	err := h.scanner.DeleteImage(r.Context(), imageID)
+	if errors.Is(err, image_list.ErrImageNotFound) {
+		http.Error(w, "Image not found", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, image_list.ErrImageInUse) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		h.log(r).Error("Failed to delete image", zap.String("image", imageID), zap.Error(err))
+		http.Error(w, "Failed to delete image", http.StatusInternalServerError)
+		return
+	}
+
+	// Drop the cached tiles right away rather than with the rescan, which
+	// drops the image from the list but may fail
+	h.renderer.InvalidateImage(imageID)
+	if err := h.scanner.Scan(); err != nil {
+		h.log(r).Warn("Failed to rescan after delete", zap.Error(err))
+	}
+
+	h.analytics.Forget(imageID)
+	h.recordAudit(r, audit.ActionDelete, imageID, before, nil)
+
+	w.WriteHeader(http.StatusNoContent)
+}
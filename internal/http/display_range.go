@@ -0,0 +1,112 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+
+	"go.uber.org/zap"
+
+	"gigaview/internal/audit"
+	"gigaview/internal/image_list"
+	"gigaview/internal/render"
+)
+
+// handleDisplayRange reads (GET), sets (PUT {"min":..,"max":..}) or resets to
+// automatic (DELETE) the value range high bit-depth images are stretched from
+func (h *Handlers) handleDisplayRange(w http.ResponseWriter, r *http.Request, imageID string) {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		h.getDisplayRange(w, r, imageID)
+	case http.MethodPut, http.MethodDelete:
+		h.updateDisplayRange(w, r, imageID)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *Handlers) getDisplayRange(w http.ResponseWriter, r *http.Request, imageID string) {
+	page, err := parsePage(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := h.deadlineContext(r)
+	defer cancel()
+
+	displayRange, auto, err := h.renderer.DisplayRange(ctx, imageID, page)
+	if errors.Is(err, context.Canceled) {
+		return
+	}
+	if errors.Is(err, render.ErrImageNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		http.Error(w, "Display range deadline exceeded", http.StatusGatewayTimeout)
+		return
+	}
+	if err != nil {
+		h.log(r).Error("Failed to compute display range", zap.String("image", imageID), zap.Error(err))
+		http.Error(w, "Failed to compute display range", http.StatusInternalServerError)
+		return
+	}
+
+	response := map[string]interface{}{
+		"auto":          auto,
+		"display_range": displayRange,
+	}
+
+	version, modifiedAt := h.scanner.State()
+	etag := fmt.Sprintf(`W/"display-range-%s-%d-p%d"`, imageID, version, page)
+	setCacheControl(w, h.settings().CacheControlMeta)
+
+	writeConditionalJSON(w, r, etag, modifiedAt, response)
+}
+
+func (h *Handlers) updateDisplayRange(w http.ResponseWriter, r *http.Request, imageID string) {
+	if !h.settings().IsUploadPublic() && h.requestToken(r) != h.settings().UploadToken {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	before := h.scanner.GetImageByID(imageID)
+	if before == nil {
+		http.Error(w, "Image not found", http.StatusNotFound)
+		return
+	}
+
+	var displayRange *image_list.DisplayRange
+	if r.Method == http.MethodPut {
+		displayRange = &image_list.DisplayRange{}
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(displayRange); err != nil {
+			http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+			return
+		}
+		if math.IsNaN(displayRange.Min) || math.IsNaN(displayRange.Max) || displayRange.Max <= displayRange.Min {
+			http.Error(w, "max must be greater than min", http.StatusBadRequest)
+			return
+		}
+	}
+
+	if err := h.scanner.SetDisplayRange(imageID, displayRange); err != nil {
+		h.log(r).Error("Failed to save display range", zap.String("image", imageID), zap.Error(err))
+		http.Error(w, "Failed to save display range", http.StatusInternalServerError)
+		return
+	}
+	if err := h.scanner.Scan(); err != nil {
+		h.log(r).Warn("Failed to rescan after display range change", zap.Error(err))
+	}
+
+	h.recordAudit(r, audit.ActionDisplayRange, imageID, before.DisplayRange, displayRange)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"auto":          displayRange == nil,
+		"display_range": displayRange,
+	})
+}
@@ -1,42 +1,182 @@
 package http
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"net/http"
 	"os"
+	"path"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/uuid"
 	"go.uber.org/zap"
 
+	"gigaview/internal/analytics"
+	"gigaview/internal/audit"
 	"gigaview/internal/config"
 	"gigaview/internal/image_list"
 	"gigaview/internal/image_renderer"
+	"gigaview/internal/logger"
+	"gigaview/internal/metrics"
+	"gigaview/internal/render"
+	"gigaview/public"
 )
 
 type Handlers struct {
-	config   *config.Config
-	logger   *zap.Logger
-	scanner  *image_list.Scanner
-	renderer *image_renderer.Renderer
+	// config is swapped as a whole when settings are reloaded
+	config       atomic.Pointer[config.Config]
+	logger       *zap.Logger
+	accessLog    *zap.Logger
+	accessFilter *accessLogFilter
+	auditLog     *audit.Log
+	scanner      *image_list.Scanner
+	renderer     render.TileRenderer
+	placeholder  *placeholderTile
+	quota        *uploadQuota
+	peers        *peerPool
+	// logLevel is the level of the application logger, nil if it can't be
+	// changed
+	logLevel *zap.AtomicLevel
+	// metrics are served by the admin API, nil if none are collected
+	metrics *metrics.Registry
+	// warmer reports the warmup progress, nil if there is no warmup
+	warmer *image_renderer.Warmer
+	// static holds the frontend, STATIC_DIR or the built-in copy
+	static fs.FS
+	// analytics counts views and tile requests, nil if disabled
+	analytics *analytics.Recorder
 }
 
-func New(config *config.Config, logger *zap.Logger, scanner *image_list.Scanner, renderer *image_renderer.Renderer) *Handlers {
-	return &Handlers{
-		config:   config,
-		logger:   logger,
-		scanner:  scanner,
-		renderer: renderer,
+func New(config *config.Config, logger *zap.Logger, accessLog *zap.Logger, auditLog *audit.Log, scanner *image_list.Scanner, renderer render.TileRenderer) *Handlers {
+	placeholder, err := loadPlaceholderTile(config.PlaceholderTile)
+	if err != nil {
+		logger.Warn("Failed to load placeholder tile, serving plain 404s", zap.Error(err))
+	}
+	peers := newPeerPool(config.CachePeerSelf, config.CachePeers, config.CachePeerSecret)
+	if peers != nil && !slices.Contains(peers.peers, peers.self) {
+		logger.Warn("CACHE_PEER_SELF is not in CACHE_PEERS, forwarding every tile", zap.String("self", config.CachePeerSelf))
+	}
+
+	h := &Handlers{
+		logger:       logger,
+		accessLog:    accessLog,
+		accessFilter: newAccessLogFilter(config.AccessLogExclude, config.AccessLogTileSample),
+		auditLog:     auditLog,
+		scanner:      scanner,
+		renderer:     renderer,
+		placeholder:  placeholder,
+		quota:        newUploadQuota(config.UploadQuotaWindow, config.UploadQuotaBytes, config.UploadQuotaFiles),
+		peers:        peers,
+		static:       public.Files,
+	}
+	if config.StaticDir != "" {
+		h.static = os.DirFS(config.StaticDir)
+	}
+	h.config.Store(config)
+	return h
+}
+
+// UseLogLevel lets the admin API change level, the level of the
+// application logger; call it before serving
+func (h *Handlers) UseLogLevel(level zap.AtomicLevel) {
+	h.logLevel = &level
+}
+
+// UseMetrics lets the admin API serve the metrics in registry; call it
+// before serving
+func (h *Handlers) UseMetrics(registry *metrics.Registry) {
+	h.metrics = registry
+}
+
+// UseWarmup lets the admin API report the progress of warmer; call it
+// before serving
+func (h *Handlers) UseWarmup(warmer *image_renderer.Warmer) {
+	h.warmer = warmer
+}
+
+// UseAnalytics records views and tile requests in recorder and lets the
+// admin API report them; call it before serving
+func (h *Handlers) UseAnalytics(recorder *analytics.Recorder) {
+	h.analytics = recorder
+}
+
+// warmUp renders the first zoom levels of a new image in the background,
+// like the startup warmup does for the whole library
+func (h *Handlers) warmUp(imageInfo *image_list.ImageInfo) {
+	if h.warmer != nil && h.settings().WarmupEnabled() {
+		h.warmer.Enqueue(*imageInfo)
 	}
 }
 
+// settings returns the configuration in effect
+func (h *Handlers) settings() *config.Config {
+	return h.config.Load()
+}
+
+// Reload applies the reloadable settings of cfg, as returned by
+// config.Config.Reload, to requests from now on
+func (h *Handlers) Reload(cfg *config.Config) {
+	h.quota.setLimits(cfg.UploadQuotaWindow, cfg.UploadQuotaBytes, cfg.UploadQuotaFiles)
+	h.config.Store(cfg)
+}
+
+// Routes registers all endpoints and wraps them with the standard middleware chain
+func (h *Handlers) Routes() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/api/images", h.HandleImages)
+	mux.HandleFunc("/api/images/", h.HandleImageRoutes)
+	mux.HandleFunc("/api/upload", h.writable(h.HandleUpload))
+	mux.HandleFunc("/api/upload/remote", h.writable(h.HandleRemoteUpload))
+	mux.HandleFunc("/api/mosaics", h.writable(h.HandleMosaics))
+	mux.HandleFunc("/api/compare/", h.HandleCompare)
+	mux.HandleFunc("/api/collections", h.HandleCollections)
+	mux.HandleFunc("/api/collections/", h.HandleCollections)
+	mux.HandleFunc("/api/albums", h.writable(h.HandleAlbums))
+	mux.HandleFunc("/api/albums/", h.writable(h.HandleAlbums))
+	mux.HandleFunc("/api/tags", h.HandleTags)
+	mux.HandleFunc("/api/scan/status", h.HandleScanStatus)
+	mux.HandleFunc("/api/admin/uploads", h.HandleAdminUploads)
+	mux.HandleFunc("/api/admin/cache", h.HandleAdminCache)
+	mux.HandleFunc("/api/admin/cache/import", h.HandleAdminCacheImport)
+	mux.HandleFunc("/api/admin/loglevel", h.HandleAdminLogLevel)
+	mux.HandleFunc("/api/admin/metrics", h.HandleAdminMetrics)
+	mux.HandleFunc("/api/admin/warmup/status", h.HandleAdminWarmupStatus)
+	mux.HandleFunc("/api/admin/warmup/pause", h.HandleAdminWarmupControl)
+	mux.HandleFunc("/api/admin/warmup/resume", h.HandleAdminWarmupControl)
+	mux.HandleFunc("/api/admin/analytics", h.HandleAdminAnalytics)
+	mux.HandleFunc("/api/admin/analytics/", h.HandleAdminAnalytics)
+	mux.HandleFunc("/api/admin/export", h.HandleAdminExport)
+	mux.HandleFunc("/api/admin/import", h.writable(h.HandleAdminImport))
+	mux.HandleFunc("/healthz", h.HandleHealthz)
+	mux.HandleFunc("/s/", h.HandleShare)
+	mux.HandleFunc("/wmts", h.HandleWMTS)
+	mux.HandleFunc("/wmts/", h.HandleWMTS)
+	mux.HandleFunc("/", h.HandleStatic)
+
+	return h.CORSMiddleware(h.RequestLoggingMiddleware(mux))
+}
+
 func (h *Handlers) RequestLoggingMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		requestID := uuid.New().String()
+		requestID := r.Header.Get("X-Request-ID")
+		if !isValidRequestID(requestID) {
+			requestID = uuid.New().String()
+		}
+		w.Header().Set("X-Request-ID", requestID)
+		r = r.WithContext(logger.WithRequestID(r.Context(), requestID))
+
 		start := time.Now()
 
 		ip := h.extractIP(r)
@@ -45,10 +185,14 @@ func (h *Handlers) RequestLoggingMiddleware(next http.Handler) http.Handler {
 
 		next.ServeHTTP(wrapped, r)
 
+		if !h.accessFilter.shouldLog(r.URL.Path, wrapped.statusCode) {
+			return
+		}
+
 		duration := time.Since(start)
 		bytes := wrapped.bytesWritten
 
-		h.logger.Info("request",
+		h.accessLog.Info("request",
 			zap.String("request_id", requestID),
 			zap.String("ip", ip),
 			zap.String("method", r.Method),
@@ -61,13 +205,67 @@ func (h *Handlers) RequestLoggingMiddleware(next http.Handler) http.Handler {
 	})
 }
 
+// writable answers requests that would change the library with 403 when
+// READ_ONLY is set; reads go through
+func (h *Handlers) writable(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !h.rejectReadOnly(w, r) {
+			next(w, r)
+		}
+	}
+}
+
+// rejectReadOnly answers requests other than GET, HEAD and OPTIONS with 403
+// when READ_ONLY is set, and reports whether it did
+func (h *Handlers) rejectReadOnly(w http.ResponseWriter, r *http.Request) bool {
+	if !h.settings().ReadOnly {
+		return false
+	}
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return false
+	}
+	http.Error(w, "Server is read-only", http.StatusForbidden)
+	return true
+}
+
+// requestToken extracts the bearer token from the Authorization header or ?token=
+func (h *Handlers) requestToken(r *http.Request) string {
+	if authHeader := r.Header.Get("Authorization"); strings.HasPrefix(authHeader, "Bearer ") {
+		return strings.TrimPrefix(authHeader, "Bearer ")
+	}
+	return r.URL.Query().Get("token")
+}
+
+// recordAudit writes a mutating operation to the audit log. Failures are
+// logged but never fail the request that already succeeded.
+func (h *Handlers) recordAudit(r *http.Request, action, imageID string, before, after interface{}) {
+	event := audit.Event{
+		Action:    action,
+		ImageID:   imageID,
+		Actor:     audit.ActorID(h.requestToken(r)),
+		IP:        h.extractIP(r),
+		RequestID: logger.RequestID(r.Context()),
+		Before:    before,
+		After:     after,
+	}
+	if err := h.auditLog.Record(event); err != nil {
+		h.log(r).Error("Failed to write audit log", zap.String("action", action), zap.Error(err))
+	}
+}
+
+// log returns the handler logger annotated with the request ID
+func (h *Handlers) log(r *http.Request) *zap.Logger {
+	return logger.FromContext(r.Context(), h.logger)
+}
+
 func (h *Handlers) CORSMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		origin := r.Header.Get("Origin")
 		allowedOrigin := ""
 
-		if h.config.AllowedOrigin != "" {
-			allowedOrigin = h.config.AllowedOrigin
+		if h.settings().AllowedOrigin != "" {
+			allowedOrigin = h.settings().AllowedOrigin
 		} else {
 			host := r.Host
 			if origin != "" && strings.HasPrefix(origin, "http://"+host) || strings.HasPrefix(origin, "https://"+host) {
@@ -79,8 +277,10 @@ func (h *Handlers) CORSMiddleware(next http.Handler) http.Handler {
 
 		if allowedOrigin != "" {
 			w.Header().Set("Access-Control-Allow-Origin", allowedOrigin)
-			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+			w.Header().Set("Access-Control-Allow-Methods", "GET, HEAD, POST, PUT, DELETE, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Deadline-Ms, X-Request-ID")
+			w.Header().Set("Access-Control-Expose-Headers", "X-Request-ID, ETag, Last-Modified")
+			w.Header().Set("Timing-Allow-Origin", allowedOrigin)
 		}
 
 		if r.Method == "OPTIONS" {
@@ -93,113 +293,125 @@ func (h *Handlers) CORSMiddleware(next http.Handler) http.Handler {
 }
 
 func (h *Handlers) HandleImages(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	images := h.scanner.GetImages()
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(images)
-}
+	version, modifiedAt := h.scanner.State()
+	setCacheControl(w, h.settings().CacheControlListing)
 
-func (h *Handlers) HandleUpload(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	query, err := parseListingQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if query.isZero() {
+		etag := fmt.Sprintf(`W/"images-%d"`, version)
+		writeConditionalJSON(w, r, etag, modifiedAt, h.scanner.GetImages())
 		return
 	}
 
-	if !h.config.IsUploadPublic() {
-		token := ""
-		if authHeader := r.Header.Get("Authorization"); authHeader != "" {
-			if strings.HasPrefix(authHeader, "Bearer ") {
-				token = strings.TrimPrefix(authHeader, "Bearer ")
-			}
-		}
-		if token == "" {
-			token = r.URL.Query().Get("token")
-		}
-
-		if token != h.config.UploadToken {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	// ?collection= limits the listing to one collection ("/" for the top level)
+	images := h.scanner.GetImages()
+	if query.hasCollection {
+		if h.scanner.GetCollection(query.collection) == nil {
+			http.Error(w, "Collection not found", http.StatusNotFound)
 			return
 		}
+		images = h.scanner.GetImagesInCollection(query.collection, query.recursive)
 	}
 
-	r.Body = http.MaxBytesReader(w, r.Body, h.config.MaxUploadSize)
+	etag := fmt.Sprintf(`W/"images-%d-%s"`, version, query.key())
+	writeConditionalJSON(w, r, etag, modifiedAt, query.apply(images))
+}
 
-	err := r.ParseMultipartForm(32 << 20)
-	if err != nil {
-		http.Error(w, "Failed to parse multipart form", http.StatusBadRequest)
+func (h *Handlers) HandleUpload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	file, header, err := r.FormFile("file")
-	if err != nil {
-		http.Error(w, "No file provided", http.StatusBadRequest)
+	if !h.settings().IsUploadPublic() && h.requestToken(r) != h.settings().UploadToken {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
-	defer file.Close()
-
-	ext := strings.ToLower(filepath.Ext(header.Filename))
-	allowedExts := map[string]bool{
-		".tif":  true,
-		".tiff": true,
-		".jpg":  true,
-		".jpeg": true,
-		".png":  true,
-		".webp": true,
-	}
 
-	if !allowedExts[ext] {
-		http.Error(w, "Invalid file extension", http.StatusBadRequest)
+	upload, ok := h.receiveUpload(w, r)
+	if !ok {
 		return
 	}
-
-	tempFile, err := os.CreateTemp(os.TempDir(), "upload_*"+ext)
-	if err != nil {
-		h.logger.Error("Failed to create temp file", zap.Error(err))
-		http.Error(w, "Failed to save file", http.StatusInternalServerError)
+	tempPath, checksum := upload.path, upload.checksum
+
+	// Tags may be repeated fields, comma-separated or both
+	meta := image_list.UploadMetadata{
+		Title:         r.FormValue("title"),
+		CopyrightText: r.FormValue("copyright_text"),
+		CopyrightLink: r.FormValue("copyright_link"),
+		UploadedBy:    audit.ActorID(h.requestToken(r)),
+	}
+	for _, field := range r.MultipartForm.Value["tags"] {
+		meta.Tags = append(meta.Tags, strings.Split(field, ",")...)
+	}
+	if err := meta.Normalize(); err != nil {
+		os.Remove(tempPath)
+		h.releaseQuota(upload)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	tempPath := tempFile.Name()
 
-	_, err = io.Copy(tempFile, file)
-	if err != nil {
-		tempFile.Close()
+	if existing := h.scanner.FindBySHA256(checksum); existing != nil && !h.settings().AllowDuplicates {
 		os.Remove(tempPath)
-		h.logger.Error("Failed to copy file", zap.Error(err))
-		http.Error(w, "Failed to save file", http.StatusInternalServerError)
+		h.releaseQuota(upload)
+		h.log(r).Info("Duplicate upload, keeping the existing image",
+			zap.String("id", existing.ID), zap.String("filename", upload.filename))
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"id":        existing.ID,
+			"name":      existing.OriginalFilename,
+			"saved":     false,
+			"duplicate": true,
+		})
 		return
 	}
-	tempFile.Close()
-
-	copyrightText := r.FormValue("copyright_text")
-	copyrightLink := r.FormValue("copyright_link")
 
-	imageID, err := h.scanner.ProcessUploadedFile(tempPath, header.Filename, copyrightText, copyrightLink)
+	imageID, err := h.scanner.ProcessUploadedFile(r.Context(), tempPath, upload.filename, checksum, meta)
 	if err != nil {
 		if _, statErr := os.Stat(tempPath); statErr == nil {
 			os.Remove(tempPath)
 		}
-		h.logger.Error("Failed to process uploaded file", zap.Error(err))
+		h.releaseQuota(upload)
+		// Pages past the first are only measured once the file is scanned
+		if errors.Is(err, image_list.ErrImageTooLarge) {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		h.log(r).Error("Failed to process uploaded file", zap.Error(err))
 		http.Error(w, "Failed to process file", http.StatusInternalServerError)
 		return
 	}
 
 	err = h.scanner.Scan()
 	if err != nil {
-		h.logger.Warn("Failed to rescan after upload", zap.Error(err))
+		h.log(r).Warn("Failed to rescan after upload", zap.Error(err))
 	}
 
 	// Get image info for response
 	imageInfo := h.scanner.GetImageByID(imageID)
 	if imageInfo == nil {
-		h.logger.Warn("Uploaded image not found after scan", zap.String("id", imageID))
+		h.log(r).Warn("Uploaded image not found after scan", zap.String("id", imageID))
 		http.Error(w, "Failed to retrieve uploaded image", http.StatusInternalServerError)
 		return
 	}
 
+	h.recordAudit(r, audit.ActionUpload, imageID, nil, imageInfo)
+	h.warmUp(imageInfo)
+
+	if h.settings().PyramidOnUpload {
+		go h.generatePyramid(context.WithoutCancel(r.Context()), imageID)
+	}
+
 	response := map[string]interface{}{
 		"id":    imageID,
 		"name":  imageInfo.OriginalFilename,
@@ -210,6 +422,118 @@ func (h *Handlers) HandleUpload(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(response)
 }
 
+// receivedUpload is an uploaded file saved to a temporary path
+type receivedUpload struct {
+	path     string
+	filename string
+	// checksum is the hex SHA-256 of the content
+	checksum string
+	// clients and size are what was reserved against the quota
+	clients []string
+	size    int64
+}
+
+// releaseQuota takes an upload that stored nothing back off the quota
+func (h *Handlers) releaseQuota(upload *receivedUpload) {
+	h.quota.release(upload.clients, upload.size)
+}
+
+// receiveUpload saves the "file" part of a multipart upload to a temporary
+// file, hashing it on the way, and validates its content. It answers the
+// request itself on failure.
+func (h *Handlers) receiveUpload(w http.ResponseWriter, r *http.Request) (*receivedUpload, bool) {
+	// Clients already at their quota are turned away before the body is read
+	clients := h.quotaClients(r)
+	if ok, retry := h.quota.check(clients); !ok {
+		rejectOverQuota(w, retry)
+		return nil, false
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, h.settings().MaxUploadSize)
+
+	err := r.ParseMultipartForm(32 << 20)
+	if err != nil {
+		http.Error(w, "Failed to parse multipart form", http.StatusBadRequest)
+		return nil, false
+	}
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		http.Error(w, "No file provided", http.StatusBadRequest)
+		return nil, false
+	}
+	defer file.Close()
+
+	ext := strings.ToLower(filepath.Ext(header.Filename))
+	// MIRAX slides span a directory of files and can't be uploaded as one file
+	if !h.scanner.Supports(ext) || ext == ".mrxs" {
+		http.Error(w, "Invalid file extension", http.StatusBadRequest)
+		return nil, false
+	}
+
+	tempFile, err := os.CreateTemp(os.TempDir(), "upload_*"+ext)
+	if err != nil {
+		h.log(r).Error("Failed to create temp file", zap.Error(err))
+		http.Error(w, "Failed to save file", http.StatusInternalServerError)
+		return nil, false
+	}
+	tempPath := tempFile.Name()
+
+	// Hash while copying, so duplicates are found without reading the file again
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tempFile, hash), file)
+	if err != nil {
+		tempFile.Close()
+		os.Remove(tempPath)
+		h.log(r).Error("Failed to copy file", zap.Error(err))
+		http.Error(w, "Failed to save file", http.StatusInternalServerError)
+		return nil, false
+	}
+	tempFile.Close()
+
+	// A renamed file of another type must not reach the data directory
+	if err := h.scanner.ValidateUpload(tempPath); err != nil {
+		os.Remove(tempPath)
+		if errors.Is(err, image_list.ErrInvalidContent) {
+			h.log(r).Warn("Rejected upload", zap.String("filename", header.Filename), zap.Error(err))
+			http.Error(w, "File content doesn't match its extension", http.StatusBadRequest)
+			return nil, false
+		}
+		if errors.Is(err, image_list.ErrImageTooLarge) {
+			h.log(r).Warn("Rejected upload", zap.String("filename", header.Filename), zap.Error(err))
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return nil, false
+		}
+		h.log(r).Error("Failed to validate upload", zap.Error(err))
+		http.Error(w, "Failed to save file", http.StatusInternalServerError)
+		return nil, false
+	}
+
+	if ok, retry := h.quota.reserve(clients, size); !ok {
+		os.Remove(tempPath)
+		h.log(r).Warn("Upload over quota", zap.Strings("clients", clients), zap.Int64("bytes", size))
+		rejectOverQuota(w, retry)
+		return nil, false
+	}
+
+	return &receivedUpload{
+		path:     tempPath,
+		filename: header.Filename,
+		checksum: hex.EncodeToString(hash.Sum(nil)),
+		clients:  clients,
+		size:     size,
+	}, true
+}
+
+// generatePyramid builds the static tile pyramid for a freshly uploaded image.
+// It runs detached from the upload request, which has already been answered.
+func (h *Handlers) generatePyramid(ctx context.Context, imageID string) {
+	if err := h.renderer.GeneratePyramid(ctx, imageID); err != nil {
+		logger.FromContext(ctx, h.logger).Error("Failed to generate static pyramid",
+			zap.String("image", imageID), zap.Error(err))
+	}
+}
+
 func (h *Handlers) HandleHealthz(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -229,65 +553,198 @@ func (h *Handlers) HandleImageRoutes(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	imageID := parts[0]
+	// Every image route takes the slug in place of the ID
+	imageID := h.scanner.ResolveID(parts[0])
+
+	// Share links and tile batches are POSTs that change nothing
+	if !(len(parts) >= 2 && (parts[1] == "share" || parts[1] == "tiles")) && h.rejectReadOnly(w, r) {
+		return
+	}
 
 	switch {
+	case len(parts) == 1 && imageID != "":
+		h.handleImage(w, r, imageID)
+	case len(parts) == 2 && parts[1] == "content":
+		h.handleImageContent(w, r, imageID)
 	case len(parts) == 2 && parts[1] == "meta":
 		h.handleImageMetaWithID(w, r, imageID)
+	case len(parts) == 2 && parts[1] == "histogram":
+		h.handleImageHistogram(w, r, imageID)
+	case len(parts) == 2 && parts[1] == "pixel":
+		h.handleImagePixel(w, r, imageID)
+	case len(parts) == 2 && parts[1] == "display-range":
+		h.handleDisplayRange(w, r, imageID)
+	case len(parts) == 2 && parts[1] == "tags":
+		h.handleImageTags(w, r, imageID)
+	case len(parts) == 2 && parts[1] == "slug":
+		h.handleImageSlug(w, r, imageID)
+	case len(parts) == 2 && parts[1] == "thumbnail":
+		h.handleThumbnail(w, r, imageID)
+	case len(parts) == 2 && parts[1] == "annotations":
+		h.handleAnnotations(w, r, imageID, "")
+	case len(parts) == 3 && parts[1] == "annotations":
+		h.handleAnnotations(w, r, imageID, parts[2])
+	case len(parts) == 2 && parts[1] == "share":
+		h.handleImageShare(w, r, imageID)
+	case len(parts) == 3 && parts[1] == "tiles" && parts[2] == "batch":
+		h.handleTileBatch(w, r, imageID)
 	case len(parts) >= 5 && parts[1] == "tiles":
 		h.handleTileWithParams(w, r, imageID, parts[2:])
+	case len(parts) >= 5 && parts[1] == "xyz":
+		h.handleXYZTile(w, r, imageID, parts[2:])
 	default:
 		http.NotFound(w, r)
 	}
 }
 
 func (h *Handlers) HandleStatic(w http.ResponseWriter, r *http.Request) {
-	path := r.URL.Path
-	if path == "/" {
-		path = "/index.html"
+	name := strings.TrimPrefix(path.Clean(r.URL.Path), "/")
+	if name == "" {
+		name = "index.html"
 	}
-
-	filePath := filepath.Join("public", path)
-
-	if !strings.HasPrefix(filepath.Clean(filePath), "public") {
+	if !fs.ValidPath(name) {
 		http.Error(w, "Forbidden", http.StatusForbidden)
 		return
 	}
+	// Unknown paths get the index, so deep links of a client-side router
+	// work; the API keeps its 404s
+	if info, err := fs.Stat(h.static, name); err != nil || info.IsDir() {
+		if name == "api" || strings.HasPrefix(name, "api/") {
+			http.NotFound(w, r)
+			return
+		}
+		name = "index.html"
+	}
 
 	// If serving index.html, replace the placeholder with the actual base URL
-	if path == "/index.html" {
-		data, err := os.ReadFile(filePath)
+	if name == "index.html" {
+		data, err := fs.ReadFile(h.static, name)
 		if err != nil {
 			http.Error(w, "Internal server error", http.StatusInternalServerError)
 			return
 		}
 
-		content := strings.ReplaceAll(string(data), "__PUBLIC_BASE_URL__", h.config.PublicBaseURL)
+		content := strings.ReplaceAll(string(data), "__PUBLIC_BASE_URL__", h.settings().PublicBaseURL)
 		w.Header().Set("Content-Type", "text/html; charset=utf-8")
 		w.Write([]byte(content))
 		return
 	}
 
-	http.ServeFile(w, r, filePath)
+	http.ServeFileFS(w, r, h.static, name)
 }
 
 func (h *Handlers) handleImageMetaWithID(w http.ResponseWriter, r *http.Request, imageID string) {
-	if r.Method != http.MethodGet {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	meta, err := h.renderer.GetImageMeta(imageID)
+	page, err := parsePage(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	meta, err := h.renderer.GetImageMeta(imageID, page)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusNotFound)
 		return
 	}
+	// The viewer asks for the metadata once each time it opens an image
+	if r.Method == http.MethodGet {
+		h.analytics.View(imageID)
+	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(meta)
+	version, modifiedAt := h.scanner.State()
+	etag := fmt.Sprintf(`W/"meta-%s-%d-p%d"`, imageID, version, page)
+	setCacheControl(w, h.settings().CacheControlMeta)
+
+	writeConditionalJSON(w, r, etag, modifiedAt, meta)
 }
 
 func (h *Handlers) handleTileWithParams(w http.ResponseWriter, r *http.Request, imageID string, tileParts []string) {
+	tileParts, tms := splitTileScheme(r, tileParts)
+	z, x, y, opts, ok := h.parseTileRequest(w, r, tileParts)
+	if !ok {
+		return
+	}
+
+	if tms {
+		rows, err := h.renderer.TileRows(imageID, opts.Page, z)
+		if err != nil || y >= rows {
+			h.writeTileNotFound(w, r)
+			return
+		}
+		y = rows - 1 - y
+	}
+
+	if h.serveStaticTile(w, r, imageID, z, x, y, opts) {
+		h.countTile(r, imageID, z, x, y)
+		return
+	}
+	if status := h.forwardTile(w, r, imageID, z, x, y); status != 0 {
+		if status < http.StatusBadRequest {
+			h.countTile(r, imageID, z, x, y)
+		}
+		return
+	}
+
+	result, err := h.renderTileWithDeadline(r, imageID, z, x, y, opts)
+	if err == nil {
+		h.countTile(r, imageID, z, x, y)
+	}
+	h.writeTile(w, r, imageID, z, x, y, opts.Format, result, err)
+}
+
+// countTile records a served tile in the analytics. HEAD requests only ask
+// for the size, and tiles forwarded by a peer were counted there; unknown
+// images are never counted, so random IDs can't grow the analytics file.
+func (h *Handlers) countTile(r *http.Request, imageID string, z, x, y int) {
+	if r.Method == http.MethodGet && !h.fromPeer(r) && h.scanner.GetImageByID(imageID) != nil {
+		h.analytics.Tile(imageID, z, x, y)
+	}
+}
+
+// handleXYZTile serves web-mercator tiles of georeferenced images
+func (h *Handlers) handleXYZTile(w http.ResponseWriter, r *http.Request, imageID string, tileParts []string) {
+	tileParts, tms := splitTileScheme(r, tileParts)
+	z, x, y, opts, ok := h.parseTileRequest(w, r, tileParts)
+	if !ok {
+		return
+	}
+
+	if tms {
+		if z > 30 || y >= 1<<z {
+			h.writeTileNotFound(w, r)
+			return
+		}
+		y = 1<<z - 1 - y
+	}
+
+	if h.forwardTile(w, r, "xyz/"+imageID, z, x, y) != 0 {
+		return
+	}
+
+	ctx, cancel := h.deadlineContext(r)
+	defer cancel()
+
+	result, err := h.renderer.RenderXYZTile(ctx, imageID, z, x, y, opts)
+	h.writeTile(w, r, imageID, z, x, y, opts.Format, result, err)
+}
+
+// splitTileScheme detects TMS addressing, either as a /tms/ path prefix or
+// ?scheme=tms, and strips the prefix from the tile path. TMS counts rows from
+// the bottom, so y has to be flipped.
+func splitTileScheme(r *http.Request, tileParts []string) ([]string, bool) {
+	if len(tileParts) > 3 && tileParts[0] == "tms" {
+		return tileParts[1:], true
+	}
+	return tileParts, r.URL.Query().Get("scheme") == "tms"
+}
+
+// parseTileRequest validates the method and parses {z}/{x}/{y}.{ext} plus the
+// render options. It writes the error response and returns ok=false on failure.
+func (h *Handlers) parseTileRequest(w http.ResponseWriter, r *http.Request, tileParts []string) (z, x, y int, opts render.TileOptions, ok bool) {
 	if r.Method != http.MethodGet && r.Method != http.MethodHead {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
@@ -298,7 +755,6 @@ func (h *Handlers) handleTileWithParams(w http.ResponseWriter, r *http.Request,
 		return
 	}
 
-	var z, x, y int
 	if _, err := fmt.Sscanf(tileParts[0], "%d", &z); err != nil {
 		http.Error(w, "Invalid zoom level", http.StatusBadRequest)
 		return
@@ -321,7 +777,7 @@ func (h *Handlers) handleTileWithParams(w http.ResponseWriter, r *http.Request,
 	}
 
 	format := strings.TrimPrefix(ext, ".")
-	if format != "jpg" && format != "jpeg" && format != "webp" {
+	if format != "jpg" && format != "jpeg" && format != "webp" && format != "png" {
 		http.Error(w, "Invalid format", http.StatusBadRequest)
 		return
 	}
@@ -330,23 +786,53 @@ func (h *Handlers) handleTileWithParams(w http.ResponseWriter, r *http.Request,
 		format = "jpeg"
 	}
 
-	result, err := h.renderer.RenderTile(imageID, z, x, y)
+	opts, err := parseTileOptions(r)
 	if err != nil {
-		h.logger.Error("Failed to render tile", zap.Error(err))
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	opts.Format = format
+
+	return z, x, y, opts, true
+}
+
+// writeTile writes a rendered tile or maps the render error to a response
+func (h *Handlers) writeTile(w http.ResponseWriter, r *http.Request, imageID string, z, x, y int, format string, result *render.TileResult, err error) {
+	if errors.Is(err, context.Canceled) {
+		// Client went away, nobody is left to answer
+		return
+	}
+	switch tileErrorStatus(err) {
+	case http.StatusGatewayTimeout:
+		h.log(r).Warn("Tile render deadline exceeded",
+			zap.String("image", imageID), zap.Int("z", z), zap.Int("x", x), zap.Int("y", y))
+		http.Error(w, "Tile render deadline exceeded", http.StatusGatewayTimeout)
+		return
+	case http.StatusNotFound:
+		h.writeTileNotFound(w, r)
+		return
+	case http.StatusBadRequest:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case http.StatusBadGateway:
+		// Logged when it first failed
+		h.writeTileError(w, r, http.StatusBadGateway)
+		return
+	case http.StatusInternalServerError:
+		h.log(r).Error("Failed to render tile", zap.Error(err))
+		http.Error(w, "Failed to render tile", http.StatusInternalServerError)
 		return
 	}
 
 	w.Header().Set("ETag", `"`+result.ETag+`"`)
-	w.Header().Set("Cache-Control", "public, max-age=31536000")
+	setCacheControl(w, h.settings().CacheControlTiles)
+	if len(result.Timing) > 0 {
+		w.Header().Set("Server-Timing", serverTiming(result.Timing))
+	}
 	w.Header().Set("Content-Length", fmt.Sprintf("%d", result.Size))
 	w.Header().Set("X-Tile-Bytes", fmt.Sprintf("%d", result.Size))
 
-	contentType := "image/jpeg"
-	if format == "webp" {
-		contentType = "image/webp"
-	}
-	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Type", "image/"+format)
 
 	// HEAD request doesn't send body
 	if r.Method == http.MethodHead {
@@ -357,6 +843,184 @@ func (h *Handlers) handleTileWithParams(w http.ResponseWriter, r *http.Request,
 	w.Write(result.Data)
 }
 
+// handleThumbnail serves the square gallery thumbnail generated on scan
+func (h *Handlers) handleThumbnail(w http.ResponseWriter, r *http.Request, imageID string) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	path := h.scanner.ThumbnailPath(imageID)
+	if path == "" {
+		http.Error(w, "Thumbnail not found", http.StatusNotFound)
+		return
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		http.Error(w, "Thumbnail not found", http.StatusNotFound)
+		return
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		http.Error(w, "Thumbnail not found", http.StatusNotFound)
+		return
+	}
+
+	// Thumbnails are regenerated when the source changes, so clients revalidate
+	setCacheControl(w, h.settings().CacheControlMeta)
+	w.Header().Set("Content-Type", "image/jpeg")
+	http.ServeContent(w, r, "", stat.ModTime(), file)
+}
+
+// serverTiming formats render stages as a Server-Timing header value
+func serverTiming(stages []render.TimingStage) string {
+	metrics := make([]string, len(stages))
+	for i, stage := range stages {
+		metrics[i] = fmt.Sprintf("%s;dur=%.2f", stage.Name, float64(stage.Duration.Microseconds())/1000)
+	}
+	return strings.Join(metrics, ", ")
+}
+
+// serveStaticTile serves a tile straight from the pre-generated pyramid when
+// one exists for the request. Static pyramids only hold plain JPEG tiles of
+// the first page, so anything else falls back to dynamic rendering.
+func (h *Handlers) serveStaticTile(w http.ResponseWriter, r *http.Request, imageID string, z, x, y int, opts render.TileOptions) bool {
+	if opts.Format != "jpeg" || opts.Page > 0 || !opts.Adjustments.IsNeutral() || len(opts.Bands) > 0 || opts.Colormap != "" {
+		return false
+	}
+
+	path := h.renderer.StaticTilePath(imageID, z, x, y)
+	if path == "" {
+		return false
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return false
+	}
+
+	w.Header().Set("ETag", fmt.Sprintf(`"%s-%d-%d-%d-s%d"`, imageID, z, x, y, stat.ModTime().Unix()))
+	setCacheControl(w, h.settings().CacheControlTiles)
+	w.Header().Set("X-Tile-Bytes", fmt.Sprintf("%d", stat.Size()))
+	w.Header().Set("Content-Type", "image/jpeg")
+
+	// ServeContent copies through ReadFrom, which lets net/http use sendfile
+	http.ServeContent(w, r, "", stat.ModTime(), file)
+	return true
+}
+
+// writeTileNotFound responds with 404, using the placeholder tile as body when configured
+func (h *Handlers) writeTileNotFound(w http.ResponseWriter, r *http.Request) {
+	h.writeTileError(w, r, http.StatusNotFound)
+}
+
+// tileErrorStatus maps a RenderTile error to the HTTP status used for the
+// tile, by single tile requests and batches alike
+func tileErrorStatus(err error) int {
+	switch {
+	case err == nil:
+		return http.StatusOK
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout
+	case errors.Is(err, render.ErrImageNotFound), errors.Is(err, render.ErrTileOutOfBounds):
+		return http.StatusNotFound
+	case errors.Is(err, render.ErrInvalidOptions):
+		return http.StatusBadRequest
+	case errors.Is(err, render.ErrRenderFailed):
+		return http.StatusBadGateway
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// writeTileError responds with status, using the placeholder tile as body
+// when configured
+func (h *Handlers) writeTileError(w http.ResponseWriter, r *http.Request, status int) {
+	if h.placeholder == nil {
+		message := "Tile not found"
+		if status != http.StatusNotFound {
+			message = "Image failed to render, retry later"
+		}
+		http.Error(w, message, status)
+		return
+	}
+
+	w.Header().Set("Content-Type", h.placeholder.contentType)
+	w.Header().Set("Content-Length", fmt.Sprintf("%d", len(h.placeholder.data)))
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(status)
+
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	w.Write(h.placeholder.data)
+}
+
+// renderTileWithDeadline renders a tile bound to the request context, which is
+// canceled on client disconnect and additionally limited by the X-Deadline-Ms budget.
+func (h *Handlers) renderTileWithDeadline(r *http.Request, imageID string, z, x, y int, opts render.TileOptions) (*render.TileResult, error) {
+	ctx, cancel := h.deadlineContext(r)
+	defer cancel()
+
+	return h.renderer.RenderTile(ctx, imageID, z, x, y, opts)
+}
+
+// deadlineContext returns the request context, limited by X-Deadline-Ms when set
+func (h *Handlers) deadlineContext(r *http.Request) (context.Context, context.CancelFunc) {
+	if deadline := h.parseDeadline(r); deadline > 0 {
+		return context.WithTimeout(r.Context(), deadline)
+	}
+	return context.WithCancel(r.Context())
+}
+
+// parseDeadline reads X-Deadline-Ms and clamps it to MAX_DEADLINE_MS.
+// Returns 0 when the header is absent, invalid or disabled by config.
+func (h *Handlers) parseDeadline(r *http.Request) time.Duration {
+	if h.settings().MaxDeadlineMs <= 0 {
+		return 0
+	}
+
+	value := r.Header.Get("X-Deadline-Ms")
+	if value == "" {
+		return 0
+	}
+
+	ms, err := strconv.Atoi(value)
+	if err != nil || ms <= 0 {
+		return 0
+	}
+
+	if ms > h.settings().MaxDeadlineMs {
+		ms = h.settings().MaxDeadlineMs
+	}
+
+	return time.Duration(ms) * time.Millisecond
+}
+
+// isValidRequestID accepts client-provided IDs that are short and printable,
+// so they are safe to echo back and write to logs
+func isValidRequestID(requestID string) bool {
+	if requestID == "" || len(requestID) > 128 {
+		return false
+	}
+	for _, c := range requestID {
+		if c < 0x21 || c > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
 // Not for real production use due to potential spoofing
 // but it's fine for a demo
 func (h *Handlers) extractIP(r *http.Request) string {
@@ -384,6 +1048,21 @@ func (rw *responseWriter) WriteHeader(code int) {
 	rw.ResponseWriter.WriteHeader(code)
 }
 
+// Flush lets streaming handlers push partial responses through the logging wrapper
+func (rw *responseWriter) Flush() {
+	if flusher, ok := rw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// ReadFrom passes file copies through to the underlying writer so static
+// tiles keep the sendfile fast path
+func (rw *responseWriter) ReadFrom(src io.Reader) (int64, error) {
+	n, err := io.Copy(rw.ResponseWriter, src)
+	rw.bytesWritten += n
+	return n, err
+}
+
 func (rw *responseWriter) Write(b []byte) (int, error) {
 	n, err := rw.ResponseWriter.Write(b)
 	rw.bytesWritten += int64(n)
@@ -0,0 +1,200 @@
+package http
+
+import (
+	"cmp"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"gigaview/internal/image_list"
+)
+
+// listingSorts are the ?sort= keys of /api/images
+var listingSorts = map[string]func(a, b *image_list.ImageInfo) int{
+	"name": func(a, b *image_list.ImageInfo) int {
+		return strings.Compare(strings.ToLower(a.OriginalFilename), strings.ToLower(b.OriginalFilename))
+	},
+	"added_at":    func(a, b *image_list.ImageInfo) int { return a.AddedAt.Compare(b.AddedAt) },
+	"updated_at":  func(a, b *image_list.ImageInfo) int { return a.UpdatedAt.Compare(b.UpdatedAt) },
+	"uploaded_at": func(a, b *image_list.ImageInfo) int { return a.UploadedAt.Compare(b.UploadedAt) },
+	"captured_at": func(a, b *image_list.ImageInfo) int { return capturedAt(a).Compare(capturedAt(b)) },
+	"bytes":       func(a, b *image_list.ImageInfo) int { return cmp.Compare(a.Bytes, b.Bytes) },
+	"pixels": func(a, b *image_list.ImageInfo) int {
+		return cmp.Compare(int64(a.Width)*int64(a.Height), int64(b.Width)*int64(b.Height))
+	},
+}
+
+// listingQuery filters and orders /api/images:
+// ?collection= (with ?recursive=), ?tag= (repeatable, all must match), ?camera= (make or model, case
+// insensitive), ?captured_after= and ?captured_before= (RFC 3339 or
+// YYYY-MM-DD), ?added_after= and ?added_before= (same formats), ?source=
+// (upload, remote, mosaic or scan), ?uploaded_by= (actor ID), ?gps=true|false,
+// ?sort= (one of listingSorts) and ?order=asc|desc. Without ?sort= images
+// are listed newest first.
+type listingQuery struct {
+	collection     string
+	hasCollection  bool
+	recursive      bool
+	tags           []string
+	camera         string
+	capturedAfter  time.Time
+	capturedBefore time.Time
+	addedAfter     time.Time
+	addedBefore    time.Time
+	source         string
+	uploadedBy     string
+	gps            *bool
+	sort           string
+	desc           bool
+}
+
+func parseListingQuery(r *http.Request) (listingQuery, error) {
+	query := r.URL.Query()
+	q := listingQuery{
+		collection:    query.Get("collection"),
+		hasCollection: query.Has("collection"),
+		recursive:     true,
+		tags:          query["tag"],
+		camera:        strings.ToLower(strings.TrimSpace(query.Get("camera"))),
+		source:        query.Get("source"),
+		uploadedBy:    query.Get("uploaded_by"),
+		sort:          query.Get("sort"),
+	}
+
+	var err error
+	if q.hasCollection {
+		if q.recursive, err = parseRecursive(r); err != nil {
+			return q, err
+		}
+	}
+	if q.capturedAfter, err = parseListingTime(query.Get("captured_after")); err != nil {
+		return q, fmt.Errorf("invalid captured_after")
+	}
+	if q.capturedBefore, err = parseListingTime(query.Get("captured_before")); err != nil {
+		return q, fmt.Errorf("invalid captured_before")
+	}
+	if q.addedAfter, err = parseListingTime(query.Get("added_after")); err != nil {
+		return q, fmt.Errorf("invalid added_after")
+	}
+	if q.addedBefore, err = parseListingTime(query.Get("added_before")); err != nil {
+		return q, fmt.Errorf("invalid added_before")
+	}
+	if raw := query.Get("gps"); raw != "" {
+		gps, err := strconv.ParseBool(raw)
+		if err != nil {
+			return q, fmt.Errorf("invalid gps")
+		}
+		q.gps = &gps
+	}
+	if _, ok := listingSorts[q.sort]; q.sort != "" && !ok {
+		return q, fmt.Errorf("invalid sort")
+	}
+	switch query.Get("order") {
+	case "", "asc":
+	case "desc":
+		q.desc = true
+	default:
+		return q, fmt.Errorf("invalid order")
+	}
+	return q, nil
+}
+
+// parseListingTime accepts RFC 3339 timestamps and plain dates
+func parseListingTime(raw string) (time.Time, error) {
+	if raw == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, raw); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.DateOnly, raw)
+}
+
+// isZero reports whether the query returns the whole list unchanged
+func (q listingQuery) isZero() bool {
+	return !q.hasCollection && len(q.tags) == 0 && q.camera == "" && q.capturedAfter.IsZero() &&
+		q.capturedBefore.IsZero() && q.addedAfter.IsZero() && q.addedBefore.IsZero() &&
+		q.source == "" && q.uploadedBy == "" && q.gps == nil && q.sort == ""
+}
+
+// key condenses the query into an entity tag component
+func (q listingQuery) key() string {
+	gps := ""
+	if q.gps != nil {
+		gps = strconv.FormatBool(*q.gps)
+	}
+	raw := strings.Join([]string{
+		strconv.FormatBool(q.hasCollection), q.collection, strconv.FormatBool(q.recursive), strings.Join(q.tags, "\x01"), q.camera,
+		q.capturedAfter.Format(time.RFC3339), q.capturedBefore.Format(time.RFC3339),
+		q.addedAfter.Format(time.RFC3339), q.addedBefore.Format(time.RFC3339), q.source, q.uploadedBy, gps,
+		q.sort, strconv.FormatBool(q.desc),
+	}, "\x00")
+	sum := sha256.Sum256([]byte(raw))
+	return hex.EncodeToString(sum[:])[:12]
+}
+
+// apply filters and sorts images into a new slice, leaving images untouched
+func (q listingQuery) apply(images []image_list.ImageInfo) []image_list.ImageInfo {
+	result := []image_list.ImageInfo{}
+	for _, image := range images {
+		if q.matches(&image) {
+			result = append(result, image)
+		}
+	}
+
+	if compare, ok := listingSorts[q.sort]; ok {
+		slices.SortStableFunc(result, func(a, b image_list.ImageInfo) int {
+			if q.desc {
+				return compare(&b, &a)
+			}
+			return compare(&a, &b)
+		})
+	}
+	return result
+}
+
+func (q listingQuery) matches(image *image_list.ImageInfo) bool {
+	for _, tag := range q.tags {
+		if tag != "" && !image.HasTag(tag) {
+			return false
+		}
+	}
+	if q.camera != "" && (image.Photo == nil || !strings.Contains(strings.ToLower(image.Photo.Camera()), q.camera)) {
+		return false
+	}
+	if !q.capturedAfter.IsZero() || !q.capturedBefore.IsZero() {
+		captured := capturedAt(image)
+		if captured.IsZero() ||
+			(!q.capturedAfter.IsZero() && captured.Before(q.capturedAfter)) ||
+			(!q.capturedBefore.IsZero() && !captured.Before(q.capturedBefore)) {
+			return false
+		}
+	}
+	if (!q.addedAfter.IsZero() && image.AddedAt.Before(q.addedAfter)) ||
+		(!q.addedBefore.IsZero() && !image.AddedAt.Before(q.addedBefore)) {
+		return false
+	}
+	if q.source != "" && image.Source != q.source {
+		return false
+	}
+	if q.uploadedBy != "" && image.UploadedBy != q.uploadedBy {
+		return false
+	}
+	if q.gps != nil && (image.Photo != nil && image.Photo.GPS != nil) != *q.gps {
+		return false
+	}
+	return true
+}
+
+// capturedAt returns the capture time of an image, zero when unknown
+func capturedAt(image *image_list.ImageInfo) time.Time {
+	if image.Photo == nil {
+		return time.Time{}
+	}
+	return image.Photo.CapturedAt
+}
@@ -0,0 +1,80 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"go.uber.org/zap"
+
+	"gigaview/internal/audit"
+	"gigaview/internal/image_list"
+)
+
+// mosaicRequest is the body of POST /api/mosaics
+type mosaicRequest struct {
+	Name          string                    `json:"name"`
+	Sources       []image_list.MosaicSource `json:"sources"`
+	CopyrightText string                    `json:"copyright_text"`
+	CopyrightLink string                    `json:"copyright_link"`
+}
+
+// HandleMosaics creates a virtual mosaic image from existing images placed at
+// pixel offsets. The mosaic is listed and tiled like any other image.
+func (h *Handlers) HandleMosaics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !h.settings().IsUploadPublic() && h.requestToken(r) != h.settings().UploadToken {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var request mosaicRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&request); err != nil {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	if request.Name == "" {
+		request.Name = "mosaic"
+	}
+	// Sizes always come from the source metadata
+	for i := range request.Sources {
+		request.Sources[i].Width, request.Sources[i].Height = 0, 0
+	}
+
+	imageID, err := h.scanner.CreateMosaic(r.Context(), request.Name, request.Sources, request.CopyrightText, request.CopyrightLink)
+	if errors.Is(err, image_list.ErrInvalidMosaic) || errors.Is(err, image_list.ErrImageTooLarge) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		h.log(r).Error("Failed to create mosaic", zap.Error(err))
+		http.Error(w, "Failed to create mosaic", http.StatusInternalServerError)
+		return
+	}
+
+	if err := h.scanner.Scan(); err != nil {
+		h.log(r).Warn("Failed to rescan after creating mosaic", zap.Error(err))
+	}
+
+	imageInfo := h.scanner.GetImageByID(imageID)
+	if imageInfo == nil {
+		h.log(r).Warn("Created mosaic not found after scan", zap.String("id", imageID))
+		http.Error(w, "Failed to retrieve created mosaic", http.StatusInternalServerError)
+		return
+	}
+
+	h.recordAudit(r, audit.ActionMosaic, imageID, nil, imageInfo)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"id":     imageID,
+		"name":   imageInfo.OriginalFilename,
+		"width":  imageInfo.Width,
+		"height": imageInfo.Height,
+		"saved":  true,
+	})
+}
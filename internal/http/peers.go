@@ -0,0 +1,139 @@
+package http
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// peerHeader marks tile requests forwarded by another node, which are
+// rendered locally rather than forwarded again. It carries the shared
+// secret of the cluster, so clients can't set it.
+const peerHeader = "X-Gigaview-Peer"
+
+// forwardedHeaders are passed on to the owning node
+var forwardedHeaders = []string{"Accept", "If-None-Match", "X-Deadline-Ms", "X-Request-Id"}
+
+// hopHeaders belong to one connection and aren't copied from the owner's
+// response, like in httputil.ReverseProxy
+var hopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Proxy-Connection",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// peerPool spreads tile rendering over a cluster. Every tile has one owner
+// node, picked by rendezvous hashing, which renders and caches it; the other
+// nodes fetch it from there instead of rendering it again.
+type peerPool struct {
+	self   string
+	peers  []string
+	secret string
+	client *http.Client
+}
+
+// newPeerPool returns nil without peers. self is this node's URL as it
+// appears in peers, secret the one all peers share.
+func newPeerPool(self string, peers []string, secret string) *peerPool {
+	if len(peers) == 0 {
+		return nil
+	}
+	normalized := make([]string, 0, len(peers))
+	for _, peer := range peers {
+		normalized = append(normalized, strings.TrimSuffix(peer, "/"))
+	}
+	return &peerPool{
+		self:   strings.TrimSuffix(self, "/"),
+		peers:  normalized,
+		secret: secret,
+		// Each request is bounded by its own context
+		client: &http.Client{Transport: &http.Transport{MaxIdleConnsPerHost: 64, IdleConnTimeout: time.Minute}},
+	}
+}
+
+// fromPeer reports whether a request was forwarded by another node of the
+// cluster, i.e. carries its secret
+func (h *Handlers) fromPeer(r *http.Request) bool {
+	value := r.Header.Get(peerHeader)
+	return h.peers != nil && h.peers.secret != "" && value != "" &&
+		subtle.ConstantTimeCompare([]byte(value), []byte(h.peers.secret)) == 1
+}
+
+// owner returns the peer with the highest hash for key, so adding or
+// removing a node only moves the tiles it gains or loses
+func (p *peerPool) owner(key string) string {
+	var best string
+	var bestScore uint64
+	for _, peer := range p.peers {
+		hash := fnv.New64a()
+		hash.Write([]byte(peer))
+		hash.Write([]byte{0})
+		hash.Write([]byte(key))
+		if score := hash.Sum64(); best == "" || score > bestScore {
+			best, bestScore = peer, score
+		}
+	}
+	return best
+}
+
+// forwardTile proxies a tile request to the node owning it and returns the
+// status the owner answered with. It returns 0 when this node should render
+// the tile itself: it owns it, the request was forwarded already or the
+// owner can't be reached.
+func (h *Handlers) forwardTile(w http.ResponseWriter, r *http.Request, imageID string, z, x, y int) int {
+	if h.peers == nil || h.fromPeer(r) {
+		return 0
+	}
+	owner := h.peers.owner(fmt.Sprintf("%s/%d/%d/%d", imageID, z, x, y))
+	if owner == h.peers.self {
+		return 0
+	}
+
+	request, err := http.NewRequestWithContext(r.Context(), r.Method, owner+r.URL.RequestURI(), nil)
+	if err != nil {
+		return 0
+	}
+	for _, name := range forwardedHeaders {
+		if value := r.Header.Get(name); value != "" {
+			request.Header.Set(name, value)
+		}
+	}
+	request.Header.Set(peerHeader, h.peers.secret)
+
+	response, err := h.peers.client.Do(request)
+	if err != nil {
+		if r.Context().Err() == nil {
+			h.log(r).Debug("Tile owner unreachable, rendering locally", zap.String("peer", owner), zap.Error(err))
+		}
+		return 0
+	}
+	defer response.Body.Close()
+
+	header := response.Header.Clone()
+	for _, name := range strings.Split(header.Get("Connection"), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			header.Del(name)
+		}
+	}
+	for _, name := range hopHeaders {
+		header.Del(name)
+	}
+	for name, values := range header {
+		w.Header()[name] = values
+	}
+	w.WriteHeader(response.StatusCode)
+	io.Copy(w, response.Body)
+	return response.StatusCode
+}
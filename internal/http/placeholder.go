@@ -0,0 +1,54 @@
+package http
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"net/http"
+	"os"
+	"strings"
+
+	"gigaview/internal/render"
+)
+
+// placeholderTile is served with 404 responses for missing tiles
+type placeholderTile struct {
+	data        []byte
+	contentType string
+}
+
+// loadPlaceholderTile builds the placeholder from PLACEHOLDER_TILE.
+// The value is either a hex color (#rrggbb) rendered as a solid 256×256 JPEG,
+// or a path to a pre-rendered tile image served as-is.
+func loadPlaceholderTile(spec string) (*placeholderTile, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		return nil, nil
+	}
+
+	if strings.HasPrefix(spec, "#") {
+		c, err := render.ParseHexColor(spec)
+		if err != nil {
+			return nil, err
+		}
+
+		img := image.NewRGBA(image.Rect(0, 0, 256, 256))
+		draw.Draw(img, img.Bounds(), &image.Uniform{C: c}, image.Point{}, draw.Src)
+
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 82}); err != nil {
+			return nil, fmt.Errorf("failed to encode placeholder tile: %w", err)
+		}
+
+		return &placeholderTile{data: buf.Bytes(), contentType: "image/jpeg"}, nil
+	}
+
+	data, err := os.ReadFile(spec)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read placeholder tile: %w", err)
+	}
+
+	return &placeholderTile{data: data, contentType: http.DetectContentType(data)}, nil
+}
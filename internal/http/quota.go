@@ -0,0 +1,216 @@
+package http
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"gigaview/internal/audit"
+)
+
+// uploadQuota limits the bytes and files each client may upload within a
+// rolling window. Clients are counted by IP and, separately, by upload token,
+// so neither a shared token nor changing addresses gets around it. Usage is
+// kept in memory and starts over on restart.
+type uploadQuota struct {
+	window   time.Duration
+	maxBytes int64
+	maxFiles int
+
+	mu    sync.Mutex
+	usage map[string][]quotaEntry
+}
+
+// quotaEntry is one accepted upload
+type quotaEntry struct {
+	at    time.Time
+	bytes int64
+}
+
+// QuotaUsage is what one client uploaded within the window
+type QuotaUsage struct {
+	// Client is "ip:{address}" or the "token:{fingerprint}" of the audit log
+	Client string `json:"client"`
+	Bytes  int64  `json:"bytes"`
+	Files  int    `json:"files"`
+	// ResetsAt is when the oldest upload leaves the window
+	ResetsAt time.Time `json:"resets_at"`
+}
+
+func newUploadQuota(window time.Duration, maxBytes int64, maxFiles int) *uploadQuota {
+	return &uploadQuota{
+		window:   window,
+		maxBytes: maxBytes,
+		maxFiles: maxFiles,
+		usage:    make(map[string][]quotaEntry),
+	}
+}
+
+func (q *uploadQuota) enabled() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.limited()
+}
+
+// limited reports whether any limit applies; the caller holds mu
+func (q *uploadQuota) limited() bool {
+	return q.window > 0 && (q.maxBytes > 0 || q.maxFiles > 0)
+}
+
+// setLimits changes the limits; uploads already counted stay counted
+func (q *uploadQuota) setLimits(window time.Duration, maxBytes int64, maxFiles int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.window = window
+	q.maxBytes = maxBytes
+	q.maxFiles = maxFiles
+}
+
+// quotaClients returns the quota keys of a request
+func (h *Handlers) quotaClients(r *http.Request) []string {
+	clients := []string{"ip:" + h.extractIP(r)}
+	if token := h.requestToken(r); token != "" {
+		clients = append(clients, audit.ActorID(token))
+	}
+	return clients
+}
+
+// prune drops entries that left the window and returns what remains; the
+// caller holds mu
+func (q *uploadQuota) prune(client string, now time.Time) []quotaEntry {
+	entries := q.usage[client]
+	n := 0
+	for n < len(entries) && now.Sub(entries[n].at) >= q.window {
+		n++
+	}
+	entries = entries[n:]
+	if len(entries) == 0 {
+		delete(q.usage, client)
+	} else {
+		q.usage[client] = entries
+	}
+	return entries
+}
+
+// check reports whether all clients have room for one more file, before
+// its size is known, and otherwise how long until they have
+func (q *uploadQuota) check(clients []string) (bool, time.Duration) {
+	return q.admit(clients, 0, false)
+}
+
+// reserve records an upload of size bytes for all clients if none of them
+// goes over its quota, and otherwise records nothing and returns how long
+// until the client that is over has room again
+func (q *uploadQuota) reserve(clients []string, size int64) (bool, time.Duration) {
+	return q.admit(clients, size, true)
+}
+
+func (q *uploadQuota) admit(clients []string, size int64, record bool) (bool, time.Duration) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if !q.limited() {
+		return true, 0
+	}
+
+	now := time.Now()
+	for _, client := range clients {
+		entries := q.prune(client, now)
+		var used int64
+		for _, entry := range entries {
+			used += entry.bytes
+		}
+		overFiles := q.maxFiles > 0 && len(entries)+1 > q.maxFiles
+		overBytes := q.maxBytes > 0 && used+max(size, 1) > q.maxBytes
+		if overFiles || overBytes {
+			retry := q.window
+			if len(entries) > 0 {
+				retry = q.window - now.Sub(entries[0].at)
+			}
+			return false, retry
+		}
+	}
+
+	if record {
+		q.add(clients, size, now)
+	}
+	return true, 0
+}
+
+// charge records an upload that already took place, even when it puts
+// clients over their quota, so their next one is turned away
+func (q *uploadQuota) charge(clients []string, size int64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.limited() {
+		q.add(clients, size, time.Now())
+	}
+}
+
+// release takes back a reservation of size bytes for an upload that stored
+// nothing (a duplicate or one that failed afterwards)
+func (q *uploadQuota) release(clients []string, size int64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, client := range clients {
+		entries := q.usage[client]
+		for i := len(entries) - 1; i >= 0; i-- {
+			if entries[i].bytes == size {
+				entries = append(entries[:i], entries[i+1:]...)
+				break
+			}
+		}
+		if len(entries) == 0 {
+			delete(q.usage, client)
+		} else {
+			q.usage[client] = entries
+		}
+	}
+}
+
+// add records an upload for all clients; the caller holds mu
+func (q *uploadQuota) add(clients []string, size int64, now time.Time) {
+	for _, client := range clients {
+		q.usage[client] = append(q.usage[client], quotaEntry{at: now, bytes: size})
+	}
+}
+
+// snapshot lists the usage of all clients with uploads in the window,
+// heaviest first
+func (q *uploadQuota) snapshot() []QuotaUsage {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := time.Now()
+	usage := []QuotaUsage{}
+	for client := range q.usage {
+		entries := q.prune(client, now)
+		if len(entries) == 0 {
+			continue
+		}
+		current := QuotaUsage{
+			Client:   client,
+			Files:    len(entries),
+			ResetsAt: entries[0].at.Add(q.window),
+		}
+		for _, entry := range entries {
+			current.Bytes += entry.bytes
+		}
+		usage = append(usage, current)
+	}
+	sort.Slice(usage, func(i, j int) bool {
+		if usage[i].Bytes != usage[j].Bytes {
+			return usage[i].Bytes > usage[j].Bytes
+		}
+		return usage[i].Client < usage[j].Client
+	})
+	return usage
+}
+
+// rejectOverQuota answers 429 with a Retry-After in whole seconds
+func rejectOverQuota(w http.ResponseWriter, retry time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(retry.Round(time.Second)/time.Second)+1))
+	http.Error(w, "Upload quota exceeded", http.StatusTooManyRequests)
+}
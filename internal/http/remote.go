@@ -0,0 +1,106 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"go.uber.org/zap"
+
+	"gigaview/internal/audit"
+	"gigaview/internal/image_list"
+)
+
+// maxRemoteRequestSize bounds remote registration bodies
+const maxRemoteRequestSize = 64 << 10
+
+// remoteRequest is the body of POST /api/upload/remote
+type remoteRequest struct {
+	URL           string   `json:"url"`
+	Title         string   `json:"title"`
+	CopyrightText string   `json:"copyright_text"`
+	CopyrightLink string   `json:"copyright_link"`
+	Tags          []string `json:"tags"`
+}
+
+// HandleRemoteUpload registers an image that stays on another server, given
+// as {"url", "title", "copyright_text", "copyright_link", "tags"}. The URL
+// must start with one of REMOTE_URL_PREFIXES.
+func (h *Handlers) HandleRemoteUpload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !h.settings().IsUploadPublic() && h.requestToken(r) != h.settings().UploadToken {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// Registering makes the server download the file, which counts like an upload
+	clients := h.quotaClients(r)
+	if ok, retry := h.quota.check(clients); !ok {
+		rejectOverQuota(w, retry)
+		return
+	}
+
+	var request remoteRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRemoteRequestSize)).Decode(&request); err != nil {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	meta := image_list.UploadMetadata{
+		Title:         request.Title,
+		CopyrightText: request.CopyrightText,
+		CopyrightLink: request.CopyrightLink,
+		Tags:          request.Tags,
+		UploadedBy:    audit.ActorID(h.requestToken(r)),
+	}
+	if err := meta.Normalize(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	imageID, err := h.scanner.RegisterRemote(r.Context(), request.URL, meta)
+	switch {
+	case errors.Is(err, image_list.ErrRemoteDisabled):
+		http.Error(w, "Remote images are disabled", http.StatusNotFound)
+		return
+	case errors.Is(err, image_list.ErrInvalidRemote), errors.Is(err, image_list.ErrInvalidContent):
+		h.log(r).Warn("Rejected remote image", zap.String("url", request.URL), zap.Error(err))
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, image_list.ErrImageTooLarge):
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	case err != nil:
+		h.log(r).Error("Failed to register remote image", zap.String("url", request.URL), zap.Error(err))
+		http.Error(w, "Failed to register remote image", http.StatusInternalServerError)
+		return
+	}
+
+	if err := h.scanner.Scan(); err != nil {
+		h.log(r).Warn("Failed to rescan after registering remote image", zap.Error(err))
+	}
+
+	imageInfo := h.scanner.GetImageByID(imageID)
+	if imageInfo == nil {
+		h.log(r).Warn("Remote image not found after scan", zap.String("id", imageID))
+		http.Error(w, "Failed to retrieve registered image", http.StatusInternalServerError)
+		return
+	}
+	// The file was downloaded by now, so it counts even when it goes over
+	h.quota.charge(clients, imageInfo.Bytes)
+
+	h.recordAudit(r, audit.ActionRemote, imageID, nil, imageInfo)
+	h.warmUp(imageInfo)
+
+	if h.settings().PyramidOnUpload {
+		go h.generatePyramid(context.WithoutCancel(r.Context()), imageID)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(imageInfo)
+}
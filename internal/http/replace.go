@@ -0,0 +1,85 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+
+	"go.uber.org/zap"
+
+	"gigaview/internal/audit"
+	"gigaview/internal/image_list"
+)
+
+// handleImageContent handles PUT /api/images/{id}/content, a multipart upload
+// like /api/upload that replaces the file of an existing image. The ID, and
+// with it every shared link, annotation and album entry, stays the same.
+func (h *Handlers) handleImageContent(w http.ResponseWriter, r *http.Request, imageID string) {
+	if r.Method != http.MethodPut {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !h.settings().IsUploadPublic() && h.requestToken(r) != h.settings().UploadToken {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	before := h.scanner.GetImageByID(imageID)
+	if before == nil {
+		http.Error(w, "Image not found", http.StatusNotFound)
+		return
+	}
+
+	upload, ok := h.receiveUpload(w, r)
+	if !ok {
+		return
+	}
+	// Moved into place on success, left behind on any failure
+	defer os.Remove(upload.path)
+
+	err := h.scanner.ReplaceImageContent(r.Context(), imageID, upload.path, upload.filename, upload.checksum)
+	if err != nil {
+		h.releaseQuota(upload)
+	}
+	switch {
+	case errors.Is(err, image_list.ErrImageNotFound):
+		http.Error(w, "Image not found", http.StatusNotFound)
+		return
+	case errors.Is(err, image_list.ErrNotReplaceable), errors.Is(err, image_list.ErrImageInUse):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case errors.Is(err, image_list.ErrImageTooLarge):
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	case err != nil:
+		h.log(r).Error("Failed to replace image content", zap.String("image", imageID), zap.Error(err))
+		http.Error(w, "Failed to process file", http.StatusInternalServerError)
+		return
+	}
+
+	// Cached tiles, statistics and the static pyramid of the old content
+	// go now; the rescan picks up the new fingerprint
+	h.renderer.InvalidateImage(imageID)
+	if err := h.scanner.Scan(); err != nil {
+		h.log(r).Warn("Failed to rescan after replace", zap.Error(err))
+	}
+
+	imageInfo := h.scanner.GetImageByID(imageID)
+	if imageInfo == nil {
+		h.log(r).Warn("Replaced image not found after scan", zap.String("id", imageID))
+		http.Error(w, "Failed to retrieve replaced image", http.StatusInternalServerError)
+		return
+	}
+
+	h.recordAudit(r, audit.ActionReplace, imageID, before, imageInfo)
+
+	if h.settings().PyramidOnUpload {
+		go h.generatePyramid(context.WithoutCancel(r.Context()), imageID)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(imageInfo)
+}
@@ -0,0 +1,202 @@
+package http
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// shareSignatureSize is the length of the truncated HMAC of share links
+const shareSignatureSize = 16
+
+// shareRequest is the body of POST /api/images/{id}/share. The viewport is
+// the centre in full-resolution pixels and the zoom level; ExpiresIn is a
+// duration up to SHARE_TTL (default: SHARE_TTL).
+type shareRequest struct {
+	X          float64 `json:"x"`
+	Y          float64 `json:"y"`
+	Zoom       int     `json:"zoom"`
+	Annotation string  `json:"annotation"`
+	ExpiresIn  string  `json:"expires_in"`
+}
+
+// shareState is the viewer state a share link carries
+type shareState struct {
+	image      string
+	x, y       float64
+	zoom       int
+	annotation string
+	expires    time.Time
+}
+
+// encode packs the state into "{payload}.{signature}", both base64url
+func (s shareState) encode(secret string) string {
+	payload := strings.Join([]string{
+		s.image,
+		strconv.FormatFloat(s.x, 'f', -1, 64),
+		strconv.FormatFloat(s.y, 'f', -1, 64),
+		strconv.Itoa(s.zoom),
+		strconv.FormatInt(s.expires.Unix(), 36),
+		s.annotation,
+	}, "|")
+	return base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." +
+		base64.RawURLEncoding.EncodeToString(shareSignature(secret, payload))
+}
+
+// decodeShare checks the signature of a share link and unpacks it. Expiry
+// is left to the caller.
+func decodeShare(code, secret string) (shareState, error) {
+	var state shareState
+	encoded, signature, ok := strings.Cut(code, ".")
+	if !ok {
+		return state, errors.New("malformed link")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return state, errors.New("malformed link")
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil || subtle.ConstantTimeCompare(mac, shareSignature(secret, string(payload))) != 1 {
+		return state, errors.New("invalid signature")
+	}
+
+	fields := strings.Split(string(payload), "|")
+	if len(fields) != 6 {
+		return state, errors.New("malformed link")
+	}
+	state.image, state.annotation = fields[0], fields[5]
+	x, errX := strconv.ParseFloat(fields[1], 64)
+	y, errY := strconv.ParseFloat(fields[2], 64)
+	zoom, errZoom := strconv.Atoi(fields[3])
+	expires, errExpires := strconv.ParseInt(fields[4], 36, 64)
+	if err := errors.Join(errX, errY, errZoom, errExpires); err != nil {
+		return state, fmt.Errorf("malformed link: %w", err)
+	}
+	state.x, state.y, state.zoom, state.expires = x, y, zoom, time.Unix(expires, 0)
+	return state, nil
+}
+
+func shareSignature(secret, payload string) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)[:shareSignatureSize]
+}
+
+// handleImageShare creates a share link for a view of an image
+// (POST /api/images/{id}/share). The link opens the viewer at the given
+// viewport, optionally highlighting an annotation, until it expires; it
+// needs the upload token and SHARE_SECRET.
+func (h *Handlers) handleImageShare(w http.ResponseWriter, r *http.Request, imageID string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	settings := h.settings()
+	if settings.ShareSecret == "" {
+		http.NotFound(w, r)
+		return
+	}
+	if !settings.IsUploadPublic() && h.requestToken(r) != settings.UploadToken {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	imageInfo := h.scanner.GetImageByID(imageID)
+	if imageInfo == nil {
+		http.Error(w, "Image not found", http.StatusNotFound)
+		return
+	}
+
+	var request shareRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&request); err != nil {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	if !(request.X >= 0 && request.Y >= 0 && request.X <= float64(imageInfo.Width) && request.Y <= float64(imageInfo.Height)) {
+		http.Error(w, "x and y must lie within the image", http.StatusBadRequest)
+		return
+	}
+	maxZoom := h.renderer.CalculateMaxZoom(imageInfo.Width, imageInfo.Height) + settings.OverzoomLevels
+	if request.Zoom < 0 || request.Zoom > maxZoom {
+		http.Error(w, fmt.Sprintf("zoom must be between 0 and %d", maxZoom), http.StatusBadRequest)
+		return
+	}
+	if request.Annotation != "" {
+		if _, err := h.scanner.GetAnnotation(imageID, request.Annotation); !h.annotationError(w, r, err) {
+			return
+		}
+	}
+	ttl := settings.ShareTTL
+	if request.ExpiresIn != "" {
+		expiresIn, err := time.ParseDuration(request.ExpiresIn)
+		if err != nil || expiresIn <= 0 || expiresIn > settings.ShareTTL {
+			http.Error(w, fmt.Sprintf("expires_in must be a duration up to %s", settings.ShareTTL), http.StatusBadRequest)
+			return
+		}
+		ttl = expiresIn
+	}
+
+	state := shareState{
+		image:      imageID,
+		x:          request.X,
+		y:          request.Y,
+		zoom:       request.Zoom,
+		annotation: request.Annotation,
+		expires:    time.Now().Add(ttl).Truncate(time.Second),
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"url":        strings.TrimSuffix(settings.PublicBaseURL, "/") + "/s/" + state.encode(settings.ShareSecret),
+		"expires_at": state.expires,
+	})
+}
+
+// HandleShare resolves a share link (GET /s/{code}) by redirecting to the
+// viewer with the shared state as query parameters. Expired links answer
+// 410, links that were tampered with or whose image is gone 404.
+func (h *Handlers) HandleShare(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	settings := h.settings()
+	if settings.ShareSecret == "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	state, err := decodeShare(strings.TrimPrefix(r.URL.Path, "/s/"), settings.ShareSecret)
+	if err != nil {
+		http.Error(w, "Link not found", http.StatusNotFound)
+		return
+	}
+	if time.Now().After(state.expires) {
+		http.Error(w, "Link expired", http.StatusGone)
+		return
+	}
+	if h.scanner.GetImageByID(state.image) == nil {
+		http.Error(w, "Image not found", http.StatusNotFound)
+		return
+	}
+
+	query := url.Values{}
+	query.Set("image", state.image)
+	query.Set("x", strconv.FormatFloat(state.x, 'f', -1, 64))
+	query.Set("y", strconv.FormatFloat(state.y, 'f', -1, 64))
+	query.Set("zoom", strconv.Itoa(state.zoom))
+	if state.annotation != "" {
+		query.Set("annotation", state.annotation)
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, strings.TrimSuffix(settings.PublicBaseURL, "/")+"/?"+query.Encode(), http.StatusFound)
+}
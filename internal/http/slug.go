@@ -0,0 +1,86 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"go.uber.org/zap"
+
+	"gigaview/internal/audit"
+	"gigaview/internal/image_list"
+)
+
+// handleImageSlug manages the slug of an image: GET returns it, PUT sets it
+// from a {"slug": "..."} body and DELETE removes it
+func (h *Handlers) handleImageSlug(w http.ResponseWriter, r *http.Request, imageID string) {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete:
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	before := h.scanner.GetImageByID(imageID)
+	if before == nil {
+		http.Error(w, "Image not found", http.StatusNotFound)
+		return
+	}
+
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		writeSlug(w, imageID, before.Slug)
+		return
+	}
+
+	if !h.settings().IsUploadPublic() && h.requestToken(r) != h.settings().UploadToken {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var request struct {
+		Slug string `json:"slug"`
+	}
+	if r.Method == http.MethodPut {
+		err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4<<10)).Decode(&request)
+		if err != nil {
+			http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+			return
+		}
+		if request.Slug == "" {
+			http.Error(w, "Slug is required, use DELETE to remove it", http.StatusBadRequest)
+			return
+		}
+	}
+
+	// The scanner rescans itself, so the slug resolves once this returns
+	slug, err := h.scanner.SetSlug(imageID, request.Slug)
+	if errors.Is(err, image_list.ErrInvalidSlug) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, image_list.ErrSlugTaken) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if errors.Is(err, image_list.ErrImageNotFound) {
+		http.Error(w, "Image not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		h.log(r).Error("Failed to save slug", zap.String("image", imageID), zap.Error(err))
+		http.Error(w, "Failed to save slug", http.StatusInternalServerError)
+		return
+	}
+
+	h.recordAudit(r, audit.ActionSlug, imageID, before.Slug, slug)
+
+	writeSlug(w, imageID, slug)
+}
+
+func writeSlug(w http.ResponseWriter, imageID, slug string) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"id":   imageID,
+		"slug": slug,
+	})
+}
@@ -0,0 +1,114 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"go.uber.org/zap"
+
+	"gigaview/internal/render"
+)
+
+// handleImageHistogram serves per-channel histograms and statistics of an
+// image page, for display-range tools and UI auto-contrast
+func (h *Handlers) handleImageHistogram(w http.ResponseWriter, r *http.Request, imageID string) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	page, err := parsePage(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := h.deadlineContext(r)
+	defer cancel()
+
+	stats, err := h.renderer.ImageStats(ctx, imageID, page)
+	if errors.Is(err, context.Canceled) {
+		return
+	}
+	if errors.Is(err, render.ErrImageNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		http.Error(w, "Statistics deadline exceeded", http.StatusGatewayTimeout)
+		return
+	}
+	if err != nil {
+		h.log(r).Error("Failed to compute image statistics", zap.String("image", imageID), zap.Error(err))
+		http.Error(w, "Failed to compute statistics", http.StatusInternalServerError)
+		return
+	}
+
+	// The validator changes only when the source file is replaced
+	_, modifiedAt := h.scanner.State()
+	etag := fmt.Sprintf(`W/"histogram-%s-%s-p%d"`, imageID, h.imageFingerprint(imageID), page)
+	setCacheControl(w, h.settings().CacheControlMeta)
+
+	writeConditionalJSON(w, r, etag, modifiedAt, stats)
+}
+
+// handleImagePixel returns the raw channel values at ?x=&y= (full-resolution
+// pixel coordinates), so viewers can probe exact intensities
+func (h *Handlers) handleImagePixel(w http.ResponseWriter, r *http.Request, imageID string) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	page, err := parsePage(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	query := r.URL.Query()
+	x, errX := strconv.Atoi(query.Get("x"))
+	y, errY := strconv.Atoi(query.Get("y"))
+	if errX != nil || errY != nil {
+		http.Error(w, "x and y must be integers", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := h.deadlineContext(r)
+	defer cancel()
+
+	pixel, err := h.renderer.PixelValue(ctx, imageID, page, x, y)
+	if errors.Is(err, context.Canceled) {
+		return
+	}
+	if errors.Is(err, render.ErrImageNotFound) || errors.Is(err, render.ErrTileOutOfBounds) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		http.Error(w, "Pixel read deadline exceeded", http.StatusGatewayTimeout)
+		return
+	}
+	if err != nil {
+		h.log(r).Error("Failed to read pixel", zap.String("image", imageID), zap.Error(err))
+		http.Error(w, "Failed to read pixel", http.StatusInternalServerError)
+		return
+	}
+
+	_, modifiedAt := h.scanner.State()
+	etag := fmt.Sprintf(`W/"pixel-%s-%s-p%d-%d-%d"`, imageID, h.imageFingerprint(imageID), page, x, y)
+	setCacheControl(w, h.settings().CacheControlMeta)
+
+	writeConditionalJSON(w, r, etag, modifiedAt, pixel)
+}
+
+// imageFingerprint returns the source file version of an image, "" if unknown
+func (h *Handlers) imageFingerprint(imageID string) string {
+	if imageInfo := h.scanner.GetImageByID(imageID); imageInfo != nil {
+		return imageInfo.Fingerprint
+	}
+	return ""
+}
@@ -0,0 +1,108 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"go.uber.org/zap"
+
+	"gigaview/internal/audit"
+	"gigaview/internal/image_list"
+)
+
+// handleImageTags manages the tags of an image: GET lists them, PUT replaces
+// them, POST adds and DELETE removes the tags of a {"tags": [...]} body.
+// DELETE also takes them as ?tag= parameters.
+func (h *Handlers) handleImageTags(w http.ResponseWriter, r *http.Request, imageID string) {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodPost, http.MethodDelete:
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	before := h.scanner.GetImageByID(imageID)
+	if before == nil {
+		http.Error(w, "Image not found", http.StatusNotFound)
+		return
+	}
+
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		writeTags(w, before.Tags)
+		return
+	}
+
+	if !h.settings().IsUploadPublic() && h.requestToken(r) != h.settings().UploadToken {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var request struct {
+		Tags []string `json:"tags"`
+	}
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&request)
+	// The body is optional for DELETE with ?tag=
+	if errors.Is(err, io.EOF) && r.Method == http.MethodDelete {
+		err = nil
+	}
+	if err != nil {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+
+	var tags []string
+	switch r.Method {
+	case http.MethodPut:
+		tags, err = h.scanner.SetTags(imageID, request.Tags)
+	case http.MethodPost:
+		tags, err = h.scanner.AddTags(imageID, request.Tags)
+	case http.MethodDelete:
+		tags, err = h.scanner.RemoveTags(imageID, append(request.Tags, r.URL.Query()["tag"]...))
+	}
+	if errors.Is(err, image_list.ErrInvalidTags) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, image_list.ErrImageNotFound) {
+		http.Error(w, "Image not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		h.log(r).Error("Failed to save tags", zap.String("image", imageID), zap.Error(err))
+		http.Error(w, "Failed to save tags", http.StatusInternalServerError)
+		return
+	}
+	if err := h.scanner.Scan(); err != nil {
+		h.log(r).Warn("Failed to rescan after tag change", zap.Error(err))
+	}
+
+	h.recordAudit(r, audit.ActionTags, imageID, before.Tags, tags)
+
+	writeTags(w, tags)
+}
+
+// HandleTags lists the tags in use with their image counts (GET /api/tags)
+func (h *Handlers) HandleTags(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	version, modifiedAt := h.scanner.State()
+	setCacheControl(w, h.settings().CacheControlListing)
+	etag := fmt.Sprintf(`W/"tags-%d"`, version)
+	writeConditionalJSON(w, r, etag, modifiedAt, h.scanner.Tags())
+}
+
+func writeTags(w http.ResponseWriter, tags []string) {
+	if tags == nil {
+		tags = []string{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"tags": tags,
+	})
+}
@@ -0,0 +1,102 @@
+package http
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"gigaview/internal/render"
+)
+
+// parseTileOptions reads per-request render options from the query string:
+// ?page=, ?brightness=, ?contrast=, ?gamma=, ?saturation=, ?grayscale=1,
+// ?bands=3,2,1 or ?channel=2 and ?colormap=
+func parseTileOptions(r *http.Request) (render.TileOptions, error) {
+	opts := render.DefaultTileOptions
+	query := r.URL.Query()
+
+	page, err := parsePage(r)
+	if err != nil {
+		return opts, err
+	}
+	opts.Page = page
+
+	adjustments := &opts.Adjustments
+	floats := []struct {
+		name  string
+		value *float64
+	}{
+		{"brightness", &adjustments.Brightness},
+		{"contrast", &adjustments.Contrast},
+		{"gamma", &adjustments.Gamma},
+		{"saturation", &adjustments.Saturation},
+	}
+
+	for _, param := range floats {
+		raw := query.Get(param.name)
+		if raw == "" {
+			continue
+		}
+		value, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return opts, fmt.Errorf("invalid %s", param.name)
+		}
+		*param.value = render.RoundAdjustment(value)
+	}
+
+	if raw := query.Get("grayscale"); raw != "" {
+		grayscale, err := strconv.ParseBool(raw)
+		if err != nil {
+			return opts, fmt.Errorf("invalid grayscale")
+		}
+		adjustments.Grayscale = grayscale
+	}
+
+	if err := adjustments.Validate(); err != nil {
+		return opts, err
+	}
+
+	if err := parseBands(r, &opts); err != nil {
+		return opts, err
+	}
+
+	return opts, nil
+}
+
+// parseBands reads the band selection: ?bands= lists 1 or 3 bands for a
+// composite, ?channel= is a single band, usually combined with ?colormap=
+func parseBands(r *http.Request, opts *render.TileOptions) error {
+	query := r.URL.Query()
+	rawBands, rawChannel := query.Get("bands"), query.Get("channel")
+	if rawBands != "" && rawChannel != "" {
+		return fmt.Errorf("use either bands or channel")
+	}
+	if rawChannel != "" {
+		rawBands = rawChannel
+	}
+	if rawBands != "" {
+		for _, raw := range strings.Split(rawBands, ",") {
+			band, err := strconv.Atoi(strings.TrimSpace(raw))
+			if err != nil {
+				return fmt.Errorf("invalid bands")
+			}
+			opts.Bands = append(opts.Bands, band)
+		}
+	}
+	opts.Colormap = query.Get("colormap")
+	return render.ValidateBands(opts.Bands, opts.Colormap)
+}
+
+// parsePage reads the 0-based ?page= of multi-page documents
+func parsePage(r *http.Request) (int, error) {
+	raw := r.URL.Query().Get("page")
+	if raw == "" {
+		return 0, nil
+	}
+	page, err := strconv.Atoi(raw)
+	if err != nil || page < 0 {
+		return 0, fmt.Errorf("invalid page")
+	}
+	return page, nil
+}
@@ -0,0 +1,269 @@
+package http
+
+import (
+	"encoding/xml"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"go.uber.org/zap"
+)
+
+// WMTS exposes georeferenced images to GIS clients (QGIS, ArcGIS) as OGC WMTS
+// 1.0.0 layers in the GoogleMapsCompatible (EPSG:3857) tile matrix set.
+// Both bindings are supported:
+//   - RESTful: /wmts/1.0.0/WMTSCapabilities.xml, tiles via the xyz endpoint
+//   - KVP: /wmts?SERVICE=WMTS&REQUEST=GetCapabilities|GetTile
+const wmtsMaxZoom = 24
+
+// wmtsFormats maps WMTS MIME types to tile extensions
+var wmtsFormats = map[string]string{
+	"image/png":  "png",
+	"image/jpeg": "jpeg",
+	"image/webp": "webp",
+}
+
+type wmtsLayer struct {
+	ID      string
+	Title   string
+	West    float64
+	South   float64
+	East    float64
+	North   float64
+	Limits  []wmtsLimit
+	BaseURL string
+}
+
+type wmtsLimit struct {
+	Zoom                           int
+	MinRow, MaxRow, MinCol, MaxCol int
+}
+
+type wmtsMatrix struct {
+	Zoom             int
+	ScaleDenominator float64
+	Size             int
+}
+
+var wmtsCapabilities = template.Must(template.New("wmts").Funcs(template.FuncMap{"x": xmlEscape}).Parse(`<?xml version="1.0" encoding="UTF-8"?>
+<Capabilities xmlns="http://www.opengis.net/wmts/1.0" xmlns:ows="http://www.opengis.net/ows/1.1" xmlns:xlink="http://www.w3.org/1999/xlink" version="1.0.0">
+  <ows:ServiceIdentification>
+    <ows:Title>Gigaview</ows:Title>
+    <ows:ServiceType>OGC WMTS</ows:ServiceType>
+    <ows:ServiceTypeVersion>1.0.0</ows:ServiceTypeVersion>
+  </ows:ServiceIdentification>
+  <ows:OperationsMetadata>
+    <ows:Operation name="GetCapabilities">
+      <ows:DCP><ows:HTTP><ows:Get xlink:href="{{x .BaseURL}}/wmts?"><ows:Constraint name="GetEncoding"><ows:AllowedValues><ows:Value>KVP</ows:Value></ows:AllowedValues></ows:Constraint></ows:Get></ows:HTTP></ows:DCP>
+    </ows:Operation>
+    <ows:Operation name="GetTile">
+      <ows:DCP><ows:HTTP><ows:Get xlink:href="{{x .BaseURL}}/wmts?"><ows:Constraint name="GetEncoding"><ows:AllowedValues><ows:Value>KVP</ows:Value></ows:AllowedValues></ows:Constraint></ows:Get></ows:HTTP></ows:DCP>
+    </ows:Operation>
+  </ows:OperationsMetadata>
+  <Contents>
+{{- range .Layers}}
+    <Layer>
+      <ows:Title>{{x .Title}}</ows:Title>
+      <ows:WGS84BoundingBox>
+        <ows:LowerCorner>{{.West}} {{.South}}</ows:LowerCorner>
+        <ows:UpperCorner>{{.East}} {{.North}}</ows:UpperCorner>
+      </ows:WGS84BoundingBox>
+      <ows:Identifier>{{x .ID}}</ows:Identifier>
+      <Style isDefault="true"><ows:Identifier>default</ows:Identifier></Style>
+      <Format>image/png</Format>
+      <Format>image/jpeg</Format>
+      <Format>image/webp</Format>
+      <TileMatrixSetLink>
+        <TileMatrixSet>GoogleMapsCompatible</TileMatrixSet>
+        <TileMatrixSetLimits>
+{{- range .Limits}}
+          <TileMatrixLimits><TileMatrix>{{.Zoom}}</TileMatrix><MinTileRow>{{.MinRow}}</MinTileRow><MaxTileRow>{{.MaxRow}}</MaxTileRow><MinTileCol>{{.MinCol}}</MinTileCol><MaxTileCol>{{.MaxCol}}</MaxTileCol></TileMatrixLimits>
+{{- end}}
+        </TileMatrixSetLimits>
+      </TileMatrixSetLink>
+      <ResourceURL format="image/png" resourceType="tile" template="{{x .BaseURL}}/api/images/{{x .ID}}/xyz/{TileMatrix}/{TileCol}/{TileRow}.png"/>
+      <ResourceURL format="image/jpeg" resourceType="tile" template="{{x .BaseURL}}/api/images/{{x .ID}}/xyz/{TileMatrix}/{TileCol}/{TileRow}.jpeg"/>
+      <ResourceURL format="image/webp" resourceType="tile" template="{{x .BaseURL}}/api/images/{{x .ID}}/xyz/{TileMatrix}/{TileCol}/{TileRow}.webp"/>
+    </Layer>
+{{- end}}
+    <TileMatrixSet>
+      <ows:Identifier>GoogleMapsCompatible</ows:Identifier>
+      <ows:SupportedCRS>urn:ogc:def:crs:EPSG::3857</ows:SupportedCRS>
+      <WellKnownScaleSet>urn:ogc:def:wkss:OGC:1.0:GoogleMapsCompatible</WellKnownScaleSet>
+{{- range .Matrices}}
+      <TileMatrix>
+        <ows:Identifier>{{.Zoom}}</ows:Identifier>
+        <ScaleDenominator>{{.ScaleDenominator}}</ScaleDenominator>
+        <TopLeftCorner>-20037508.3427892 20037508.3427892</TopLeftCorner>
+        <TileWidth>256</TileWidth>
+        <TileHeight>256</TileHeight>
+        <MatrixWidth>{{.Size}}</MatrixWidth>
+        <MatrixHeight>{{.Size}}</MatrixHeight>
+      </TileMatrix>
+{{- end}}
+    </TileMatrixSet>
+  </Contents>
+  <ServiceMetadataURL xlink:href="{{x .BaseURL}}/wmts/1.0.0/WMTSCapabilities.xml"/>
+</Capabilities>
+`))
+
+func xmlEscape(value string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(value))
+	return b.String()
+}
+
+// HandleWMTS serves the RESTful capabilities document and the KVP binding
+func (h *Handlers) HandleWMTS(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if r.URL.Path == "/wmts/1.0.0/WMTSCapabilities.xml" {
+		h.writeWMTSCapabilities(w, r)
+		return
+	}
+	if r.URL.Path != "/wmts" {
+		http.NotFound(w, r)
+		return
+	}
+
+	// KVP parameter names are case-insensitive
+	params := map[string]string{}
+	for key, values := range r.URL.Query() {
+		params[strings.ToUpper(key)] = values[0]
+	}
+
+	if !strings.EqualFold(params["SERVICE"], "WMTS") {
+		writeOWSException(w, http.StatusBadRequest, "MissingParameterValue", "service", "SERVICE=WMTS is required")
+		return
+	}
+
+	switch strings.ToLower(params["REQUEST"]) {
+	case "getcapabilities":
+		h.writeWMTSCapabilities(w, r)
+	case "gettile":
+		h.handleWMTSGetTile(w, r, params)
+	default:
+		writeOWSException(w, http.StatusBadRequest, "OperationNotSupported", "request", "Unsupported request")
+	}
+}
+
+func (h *Handlers) writeWMTSCapabilities(w http.ResponseWriter, r *http.Request) {
+	maxZoom := 0
+	var layers []wmtsLayer
+	for _, image := range h.scanner.GetImages() {
+		if image.Geo == nil {
+			continue
+		}
+		imageMaxZoom := h.renderer.XYZMaxZoom(image.ID)
+		maxZoom = max(maxZoom, imageMaxZoom)
+		b := image.Geo.Bounds
+		layers = append(layers, wmtsLayer{
+			ID:      image.ID,
+			Title:   image.OriginalFilename,
+			West:    b[0],
+			South:   b[1],
+			East:    b[2],
+			North:   b[3],
+			Limits:  wmtsLimits(b, imageMaxZoom),
+			BaseURL: h.settings().PublicBaseURL,
+		})
+	}
+
+	var matrices []wmtsMatrix
+	for z := 0; z <= min(maxZoom, wmtsMaxZoom); z++ {
+		matrices = append(matrices, wmtsMatrix{
+			Zoom:             z,
+			ScaleDenominator: 559082264.0287178 / math.Pow(2, float64(z)),
+			Size:             1 << z,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	setCacheControl(w, h.settings().CacheControlListing)
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	err := wmtsCapabilities.Execute(w, map[string]interface{}{
+		"BaseURL":  h.settings().PublicBaseURL,
+		"Layers":   layers,
+		"Matrices": matrices,
+	})
+	if err != nil {
+		h.log(r).Error("Failed to write WMTS capabilities", zap.Error(err))
+	}
+}
+
+// wmtsLimits returns the tile ranges covering the bounds at every zoom level
+func wmtsLimits(bounds [4]float64, maxZoom int) []wmtsLimit {
+	limits := make([]wmtsLimit, 0, maxZoom+1)
+	for z := 0; z <= min(maxZoom, wmtsMaxZoom); z++ {
+		minCol, minRow := lonLatToTile(bounds[0], bounds[3], z)
+		maxCol, maxRow := lonLatToTile(bounds[2], bounds[1], z)
+		limits = append(limits, wmtsLimit{Zoom: z, MinRow: minRow, MaxRow: maxRow, MinCol: minCol, MaxCol: maxCol})
+	}
+	return limits
+}
+
+// lonLatToTile returns the web-mercator tile containing a point
+func lonLatToTile(lon, lat float64, z int) (col, row int) {
+	n := math.Pow(2, float64(z))
+	latRad := lat * math.Pi / 180
+	col = int(math.Floor((lon + 180) / 360 * n))
+	row = int(math.Floor((1 - math.Log(math.Tan(latRad)+1/math.Cos(latRad))/math.Pi) / 2 * n))
+	last := int(n) - 1
+	return max(0, min(col, last)), max(0, min(row, last))
+}
+
+func (h *Handlers) handleWMTSGetTile(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	layer := h.scanner.ResolveID(params["LAYER"])
+	if image := h.scanner.GetImageByID(layer); image == nil || image.Geo == nil {
+		writeOWSException(w, http.StatusBadRequest, "InvalidParameterValue", "layer", "Unknown layer")
+		return
+	}
+
+	if set := params["TILEMATRIXSET"]; set != "" && set != "GoogleMapsCompatible" {
+		writeOWSException(w, http.StatusBadRequest, "InvalidParameterValue", "tilematrixset", "Unknown tile matrix set")
+		return
+	}
+
+	format := "png"
+	if mime := params["FORMAT"]; mime != "" {
+		var ok bool
+		if format, ok = wmtsFormats[mime]; !ok {
+			writeOWSException(w, http.StatusBadRequest, "InvalidParameterValue", "format", "Unsupported format")
+			return
+		}
+	}
+
+	coords := map[string]int{}
+	for _, name := range []string{"TILEMATRIX", "TILEROW", "TILECOL"} {
+		value, err := strconv.Atoi(params[name])
+		if err != nil || value < 0 {
+			writeOWSException(w, http.StatusBadRequest, "InvalidParameterValue", strings.ToLower(name), "Invalid "+strings.ToLower(name))
+			return
+		}
+		coords[name] = value
+	}
+
+	z, x, y := coords["TILEMATRIX"], coords["TILECOL"], coords["TILEROW"]
+	tileParts := []string{strconv.Itoa(z), strconv.Itoa(x), fmt.Sprintf("%d.%s", y, format)}
+	h.handleXYZTile(w, r, layer, tileParts)
+}
+
+// writeOWSException writes an OGC exception report
+func writeOWSException(w http.ResponseWriter, status int, code, locator, text string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?>
+<ows:ExceptionReport xmlns:ows="http://www.opengis.net/ows/1.1" version="1.1.0">
+  <ows:Exception exceptionCode="%s" locator="%s"><ows:ExceptionText>%s</ows:ExceptionText></ows:Exception>
+</ows:ExceptionReport>
+`, xmlEscape(code), xmlEscape(locator), xmlEscape(text))
+}
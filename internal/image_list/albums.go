@@ -0,0 +1,344 @@
+package image_list
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+)
+
+const (
+	maxAlbumNameLength = 200
+	maxAlbumImages     = 10000
+)
+
+var (
+	// ErrAlbumNotFound is returned for unknown album IDs
+	ErrAlbumNotFound = errors.New("album not found")
+	// ErrInvalidAlbum is returned for album changes that break its limits or
+	// reference unknown images
+	ErrInvalidAlbum = errors.New("invalid album")
+)
+
+// Album is a curated, ordered selection of images, independent of the
+// directories they are stored in. An image can be in any number of albums.
+type Album struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	// Images are image IDs in display order. Deleted images are kept here
+	// but left out when the album is resolved.
+	Images    []string  `json:"images"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// albumStore keeps the albums in one JSON file, rewritten on every change.
+// Unlike the image index it is the only copy, so writes go through a
+// temporary file and a rename.
+type albumStore struct {
+	path string
+
+	mu     sync.RWMutex
+	albums map[string]*Album
+}
+
+func openAlbumStore(path string) (*albumStore, error) {
+	store := &albumStore{path: path, albums: make(map[string]*Album)}
+	if path == "" {
+		return store, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read albums: %w", err)
+	}
+
+	var albums []*Album
+	if err := json.Unmarshal(data, &albums); err != nil {
+		return nil, fmt.Errorf("failed to parse albums: %w", err)
+	}
+	for _, album := range albums {
+		store.albums[album.ID] = album
+	}
+	return store, nil
+}
+
+// save writes all albums; the caller holds mu
+func (a *albumStore) save() error {
+	if a.path == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(a.list(), "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal albums: %w", err)
+	}
+	temp, err := os.CreateTemp(filepath.Dir(a.path), ".albums-*.json")
+	if err != nil {
+		return fmt.Errorf("failed to write albums: %w", err)
+	}
+	defer os.Remove(temp.Name())
+
+	if _, err := temp.Write(data); err != nil {
+		temp.Close()
+		return fmt.Errorf("failed to write albums: %w", err)
+	}
+	if err := temp.Close(); err != nil {
+		return fmt.Errorf("failed to write albums: %w", err)
+	}
+	if err := os.Rename(temp.Name(), a.path); err != nil {
+		return fmt.Errorf("failed to write albums: %w", err)
+	}
+	return nil
+}
+
+// list returns copies of all albums, oldest first; the caller holds mu
+func (a *albumStore) list() []*Album {
+	albums := make([]*Album, 0, len(a.albums))
+	for _, album := range a.albums {
+		albums = append(albums, album.clone())
+	}
+	sort.Slice(albums, func(i, j int) bool {
+		if !albums[i].CreatedAt.Equal(albums[j].CreatedAt) {
+			return albums[i].CreatedAt.Before(albums[j].CreatedAt)
+		}
+		return albums[i].ID < albums[j].ID
+	})
+	return albums
+}
+
+// update applies a change to one album and saves, or leaves the album
+// unchanged if change or saving fails
+func (a *albumStore) update(id string, change func(album *Album) error) (*Album, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	current, ok := a.albums[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrAlbumNotFound, id)
+	}
+	album := current.clone()
+	if err := change(album); err != nil {
+		return nil, err
+	}
+	album.UpdatedAt = time.Now()
+
+	a.albums[id] = album
+	if err := a.save(); err != nil {
+		a.albums[id] = current
+		return nil, err
+	}
+	return album.clone(), nil
+}
+
+// restore puts an album back as it was exported, replacing one with its ID
+func (a *albumStore) restore(album *Album) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	previous, existed := a.albums[album.ID]
+	a.albums[album.ID] = album
+	if err := a.save(); err != nil {
+		if existed {
+			a.albums[album.ID] = previous
+		} else {
+			delete(a.albums, album.ID)
+		}
+		return err
+	}
+	return nil
+}
+
+func (album *Album) clone() *Album {
+	copied := *album
+	copied.Images = slices.Clone(album.Images)
+	return &copied
+}
+
+// normalizeAlbumName trims a name and checks its length
+func normalizeAlbumName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", fmt.Errorf("%w: name is required", ErrInvalidAlbum)
+	}
+	if utf8.RuneCountInString(name) > maxAlbumNameLength {
+		return "", fmt.Errorf("%w: names are limited to %d characters", ErrInvalidAlbum, maxAlbumNameLength)
+	}
+	return name, nil
+}
+
+// checkAlbumImages verifies that images exist, resolves slugs to IDs and
+// drops duplicates, keeping the first occurrence
+func (s *Scanner) checkAlbumImages(images []string) ([]string, error) {
+	checked := make([]string, 0, len(images))
+	for _, ref := range images {
+		id := s.ResolveID(ref)
+		if s.GetImageByID(id) == nil {
+			return nil, fmt.Errorf("%w: unknown image %s", ErrInvalidAlbum, id)
+		}
+		if !slices.Contains(checked, id) {
+			checked = append(checked, id)
+		}
+	}
+	return checked, nil
+}
+
+// Albums lists all albums, oldest first
+func (s *Scanner) Albums() []*Album {
+	s.albums.mu.RLock()
+	defer s.albums.mu.RUnlock()
+	return s.albums.list()
+}
+
+// GetAlbum returns a copy of an album, or nil for unknown IDs
+func (s *Scanner) GetAlbum(id string) *Album {
+	s.albums.mu.RLock()
+	defer s.albums.mu.RUnlock()
+	album, ok := s.albums.albums[id]
+	if !ok {
+		return nil
+	}
+	return album.clone()
+}
+
+// AlbumImages resolves the images of an album in order, skipping deleted ones
+func (s *Scanner) AlbumImages(album *Album) []ImageInfo {
+	images := []ImageInfo{}
+	for _, id := range album.Images {
+		if image := s.GetImageByID(id); image != nil {
+			images = append(images, *image)
+		}
+	}
+	return images
+}
+
+// CreateAlbum creates an album with the given images in order
+func (s *Scanner) CreateAlbum(name, description string, images []string) (*Album, error) {
+	name, err := normalizeAlbumName(name)
+	if err != nil {
+		return nil, err
+	}
+	if images, err = s.checkAlbumImages(images); err != nil {
+		return nil, err
+	}
+	if len(images) > maxAlbumImages {
+		return nil, fmt.Errorf("%w: at most %d images per album", ErrInvalidAlbum, maxAlbumImages)
+	}
+
+	now := time.Now()
+	album := &Album{
+		ID:          uuid.New().String(),
+		Name:        name,
+		Description: strings.TrimSpace(description),
+		Images:      images,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+
+	s.albums.mu.Lock()
+	defer s.albums.mu.Unlock()
+	s.albums.albums[album.ID] = album
+	if err := s.albums.save(); err != nil {
+		delete(s.albums.albums, album.ID)
+		return nil, err
+	}
+	return album.clone(), nil
+}
+
+// UpdateAlbum renames an album or changes its description; nil leaves a
+// field unchanged
+func (s *Scanner) UpdateAlbum(id string, name, description *string) (*Album, error) {
+	return s.albums.update(id, func(album *Album) error {
+		if name != nil {
+			normalized, err := normalizeAlbumName(*name)
+			if err != nil {
+				return err
+			}
+			album.Name = normalized
+		}
+		if description != nil {
+			album.Description = strings.TrimSpace(*description)
+		}
+		return nil
+	})
+}
+
+// DeleteAlbum deletes an album; its images are not affected
+func (s *Scanner) DeleteAlbum(id string) error {
+	s.albums.mu.Lock()
+	defer s.albums.mu.Unlock()
+
+	album, ok := s.albums.albums[id]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrAlbumNotFound, id)
+	}
+	delete(s.albums.albums, id)
+	if err := s.albums.save(); err != nil {
+		s.albums.albums[id] = album
+		return err
+	}
+	return nil
+}
+
+// SetAlbumImages replaces the images of an album, which also reorders them
+func (s *Scanner) SetAlbumImages(id string, images []string) (*Album, error) {
+	images, err := s.checkAlbumImages(images)
+	if err != nil {
+		return nil, err
+	}
+	if len(images) > maxAlbumImages {
+		return nil, fmt.Errorf("%w: at most %d images per album", ErrInvalidAlbum, maxAlbumImages)
+	}
+	return s.albums.update(id, func(album *Album) error {
+		album.Images = images
+		return nil
+	})
+}
+
+// AddAlbumImages inserts images at a position of an album (negative or past
+// the end appends). Images already in the album are moved there.
+func (s *Scanner) AddAlbumImages(id string, images []string, position int) (*Album, error) {
+	images, err := s.checkAlbumImages(images)
+	if err != nil {
+		return nil, err
+	}
+	return s.albums.update(id, func(album *Album) error {
+		kept := slices.DeleteFunc(album.Images, func(existing string) bool {
+			return slices.Contains(images, existing)
+		})
+		if position < 0 || position > len(kept) {
+			position = len(kept)
+		}
+		album.Images = slices.Insert(kept, position, images...)
+		if len(album.Images) > maxAlbumImages {
+			return fmt.Errorf("%w: at most %d images per album", ErrInvalidAlbum, maxAlbumImages)
+		}
+		return nil
+	})
+}
+
+// RemoveAlbumImages removes images from an album, ignoring ones not in it
+func (s *Scanner) RemoveAlbumImages(id string, images []string) (*Album, error) {
+	for n, ref := range images {
+		images[n] = s.ResolveID(ref)
+	}
+	return s.albums.update(id, func(album *Album) error {
+		album.Images = slices.DeleteFunc(album.Images, func(existing string) bool {
+			return slices.Contains(images, existing)
+		})
+		return nil
+	})
+}
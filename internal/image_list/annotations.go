@@ -0,0 +1,325 @@
+package image_list
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+)
+
+const (
+	maxImageAnnotations   = 10000
+	maxAnnotationBodies   = 100
+	maxAnnotationComment  = 10000
+	maxAnnotationLabel    = 200
+	maxAnnotationCreator  = 200
+	maxAnnotationVertices = 10000
+)
+
+// Web Annotation vocabulary used by Annotation
+const (
+	AnnotationContext    = "http://www.w3.org/ns/anno.jsonld"
+	annotationType       = "Annotation"
+	textualBodyType      = "TextualBody"
+	fragmentSelectorType = "FragmentSelector"
+	svgSelectorType      = "SvgSelector"
+	mediaFragmentsSpec   = "http://www.w3.org/TR/media-frags/"
+	purposeCommenting    = "commenting"
+	purposeTagging       = "tagging"
+)
+
+var (
+	// ErrAnnotationNotFound is returned for unknown annotation IDs
+	ErrAnnotationNotFound = errors.New("annotation not found")
+	// ErrInvalidAnnotation is returned for annotations outside the supported
+	// subset of the model or the limits
+	ErrInvalidAnnotation = errors.New("invalid annotation")
+	// ErrAnnotationsUnavailable is returned without a persistent index,
+	// which is where annotations are kept
+	ErrAnnotationsUnavailable = errors.New("annotations need the image index")
+)
+
+// Annotation marks a region of an image: a rectangle, polygon or point,
+// with comments, labels and the name of its author. It follows the W3C Web
+// Annotation data model, restricted to textual bodies and two selectors:
+//
+//	FragmentSelector  "xywh=pixel:x,y,w,h", a rectangle, or a point if w and h are 0
+//	SvgSelector       "<svg><polygon points=\"x,y x,y x,y\"/></svg>"
+//
+// Coordinates are pixels of the full-resolution image. Stored annotations
+// carry bare IDs; the API turns them into URLs.
+type Annotation struct {
+	Context  string             `json:"@context,omitempty"`
+	ID       string             `json:"id"`
+	Type     string             `json:"type"`
+	Creator  *AnnotationCreator `json:"creator,omitempty"`
+	Created  time.Time          `json:"created"`
+	Modified time.Time          `json:"modified"`
+	Body     []AnnotationBody   `json:"body"`
+	Target   AnnotationTarget   `json:"target"`
+}
+
+// AnnotationCreator is the author of an annotation, as given by the client
+type AnnotationCreator struct {
+	Type string `json:"type,omitempty"`
+	Name string `json:"name"`
+}
+
+// AnnotationBody is a comment (purpose "commenting") or a label ("tagging")
+type AnnotationBody struct {
+	Type    string `json:"type"`
+	Value   string `json:"value"`
+	Purpose string `json:"purpose,omitempty"`
+}
+
+// AnnotationTarget is the annotated region; Source is the image
+type AnnotationTarget struct {
+	Source   string             `json:"source"`
+	Selector AnnotationSelector `json:"selector"`
+}
+
+// AnnotationSelector locates the region within the image
+type AnnotationSelector struct {
+	Type       string `json:"type"`
+	ConformsTo string `json:"conformsTo,omitempty"`
+	Value      string `json:"value"`
+}
+
+var (
+	fragmentPattern = regexp.MustCompile(`^xywh=(?:pixel:)?([^,]+),([^,]+),([^,]+),([^,]+)$`)
+	polygonPattern  = regexp.MustCompile(`^<svg[^>]*>\s*<polygon\s+points="([^"]*)"\s*/?>\s*(?:</polygon>\s*)?</svg>$`)
+)
+
+// normalizeAnnotation checks the parts of an annotation a client sets
+// against the image and rewrites them in canonical form. Selector values are
+// regenerated from the parsed coordinates, so no client markup is stored.
+func normalizeAnnotation(annotation *Annotation, imageInfo *ImageInfo) error {
+	if annotation.Creator != nil {
+		name := strings.TrimSpace(annotation.Creator.Name)
+		if utf8.RuneCountInString(name) > maxAnnotationCreator {
+			return fmt.Errorf("%w: creator names are limited to %d characters", ErrInvalidAnnotation, maxAnnotationCreator)
+		}
+		if name == "" {
+			annotation.Creator = nil
+		} else {
+			annotation.Creator = &AnnotationCreator{Type: "Person", Name: name}
+		}
+	}
+
+	if len(annotation.Body) > maxAnnotationBodies {
+		return fmt.Errorf("%w: at most %d bodies", ErrInvalidAnnotation, maxAnnotationBodies)
+	}
+	bodies := make([]AnnotationBody, 0, len(annotation.Body))
+	for _, body := range annotation.Body {
+		if body.Type != "" && body.Type != textualBodyType {
+			return fmt.Errorf("%w: unsupported body type %q", ErrInvalidAnnotation, body.Type)
+		}
+		value := strings.TrimSpace(body.Value)
+		limit := maxAnnotationComment
+		switch body.Purpose {
+		case "":
+			body.Purpose = purposeCommenting
+		case purposeCommenting:
+		case purposeTagging:
+			limit = maxAnnotationLabel
+		default:
+			return fmt.Errorf("%w: unsupported body purpose %q", ErrInvalidAnnotation, body.Purpose)
+		}
+		if value == "" {
+			return fmt.Errorf("%w: empty body", ErrInvalidAnnotation)
+		}
+		if utf8.RuneCountInString(value) > limit {
+			return fmt.Errorf("%w: %s bodies are limited to %d characters", ErrInvalidAnnotation, body.Purpose, limit)
+		}
+		bodies = append(bodies, AnnotationBody{Type: textualBodyType, Value: value, Purpose: body.Purpose})
+	}
+	annotation.Body = bodies
+
+	selector, err := normalizeSelector(annotation.Target.Selector, float64(imageInfo.Width), float64(imageInfo.Height))
+	if err != nil {
+		return err
+	}
+	annotation.Target = AnnotationTarget{Source: imageInfo.ID, Selector: selector}
+	return nil
+}
+
+// normalizeSelector parses a rectangle, point or polygon that has to lie
+// within an image of the given size
+func normalizeSelector(selector AnnotationSelector, width, height float64) (AnnotationSelector, error) {
+	inside := func(x, y float64) bool {
+		return x >= 0 && y >= 0 && x <= width && y <= height
+	}
+
+	switch selector.Type {
+	case fragmentSelectorType:
+		match := fragmentPattern.FindStringSubmatch(strings.TrimSpace(selector.Value))
+		if match == nil {
+			return selector, fmt.Errorf("%w: fragment selectors take xywh=pixel:x,y,w,h", ErrInvalidAnnotation)
+		}
+		var xywh [4]float64
+		for n := range xywh {
+			value, err := strconv.ParseFloat(strings.TrimSpace(match[n+1]), 64)
+			if err != nil {
+				return selector, fmt.Errorf("%w: invalid coordinate %q", ErrInvalidAnnotation, match[n+1])
+			}
+			xywh[n] = value
+		}
+		x, y, w, h := xywh[0], xywh[1], xywh[2], xywh[3]
+		if w < 0 || h < 0 || !inside(x, y) || !inside(x+w, y+h) {
+			return selector, fmt.Errorf("%w: region outside the image", ErrInvalidAnnotation)
+		}
+		return AnnotationSelector{
+			Type:       fragmentSelectorType,
+			ConformsTo: mediaFragmentsSpec,
+			Value:      "xywh=pixel:" + formatCoordinates(",", x, y, w, h),
+		}, nil
+
+	case svgSelectorType:
+		match := polygonPattern.FindStringSubmatch(strings.TrimSpace(selector.Value))
+		if match == nil {
+			return selector, fmt.Errorf("%w: SVG selectors take a single polygon", ErrInvalidAnnotation)
+		}
+		values := strings.FieldsFunc(match[1], func(r rune) bool {
+			return r == ',' || r == ' ' || r == '\t' || r == '\n' || r == '\r'
+		})
+		if len(values)%2 != 0 || len(values) < 6 {
+			return selector, fmt.Errorf("%w: polygons need at least 3 points", ErrInvalidAnnotation)
+		}
+		if len(values) > 2*maxAnnotationVertices {
+			return selector, fmt.Errorf("%w: polygons are limited to %d points", ErrInvalidAnnotation, maxAnnotationVertices)
+		}
+		points := make([]string, 0, len(values)/2)
+		for n := 0; n < len(values); n += 2 {
+			x, errX := strconv.ParseFloat(values[n], 64)
+			y, errY := strconv.ParseFloat(values[n+1], 64)
+			if errX != nil || errY != nil {
+				return selector, fmt.Errorf("%w: invalid point %s,%s", ErrInvalidAnnotation, values[n], values[n+1])
+			}
+			if !inside(x, y) {
+				return selector, fmt.Errorf("%w: region outside the image", ErrInvalidAnnotation)
+			}
+			points = append(points, formatCoordinates(",", x, y))
+		}
+		return AnnotationSelector{
+			Type:  svgSelectorType,
+			Value: `<svg><polygon points="` + strings.Join(points, " ") + `"/></svg>`,
+		}, nil
+
+	default:
+		return selector, fmt.Errorf("%w: unsupported selector type %q", ErrInvalidAnnotation, selector.Type)
+	}
+}
+
+func formatCoordinates(separator string, values ...float64) string {
+	formatted := make([]string, len(values))
+	for n, value := range values {
+		formatted[n] = strconv.FormatFloat(value, 'f', -1, 64)
+	}
+	return strings.Join(formatted, separator)
+}
+
+// annotationImage returns the image annotations are requested for
+func (s *Scanner) annotationImage(imageID string) (*ImageInfo, error) {
+	if s.index == nil {
+		return nil, ErrAnnotationsUnavailable
+	}
+	imageInfo := s.GetImageByID(imageID)
+	if imageInfo == nil {
+		return nil, fmt.Errorf("%w: %s", ErrImageNotFound, imageID)
+	}
+	return imageInfo, nil
+}
+
+// Annotations lists the annotations of an image, oldest first
+func (s *Scanner) Annotations(imageID string) ([]*Annotation, error) {
+	if _, err := s.annotationImage(imageID); err != nil {
+		return nil, err
+	}
+	annotations, err := s.index.annotations(imageID)
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(annotations, func(i, j int) bool {
+		return annotations[i].Created.Before(annotations[j].Created)
+	})
+	return annotations, nil
+}
+
+// GetAnnotation returns one annotation of an image
+func (s *Scanner) GetAnnotation(imageID, id string) (*Annotation, error) {
+	annotations, err := s.Annotations(imageID)
+	if err != nil {
+		return nil, err
+	}
+	for _, annotation := range annotations {
+		if annotation.ID == id {
+			return annotation, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrAnnotationNotFound, id)
+}
+
+// CreateAnnotation adds an annotation to an image. Its creator, body and
+// target are taken from the argument; ID and timestamps are assigned.
+func (s *Scanner) CreateAnnotation(imageID string, annotation *Annotation) (*Annotation, error) {
+	imageInfo, err := s.annotationImage(imageID)
+	if err != nil {
+		return nil, err
+	}
+	if err := normalizeAnnotation(annotation, imageInfo); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	annotation.Context = ""
+	annotation.ID = uuid.New().String()
+	annotation.Type = annotationType
+	annotation.Created = now
+	annotation.Modified = now
+	if err := s.index.putAnnotation(imageID, annotation, maxImageAnnotations); err != nil {
+		return nil, err
+	}
+	return annotation, nil
+}
+
+// UpdateAnnotation replaces the creator, body and target of an annotation
+func (s *Scanner) UpdateAnnotation(imageID, id string, annotation *Annotation) (*Annotation, error) {
+	current, err := s.GetAnnotation(imageID, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := normalizeAnnotation(annotation, s.GetImageByID(imageID)); err != nil {
+		return nil, err
+	}
+
+	annotation.Context = ""
+	annotation.ID = current.ID
+	annotation.Type = annotationType
+	annotation.Created = current.Created
+	annotation.Modified = time.Now()
+	if err := s.index.putAnnotation(imageID, annotation, 0); err != nil {
+		return nil, err
+	}
+	return annotation, nil
+}
+
+// DeleteAnnotation deletes one annotation of an image
+func (s *Scanner) DeleteAnnotation(imageID, id string) error {
+	if _, err := s.annotationImage(imageID); err != nil {
+		return err
+	}
+	found, err := s.index.deleteAnnotation(imageID, id)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("%w: %s", ErrAnnotationNotFound, id)
+	}
+	return nil
+}
@@ -0,0 +1,155 @@
+package image_list
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Collection is a directory of the data directory with the images in it
+type Collection struct {
+	// Path is slash-separated and relative to the data directory ("" for the top level)
+	Path string `json:"path"`
+	Name string `json:"name"`
+	// Images counts the images directly in the collection, TotalImages
+	// includes nested collections
+	Images      int           `json:"images"`
+	TotalImages int           `json:"total_images"`
+	Children    []*Collection `json:"children,omitempty"`
+}
+
+// isStateDir reports whether a top-level directory of the metadata directory
+// holds files the scanner keeps for itself
+func isStateDir(name string) bool {
+	return name == rawDir || name == thumbnailDir || name == quarantineDir || name == stagingDir
+}
+
+// isCollectionDir reports whether a subdirectory is scanned as a collection.
+// Hidden directories, the directories the server keeps its own files in and
+// MIRAX slide data (a directory next to {name}.mrxs) are skipped.
+func (s *Scanner) isCollectionDir(dir, collection, name string) bool {
+	if strings.HasPrefix(name, ".") {
+		return false
+	}
+	if collection == "" && s.metadataDir == s.dataDir && isStateDir(name) {
+		return false
+	}
+	if _, err := os.Stat(filepath.Join(dir, name+".mrxs")); err == nil {
+		return false
+	}
+
+	full := filepath.Clean(filepath.Join(dir, name))
+	for _, excluded := range s.options.ExcludeDirs {
+		if excluded != "" && filepath.Clean(excluded) == full {
+			return false
+		}
+	}
+	return true
+}
+
+// Collections returns the collection tree, rooted at the top level of the
+// data directory
+func (s *Scanner) Collections() *Collection {
+	current := s.registry.Load()
+	images, collections := current.images, current.collections
+
+	root := &Collection{}
+	nodes := map[string]*Collection{"": root}
+	// Parents are always listed before their children
+	for _, collectionPath := range collections {
+		node := &Collection{Path: collectionPath, Name: path.Base(collectionPath)}
+		nodes[collectionPath] = node
+		parent := nodes[parentCollection(collectionPath)]
+		parent.Children = append(parent.Children, node)
+	}
+
+	for _, image := range images {
+		node := nodes[image.Collection]
+		if node == nil {
+			continue
+		}
+		node.Images++
+		for collectionPath := image.Collection; ; collectionPath = parentCollection(collectionPath) {
+			nodes[collectionPath].TotalImages++
+			if collectionPath == "" {
+				break
+			}
+		}
+	}
+	return root
+}
+
+// GetCollection returns one node of the collection tree, or nil if the
+// collection doesn't exist
+func (s *Scanner) GetCollection(collectionPath string) *Collection {
+	node := s.Collections()
+	for _, name := range splitCollection(collectionPath) {
+		var child *Collection
+		for _, candidate := range node.Children {
+			if candidate.Name == name {
+				child = candidate
+				break
+			}
+		}
+		if child == nil {
+			return nil
+		}
+		node = child
+	}
+	return node
+}
+
+// GetImagesInCollection lists the images of a collection, including nested
+// collections when recursive is set
+func (s *Scanner) GetImagesInCollection(collectionPath string, recursive bool) []ImageInfo {
+	collectionPath = strings.Join(splitCollection(collectionPath), "/")
+	images := []ImageInfo{}
+	for _, image := range s.GetImages() {
+		if image.Collection == collectionPath ||
+			(recursive && (collectionPath == "" || strings.HasPrefix(image.Collection, collectionPath+"/"))) {
+			images = append(images, image)
+		}
+	}
+	return images
+}
+
+// collectionsOf derives the collection list from the images in it. Sorting
+// keeps parents before their children.
+func collectionsOf(images []ImageInfo) []string {
+	seen := make(map[string]bool)
+	for _, image := range images {
+		for collectionPath := image.Collection; collectionPath != "" && !seen[collectionPath]; collectionPath = parentCollection(collectionPath) {
+			seen[collectionPath] = true
+		}
+	}
+
+	collections := make([]string, 0, len(seen))
+	for collectionPath := range seen {
+		collections = append(collections, collectionPath)
+	}
+	sort.Strings(collections)
+	return collections
+}
+
+// parentCollection returns the collection containing another one
+func parentCollection(collectionPath string) string {
+	parent := path.Dir(collectionPath)
+	if parent == "." {
+		return ""
+	}
+	return parent
+}
+
+// splitCollection splits a collection path into directory names, ignoring
+// leading, trailing and doubled slashes
+func splitCollection(collectionPath string) []string {
+	var names []string
+	for _, name := range strings.Split(collectionPath, "/") {
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
@@ -0,0 +1,182 @@
+package image_list
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cshum/vipsgen/vips"
+	"go.uber.org/zap"
+)
+
+// ErrNotConvertible is returned by ConvertToPyramid for images it leaves
+// alone, see NeedsPyramid
+var ErrNotConvertible = errors.New("image can't be converted to a pyramid")
+
+// convertibleExtensions are the formats ConvertToPyramid rewrites. Vector,
+// multi-page and slide formats have pyramids of their own or none to gain.
+var convertibleExtensions = map[string]bool{
+	".jpg": true, ".jpeg": true, ".png": true, ".webp": true,
+	".heic": true, ".heif": true, ".jxl": true, ".tif": true, ".tiff": true,
+}
+
+// ConvertOptions controls ConvertToPyramid
+type ConvertOptions struct {
+	// MinDimension skips images whose longest side is at most this many
+	// pixels; they render fast enough from their source
+	MinDimension int
+	// Quality is the JPEG quality of the tiles (default 90)
+	Quality int
+	// Lossless stores the tiles with deflate instead of JPEG. Images with
+	// alpha or more than 8 bits per sample always are.
+	Lossless bool
+	// DiscardOriginal deletes the source file instead of keeping it as the
+	// master of the image under raw/, like the masters of RAW files
+	DiscardOriginal bool
+}
+
+// NeedsPyramid reports whether ConvertToPyramid would rewrite an image: a
+// local, single-page raster image without a pyramid of its own that isn't
+// developed from a RAW file. GeoTIFFs are left alone to keep their tags,
+// and preserve mode can't rename files.
+func (s *Scanner) NeedsPyramid(imageInfo *ImageInfo, options ConvertOptions) bool {
+	if s.options.PreserveNames || isStaged(imageInfo) || imageInfo.RawFilename != "" ||
+		imageInfo.Geo != nil || len(imageInfo.Pages) > 0 {
+		return false
+	}
+	if !convertibleExtensions[strings.ToLower(filepath.Ext(imageInfo.CurrentFilename))] {
+		return false
+	}
+	if max(imageInfo.Width, imageInfo.Height) <= options.MinDimension {
+		return false
+	}
+	path := s.imagePath(imageInfo)
+	return !isTiff(path) || !isPyramidalTiff(path)
+}
+
+// isPyramidalTiff reports whether a TIFF has reduced-resolution levels as
+// sub-IFDs or as pages that halve in size
+func isPyramidalTiff(path string) bool {
+	image, err := LoadTiffPage(path, 0, vips.AccessSequential)
+	if err != nil {
+		return false
+	}
+	subifds, _ := image.GetInt("n-subifds")
+	pages := image.Pages()
+	image.Close()
+	if subifds > 0 {
+		return true
+	}
+	if pages < 2 {
+		return false
+	}
+	sizes, err := readTiffPageSizes(path)
+	return err == nil && isPagePyramid(sizes)
+}
+
+// ConvertToPyramid rewrites an image as a tiled, pyramidal TIFF
+// ({uuid}.tif), so its tiles render from the nearest level instead of the
+// full image. The TIFF is written next to the source and swapped in once
+// complete; the source is kept as the master of the image unless
+// DiscardOriginal is set. The sidecar is updated and the next scan reads the
+// new file. It returns ErrNotConvertible for images NeedsPyramid rejects.
+func (s *Scanner) ConvertToPyramid(id string, options ConvertOptions) error {
+	imageInfo := s.GetImageByID(id)
+	if imageInfo == nil {
+		return fmt.Errorf("%w: %s", ErrImageNotFound, id)
+	}
+	if !s.NeedsPyramid(imageInfo, options) {
+		return ErrNotConvertible
+	}
+	if options.Quality <= 0 {
+		options.Quality = 90
+	}
+
+	path := s.imagePath(imageInfo)
+	dir := filepath.Dir(path)
+	finalName := id + ".tif"
+	finalPath := filepath.Join(dir, finalName)
+	tempPath := finalPath + ".tmp"
+
+	if err := s.writePyramid(path, tempPath, options); err != nil {
+		os.Remove(tempPath)
+		return err
+	}
+
+	var master string
+	if !options.DiscardOriginal {
+		if err := os.MkdirAll(s.statePath(rawDir), 0755); err != nil {
+			os.Remove(tempPath)
+			return fmt.Errorf("failed to create raw directory: %w", err)
+		}
+		master = id + strings.ToLower(filepath.Ext(path))
+		masterPath := filepath.Join(s.statePath(rawDir), master)
+		// A link keeps the source in place until the swap
+		if err := os.Link(path, masterPath); err != nil {
+			if err := copyFile(path, masterPath); err != nil {
+				os.Remove(tempPath)
+				return fmt.Errorf("failed to keep original: %w", err)
+			}
+		}
+	}
+
+	if err := os.Rename(tempPath, finalPath); err != nil {
+		os.Remove(tempPath)
+		return fmt.Errorf("failed to replace image: %w", err)
+	}
+	// The fingerprint of the old file makes the next scan read the new one,
+	// keeping the fields set by users
+	err := s.updateMetadata(id, func(meta *ImageInfo) error {
+		meta.CurrentFilename = finalName
+		meta.RawFilename = master
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update metadata: %w", err)
+	}
+	if path != finalPath {
+		if err := os.Remove(path); err != nil {
+			s.logger.Warn("Failed to remove converted original", zap.String("path", path), zap.Error(err))
+		}
+	}
+
+	s.logger.Info("Converted image to pyramidal TIFF", zap.String("uuid", id), zap.String("path", finalPath), zap.String("master", master))
+	return nil
+}
+
+// writePyramid saves an upright copy of an image as a tiled, pyramidal TIFF
+func (s *Scanner) writePyramid(source, target string, options ConvertOptions) error {
+	image, err := s.loadImage(source)
+	if err != nil {
+		return fmt.Errorf("failed to open image: %w", err)
+	}
+	defer image.Close()
+
+	// Bake the orientation in; the TIFF is scanned as upright
+	if image.Orientation() > 1 {
+		if err := image.Autorot(nil); err != nil {
+			return fmt.Errorf("failed to apply orientation: %w", err)
+		}
+	}
+
+	saveOpts := vips.DefaultTiffsaveOptions()
+	// JPEG-compressed TIFF only takes 8-bit images without alpha
+	if options.Lossless || image.HasAlpha() || image.BandFormat() != vips.BandFormatUchar {
+		saveOpts.Compression = vips.TiffCompressionDeflate
+		saveOpts.Predictor = vips.TiffPredictorHorizontal
+	} else {
+		saveOpts.Compression = vips.TiffCompressionJpeg
+		saveOpts.Q = options.Quality
+	}
+	saveOpts.Tile = true
+	saveOpts.TileWidth = 256
+	saveOpts.TileHeight = 256
+	saveOpts.Pyramid = true
+	saveOpts.Bigtiff = true
+	if err := image.Tiffsave(target, saveOpts); err != nil {
+		return fmt.Errorf("failed to write pyramidal tiff: %w", err)
+	}
+	return nil
+}
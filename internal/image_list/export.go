@@ -0,0 +1,237 @@
+package image_list
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"slices"
+	"time"
+
+	"go.uber.org/zap"
+
+	"gigaview/internal/logger"
+)
+
+// exportVersion is the format version of Export dumps
+const exportVersion = 1
+
+// ErrInvalidExport is returned when importing a dump of an unknown format
+var ErrInvalidExport = errors.New("invalid export")
+
+// Export is a dump of the library metadata: image records, albums,
+// annotations by image ID and collections. Image files are not part of it.
+type Export struct {
+	Version     int                      `json:"version"`
+	ExportedAt  time.Time                `json:"exported_at"`
+	Images      []ImageInfo              `json:"images"`
+	Albums      []*Album                 `json:"albums"`
+	Annotations map[string][]*Annotation `json:"annotations,omitempty"`
+	Collections []string                 `json:"collections"`
+}
+
+// ImportResult reports what an import restored. Unmatched lists the IDs of
+// exported images without a counterpart here; Skipped describes fields that
+// couldn't be restored.
+type ImportResult struct {
+	Images      int      `json:"images"`
+	Albums      int      `json:"albums"`
+	Annotations int      `json:"annotations"`
+	Unmatched   []string `json:"unmatched"`
+	Skipped     []string `json:"skipped"`
+}
+
+// Export dumps the metadata of all images, albums, annotations and
+// collections
+func (s *Scanner) Export() *Export {
+	current := s.registry.Load()
+	export := &Export{
+		Version:     exportVersion,
+		ExportedAt:  time.Now(),
+		Images:      slices.Clone(current.images),
+		Albums:      s.Albums(),
+		Collections: slices.Clone(current.collections),
+	}
+	if s.index == nil {
+		return export
+	}
+	export.Annotations = make(map[string][]*Annotation)
+	for _, image := range current.images {
+		annotations, err := s.index.annotations(image.ID)
+		if err != nil {
+			s.logger.Warn("Failed to export annotations", zap.String("uuid", image.ID), zap.Error(err))
+			continue
+		}
+		if len(annotations) > 0 {
+			export.Annotations[image.ID] = annotations
+		}
+	}
+	return export
+}
+
+// Import restores a dump onto the images of this library, e.g. after
+// moving the files to a new instance. Exported images are matched by ID,
+// then by content hash, then by collection, file name and size, so files
+// that were ingested again under new IDs are found too. Their user-set
+// fields (title, copyright, tags, slug, padding colour, display range) are
+// replaced; what is read from the files is not. Albums are restored by ID
+// with their images mapped to the matches, annotations by ID onto the
+// matching images. It rescans before returning.
+func (s *Scanner) Import(ctx context.Context, export *Export) (*ImportResult, error) {
+	if export.Version != exportVersion {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrInvalidExport, export.Version)
+	}
+
+	s.scanMu.Lock()
+	defer s.scanMu.Unlock()
+
+	log := logger.FromContext(ctx, s.logger)
+	result := &ImportResult{Unmatched: []string{}, Skipped: []string{}}
+	current := s.registry.Load()
+	matches := s.matchImports(current, export.Images)
+
+	// Slugs of images that aren't restored stay taken
+	slugs := make(map[string]string)
+	for _, image := range current.images {
+		if image.Slug != "" {
+			slugs[image.Slug] = image.ID
+		}
+	}
+	for _, exported := range export.Images {
+		if id, ok := matches[exported.ID]; ok {
+			if slug := current.get(id).Slug; slug != "" && slugs[slug] == id {
+				delete(slugs, slug)
+			}
+		}
+	}
+
+	for _, exported := range export.Images {
+		id, ok := matches[exported.ID]
+		if !ok {
+			result.Unmatched = append(result.Unmatched, exported.ID)
+			continue
+		}
+
+		slug := exported.Slug
+		if slug != "" {
+			if _, err := NormalizeSlug(slug); err != nil {
+				result.Skipped = append(result.Skipped, fmt.Sprintf("slug %s of %s: %v", slug, exported.ID, err))
+				slug = ""
+			} else if owner, taken := slugs[slug]; taken && owner != id {
+				result.Skipped = append(result.Skipped, fmt.Sprintf("slug %s of %s: %v", slug, exported.ID, ErrSlugTaken))
+				slug = ""
+			} else {
+				slugs[slug] = id
+			}
+		}
+		tags, err := NormalizeTags(exported.Tags)
+		if err != nil {
+			result.Skipped = append(result.Skipped, fmt.Sprintf("tags of %s: %v", exported.ID, err))
+			tags = nil
+		}
+
+		err = s.updateMetadata(id, func(meta *ImageInfo) error {
+			meta.Title = exported.Title
+			meta.CopyrightText = exported.CopyrightText
+			meta.CopyrightLink = exported.CopyrightLink
+			meta.Tags = tags
+			meta.Slug = slug
+			meta.PaddingColor = exported.PaddingColor
+			meta.DisplayRange = exported.DisplayRange
+			meta.Warmup = exported.Warmup
+			return nil
+		})
+		if err != nil {
+			return result, fmt.Errorf("failed to restore %s: %w", exported.ID, err)
+		}
+		result.Images++
+	}
+
+	for _, album := range export.Albums {
+		restored := album.clone()
+		restored.Images = restored.Images[:0]
+		for _, id := range album.Images {
+			if match, ok := matches[id]; ok {
+				restored.Images = append(restored.Images, match)
+			}
+		}
+		name, err := normalizeAlbumName(restored.Name)
+		if err != nil || restored.ID == "" {
+			result.Skipped = append(result.Skipped, fmt.Sprintf("album %q: %v", album.Name, ErrInvalidAlbum))
+			continue
+		}
+		restored.Name = name
+		if err := s.albums.restore(restored); err != nil {
+			return result, err
+		}
+		result.Albums++
+	}
+
+	for exportedID, annotations := range export.Annotations {
+		id, ok := matches[exportedID]
+		if !ok {
+			continue
+		}
+		if s.index == nil {
+			result.Skipped = append(result.Skipped, fmt.Sprintf("annotations of %s: %v", exportedID, ErrAnnotationsUnavailable))
+			continue
+		}
+		for _, annotation := range annotations {
+			if annotation.ID == "" {
+				result.Skipped = append(result.Skipped, fmt.Sprintf("annotation of %s: %v", exportedID, ErrInvalidAnnotation))
+				continue
+			}
+			annotation.Target.Source = id
+			if err := s.index.putAnnotation(id, annotation, 0); err != nil {
+				return result, err
+			}
+			result.Annotations++
+		}
+	}
+
+	if err := s.scan(context.Background()); err != nil {
+		log.Warn("Failed to rescan after import", zap.Error(err))
+	}
+	log.Info("Imported metadata",
+		zap.Int("images", result.Images),
+		zap.Int("albums", result.Albums),
+		zap.Int("annotations", result.Annotations),
+		zap.Int("unmatched", len(result.Unmatched)))
+	return result, nil
+}
+
+// matchImports maps exported image IDs to the images they describe here
+func (s *Scanner) matchImports(current *registry, exported []ImageInfo) map[string]string {
+	type fileKey struct {
+		path  string
+		bytes int64
+	}
+	byFile := make(map[fileKey][]string)
+	for _, image := range current.images {
+		key := fileKey{path.Join(image.Collection, image.OriginalFilename), image.Bytes}
+		byFile[key] = append(byFile[key], image.ID)
+	}
+
+	matches := make(map[string]string)
+	claimed := make(map[string]bool)
+	for _, image := range exported {
+		var id string
+		switch {
+		case current.get(image.ID) != nil:
+			id = image.ID
+		case image.SHA256 != "" && current.findBySHA256(image.SHA256) != nil:
+			id = current.findBySHA256(image.SHA256).ID
+		default:
+			// Only unambiguous names count
+			candidates := byFile[fileKey{path.Join(image.Collection, image.OriginalFilename), image.Bytes}]
+			if len(candidates) == 1 {
+				id = candidates[0]
+			}
+		}
+		if id != "" && !claimed[id] {
+			matches[image.ID] = id
+			claimed[id] = true
+		}
+	}
+	return matches
+}
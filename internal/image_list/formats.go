@@ -0,0 +1,162 @@
+package image_list
+
+import (
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/cshum/vipsgen/vips"
+)
+
+// SupportedExtensions lists the file extensions accepted for scanning and upload
+var SupportedExtensions = map[string]bool{
+	".tif":  true,
+	".tiff": true,
+	".jpg":  true,
+	".jpeg": true,
+	".png":  true,
+	".webp": true,
+	".pdf":  true,
+	".heic": true,
+	".heif": true,
+	".jxl":  true,
+	".svg":  true,
+	// Whole-slide formats, decoded through OpenSlide
+	".svs":  true,
+	".ndpi": true,
+	".mrxs": true,
+	".scn":  true,
+	".vms":  true,
+	".bif":  true,
+	// Virtual mosaics of other images, see mosaic.go
+	".mosaic": true,
+}
+
+// slideExtensions are vendor formats that are only readable through OpenSlide
+var slideExtensions = map[string]bool{
+	".svs":  true,
+	".ndpi": true,
+	".mrxs": true,
+	".scn":  true,
+	".vms":  true,
+	".bif":  true,
+}
+
+// Supports reports whether a file extension (with dot, any case) can be
+// served, taking the optional format toggles into account
+func (s *Scanner) Supports(ext string) bool {
+	ext = strings.ToLower(ext)
+	switch ext {
+	case ".heic", ".heif":
+		return s.options.HEIF
+	case ".jxl":
+		return s.options.JXL
+	}
+	// Developing RAW files moves them out of the data directory
+	if rawExtensions[ext] {
+		return s.options.RAW && !s.options.PreserveNames
+	}
+	return SupportedExtensions[ext]
+}
+
+// IsSlide reports whether path is a whole-slide image that must be opened with OpenSlide
+func IsSlide(path string) bool {
+	return slideExtensions[strings.ToLower(filepath.Ext(path))]
+}
+
+func isTiff(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	return ext == ".tif" || ext == ".tiff"
+}
+
+func isPDF(path string) bool {
+	return strings.ToLower(filepath.Ext(path)) == ".pdf"
+}
+
+// LoadTiffPage opens one page (directory) of a TIFF
+func LoadTiffPage(path string, page int, access vips.Access) (*vips.Image, error) {
+	opts := vips.DefaultTiffloadOptions()
+	opts.Page = page
+	opts.Access = access
+	return vips.NewTiffload(path, opts)
+}
+
+// LoadHEIF opens the primary image of a HEIC/HEIF file
+func LoadHEIF(path string, access vips.Access) (*vips.Image, error) {
+	opts := vips.DefaultHeifloadOptions()
+	opts.Access = access
+	return vips.NewHeifload(path, opts)
+}
+
+// LoadJXL opens a JPEG XL file
+func LoadJXL(path string, access vips.Access) (*vips.Image, error) {
+	opts := vips.DefaultJxlloadOptions()
+	opts.Access = access
+	return vips.NewJxlload(path, opts)
+}
+
+// LoadSVG rasterizes an SVG, scaled from its natural size
+func LoadSVG(path string, scale float64, access vips.Access) (*vips.Image, error) {
+	opts := vips.DefaultSvgloadOptions()
+	if scale > 0 {
+		opts.Scale = scale
+	}
+	opts.Access = access
+	return vips.NewSvgload(path, opts)
+}
+
+func isSVG(path string) bool {
+	return strings.ToLower(filepath.Ext(path)) == ".svg"
+}
+
+// LoadPDF renders one page of a PDF at the given resolution
+func LoadPDF(path string, page int, dpi float64, access vips.Access) (*vips.Image, error) {
+	opts := vips.DefaultPdfloadOptions()
+	opts.Page = page
+	if dpi > 0 {
+		opts.Dpi = dpi
+	}
+	opts.Access = access
+	return vips.NewPdfload(path, opts)
+}
+
+// SlideInfo holds the OpenSlide properties exposed in image metadata
+type SlideInfo struct {
+	Vendor         string  `json:"vendor"`
+	Levels         int     `json:"levels"`
+	MppX           float64 `json:"mpp_x,omitempty"`
+	MppY           float64 `json:"mpp_y,omitempty"`
+	ObjectivePower float64 `json:"objective_power,omitempty"`
+}
+
+// LoadSlide opens one level of a whole-slide image (0 = full resolution)
+func LoadSlide(path string, level int, access vips.Access) (*vips.Image, error) {
+	opts := vips.DefaultOpenslideloadOptions()
+	opts.Level = level
+	opts.Access = access
+	return vips.NewOpenslideload(path, opts)
+}
+
+// ReadSlideInfo extracts vendor, level count and resolution from the
+// openslide.* properties libvips attaches to slide images
+func ReadSlideInfo(image *vips.Image) *SlideInfo {
+	info := &SlideInfo{
+		Vendor: slideProperty(image, "openslide.vendor"),
+		Levels: 1,
+	}
+	if levels, err := strconv.Atoi(slideProperty(image, "openslide.level-count")); err == nil {
+		info.Levels = levels
+	}
+	info.MppX, _ = strconv.ParseFloat(slideProperty(image, "openslide.mpp-x"), 64)
+	info.MppY, _ = strconv.ParseFloat(slideProperty(image, "openslide.mpp-y"), 64)
+	info.ObjectivePower, _ = strconv.ParseFloat(slideProperty(image, "openslide.objective-power"), 64)
+	return info
+}
+
+func slideProperty(image *vips.Image, name string) string {
+	value, err := image.GetString(name)
+	if err != nil {
+		return ""
+	}
+	return value
+}
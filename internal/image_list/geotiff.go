@@ -0,0 +1,125 @@
+package image_list
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+)
+
+// GeoInfo is the georeferencing of a north-up GeoTIFF in a CRS that can be
+// served as web-mercator XYZ tiles
+type GeoInfo struct {
+	// EPSG is the source CRS: 4326 (lon/lat degrees) or 3857 (web-mercator meters)
+	EPSG int `json:"epsg"`
+	// OriginX and OriginY are the outer top-left corner of the image in CRS units
+	OriginX float64 `json:"origin_x"`
+	OriginY float64 `json:"origin_y"`
+	// PixelWidth and PixelHeight are the pixel size in CRS units (both positive)
+	PixelWidth  float64 `json:"pixel_width"`
+	PixelHeight float64 `json:"pixel_height"`
+	// Bounds is west, south, east, north in degrees
+	Bounds [4]float64 `json:"bounds"`
+}
+
+// GeoTIFF tags and keys, see the GeoTIFF 1.1 specification
+const (
+	tagModelPixelScale  = 33550
+	tagModelTiepoint    = 33922
+	tagGeoKeyDirectory  = 34735
+	keyRasterType       = 1025
+	keyGeographicType   = 2048
+	keyProjectedCSType  = 3072
+	rasterPixelIsPoint  = 2
+	earthRadius         = 6378137.0
+	maxMercatorLatitude = 85.0511287798066
+)
+
+// mercatorAliases are legacy codes for EPSG:3857 still written by older software
+var mercatorAliases = map[int]bool{3857: true, 3785: true, 900913: true, 102100: true, 102113: true}
+
+var errNotGeoTIFF = errors.New("not a georeferenced tiff")
+
+// readGeoInfo parses the GeoTIFF tags of the first IFD. Files without
+// georeferencing, with rotation or in an unsupported CRS return errNotGeoTIFF.
+func readGeoInfo(path string, width, height int) (*GeoInfo, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	tags, err := readTiffTags(file, tagModelPixelScale, tagModelTiepoint, tagGeoKeyDirectory)
+	if err != nil {
+		return nil, err
+	}
+
+	scale := tags[tagModelPixelScale]
+	tiepoint := tags[tagModelTiepoint]
+	keys := tags[tagGeoKeyDirectory]
+	if len(scale) < 2 || len(tiepoint) < 6 || len(keys) < 4 || scale[0] <= 0 || scale[1] <= 0 {
+		return nil, errNotGeoTIFF
+	}
+
+	geoKeys := map[int]int{}
+	count := int(keys[3])
+	for i := 0; i < count && 4+i*4+3 < len(keys); i++ {
+		entry := keys[4+i*4 : 8+i*4]
+		// Location 0 means the value is stored inline
+		if entry[1] == 0 {
+			geoKeys[int(entry[0])] = int(entry[3])
+		}
+	}
+
+	epsg := geoKeys[keyProjectedCSType]
+	if epsg == 0 {
+		epsg = geoKeys[keyGeographicType]
+	}
+	switch {
+	case mercatorAliases[epsg]:
+		epsg = 3857
+	case epsg == 4326:
+	default:
+		return nil, fmt.Errorf("%w: unsupported crs epsg:%d", errNotGeoTIFF, epsg)
+	}
+
+	geo := &GeoInfo{
+		EPSG:        epsg,
+		PixelWidth:  scale[0],
+		PixelHeight: scale[1],
+		OriginX:     tiepoint[3] - tiepoint[0]*scale[0],
+		OriginY:     tiepoint[4] + tiepoint[1]*scale[1],
+	}
+	// PixelIsPoint tiepoints refer to pixel centers rather than corners
+	if geoKeys[keyRasterType] == rasterPixelIsPoint {
+		geo.OriginX -= scale[0] / 2
+		geo.OriginY += scale[1] / 2
+	}
+
+	west, north := geo.ToLonLat(geo.OriginX, geo.OriginY)
+	east, south := geo.ToLonLat(geo.OriginX+float64(width)*geo.PixelWidth, geo.OriginY-float64(height)*geo.PixelHeight)
+	geo.Bounds = [4]float64{west, math.Max(south, -maxMercatorLatitude), east, math.Min(north, maxMercatorLatitude)}
+
+	return geo, nil
+}
+
+// ToLonLat converts CRS coordinates to degrees
+func (g *GeoInfo) ToLonLat(x, y float64) (lon, lat float64) {
+	if g.EPSG == 4326 {
+		return x, y
+	}
+	lon = x / earthRadius * 180 / math.Pi
+	lat = (2*math.Atan(math.Exp(y/earthRadius)) - math.Pi/2) * 180 / math.Pi
+	return lon, lat
+}
+
+// MaxZoom is the web-mercator zoom level whose resolution first reaches the
+// source resolution (measured at the equator)
+func (g *GeoInfo) MaxZoom() int {
+	resolution := g.PixelWidth
+	if g.EPSG == 4326 {
+		resolution = g.PixelWidth * math.Pi / 180 * earthRadius
+	}
+	zoom := int(math.Ceil(math.Log2(2 * math.Pi * earthRadius / (256 * resolution))))
+	return max(0, min(zoom, 24))
+}
@@ -0,0 +1,221 @@
+package image_list
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// imagesBucket holds one JSON-encoded ImageInfo per image, keyed by ID
+var imagesBucket = []byte("images")
+
+// annotationsBucket holds a bucket per image ID with one JSON-encoded
+// Annotation per key. Unlike the image records they exist nowhere else.
+var annotationsBucket = []byte("annotations")
+
+// index is the persistent image index. It holds the same records as the
+// metadata sidecars, so the server can list images right at startup and scans
+// can skip reading the sidecar of every unchanged file. The sidecars stay the
+// source of truth: a deleted index is rebuilt from them by the next scan.
+// Annotations are the exception, they are only kept here.
+type index struct {
+	db *bolt.DB
+}
+
+func openIndex(path string) (*index, error) {
+	// Another server on the same data directory holds the file lock
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open image index: %w", err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(imagesBucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists(annotationsBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to initialize image index: %w", err)
+	}
+	return &index{db: db}, nil
+}
+
+// load returns every indexed image, in key order. Records that can't be
+// decoded or are of another schema version are skipped; the next scan
+// restores them from their sidecars.
+func (i *index) load() ([]ImageInfo, error) {
+	images := []ImageInfo{}
+	err := i.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(imagesBucket).ForEach(func(key, value []byte) error {
+			var image ImageInfo
+			if err := json.Unmarshal(value, &image); err == nil && image.SchemaVersion == schemaVersion {
+				images = append(images, image)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image index: %w", err)
+	}
+	return images, nil
+}
+
+// put stores an image record
+func (i *index) put(image *ImageInfo) error {
+	data, err := json.Marshal(image)
+	if err != nil {
+		return fmt.Errorf("failed to marshal index record: %w", err)
+	}
+	err = i.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(imagesBucket).Put([]byte(image.ID), data)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update image index: %w", err)
+	}
+	return nil
+}
+
+// delete removes an image record
+func (i *index) delete(id string) error {
+	err := i.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(imagesBucket).Delete([]byte(id))
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update image index: %w", err)
+	}
+	return nil
+}
+
+// sync makes the index hold exactly the scanned images, in one transaction.
+// Only records that differ are rewritten.
+func (i *index) sync(images []ImageInfo) error {
+	records := make(map[string][]byte, len(images))
+	for n := range images {
+		data, err := json.Marshal(&images[n])
+		if err != nil {
+			return fmt.Errorf("failed to marshal index record: %w", err)
+		}
+		records[images[n].ID] = data
+	}
+
+	err := i.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(imagesBucket)
+
+		var stale [][]byte
+		err := bucket.ForEach(func(key, value []byte) error {
+			if _, ok := records[string(key)]; !ok {
+				stale = append(stale, append([]byte{}, key...))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, key := range stale {
+			if err := bucket.Delete(key); err != nil {
+				return err
+			}
+		}
+
+		for id, data := range records {
+			if existing := bucket.Get([]byte(id)); string(existing) == string(data) {
+				continue
+			}
+			if err := bucket.Put([]byte(id), data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to sync image index: %w", err)
+	}
+	return nil
+}
+
+// annotations returns the annotations of an image, in key order
+func (i *index) annotations(imageID string) ([]*Annotation, error) {
+	annotations := []*Annotation{}
+	err := i.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(annotationsBucket).Bucket([]byte(imageID))
+		if bucket == nil {
+			return nil
+		}
+		return bucket.ForEach(func(key, value []byte) error {
+			var annotation Annotation
+			if err := json.Unmarshal(value, &annotation); err != nil {
+				return fmt.Errorf("annotation %s: %w", key, err)
+			}
+			annotations = append(annotations, &annotation)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read annotations: %w", err)
+	}
+	return annotations, nil
+}
+
+// putAnnotation stores an annotation of an image. A new one is refused with
+// ErrInvalidAnnotation once the image has limit annotations (0 = no limit).
+func (i *index) putAnnotation(imageID string, annotation *Annotation, limit int) error {
+	data, err := json.Marshal(annotation)
+	if err != nil {
+		return fmt.Errorf("failed to marshal annotation: %w", err)
+	}
+	err = i.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.Bucket(annotationsBucket).CreateBucketIfNotExists([]byte(imageID))
+		if err != nil {
+			return err
+		}
+		if limit > 0 && bucket.Get([]byte(annotation.ID)) == nil && bucket.Stats().KeyN >= limit {
+			return fmt.Errorf("%w: at most %d annotations per image", ErrInvalidAnnotation, limit)
+		}
+		return bucket.Put([]byte(annotation.ID), data)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save annotation: %w", err)
+	}
+	return nil
+}
+
+// deleteAnnotation removes an annotation and reports whether it existed
+func (i *index) deleteAnnotation(imageID, id string) (bool, error) {
+	found := false
+	err := i.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(annotationsBucket).Bucket([]byte(imageID))
+		if bucket == nil || bucket.Get([]byte(id)) == nil {
+			return nil
+		}
+		found = true
+		return bucket.Delete([]byte(id))
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to delete annotation: %w", err)
+	}
+	return found, nil
+}
+
+// deleteAnnotations removes all annotations of an image
+func (i *index) deleteAnnotations(imageID string) error {
+	err := i.db.Update(func(tx *bolt.Tx) error {
+		err := tx.Bucket(annotationsBucket).DeleteBucket([]byte(imageID))
+		if errors.Is(err, bolt.ErrBucketNotFound) {
+			return nil
+		}
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete annotations: %w", err)
+	}
+	return nil
+}
+
+func (i *index) close() error {
+	return i.db.Close()
+}
@@ -0,0 +1,84 @@
+package image_list
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// ErrImageTooLarge is returned for images over MaxPixels or MaxDimension
+var ErrImageTooLarge = errors.New("image exceeds the size limits")
+
+// quarantineDir receives files found by a scan that exceed the size limits,
+// under their original names. It isn't scanned; files moved back into the
+// data directory are picked up again.
+const quarantineDir = "quarantine"
+
+// checkSize returns ErrImageTooLarge if an image or page of the given
+// dimensions exceeds the configured limits
+func (s *Scanner) checkSize(width, height int) error {
+	if limit := s.options.MaxDimension; limit > 0 && max(width, height) > limit {
+		return fmt.Errorf("%w: %d×%d is longer than %d pixels", ErrImageTooLarge, width, height, limit)
+	}
+	if limit := s.options.MaxPixels; limit > 0 && int64(width)*int64(height) > limit {
+		return fmt.Errorf("%w: %d×%d is more than %d pixels", ErrImageTooLarge, width, height, limit)
+	}
+	return nil
+}
+
+// checkImageSize applies checkSize to an image record and all of its pages
+func (s *Scanner) checkImageSize(imageInfo *ImageInfo) error {
+	if err := s.checkSize(imageInfo.Width, imageInfo.Height); err != nil {
+		return err
+	}
+	for _, page := range imageInfo.Pages {
+		if err := s.checkSize(page.Width, page.Height); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// quarantine moves a file that exceeds the size limits or can't be read out
+// of the scanned directories and reports whether it did. path is the file
+// after its rename to a UUID, rawFilename its RAW master if it was
+// developed, and name the original file name it is restored to.
+func (s *Scanner) quarantine(path, rawFilename, name string, reason error) bool {
+	log := s.logger.With(zap.String("path", path), zap.NamedError("reason", reason))
+
+	dir := s.statePath(quarantineDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		log.Warn("Failed to create quarantine directory, leaving image in place", zap.Error(err))
+		return false
+	}
+
+	id := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	source := path
+	if rawFilename != "" {
+		// The developed TIFF can be made again, the master is what to keep
+		os.Remove(path)
+		source = filepath.Join(s.statePath(rawDir), rawFilename)
+	}
+	target := filepath.Join(dir, name)
+	if _, err := os.Stat(target); err == nil {
+		target = filepath.Join(dir, id+"-"+name)
+	}
+
+	if err := moveFile(source, target); err != nil {
+		log.Warn("Failed to quarantine image", zap.Error(err))
+		return false
+	}
+	// MIRAX slide data lives in a directory named after the file
+	if strings.ToLower(filepath.Ext(path)) == ".mrxs" {
+		slideData := strings.TrimSuffix(target, filepath.Ext(target))
+		if err := os.Rename(filepath.Join(filepath.Dir(path), id), slideData); err != nil {
+			log.Warn("Failed to quarantine slide data directory", zap.Error(err))
+		}
+	}
+	log.Warn("Quarantined image", zap.String("quarantined_path", target))
+	return true
+}
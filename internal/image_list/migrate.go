@@ -0,0 +1,176 @@
+package image_list
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// schemaVersion is the sidecar format this version writes. Changing the
+// meaning or name of an ImageInfo field takes a new version and a migration
+// from the previous one; adding an optional field doesn't.
+const schemaVersion = 1
+
+// schemaMarker records, in the metadata directory, the schema version all
+// sidecars were last migrated to, so startups don't read every sidecar
+const schemaMarker = ".schema_version"
+
+// ErrNewerSchema is returned for sidecars written by a newer version, which
+// are left alone rather than rewritten without the fields this one lacks
+var ErrNewerSchema = errors.New("metadata written by a newer version")
+
+// migrations[n] upgrades a decoded sidecar from version n to n+1. They work
+// on the raw JSON object so renamed or restructured keys can be carried over.
+var migrations = []func(meta map[string]any) error{
+	// 0 → 1: the source of images was not recorded; uploads are recognized
+	// by their content hash or stored original, which scans never have
+	func(meta map[string]any) error {
+		if jsonString(meta, "source") != "" {
+			return nil
+		}
+		mosaic, _ := meta["mosaic"].([]any)
+		switch {
+		case jsonString(meta, "source_url") != "":
+			meta["source"] = SourceRemote
+		case len(mosaic) > 0:
+			meta["source"] = SourceMosaic
+		case jsonString(meta, "sha256") != "" || jsonString(meta, "storage_key") != "":
+			meta["source"] = SourceUpload
+		default:
+			meta["source"] = SourceScan
+		}
+		return nil
+	},
+}
+
+func jsonString(meta map[string]any, key string) string {
+	value, _ := meta[key].(string)
+	return value
+}
+
+// migrateMetadata upgrades sidecar JSON to schemaVersion and reports whether
+// it changed
+func migrateMetadata(data []byte) ([]byte, bool, error) {
+	var probe struct {
+		SchemaVersion int `json:"schema_version"`
+	}
+	if err := json.Unmarshal(data, &probe); err != nil {
+		return nil, false, fmt.Errorf("failed to parse metadata: %w", err)
+	}
+	if probe.SchemaVersion > schemaVersion {
+		return nil, false, fmt.Errorf("%w (schema version %d)", ErrNewerSchema, probe.SchemaVersion)
+	}
+	if probe.SchemaVersion == schemaVersion {
+		return data, false, nil
+	}
+
+	var meta map[string]any
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return nil, false, fmt.Errorf("failed to parse metadata: %w", err)
+	}
+	for version := probe.SchemaVersion; version < schemaVersion; version++ {
+		if err := migrations[version](meta); err != nil {
+			return nil, false, fmt.Errorf("failed to migrate metadata to version %d: %w", version+1, err)
+		}
+	}
+	meta["schema_version"] = schemaVersion
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to marshal metadata: %w", err)
+	}
+	return data, true, nil
+}
+
+// migrateSidecars upgrades every sidecar of the library to schemaVersion
+// before the first scan. Sidecars loaded later (e.g. restored from a backup)
+// are migrated in memory by loadMetadata and written in the current version
+// with their next change.
+func (s *Scanner) migrateSidecars() {
+	markerPath := filepath.Join(s.metadataDir, schemaMarker)
+	if data, err := os.ReadFile(markerPath); err == nil {
+		if version, err := strconv.Atoi(strings.TrimSpace(string(data))); err == nil && version >= schemaVersion {
+			return
+		}
+	}
+
+	var migrated, failed int
+	filepath.WalkDir(s.metadataDir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if entry.IsDir() {
+			if path == s.metadataDir {
+				return nil
+			}
+			// Collections are the only directories with sidecars
+			parent := filepath.Dir(path)
+			collection, _ := filepath.Rel(s.metadataDir, parent)
+			if collection == "." {
+				collection = ""
+			}
+			if (collection == "" && isStateDir(entry.Name())) || !s.isCollectionDir(parent, filepath.ToSlash(collection), entry.Name()) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		name := entry.Name()
+		if !strings.HasSuffix(name, ".json") {
+			return nil
+		}
+		if _, err := uuid.Parse(strings.TrimSuffix(name, ".json")); err != nil {
+			return nil
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			failed++
+			return nil
+		}
+		upgraded, changed, err := migrateMetadata(data)
+		if errors.Is(err, ErrNewerSchema) {
+			s.logger.Warn("Leaving metadata of a newer version alone", zap.String("json_path", path), zap.Error(err))
+			return nil
+		}
+		if err != nil {
+			s.logger.Warn("Failed to migrate metadata", zap.String("json_path", path), zap.Error(err))
+			failed++
+			return nil
+		}
+		if !changed {
+			return nil
+		}
+		var meta ImageInfo
+		if err := json.Unmarshal(upgraded, &meta); err != nil {
+			failed++
+			return nil
+		}
+		if err := s.saveMetadata(path, &meta); err != nil {
+			s.logger.Warn("Failed to save migrated metadata", zap.String("json_path", path), zap.Error(err))
+			failed++
+			return nil
+		}
+		migrated++
+		return nil
+	})
+
+	if migrated > 0 || failed > 0 {
+		s.logger.Info("Migrated metadata",
+			zap.Int("schema_version", schemaVersion),
+			zap.Int("migrated", migrated),
+			zap.Int("failed", failed))
+	}
+	// Failed sidecars are tried again on the next start
+	if failed == 0 {
+		if err := os.WriteFile(markerPath, []byte(strconv.Itoa(schemaVersion)+"\n"), 0644); err != nil {
+			s.logger.Warn("Failed to record schema version", zap.String("path", markerPath), zap.Error(err))
+		}
+	}
+}
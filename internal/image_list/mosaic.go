@@ -0,0 +1,165 @@
+package image_list
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+
+	"gigaview/internal/logger"
+)
+
+// A mosaic is a virtual image composed of other images placed at pixel
+// offsets, e.g. stitched scan strips. It is stored as a {id}.mosaic JSON file
+// listing the sources; nothing is rendered up front, the renderer composes
+// the sources covering each tile on the fly.
+
+// MosaicSource places one image inside a mosaic. Width and Height are filled
+// in from the source metadata when the mosaic is scanned.
+type MosaicSource struct {
+	ImageID string `json:"image_id"`
+	X       int    `json:"x"`
+	Y       int    `json:"y"`
+	Width   int    `json:"width,omitempty"`
+	Height  int    `json:"height,omitempty"`
+}
+
+// mosaicDefinition is the content of a .mosaic file
+type mosaicDefinition struct {
+	Sources []MosaicSource `json:"sources"`
+}
+
+// ErrInvalidMosaic is returned for mosaic definitions that can't be composed
+var ErrInvalidMosaic = errors.New("invalid mosaic")
+
+// maxMosaicSources bounds the work of composing a single tile
+const maxMosaicSources = 1024
+
+// IsMosaic reports whether path is a mosaic definition
+func IsMosaic(path string) bool {
+	return strings.ToLower(filepath.Ext(path)) == ".mosaic"
+}
+
+// CreateMosaic stores a new mosaic of existing images and returns its ID. The
+// caller rescans afterwards, like after an upload.
+func (s *Scanner) CreateMosaic(ctx context.Context, name string, sources []MosaicSource, copyrightText, copyrightLink string) (string, error) {
+	if len(sources) == 0 || len(sources) > maxMosaicSources {
+		return "", fmt.Errorf("%w: needs 1 to %d sources", ErrInvalidMosaic, maxMosaicSources)
+	}
+	for n := range sources {
+		// Sources may be given by slug, the definition keeps IDs
+		sources[n].ImageID = s.ResolveID(sources[n].ImageID)
+		source := sources[n]
+		imageInfo := s.GetImageByID(source.ImageID)
+		if imageInfo == nil {
+			return "", fmt.Errorf("%w: unknown image %s", ErrInvalidMosaic, source.ImageID)
+		}
+		// Nesting would allow cycles and multiplies the per-tile work
+		if len(imageInfo.Mosaic) > 0 {
+			return "", fmt.Errorf("%w: image %s is a mosaic itself", ErrInvalidMosaic, source.ImageID)
+		}
+		if source.X < 0 || source.Y < 0 {
+			return "", fmt.Errorf("%w: offsets must be non-negative", ErrInvalidMosaic)
+		}
+	}
+
+	data, err := json.MarshalIndent(mosaicDefinition{Sources: sources}, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal mosaic: %w", err)
+	}
+
+	id := uuid.New().String()
+	path := s.getFilePath(id + ".mosaic")
+	if s.options.PreserveNames {
+		id = pathID("", filepath.Base(path))
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return "", fmt.Errorf("failed to write mosaic: %w", err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to stat mosaic: %w", err)
+	}
+	imageInfo, err := s.scanImage(path, info)
+	if err != nil {
+		os.Remove(path)
+		return "", err
+	}
+
+	imageInfo.ID = id
+	imageInfo.OriginalFilename = name
+	imageInfo.CurrentFilename = filepath.Base(path)
+	imageInfo.CopyrightText = copyrightText
+	imageInfo.CopyrightLink = copyrightLink
+	imageInfo.AddedAt = time.Now()
+	imageInfo.Source = SourceMosaic
+
+	if err := s.saveMetadata(filepath.Join(s.sidecarDir(""), id+".json"), imageInfo); err != nil {
+		os.Remove(path)
+		return "", fmt.Errorf("failed to save metadata: %w", err)
+	}
+
+	logger.FromContext(ctx, s.logger).Info("Created mosaic",
+		zap.String("uuid", id),
+		zap.String("name", name),
+		zap.Int("sources", len(sources)))
+
+	return id, nil
+}
+
+// scanMosaic resolves the sources of a mosaic file against their metadata
+// and sizes the mosaic to their bounding box
+func (s *Scanner) scanMosaic(path string, info os.FileInfo) (*ImageInfo, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var definition mosaicDefinition
+	if err := json.Unmarshal(data, &definition); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidMosaic, err)
+	}
+	if len(definition.Sources) == 0 || len(definition.Sources) > maxMosaicSources {
+		return nil, fmt.Errorf("%w: needs 1 to %d sources", ErrInvalidMosaic, maxMosaicSources)
+	}
+
+	imageInfo := &ImageInfo{
+		ID:          uuid.New().String(),
+		Bytes:       info.Size(),
+		Fingerprint: fileFingerprint(info),
+	}
+	for _, source := range definition.Sources {
+		// Sources are read from their sidecars since the scan that is
+		// building the image list may not have reached them yet
+		meta, err := s.loadMetadata(s.sourceMetadataPath(source.ImageID))
+		if err != nil {
+			return nil, fmt.Errorf("%w: source %s: %v", ErrInvalidMosaic, source.ImageID, err)
+		}
+		if len(meta.Mosaic) > 0 || source.X < 0 || source.Y < 0 {
+			return nil, fmt.Errorf("%w: source %s can't be placed", ErrInvalidMosaic, source.ImageID)
+		}
+		source.Width, source.Height = meta.Width, meta.Height
+		imageInfo.Mosaic = append(imageInfo.Mosaic, source)
+		imageInfo.Width = max(imageInfo.Width, source.X+source.Width)
+		imageInfo.Height = max(imageInfo.Height, source.Y+source.Height)
+	}
+
+	return imageInfo, nil
+}
+
+// sourceMetadataPath locates the sidecar of a mosaic source. Sources in
+// collections are found through the last scanned image list; sources that
+// aren't in it yet are looked up at the top level.
+func (s *Scanner) sourceMetadataPath(id string) string {
+	if imageInfo := s.GetImageByID(id); imageInfo != nil {
+		return s.metadataPath(imageInfo)
+	}
+	return filepath.Join(s.sidecarDir(""), id+".json")
+}
@@ -0,0 +1,332 @@
+package image_list
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/xml"
+	"io"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cshum/vipsgen/vips"
+)
+
+// PhotoInfo is the camera and descriptive metadata embedded in an image as
+// EXIF, XMP or IPTC, read when the image is scanned
+type PhotoInfo struct {
+	Make  string `json:"make,omitempty"`
+	Model string `json:"model,omitempty"`
+	Lens  string `json:"lens,omitempty"`
+	// CapturedAt is the original capture time. Cameras that don't record
+	// their time zone get UTC, which keeps the wall-clock reading.
+	CapturedAt   time.Time `json:"captured_at,omitzero"`
+	ExposureTime string    `json:"exposure_time,omitempty"`
+	FNumber      float64   `json:"f_number,omitempty"`
+	ISO          int       `json:"iso,omitempty"`
+	FocalLength  float64   `json:"focal_length,omitempty"`
+	GPS          *GPSInfo  `json:"gps,omitempty"`
+
+	Artist      string   `json:"artist,omitempty"`
+	Copyright   string   `json:"copyright,omitempty"`
+	Title       string   `json:"title,omitempty"`
+	Description string   `json:"description,omitempty"`
+	Keywords    []string `json:"keywords,omitempty"`
+}
+
+// GPSInfo is the position a photo was taken at, in WGS84 degrees
+type GPSInfo struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	Altitude  float64 `json:"altitude,omitempty"`
+}
+
+// Camera returns make and model for display and filtering, without the
+// make repeated in the model as many vendors do
+func (p *PhotoInfo) Camera() string {
+	if strings.HasPrefix(strings.ToLower(p.Model), strings.ToLower(p.Make)) {
+		return p.Model
+	}
+	return strings.TrimSpace(p.Make + " " + p.Model)
+}
+
+// exifField matches the libvips rendering of an EXIF entry: the raw value
+// followed by its human-readable form, format and size in parentheses
+var exifField = regexp.MustCompile(`^(.*?) \((.*), [A-Za-z]+, \d+ components, \d+ bytes\)$`)
+
+// exifDateLayout is the EXIF timestamp format, in camera local time
+const exifDateLayout = "2006:01:02 15:04:05"
+
+// readPhotoInfo collects the EXIF fields libvips exposes as exif-ifdN-*
+// properties and the XMP and IPTC packets. EXIF takes precedence, XMP and
+// IPTC fill in what it lacks. It returns nil when the image has none.
+func readPhotoInfo(image *vips.Image) *PhotoInfo {
+	exif := func(name string) string {
+		value, err := image.GetString(name)
+		if err != nil {
+			return ""
+		}
+		if match := exifField.FindStringSubmatch(value); match != nil {
+			value = match[1]
+		}
+		return strings.TrimSpace(value)
+	}
+
+	photo := &PhotoInfo{
+		Make:         exif("exif-ifd0-Make"),
+		Model:        exif("exif-ifd0-Model"),
+		Lens:         exif("exif-ifd2-LensModel"),
+		ExposureTime: exif("exif-ifd2-ExposureTime"),
+		Artist:       exif("exif-ifd0-Artist"),
+		Copyright:    exif("exif-ifd0-Copyright"),
+		Description:  exif("exif-ifd0-ImageDescription"),
+	}
+	photo.FNumber = parseRationals(exif("exif-ifd2-FNumber"))[0]
+	photo.FocalLength = parseRationals(exif("exif-ifd2-FocalLength"))[0]
+	photo.ISO, _ = strconv.Atoi(strings.Fields(exif("exif-ifd2-ISOSpeedRatings") + " 0")[0])
+
+	if captured, err := time.Parse(exifDateLayout, exif("exif-ifd2-DateTimeOriginal")); err == nil {
+		if offset, err := time.Parse("-07:00", exif("exif-ifd2-OffsetTimeOriginal")); err == nil {
+			captured = time.Date(captured.Year(), captured.Month(), captured.Day(),
+				captured.Hour(), captured.Minute(), captured.Second(), 0, offset.Location())
+		}
+		photo.CapturedAt = captured
+	}
+
+	latitude := parseGPSCoordinate(exif("exif-ifd3-GPSLatitude"), exif("exif-ifd3-GPSLatitudeRef"))
+	longitude := parseGPSCoordinate(exif("exif-ifd3-GPSLongitude"), exif("exif-ifd3-GPSLongitudeRef"))
+	if latitude != nil && longitude != nil {
+		photo.GPS = &GPSInfo{Latitude: *latitude, Longitude: *longitude}
+		photo.GPS.Altitude = parseRationals(exif("exif-ifd3-GPSAltitude"))[0]
+		// Reference 1 means below sea level
+		if strings.HasPrefix(exif("exif-ifd3-GPSAltitudeRef"), "1") {
+			photo.GPS.Altitude = -photo.GPS.Altitude
+		}
+	}
+
+	if data, err := image.GetBlob("xmp-data"); err == nil {
+		photo.merge(parseXMP(data))
+	}
+	if data, err := image.GetBlob("iptc-data"); err == nil {
+		photo.merge(parseIPTC(data))
+	}
+
+	if photo.isEmpty() {
+		return nil
+	}
+	return photo
+}
+
+// merge fills the descriptive fields p lacks from other and adds its keywords
+func (p *PhotoInfo) merge(other *PhotoInfo) {
+	if other == nil {
+		return
+	}
+	for _, field := range []struct{ dst, src *string }{
+		{&p.Artist, &other.Artist},
+		{&p.Copyright, &other.Copyright},
+		{&p.Title, &other.Title},
+		{&p.Description, &other.Description},
+	} {
+		if *field.dst == "" {
+			*field.dst = *field.src
+		}
+	}
+	if p.CapturedAt.IsZero() {
+		p.CapturedAt = other.CapturedAt
+	}
+	for _, keyword := range other.Keywords {
+		if !slices.Contains(p.Keywords, keyword) {
+			p.Keywords = append(p.Keywords, keyword)
+		}
+	}
+}
+
+func (p *PhotoInfo) isEmpty() bool {
+	return p.Make == "" && p.Model == "" && p.Lens == "" && p.CapturedAt.IsZero() &&
+		p.ExposureTime == "" && p.FNumber == 0 && p.ISO == 0 && p.FocalLength == 0 && p.GPS == nil &&
+		p.Artist == "" && p.Copyright == "" && p.Title == "" && p.Description == "" && len(p.Keywords) == 0
+}
+
+// parseRationals parses space-separated EXIF rationals ("28/10 ...").
+// The result always has at least one element, 0 for missing values.
+func parseRationals(raw string) []float64 {
+	values := []float64{}
+	for _, field := range strings.Fields(raw) {
+		numerator, denominator, found := strings.Cut(field, "/")
+		n, err := strconv.ParseFloat(numerator, 64)
+		if err != nil {
+			break
+		}
+		if found {
+			d, err := strconv.ParseFloat(denominator, 64)
+			if err != nil || d == 0 {
+				break
+			}
+			n /= d
+		}
+		values = append(values, n)
+	}
+	if len(values) == 0 {
+		return []float64{0}
+	}
+	return values
+}
+
+// parseGPSCoordinate converts degrees, minutes and seconds with an N/S/E/W
+// reference to signed degrees
+func parseGPSCoordinate(raw, ref string) *float64 {
+	dms := parseRationals(raw)
+	if raw == "" || len(dms) != 3 {
+		return nil
+	}
+	degrees := dms[0] + dms[1]/60 + dms[2]/3600
+	if ref == "S" || ref == "W" {
+		degrees = -degrees
+	}
+	return &degrees
+}
+
+// XMP namespaces of the fields read from XMP packets
+const (
+	xmpDublinCore = "http://purl.org/dc/elements/1.1/"
+	xmpPhotoshop  = "http://ns.adobe.com/photoshop/1.0/"
+	xmpRDF        = "http://www.w3.org/1999/02/22-rdf-syntax-ns#"
+)
+
+// parseXMP reads the Dublin Core title, description, creator, rights and
+// subject and the Photoshop creation date of an XMP packet. Values may be
+// element text, rdf:li items or attributes of rdf:Description.
+func parseXMP(data []byte) *PhotoInfo {
+	photo := &PhotoInfo{}
+	set := func(space, local, value string) {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			return
+		}
+		switch {
+		case space == xmpDublinCore && local == "title" && photo.Title == "":
+			photo.Title = value
+		case space == xmpDublinCore && local == "description" && photo.Description == "":
+			photo.Description = value
+		case space == xmpDublinCore && local == "creator" && photo.Artist == "":
+			photo.Artist = value
+		case space == xmpDublinCore && local == "rights" && photo.Copyright == "":
+			photo.Copyright = value
+		case space == xmpDublinCore && local == "subject":
+			if !slices.Contains(photo.Keywords, value) {
+				photo.Keywords = append(photo.Keywords, value)
+			}
+		case space == xmpPhotoshop && local == "DateCreated" && photo.CapturedAt.IsZero():
+			for _, layout := range []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02"} {
+				if captured, err := time.Parse(layout, value); err == nil {
+					photo.CapturedAt = captured
+					break
+				}
+			}
+		}
+	}
+
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	// The property currently being read, and its text so far
+	var property xml.Name
+	var text strings.Builder
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			break
+		}
+		switch token := token.(type) {
+		case xml.StartElement:
+			switch {
+			case token.Name.Space == xmpRDF && token.Name.Local == "Description":
+				for _, attr := range token.Attr {
+					set(attr.Name.Space, attr.Name.Local, attr.Value)
+				}
+			case token.Name.Space == xmpRDF && token.Name.Local == "li":
+				text.Reset()
+			case token.Name.Space != xmpRDF:
+				property = token.Name
+				text.Reset()
+			}
+		case xml.CharData:
+			text.Write(token)
+		case xml.EndElement:
+			switch {
+			case token.Name.Space == xmpRDF && token.Name.Local == "li":
+				set(property.Space, property.Local, text.String())
+				text.Reset()
+			case token.Name == property:
+				set(property.Space, property.Local, text.String())
+				property = xml.Name{}
+			}
+		}
+	}
+
+	if photo.isEmpty() {
+		return nil
+	}
+	return photo
+}
+
+// IPTC IIM application record (2) datasets read from IPTC blocks
+const (
+	iptcObjectName = 5
+	iptcKeywords   = 25
+	iptcDateCreate = 55
+	iptcByline     = 80
+	iptcCopyright  = 116
+	iptcCaption    = 120
+)
+
+// parseIPTC reads title, keywords, creation date, byline, copyright and
+// caption from an IPTC IIM block
+func parseIPTC(data []byte) *PhotoInfo {
+	photo := &PhotoInfo{}
+	r := bytes.NewReader(data)
+	header := make([]byte, 5)
+	for {
+		if _, err := io.ReadFull(r, header); err != nil || header[0] != 0x1c {
+			break
+		}
+		length := int(binary.BigEndian.Uint16(header[3:5]))
+		// Extended datasets (length with the high bit set) aren't used for text
+		if length&0x8000 != 0 {
+			break
+		}
+		value := make([]byte, length)
+		if _, err := io.ReadFull(r, value); err != nil {
+			break
+		}
+		if header[1] != 2 {
+			continue
+		}
+
+		text := strings.TrimSpace(string(value))
+		switch header[2] {
+		case iptcObjectName:
+			photo.Title = text
+		case iptcKeywords:
+			if text != "" && !slices.Contains(photo.Keywords, text) {
+				photo.Keywords = append(photo.Keywords, text)
+			}
+		case iptcDateCreate:
+			if captured, err := time.Parse("20060102", text); err == nil {
+				photo.CapturedAt = captured
+			}
+		case iptcByline:
+			photo.Artist = text
+		case iptcCopyright:
+			photo.Copyright = text
+		case iptcCaption:
+			photo.Description = text
+		}
+	}
+
+	if photo.isEmpty() {
+		return nil
+	}
+	return photo
+}
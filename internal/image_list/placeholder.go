@@ -0,0 +1,44 @@
+package image_list
+
+import (
+	"encoding/base64"
+	"fmt"
+
+	"github.com/cshum/vipsgen/vips"
+)
+
+// placeholderSize is the longer side of the low-quality placeholder; frontends
+// scale it up with a blur while the first tiles load
+const placeholderSize = 32
+
+// makePlaceholder renders a tiny JPEG of the whole image as a data URI, a few
+// hundred bytes that can be inlined in listings. Thumbnail uses
+// shrink-on-load and embedded pyramids, so it stays cheap for large sources.
+func makePlaceholder(path string) (string, error) {
+	opts := vips.DefaultThumbnailOptions()
+	opts.Height = placeholderSize
+	opts.Size = vips.SizeDown
+	image, err := vips.NewThumbnail(path, placeholderSize, opts)
+	if err != nil {
+		return "", fmt.Errorf("failed to create placeholder: %w", err)
+	}
+	defer image.Close()
+
+	if image.HasAlpha() {
+		flatten := vips.DefaultFlattenOptions()
+		flatten.Background = []float64{255, 255, 255}
+		if err := image.Flatten(flatten); err != nil {
+			return "", fmt.Errorf("failed to create placeholder: %w", err)
+		}
+	}
+
+	saveOpts := vips.DefaultJpegsaveBufferOptions()
+	saveOpts.Q = 50
+	// Drop EXIF, XMP and ICC data, which would outweigh the pixels
+	saveOpts.Keep = vips.KeepOther
+	data, err := image.JpegsaveBuffer(saveOpts)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode placeholder: %w", err)
+	}
+	return "data:image/jpeg;base64," + base64.StdEncoding.EncodeToString(data), nil
+}
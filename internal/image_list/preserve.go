@@ -0,0 +1,64 @@
+package image_list
+
+import (
+	"errors"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// pathIDNamespace seeds the name-based UUIDs of images in preserve mode
+var pathIDNamespace = uuid.MustParse("5b0c6f7e-2d7a-4c36-9a43-8f1e0b6d2c19")
+
+// pathID derives the ID of a file in preserve mode from its path relative to
+// the data directory, so it stays the same across scans and restarts without
+// renaming the file. Moving or renaming the file gives it a new ID.
+func pathID(collection, name string) string {
+	return uuid.NewSHA1(pathIDNamespace, []byte(path.Join(collection, name))).String()
+}
+
+// sidecarDir returns the directory the sidecars of a collection are kept in:
+// the collection directory itself, or its mirror under the metadata directory
+func (s *Scanner) sidecarDir(collection string) string {
+	return filepath.Join(s.metadataDir, filepath.FromSlash(collection))
+}
+
+// statePath returns a file or directory the scanner keeps its own data in
+// (thumbnails, RAW masters, quarantine, staging)
+func (s *Scanner) statePath(name string) string {
+	return filepath.Join(s.metadataDir, name)
+}
+
+// uploadName returns the file name an upload is saved under in the data
+// directory and the ID it gets: a UUID, or in preserve mode the original
+// name, numbered if it is taken. In preserve mode the name is reserved with
+// an empty file, which the upload is moved over.
+func (s *Scanner) uploadName(originalFilename, ext string) (name, id string) {
+	if !s.options.PreserveNames {
+		id = uuid.New().String()
+		return id + ext, id
+	}
+
+	base := strings.TrimSuffix(filepath.Base(filepath.FromSlash(originalFilename)), filepath.Ext(originalFilename))
+	if base == "" || base == "." || strings.HasPrefix(base, ".") {
+		base = "upload"
+	}
+	name = base + ext
+	for n := 2; n < 1000; n++ {
+		file, err := os.OpenFile(s.getFilePath(name), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err == nil {
+			file.Close()
+			break
+		}
+		// Moving the upload fails the same way
+		if !errors.Is(err, os.ErrExist) {
+			break
+		}
+		name = base + " (" + strconv.Itoa(n) + ")" + ext
+	}
+	return name, pathID("", name)
+}
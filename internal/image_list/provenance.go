@@ -0,0 +1,31 @@
+package image_list
+
+import (
+	"slices"
+	"time"
+)
+
+// Sources of images, recorded as ImageInfo.Source
+const (
+	SourceScan   = "scan"
+	SourceUpload = "upload"
+	SourceRemote = "remote"
+	SourceMosaic = "mosaic"
+)
+
+// sortNewestFirst orders images by when they were added, newest first,
+// keeping the listing order of images added at the same time
+func sortNewestFirst(images []ImageInfo) {
+	slices.SortStableFunc(images, func(a, b ImageInfo) int {
+		return b.AddedAt.Compare(a.AddedAt)
+	})
+}
+
+// markUploaded records an image as uploaded now by actor
+func (imageInfo *ImageInfo) markUploaded(source, actor string) {
+	now := time.Now()
+	imageInfo.Source = source
+	imageInfo.AddedAt = now
+	imageInfo.UploadedAt = now
+	imageInfo.UploadedBy = actor
+}
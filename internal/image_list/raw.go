@@ -0,0 +1,84 @@
+package image_list
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cshum/vipsgen/vips"
+	"go.uber.org/zap"
+)
+
+// rawExtensions are camera RAW formats, decoded through ImageMagick (libraw)
+var rawExtensions = map[string]bool{
+	".dng": true,
+	".cr2": true,
+	".cr3": true,
+	".nef": true,
+	".arw": true,
+	".orf": true,
+	".rw2": true,
+	".raf": true,
+}
+
+// rawDir holds the RAW masters once they have been converted, and the
+// sources of images converted to pyramidal TIFFs.
+// Structure: {dataDir}/raw/{uuid}.{ext}
+const rawDir = "raw"
+
+func isRaw(path string) bool {
+	return rawExtensions[strings.ToLower(filepath.Ext(path))]
+}
+
+// convertRaw develops a RAW file into a tiled, pyramidal 8-bit TIFF next to
+// it ({uuid}.tif) and moves the master into the raw directory. RAW decoding
+// is far too slow to do per tile, so tiles are rendered from the TIFF.
+func (s *Scanner) convertRaw(rawPath string, id string) (string, error) {
+	opts := vips.DefaultMagickloadOptions()
+	opts.Access = vips.AccessSequential
+	image, err := vips.NewMagickload(rawPath, opts)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode raw image: %w", err)
+	}
+	defer image.Close()
+
+	if image.Orientation() > 1 {
+		if err := image.Autorot(nil); err != nil {
+			return "", fmt.Errorf("failed to apply orientation: %w", err)
+		}
+	}
+
+	// Developed RAWs are usually 16-bit; JPEG-compressed TIFF needs 8-bit sRGB
+	if err := image.Colourspace(vips.InterpretationSrgb, nil); err != nil {
+		return "", fmt.Errorf("failed to convert to sRGB: %w", err)
+	}
+
+	tiffPath := filepath.Join(filepath.Dir(rawPath), id+".tif")
+	saveOpts := vips.DefaultTiffsaveOptions()
+	saveOpts.Compression = vips.TiffCompressionJpeg
+	saveOpts.Q = 90
+	saveOpts.Tile = true
+	saveOpts.TileWidth = 256
+	saveOpts.TileHeight = 256
+	saveOpts.Pyramid = true
+	saveOpts.Bigtiff = true
+	if err := image.Tiffsave(tiffPath, saveOpts); err != nil {
+		os.Remove(tiffPath)
+		return "", fmt.Errorf("failed to write intermediate tiff: %w", err)
+	}
+
+	if err := os.MkdirAll(s.statePath(rawDir), 0755); err != nil {
+		os.Remove(tiffPath)
+		return "", fmt.Errorf("failed to create raw directory: %w", err)
+	}
+	masterPath := filepath.Join(s.statePath(rawDir), id+strings.ToLower(filepath.Ext(rawPath)))
+	if err := moveFile(rawPath, masterPath); err != nil {
+		os.Remove(tiffPath)
+		return "", fmt.Errorf("failed to keep raw master: %w", err)
+	}
+
+	s.logger.Info("Converted raw image", zap.String("raw", masterPath), zap.String("tiff", tiffPath))
+
+	return tiffPath, nil
+}
@@ -0,0 +1,79 @@
+package image_list
+
+import (
+	"time"
+)
+
+// registry is an immutable snapshot of the scanned library. Scans build a
+// new one and swap it in atomically, so readers never lock and never see a
+// list that is half replaced.
+type registry struct {
+	// images must not be modified, it is shared by all readers
+	images []ImageInfo
+	// byID maps image IDs to their position in images, bySHA256 content
+	// hashes of uploaded images and bySlug image slugs
+	byID     map[string]int
+	bySHA256 map[string]int
+	bySlug   map[string]int
+	// collections lists the scanned subdirectories, parents before children
+	collections []string
+
+	// version is bumped whenever a scan changes the image list, so HTTP
+	// handlers can derive validators for listing and metadata responses
+	version    uint64
+	modifiedAt time.Time
+}
+
+func newRegistry(images []ImageInfo, collections []string, version uint64, modifiedAt time.Time) *registry {
+	byID := make(map[string]int, len(images))
+	bySHA256 := make(map[string]int)
+	bySlug := make(map[string]int)
+	for n, image := range images {
+		byID[image.ID] = n
+		if image.SHA256 != "" {
+			bySHA256[image.SHA256] = n
+		}
+		// SetSlug keeps slugs unique; of hand-edited duplicates the first wins
+		if _, taken := bySlug[image.Slug]; image.Slug != "" && !taken {
+			bySlug[image.Slug] = n
+		}
+	}
+	return &registry{
+		images:      images,
+		byID:        byID,
+		bySHA256:    bySHA256,
+		bySlug:      bySlug,
+		collections: collections,
+		version:     version,
+		modifiedAt:  modifiedAt,
+	}
+}
+
+// get returns a copy of an image record, or nil for unknown IDs
+func (r *registry) get(id string) *ImageInfo {
+	n, ok := r.byID[id]
+	if !ok {
+		return nil
+	}
+	image := r.images[n]
+	return &image
+}
+
+// findBySHA256 returns a copy of the image with a content hash, or nil
+func (r *registry) findBySHA256(checksum string) *ImageInfo {
+	n, ok := r.bySHA256[checksum]
+	if !ok {
+		return nil
+	}
+	image := r.images[n]
+	return &image
+}
+
+// resolve returns the ID of the image a slug names, or ref itself if it
+// isn't a slug
+func (r *registry) resolve(ref string) string {
+	if n, ok := r.bySlug[ref]; ok {
+		return r.images[n].ID
+	}
+	return ref
+}
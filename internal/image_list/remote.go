@@ -0,0 +1,116 @@
+package image_list
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+
+	"gigaview/internal/logger"
+	"gigaview/internal/storage"
+)
+
+var (
+	// ErrRemoteDisabled is returned when registering remote images without
+	// any allowed URL prefix configured
+	ErrRemoteDisabled = errors.New("remote images are disabled")
+	// ErrInvalidRemote is returned for URLs that aren't allowed or don't
+	// point to a single image file of a supported format
+	ErrInvalidRemote = errors.New("invalid remote image")
+)
+
+// RegisterRemote adds an image that stays on another HTTP(S) server. It is
+// downloaded once to be validated and scanned, then served from the staging
+// area like originals in the storage backend and downloaded again after
+// eviction. Only the sidecar and thumbnail are kept in the data directory.
+// The remote version (ETag or Last-Modified) is recorded, so a file changed
+// on the server fails to stage instead of rendering with stale dimensions.
+func (s *Scanner) RegisterRemote(ctx context.Context, rawURL string, meta UploadMetadata) (string, error) {
+	remote := s.options.Remote
+	if remote == nil || s.staging == nil {
+		return "", ErrRemoteDisabled
+	}
+	parsed, err := url.Parse(rawURL)
+	if err != nil || !remote.Allowed(rawURL) {
+		return "", fmt.Errorf("%w: URL not allowed: %s", ErrInvalidRemote, rawURL)
+	}
+	ext := strings.ToLower(path.Ext(parsed.Path))
+	// Formats that span several files or get converted need local copies
+	if !s.Supports(ext) || rawExtensions[ext] || ext == ".mrxs" || ext == ".vms" || ext == ".mosaic" {
+		return "", fmt.Errorf("%w: unsupported file type %q", ErrInvalidRemote, ext)
+	}
+
+	id := uuid.New().String()
+	tempPath := filepath.Join(s.staging.dir, ".remote-"+id+ext)
+	defer os.Remove(tempPath)
+
+	start := time.Now()
+	version, err := remote.Fetch(ctx, rawURL, "", tempPath)
+	switch {
+	case errors.Is(err, storage.ErrRemoteTooLarge):
+		return "", fmt.Errorf("%w: %v", ErrImageTooLarge, err)
+	case err != nil:
+		return "", fmt.Errorf("%w: %v", ErrInvalidRemote, err)
+	}
+	if err := s.ValidateUpload(tempPath); err != nil {
+		return "", err
+	}
+
+	info, err := os.Stat(tempPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to stat file: %w", err)
+	}
+	imageInfo, err := s.scanImage(tempPath, info)
+	if err != nil {
+		return "", fmt.Errorf("failed to scan image: %w", err)
+	}
+
+	imageInfo.ID = id
+	imageInfo.OriginalFilename = path.Base(parsed.Path)
+	imageInfo.CurrentFilename = id + ext
+	imageInfo.Title = meta.Title
+	imageInfo.CopyrightText = meta.CopyrightText
+	imageInfo.CopyrightLink = meta.CopyrightLink
+	imageInfo.Tags = meta.Tags
+	imageInfo.SourceURL = rawURL
+	imageInfo.SourceVersion = version
+	imageInfo.Fingerprint = remoteFingerprint(rawURL, version, info.Size())
+	imageInfo.markUploaded(SourceRemote, meta.UploadedBy)
+
+	log := logger.FromContext(ctx, s.logger)
+	if err := s.makeThumbnail(tempPath, imageInfo); err != nil {
+		log.Warn("Failed to create thumbnail", zap.String("url", rawURL), zap.Error(err))
+	}
+
+	jsonPath := filepath.Join(s.sidecarDir(""), id+".json")
+	if err := s.saveMetadata(jsonPath, imageInfo); err != nil {
+		os.Remove(jsonPath)
+		return "", fmt.Errorf("failed to save metadata: %w", err)
+	}
+	// The download is the first staged copy
+	s.staging.adopt(stagedName(imageInfo), tempPath)
+
+	log.Info("Registered remote image",
+		zap.String("uuid", id),
+		zap.String("url", rawURL),
+		zap.String("version", version),
+		zap.Duration("duration", time.Since(start)))
+	return id, nil
+}
+
+// remoteFingerprint identifies one version of a remote file, like
+// fileFingerprint does for local ones
+func remoteFingerprint(rawURL, version string, size int64) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s:%s:%d", rawURL, version, size)))
+	return hex.EncodeToString(sum[:])[:12]
+}
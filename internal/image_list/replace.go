@@ -0,0 +1,149 @@
+package image_list
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"gigaview/internal/logger"
+)
+
+// ErrNotReplaceable is returned when replacing the content of an image
+// that has no single file of its own (mosaics, MIRAX slides)
+var ErrNotReplaceable = errors.New("image content can't be replaced")
+
+// ReplaceImageContent swaps the file of an image for a new version (e.g. a
+// corrected re-scan) under the same ID, so shared links keep working. The new
+// file is scanned before the old one is touched. User-set fields (copyright,
+// tags, display range, collection) are kept, everything read from the file is
+// replaced. The fingerprint changes, so the next Scan drops the tiles,
+// statistics and static pyramid rendered from the old file.
+func (s *Scanner) ReplaceImageContent(ctx context.Context, id, tempPath, originalFilename, checksum string) error {
+	// A concurrent scan could see the image with no file or with two
+	s.scanMu.Lock()
+	defer s.scanMu.Unlock()
+
+	current := s.GetImageByID(id)
+	if current == nil {
+		return fmt.Errorf("%w: %s", ErrImageNotFound, id)
+	}
+	oldPath := s.imagePath(current)
+	if IsMosaic(oldPath) || strings.ToLower(filepath.Ext(oldPath)) == ".mrxs" {
+		return fmt.Errorf("%w: %s", ErrNotReplaceable, id)
+	}
+
+	ext := strings.ToLower(filepath.Ext(originalFilename))
+	source := tempPath
+	var rawFilename string
+	if isRaw(tempPath) {
+		tiffPath, err := s.convertRaw(tempPath, id)
+		if err != nil {
+			return err
+		}
+		defer os.Remove(tiffPath)
+		source = tiffPath
+		rawFilename = id + ext
+	}
+
+	info, err := os.Stat(source)
+	if err != nil {
+		return fmt.Errorf("failed to stat file: %w", err)
+	}
+	scanned, err := s.scanImage(source, info)
+	if err != nil {
+		return fmt.Errorf("failed to scan image: %w", err)
+	}
+
+	// Mosaics place their sources by pixel position
+	if scanned.Width != current.Width || scanned.Height != current.Height {
+		for _, image := range s.GetImages() {
+			for _, mosaicSource := range image.Mosaic {
+				if mosaicSource.ImageID == id {
+					return fmt.Errorf("%w %s, which needs the same dimensions", ErrImageInUse, image.ID)
+				}
+			}
+		}
+	}
+
+	finalPath := filepath.Join(filepath.Dir(oldPath), id+strings.ToLower(filepath.Ext(source)))
+	// The file is overwritten in place, a new name would be a new ID
+	if s.options.PreserveNames {
+		if !strings.EqualFold(filepath.Ext(source), filepath.Ext(oldPath)) {
+			return fmt.Errorf("%w: %s must keep its file type", ErrNotReplaceable, id)
+		}
+		finalPath = oldPath
+	}
+	if err := moveFile(source, finalPath); err != nil {
+		return fmt.Errorf("failed to move uploaded file: %w", err)
+	}
+	if finalPath != oldPath {
+		if err := os.Remove(oldPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			logger.FromContext(ctx, s.logger).Warn("Failed to remove replaced file", zap.String("path", oldPath), zap.Error(err))
+		}
+	}
+	// A RAW master of another type, or one the new version no longer has
+	if current.RawFilename != "" && current.RawFilename != rawFilename {
+		os.Remove(filepath.Join(s.statePath(rawDir), current.RawFilename))
+	}
+
+	// Moving across devices copies, which gives the file a new version
+	if info, err = os.Stat(finalPath); err != nil {
+		return fmt.Errorf("failed to stat file: %w", err)
+	}
+	scanned.Fingerprint = fileFingerprint(info)
+
+	scanned.ID = id
+	scanned.OriginalFilename = originalFilename
+	scanned.CurrentFilename = filepath.Base(finalPath)
+	scanned.Title = current.Title
+	scanned.CopyrightText = current.CopyrightText
+	scanned.CopyrightLink = current.CopyrightLink
+	scanned.PaddingColor = current.PaddingColor
+	scanned.DisplayRange = current.DisplayRange
+	scanned.Collection = current.Collection
+	scanned.Tags = current.Tags
+	scanned.Slug = current.Slug
+	scanned.Warmup = current.Warmup
+	scanned.AddedAt = current.AddedAt
+	scanned.Source = current.Source
+	scanned.UploadedAt = current.UploadedAt
+	scanned.UploadedBy = current.UploadedBy
+	scanned.RawFilename = rawFilename
+	scanned.SHA256 = checksum
+
+	log := logger.FromContext(ctx, s.logger)
+	if err := s.makeThumbnail(finalPath, scanned); err != nil {
+		log.Warn("Failed to create thumbnail", zap.String("path", finalPath), zap.Error(err))
+	}
+	if err := s.offload(ctx, scanned, finalPath); err != nil {
+		log.Warn("Failed to store original, keeping it in the data directory", zap.String("path", finalPath), zap.Error(err))
+	}
+
+	s.metaMu.Lock()
+	defer s.metaMu.Unlock()
+	if err := s.saveMetadata(s.metadataPath(scanned), scanned); err != nil {
+		return fmt.Errorf("failed to save metadata: %w", err)
+	}
+
+	if scanned.StorageKey != "" {
+		os.Remove(finalPath)
+	}
+	// A remote image becomes a local one; the remote file is left alone
+	s.unstage(current)
+	if current.StorageKey != "" && current.StorageKey != scanned.StorageKey && s.options.Storage != nil {
+		if err := s.options.Storage.Delete(ctx, current.StorageKey); err != nil {
+			log.Warn("Failed to delete replaced original", zap.String("key", current.StorageKey), zap.Error(err))
+		}
+	}
+
+	log.Info("Replaced image content",
+		zap.String("uuid", id),
+		zap.String("original_filename", originalFilename),
+		zap.String("final_path", finalPath))
+	return nil
+}
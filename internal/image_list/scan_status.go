@@ -0,0 +1,82 @@
+package image_list
+
+import (
+	"time"
+)
+
+// ScanStatus reports the progress of the running scan and the outcome of the
+// last one
+type ScanStatus struct {
+	Running   bool      `json:"running"`
+	StartedAt time.Time `json:"started_at,omitzero"`
+	// Discovered counts the image files found so far, Processed those
+	// scanned (including unchanged ones) and Failed those that couldn't be
+	// opened or migrated
+	Discovered int `json:"discovered"`
+	Processed  int `json:"processed"`
+	Failed     int `json:"failed"`
+	// EstimatedCompletion extrapolates the rate files were processed at so
+	// far, it is only set while a scan is running
+	EstimatedCompletion time.Time `json:"estimated_completion,omitzero"`
+
+	// LastScanAt is when the last successful scan finished
+	LastScanAt         time.Time `json:"last_scan_at,omitzero"`
+	LastScanDurationMs int64     `json:"last_scan_duration_ms"`
+	LastError          string    `json:"last_error,omitempty"`
+	Images             int       `json:"images"`
+}
+
+// ScanStatus returns a snapshot of the scan progress
+func (s *Scanner) ScanStatus() ScanStatus {
+	s.statusMu.Lock()
+	status := s.status
+	s.statusMu.Unlock()
+
+	status.Images = len(s.GetImages())
+	if status.Running && status.Processed > 0 && status.Discovered > status.Processed {
+		elapsed := time.Since(status.StartedAt)
+		remaining := time.Duration(float64(elapsed) / float64(status.Processed) * float64(status.Discovered-status.Processed))
+		status.EstimatedCompletion = time.Now().Add(remaining).Truncate(time.Second)
+	}
+	return status
+}
+
+// beginScan resets the progress counters
+func (s *Scanner) beginScan(start time.Time) {
+	s.statusMu.Lock()
+	defer s.statusMu.Unlock()
+	s.status.Running = true
+	s.status.StartedAt = start
+	s.status.Discovered, s.status.Processed, s.status.Failed = 0, 0, 0
+}
+
+// fileDiscovered counts an image file found while walking the directories
+func (s *Scanner) fileDiscovered() {
+	s.statusMu.Lock()
+	defer s.statusMu.Unlock()
+	s.status.Discovered++
+}
+
+// fileScanned counts a processed image file
+func (s *Scanner) fileScanned(ok bool) {
+	s.statusMu.Lock()
+	defer s.statusMu.Unlock()
+	s.status.Processed++
+	if !ok {
+		s.status.Failed++
+	}
+}
+
+// endScan records the outcome of a scan
+func (s *Scanner) endScan(err error) {
+	s.statusMu.Lock()
+	defer s.statusMu.Unlock()
+	s.status.Running = false
+	if err != nil {
+		s.status.LastError = err.Error()
+		return
+	}
+	s.status.LastError = ""
+	s.status.LastScanAt = time.Now()
+	s.status.LastScanDurationMs = s.status.LastScanAt.Sub(s.status.StartedAt).Milliseconds()
+}
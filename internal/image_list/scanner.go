@@ -1,18 +1,31 @@
 package image_list
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"math"
 	"os"
+	"path"
 	"path/filepath"
+	"reflect"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
+	"time"
 
 	"github.com/cshum/vipsgen/vips"
 	"github.com/google/uuid"
 	"go.uber.org/zap"
+
+	"gigaview/internal/logger"
+	"gigaview/internal/render"
+	"gigaview/internal/storage"
 )
 
 type ImageInfo struct {
@@ -22,110 +35,769 @@ type ImageInfo struct {
 	Width            int    `json:"width"`
 	Height           int    `json:"height"`
 	Bytes            int64  `json:"bytes"`
-	CopyrightText    string `json:"copyright_text"`
-	CopyrightLink    string `json:"copyright_link"`
+	// Title is the display name given on upload, as opposed to the file name
+	Title         string     `json:"title,omitempty"`
+	CopyrightText string     `json:"copyright_text"`
+	CopyrightLink string     `json:"copyright_link"`
+	Orientation   int        `json:"orientation,omitempty"`
+	PaddingColor  string     `json:"padding_color,omitempty"`
+	Slide         *SlideInfo `json:"slide,omitempty"`
+	// DPI is the resolution PDFs were rasterized at when scanned, so the
+	// recorded dimensions stay valid if PDF_DPI changes later
+	DPI float64 `json:"dpi,omitempty"`
+	// Scale is the factor SVGs are rasterized at, chosen at scan time so the
+	// longer side matches SVG_TARGET_SIZE. Width and Height are the virtual
+	// pixel dimensions at that scale.
+	Scale float64 `json:"scale,omitempty"`
+	// Pages lists per-page dimensions of multi-page documents; Width and
+	// Height always describe page 0
+	Pages []PageSize `json:"pages,omitempty"`
+	// Geo is set for GeoTIFFs that can be served as web-mercator XYZ tiles
+	Geo *GeoInfo `json:"geo,omitempty"`
+	// Photo holds embedded EXIF, XMP and IPTC metadata
+	Photo *PhotoInfo `json:"photo,omitempty"`
+	// RawFilename is the master (under raw/) the image was made from: a camera
+	// RAW file, or the source of an image converted to a pyramidal TIFF
+	RawFilename string `json:"raw_filename,omitempty"`
+	// StorageKey is set when the original lives in the storage backend
+	// instead of next to the sidecar; it is staged locally for rendering
+	StorageKey string `json:"storage_key,omitempty"`
+	// SourceURL is set for images registered by URL, which stay on the remote
+	// server; SourceVersion is its ETag or Last-Modified at registration
+	SourceURL     string `json:"source_url,omitempty"`
+	SourceVersion string `json:"source_version,omitempty"`
+	// DisplayRange overrides the automatic value range high bit-depth images
+	// are stretched from when rendered to 8 bits
+	DisplayRange *DisplayRange `json:"display_range,omitempty"`
+	// Placeholder is a tiny JPEG data URI of the whole image, shown blurred
+	// while tiles load
+	Placeholder string `json:"placeholder,omitempty"`
+	// Fingerprint identifies the version of the source file (size and
+	// modification time). It is part of tile cache keys and ETags, so
+	// replacing a file invalidates everything rendered from the old one.
+	Fingerprint string `json:"fingerprint,omitempty"`
+	// ThumbnailURL serves the smart-cropped square gallery thumbnail
+	ThumbnailURL string `json:"thumbnail_url,omitempty"`
+	// Mosaic lists the placed sources of a virtual mosaic image
+	Mosaic []MosaicSource `json:"mosaic,omitempty"`
+	// Collection is the directory holding the image, slash-separated and
+	// relative to the data directory ("" for the top level)
+	Collection string `json:"collection,omitempty"`
+	// Tags are free-form labels set through the API
+	Tags []string `json:"tags,omitempty"`
+	// Slug is a unique, human-readable name accepted wherever the ID is
+	Slug string `json:"slug,omitempty"`
+	// Warmup opts the image in to (true) or out of (false) tile warmup,
+	// overriding WARMUP_IMAGES; set it in the sidecar
+	Warmup *bool `json:"warmup,omitempty"`
+	// SHA256 is the hex content hash of uploaded files, used to detect
+	// duplicate uploads. It is dropped when the file is replaced on disk.
+	SHA256 string `json:"sha256,omitempty"`
+	// AddedAt is when the image was first scanned or uploaded, UpdatedAt
+	// when its metadata was last written
+	AddedAt   time.Time `json:"added_at,omitzero"`
+	UpdatedAt time.Time `json:"updated_at,omitzero"`
+	// Source is how the image entered the library, one of the Source*
+	// constants in provenance.go
+	Source string `json:"source,omitempty"`
+	// UploadedAt and UploadedBy are set for images added through the API:
+	// when, and by whom (an upload token fingerprint or "anonymous")
+	UploadedAt time.Time `json:"uploaded_at,omitzero"`
+	UploadedBy string    `json:"uploaded_by,omitempty"`
+	// SchemaVersion is the sidecar format version, see migrate.go
+	SchemaVersion int `json:"schema_version,omitempty"`
+}
+
+var (
+	// ErrImageNotFound is returned for unknown image IDs
+	ErrImageNotFound = errors.New("image not found")
+	// ErrImageInUse is returned when deleting an image a mosaic is made of
+	ErrImageInUse = errors.New("image is used by a mosaic")
+)
+
+// DisplayRange is the source value range mapped linearly onto 0-255
+type DisplayRange = render.DisplayRange
+
+// PageSize is the rendered size of one document page
+type PageSize struct {
+	Width  int `json:"width"`
+	Height int `json:"height"`
 }
 
+// PageCount returns the number of pages, 1 for single-page images
+func (i *ImageInfo) PageCount() int {
+	if len(i.Pages) == 0 {
+		return 1
+	}
+	return len(i.Pages)
+}
+
+// PageSize returns the dimensions of a page, or ok=false when it doesn't exist
+func (i *ImageInfo) PageSize(page int) (width, height int, ok bool) {
+	if page < 0 || page >= i.PageCount() {
+		return 0, 0, false
+	}
+	if len(i.Pages) == 0 {
+		return i.Width, i.Height, true
+	}
+	return i.Pages[page].Width, i.Pages[page].Height, true
+}
+
+// Options tunes how source files are decoded
+type Options struct {
+	// PDFDPI is the resolution PDF pages are rasterized at
+	PDFDPI float64
+	// HEIF and JXL enable formats that need optional libvips support
+	HEIF bool
+	JXL  bool
+	// SVGTargetSize is the longer side, in pixels, SVGs are rasterized to
+	SVGTargetSize int
+	// RAW enables camera RAW ingestion (needs libvips with ImageMagick/libraw)
+	RAW bool
+	// ThumbnailSize is the side of square gallery thumbnails, ThumbnailCrop
+	// the smart-crop strategy: "attention", "entropy" or "centre"
+	ThumbnailSize int
+	ThumbnailCrop string
+	// Recursive scans subdirectories of the data directory as collections
+	Recursive bool
+	// ExcludeDirs are directories inside the data directory that are never
+	// scanned, e.g. the tile cache and static pyramids
+	ExcludeDirs []string
+	// IndexPath is the persistent image index ("" = sidecars only)
+	IndexPath string
+	// Workers is the number of files opened in parallel during a scan
+	Workers int
+	// AlbumsPath is the JSON file albums are kept in ("" = not persisted)
+	AlbumsPath string
+	// MaxPixels and MaxDimension reject images, or any of their pages, with
+	// more pixels or a longer side (0 = unlimited), see limits.go
+	MaxPixels    int64
+	MaxDimension int
+	// Storage keeps the originals of uploads outside the data directory (nil
+	// = next to their sidecars); StagingBytes bounds the local copies made
+	// of them for rendering
+	Storage      storage.Storage
+	StagingBytes int64
+	// Remote downloads images registered by URL (nil = disabled)
+	Remote *storage.Remote
+	// PreserveNames never renames or moves files in the data directory;
+	// IDs are derived from file paths instead, see preserve.go
+	PreserveNames bool
+	// MetadataDir keeps sidecars, thumbnails and the other files the scanner
+	// writes, mirroring the collections ("" = the data directory)
+	MetadataDir string
+	// ReadOnly scans without writing to the data or metadata directory: no
+	// UUID renames, sidecar migrations or cleanups. Files without a sidecar
+	// are left to the node that writes.
+	ReadOnly bool
+}
+
+// defaultPDFDPI renders letter-size pages at about 2550×3300
+const defaultPDFDPI = 300
+
+// defaultSVGTargetSize gives vector drawings 7 zoom levels
+const defaultSVGTargetSize = 16384
+
+// defaultScanWorkers keeps a few files decoding at once without starving
+// tile rendering of libvips threads
+const defaultScanWorkers = 4
+
 type Scanner struct {
-	dataDir string
-	logger  *zap.Logger
-	images  []ImageInfo
+	dataDir     string
+	metadataDir string
+	options     Options
+	logger      *zap.Logger
+	// index is nil when the persistent index is disabled or unavailable
+	index  *index
+	albums *albumStore
+	// staging is nil without a storage backend or remote images
+	staging *stagingArea
+
+	// registry is the current image list; scanMu serializes scans, which
+	// are the only writers
+	registry atomic.Pointer[registry]
+	scanMu   sync.Mutex
+	// metaMu serializes read-modify-write updates of metadata sidecars
+	metaMu sync.Mutex
+
+	// mu guards invalidators, which are called with images a scan removed or
+	// found replaced
+	mu           sync.RWMutex
+	invalidators []func(imageID string)
+
+	// statusMu guards status, which workers update for every file
+	statusMu sync.Mutex
+	status   ScanStatus
+}
+
+// scanResult is the image list a scan is building
+type scanResult struct {
+	images      []ImageInfo
+	collections []string
+	// known holds the records of the previous list by sidecar path, reused
+	// for files and sidecars that haven't changed since
+	known map[string]ImageInfo
+	// files are the candidate image files found by walking the directories,
+	// in listing order
+	files []scanFileJob
+}
+
+// scanFileJob is one file of a scanned directory, or the sidecar of an
+// image whose original is in the storage backend
+type scanFileJob struct {
+	dir        string
+	collection string
+	entry      os.DirEntry
+	stored     *ImageInfo
+}
+
+func New(dataDir string, options Options, logger *zap.Logger) *Scanner {
+	if options.PDFDPI <= 0 {
+		options.PDFDPI = defaultPDFDPI
+	}
+	if options.SVGTargetSize <= 0 {
+		options.SVGTargetSize = defaultSVGTargetSize
+	}
+	if options.ThumbnailSize <= 0 {
+		options.ThumbnailSize = defaultThumbnailSize
+	}
+	if _, ok := thumbnailCrops[options.ThumbnailCrop]; !ok {
+		options.ThumbnailCrop = "attention"
+	}
+	if options.Workers <= 0 {
+		options.Workers = defaultScanWorkers
+	}
+	if options.StagingBytes <= 0 {
+		options.StagingBytes = defaultStagingBytes
+	}
+
+	if options.MetadataDir == "" {
+		options.MetadataDir = dataDir
+	}
+
+	s := &Scanner{
+		dataDir:     dataDir,
+		metadataDir: options.MetadataDir,
+		options:     options,
+		logger:      logger,
+	}
+	// Start at 1 so the first validator never collides with a zero value
+	s.registry.Store(newRegistry([]ImageInfo{}, []string{}, 1, time.Now()))
+
+	if !options.ReadOnly {
+		s.migrateSidecars()
+	}
+	if options.IndexPath != "" {
+		s.openIndex(options.IndexPath)
+	}
+
+	albums, err := openAlbumStore(options.AlbumsPath)
+	if err != nil {
+		// Starting empty would overwrite the file with the next change
+		logger.Error("Albums unavailable, changes won't be saved", zap.Error(err))
+		albums = &albumStore{albums: make(map[string]*Album)}
+	}
+	s.albums = albums
+
+	if options.Storage != nil || options.Remote != nil {
+		staging, err := newStagingArea(s.statePath(stagingDir), options.StagingBytes)
+		if err != nil {
+			logger.Error("Staging unavailable, stored images can't be rendered", zap.Error(err))
+		}
+		s.staging = staging
+	}
+	return s
 }
 
-func New(dataDir string, logger *zap.Logger) *Scanner {
-	return &Scanner{
-		dataDir: dataDir,
-		logger:  logger,
-		images:  []ImageInfo{},
+// openIndex opens the persistent index and serves its records until the
+// first scan completes. Without it, the scanner falls back to the sidecars.
+func (s *Scanner) openIndex(path string) {
+	index, err := openIndex(path)
+	if err != nil {
+		s.logger.Warn("Image index unavailable, reading metadata sidecars", zap.Error(err))
+		return
 	}
+	images, err := index.load()
+	if err != nil {
+		index.close()
+		s.logger.Warn("Image index unavailable, reading metadata sidecars", zap.Error(err))
+		return
+	}
+
+	s.index = index
+	sortNewestFirst(images)
+	s.registry.Store(newRegistry(images, collectionsOf(images), 1, time.Now()))
+	s.logger.Info("Loaded image index", zap.String("path", path), zap.Int("images", len(images)))
+}
+
+// Close releases the persistent index
+func (s *Scanner) Close() error {
+	if s.index == nil {
+		return nil
+	}
+	return s.index.close()
 }
 
 func (s *Scanner) Scan() error {
-	s.images = []ImageInfo{}
+	return s.ScanContext(context.Background())
+}
+
+// ScanContext is Scan that stops opening files once ctx is done and then
+// keeps the previous image list, since a partial one would look like the
+// rest of the images were deleted
+func (s *Scanner) ScanContext(ctx context.Context) error {
+	s.scanMu.Lock()
+	defer s.scanMu.Unlock()
+	return s.scan(ctx)
+}
+
+// scan rebuilds the image list; the caller holds scanMu
+func (s *Scanner) scan(ctx context.Context) error {
+	start := time.Now()
+	s.beginScan(start)
+	result := &scanResult{
+		images:      []ImageInfo{},
+		collections: []string{},
+		known:       make(map[string]ImageInfo),
+	}
+	for _, image := range s.GetImages() {
+		result.known[s.metadataPath(&image)] = image
+	}
+	if err := s.scanDir("", result); err != nil {
+		s.endScan(err)
+		return err
+	}
+	s.scanFiles(ctx, result)
+	if err := ctx.Err(); err != nil {
+		s.endScan(err)
+		return err
+	}
+	// Newest first is the default order of listings
+	sortNewestFirst(result.images)
+
+	if s.index != nil {
+		if err := s.index.sync(result.images); err != nil {
+			s.logger.Warn("Failed to update image index", zap.Error(err))
+		}
+	}
 
-	extensions := map[string]bool{
-		".tif":  true,
-		".tiff": true,
-		".jpg":  true,
-		".jpeg": true,
-		".png":  true,
-		".webp": true,
+	current := s.registry.Load()
+	previous := current.images
+	version, modifiedAt := current.version, current.modifiedAt
+	if !reflect.DeepEqual(previous, result.images) || !reflect.DeepEqual(current.collections, result.collections) {
+		version++
+		modifiedAt = time.Now()
+	}
+	s.registry.Store(newRegistry(result.images, result.collections, version, modifiedAt))
+
+	s.mu.RLock()
+	invalidators := s.invalidators
+	s.mu.RUnlock()
+
+	added, changed, removed := diffImages(previous, result.images)
+	log := s.logger.Debug
+	if len(added)+len(changed)+len(removed) > 0 {
+		log = s.logger.Info
+	}
+	log("Scan complete",
+		zap.Int("images", len(result.images)),
+		zap.Int("added", len(added)),
+		zap.Int("changed", len(changed)),
+		zap.Int("removed", len(removed)),
+		zap.Duration("duration", time.Since(start)))
+
+	for _, imageID := range append(changed, removed...) {
+		for _, invalidate := range invalidators {
+			invalidate(imageID)
+		}
+	}
+	s.endScan(nil)
+	return nil
+}
+
+// OnInvalidate registers a function called after each scan with every image
+// that was removed or renders differently (replaced file, new padding
+// colour), so derived data (rendered tiles, statistics) can be dropped
+func (s *Scanner) OnInvalidate(invalidate func(imageID string)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.invalidators = append(s.invalidators, invalidate)
+}
+
+// diffImages compares two image lists by ID and rendering: added images are
+// only in current, changed ones render differently there and removed ones
+// are gone from it
+func diffImages(previous, current []ImageInfo) (added, changed, removed []string) {
+	renderings := make(map[string]rendering, len(previous))
+	for _, image := range previous {
+		renderings[image.ID] = renderingOf(&image)
+	}
+
+	for _, image := range current {
+		rendered, ok := renderings[image.ID]
+		switch {
+		case !ok:
+			added = append(added, image.ID)
+		case rendered != renderingOf(&image):
+			changed = append(changed, image.ID)
+		}
+		delete(renderings, image.ID)
+	}
+	for _, image := range previous {
+		if _, ok := renderings[image.ID]; ok {
+			removed = append(removed, image.ID)
+		}
 	}
+	return added, changed, removed
+}
+
+// rendering is what tiles of an image depend on beyond their cache key,
+// which holds the file fingerprint and display range. A padding colour
+// edited in the sidecar changes the pixels of edge tiles.
+type rendering struct {
+	fingerprint  string
+	paddingColor string
+}
+
+func renderingOf(image *ImageInfo) rendering {
+	return rendering{fingerprint: image.Fingerprint, paddingColor: image.PaddingColor}
+}
 
-	if err := s.cleanupOrphanedJSON(); err != nil {
+// scanDir scans one directory of the data directory, given by its
+// slash-separated path relative to it ("" is the top level), and recurses
+// into subdirectories, which become collections
+func (s *Scanner) scanDir(collection string, result *scanResult) error {
+	dir := s.getFilePath(filepath.FromSlash(collection))
+
+	if err := s.cleanupOrphanedJSON(dir, collection, result); err != nil {
 		return err
 	}
 
-	entries, err := os.ReadDir(s.dataDir)
+	entries, err := os.ReadDir(dir)
 	if err != nil {
 		return fmt.Errorf("failed to read data directory: %w", err)
 	}
 
+	var subdirs []string
 	for _, entry := range entries {
 		if entry.IsDir() {
+			if s.options.Recursive && s.isCollectionDir(dir, collection, entry.Name()) {
+				subdirs = append(subdirs, entry.Name())
+			}
 			continue
 		}
-
-		path := s.getFilePath(entry.Name())
-		info, err := entry.Info()
-		if err != nil {
-			s.logger.Warn("Error getting file info", zap.String("path", path), zap.Error(err))
+		if !s.Supports(strings.ToLower(filepath.Ext(entry.Name()))) {
 			continue
 		}
+		result.files = append(result.files, scanFileJob{dir: dir, collection: collection, entry: entry})
+		s.fileDiscovered()
+	}
 
-		ext := strings.ToLower(filepath.Ext(path))
-		if !extensions[ext] {
-			continue
+	for _, name := range subdirs {
+		child := path.Join(collection, name)
+		result.collections = append(result.collections, child)
+		if err := s.scanDir(child, result); err != nil {
+			s.logger.Warn("Failed to scan collection", zap.String("collection", child), zap.Error(err))
 		}
+	}
 
-		basename := strings.TrimSuffix(filepath.Base(path), ext)
-		jsonPath := s.getFilePath(basename + ".json")
+	return nil
+}
 
-		var imageInfo *ImageInfo
-		var finalPath string
+// scanFiles scans the image files found by scanDir on a bounded worker pool, since
+// opening a new image and creating its previews can take seconds. Images
+// added at the same time keep the directory listing order.
+func (s *Scanner) scanFiles(ctx context.Context, result *scanResult) {
+	scanned := make([]*ImageInfo, len(result.files))
+	jobs := make(chan int)
 
-		// If there is no metadata, we need to create it and rename the file
-		if _, err := os.Stat(jsonPath); err != nil {
-			newUUID := uuid.New().String()
-			finalPath = s.getFilePath(newUUID + ext)
-			if err := os.Rename(path, finalPath); err != nil {
-				s.logger.Warn("Failed to rename file", zap.String("old_path", path), zap.String("new_path", finalPath), zap.Error(err))
-				continue
+	var wg sync.WaitGroup
+	for i := 0; i < min(s.options.Workers, len(result.files)); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := range jobs {
+				job := result.files[n]
+				if job.stored != nil {
+					scanned[n] = s.scanStored(job.dir, job.collection, job.stored)
+				} else {
+					scanned[n] = s.scanFile(job.dir, job.collection, job.entry, result.known)
+				}
+				s.fileScanned(scanned[n] != nil)
+			}
+		}()
+	}
+	for n := range result.files {
+		if ctx.Err() != nil {
+			break
+		}
+		jobs <- n
+	}
+	close(jobs)
+	wg.Wait()
+
+	for _, imageInfo := range scanned {
+		if imageInfo != nil {
+			result.images = append(result.images, *imageInfo)
+		}
+	}
+}
+
+// scanFile returns the image record of one image file of a scanned
+// directory, or nil if it couldn't be scanned. Files are migrated to a UUID
+// name with a metadata sidecar on first sight, or in preserve mode keep their
+// name and get an ID derived from it. It runs concurrently for files of a
+// scan and only reads known.
+func (s *Scanner) scanFile(dir, collection string, entry os.DirEntry, known map[string]ImageInfo) *ImageInfo {
+	path := filepath.Join(dir, entry.Name())
+	ext := strings.ToLower(filepath.Ext(path))
+
+	info, err := entry.Info()
+	if err != nil {
+		s.logger.Warn("Error getting file info", zap.String("path", path), zap.Error(err))
+		return nil
+	}
+
+	basename := strings.TrimSuffix(filepath.Base(path), ext)
+	if s.options.PreserveNames {
+		basename = pathID(collection, entry.Name())
+	}
+	jsonPath := filepath.Join(s.sidecarDir(collection), basename+".json")
+
+	var imageInfo *ImageInfo
+	var finalPath string
+
+	// If there is no metadata, we need to create it and rename the file
+	jsonInfo, err := os.Stat(jsonPath)
+	if err != nil && s.options.ReadOnly {
+		s.logger.Debug("Skipping image without metadata, the server is read-only", zap.String("path", path))
+		return nil
+	} else if err != nil && s.options.PreserveNames {
+		newUUID := basename
+		finalPath = path
+
+		imageInfo, err = s.scanImage(finalPath, info)
+		if err != nil {
+			// Over the limits too: the file isn't ours to move to quarantine
+			s.logger.Warn("Failed to scan image", zap.String("path", finalPath), zap.Error(err))
+			return nil
+		}
+		imageInfo.ID = newUUID
+		imageInfo.OriginalFilename = entry.Name()
+		imageInfo.CurrentFilename = entry.Name()
+		imageInfo.Collection = collection
+		imageInfo.AddedAt = time.Now()
+		imageInfo.Source = SourceScan
+
+		if err := s.makeThumbnail(finalPath, imageInfo); err != nil {
+			s.logger.Warn("Failed to create thumbnail", zap.String("path", finalPath), zap.Error(err))
+		}
+		if err := s.saveMetadata(jsonPath, imageInfo); err != nil {
+			s.logger.Warn("Failed to save metadata", zap.String("json_path", jsonPath), zap.Error(err))
+		} else {
+			s.logger.Info("Created metadata file", zap.String("json_path", jsonPath))
+		}
+	} else if err != nil {
+		newUUID := uuid.New().String()
+		finalPath = filepath.Join(dir, newUUID+ext)
+		if err := os.Rename(path, finalPath); err != nil {
+			s.logger.Warn("Failed to rename file", zap.String("old_path", path), zap.String("new_path", finalPath), zap.Error(err))
+			return nil
+		}
+		s.logger.Info("Migrated file to UUID", zap.String("old_path", path), zap.String("new_path", finalPath))
+
+		// MIRAX slides keep their tiles in a sibling directory named after the file
+		if ext == ".mrxs" {
+			if err := os.Rename(filepath.Join(dir, basename), filepath.Join(dir, newUUID)); err != nil {
+				s.logger.Warn("Failed to rename slide data directory", zap.String("path", path), zap.Error(err))
 			}
-			s.logger.Info("Migrated file to UUID", zap.String("old_path", path), zap.String("new_path", finalPath))
+		}
 
-			imageInfo, err = s.scanImage(finalPath, info)
+		var rawFilename string
+		if isRaw(finalPath) {
+			rawFilename = newUUID + ext
+			finalPath, err = s.convertRaw(finalPath, newUUID)
 			if err != nil {
-				s.logger.Warn("Failed to scan image", zap.String("path", finalPath), zap.Error(err))
-				continue
+				s.logger.Warn("Failed to convert raw image", zap.String("path", path), zap.Error(err))
+				return nil
+			}
+			if info, err = os.Stat(finalPath); err != nil {
+				return nil
 			}
+		}
 
-			imageInfo.ID = newUUID
-			imageInfo.OriginalFilename = filepath.Base(path)
-			imageInfo.CurrentFilename = filepath.Base(finalPath)
+		imageInfo, err = s.scanImage(finalPath, info)
+		if errors.Is(err, ErrImageTooLarge) {
+			s.quarantine(finalPath, rawFilename, filepath.Base(path), err)
+			return nil
+		}
+		if err != nil {
+			s.logger.Warn("Failed to scan image", zap.String("path", finalPath), zap.Error(err))
+			return nil
+		}
 
-			jsonPath = s.getFilePath(newUUID + ".json")
-			if err := s.saveMetadata(jsonPath, imageInfo); err != nil {
-				s.logger.Warn("Failed to save metadata", zap.String("json_path", jsonPath), zap.Error(err))
-			} else {
-				s.logger.Info("Created metadata file", zap.String("json_path", jsonPath))
-			}
+		imageInfo.ID = newUUID
+		imageInfo.RawFilename = rawFilename
+		imageInfo.OriginalFilename = filepath.Base(path)
+		imageInfo.CurrentFilename = filepath.Base(finalPath)
+		imageInfo.Collection = collection
+		imageInfo.AddedAt = time.Now()
+		imageInfo.Source = SourceScan
+
+		if err := s.makeThumbnail(finalPath, imageInfo); err != nil {
+			s.logger.Warn("Failed to create thumbnail", zap.String("path", finalPath), zap.Error(err))
+		}
+
+		jsonPath = filepath.Join(s.sidecarDir(collection), newUUID+".json")
+		if err := s.saveMetadata(jsonPath, imageInfo); err != nil {
+			s.logger.Warn("Failed to save metadata", zap.String("json_path", jsonPath), zap.Error(err))
+		} else {
+			s.logger.Info("Created metadata file", zap.String("json_path", jsonPath))
+		}
+	} else {
+		// Metadata exists: reuse the known record unless the sidecar was
+		// written since, otherwise load it
+		if record, ok := known[jsonPath]; ok && !jsonInfo.ModTime().After(record.UpdatedAt) {
+			imageInfo = &record
 		} else {
-			// Metadata exists, load it
 			imageInfo, err = s.loadMetadata(jsonPath)
 			if err != nil {
 				s.logger.Warn("Failed to load metadata, skipping", zap.String("json_path", jsonPath), zap.Error(err))
-				continue
+				return nil
+			}
+			// Covers sidecars edited by hand or written by older versions
+			if jsonInfo.ModTime().After(imageInfo.UpdatedAt) {
+				imageInfo.UpdatedAt = jsonInfo.ModTime()
+			}
+		}
+
+		// The collection follows the directory, so moving an image together
+		// with its sidecar moves it between collections
+		moved := imageInfo.Collection != collection
+		imageInfo.Collection = collection
+
+		// Read-only servers leave the sidecar to the node that writes
+		if !s.options.ReadOnly && (s.refreshMetadata(path, info, imageInfo) || moved) {
+			if err := s.saveMetadata(jsonPath, imageInfo); err != nil {
+				s.logger.Warn("Failed to save metadata", zap.String("json_path", jsonPath), zap.Error(err))
 			}
 		}
-		s.images = append(s.images, *imageInfo)
+
+		// Images added before the limits were lowered keep their files and
+		// sidecars, but aren't served
+		if err := s.checkImageSize(imageInfo); err != nil {
+			s.logger.Warn("Skipping image over the size limits", zap.String("path", path), zap.Error(err))
+			return nil
+		}
 	}
+	return imageInfo
+}
 
-	return nil
+// refreshMetadata brings stored metadata up to date with the file on disk and
+// reports whether it changed. A file replaced under the same name is scanned
+// again, keeping the fields set by users, and so is an image recorded with
+// the dimensions of its unrotated pixels; metadata written by older versions
+// gets its fingerprint, orientation, placeholder and thumbnail backfilled.
+func (s *Scanner) refreshMetadata(path string, info os.FileInfo, imageInfo *ImageInfo) bool {
+	fingerprint := fileFingerprint(info)
+	changed := false
+
+	rescan := imageInfo.Fingerprint != "" && imageInfo.Fingerprint != fingerprint
+	// Metadata written before orientations were recorded has the dimensions
+	// of the stored pixels, while tiles are rendered upright
+	if !rescan && imageInfo.Orientation == 0 && !IsMosaic(path) {
+		orientation, err := s.readOrientation(path)
+		switch {
+		case err != nil:
+			s.logger.Warn("Failed to read orientation", zap.String("path", path), zap.Error(err))
+		case orientation >= 5 && orientation <= 8:
+			rescan = true
+		default:
+			imageInfo.Orientation = orientation
+			changed = true
+		}
+	}
+
+	if rescan {
+		scanned, err := s.scanImage(path, info)
+		if err != nil {
+			s.logger.Warn("Failed to rescan replaced image", zap.String("path", path), zap.Error(err))
+			return false
+		}
+		scanned.ID = imageInfo.ID
+		scanned.OriginalFilename = imageInfo.OriginalFilename
+		scanned.CurrentFilename = imageInfo.CurrentFilename
+		scanned.Title = imageInfo.Title
+		scanned.CopyrightText = imageInfo.CopyrightText
+		scanned.CopyrightLink = imageInfo.CopyrightLink
+		scanned.PaddingColor = imageInfo.PaddingColor
+		scanned.DisplayRange = imageInfo.DisplayRange
+		scanned.RawFilename = imageInfo.RawFilename
+		scanned.Collection = imageInfo.Collection
+		scanned.Tags = imageInfo.Tags
+		scanned.Slug = imageInfo.Slug
+		scanned.Warmup = imageInfo.Warmup
+		scanned.AddedAt = imageInfo.AddedAt
+		scanned.Source = imageInfo.Source
+		scanned.UploadedAt = imageInfo.UploadedAt
+		scanned.UploadedBy = imageInfo.UploadedBy
+		*imageInfo = *scanned
+		s.logger.Info("Image rescanned", zap.String("path", path), zap.String("id", imageInfo.ID))
+
+		if err := s.makeThumbnail(path, imageInfo); err != nil {
+			s.logger.Warn("Failed to create thumbnail", zap.String("path", path), zap.Error(err))
+		}
+		return true
+	}
+
+	if imageInfo.Fingerprint == "" {
+		imageInfo.Fingerprint = fingerprint
+		changed = true
+	}
+	if imageInfo.AddedAt.IsZero() {
+		imageInfo.AddedAt = info.ModTime()
+		changed = true
+	}
+	// Mosaics have no file of their own to preview
+	if IsMosaic(path) {
+		return changed
+	}
+	if imageInfo.Placeholder == "" {
+		if placeholder, err := makePlaceholder(path); err != nil {
+			s.logger.Warn("Failed to create placeholder", zap.String("path", path), zap.Error(err))
+		} else {
+			imageInfo.Placeholder = placeholder
+			changed = true
+		}
+	}
+	if imageInfo.ThumbnailURL == "" {
+		if err := s.makeThumbnail(path, imageInfo); err != nil {
+			s.logger.Warn("Failed to create thumbnail", zap.String("path", path), zap.Error(err))
+		} else {
+			changed = true
+		}
+	}
+	return changed
+}
+
+// fileFingerprint derives a file version from its size and modification
+// time, which is cheap enough to check on every scan, unlike a content hash
+func fileFingerprint(info os.FileInfo) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%d:%d", info.Size(), info.ModTime().UnixNano())))
+	return hex.EncodeToString(sum[:])[:12]
 }
 
-func (s *Scanner) cleanupOrphanedJSON() error {
-	entries, err := os.ReadDir(s.dataDir)
+// cleanupOrphanedJSON deletes sidecars that are invalid or whose image is
+// gone, and queues the ones of images kept in the storage backend or on
+// remote servers, which have no local file. Sidecars unchanged since the last scan aren't parsed
+// again.
+func (s *Scanner) cleanupOrphanedJSON(dir, collection string, result *scanResult) error {
+	sidecarDir := s.sidecarDir(collection)
+	entries, err := os.ReadDir(sidecarDir)
+	// A separate metadata directory only has the collections with sidecars
+	if errors.Is(err, os.ErrNotExist) && sidecarDir != dir {
+		return nil
+	}
 	if err != nil {
 		return fmt.Errorf("failed to read data directory: %w", err)
 	}
@@ -135,17 +807,40 @@ func (s *Scanner) cleanupOrphanedJSON() error {
 			continue
 		}
 
-		path := s.getFilePath(entry.Name())
+		path := filepath.Join(sidecarDir, entry.Name())
 		if strings.ToLower(filepath.Ext(path)) != ".json" {
 			continue
 		}
 
 		// Get ID from filename (basename without .json)
 		basename := strings.TrimSuffix(filepath.Base(path), ".json")
+		// Sidecars are named after UUIDs; other JSON files (albums.json,
+		// files of the user) are left alone
+		if _, err := uuid.Parse(basename); err != nil {
+			continue
+		}
+
+		var meta *ImageInfo
+		var err error
+		if record, ok := result.known[path]; ok {
+			if info, err := entry.Info(); err == nil && !info.ModTime().After(record.UpdatedAt) {
+				meta = &record
+			}
+		}
 
 		// Try to load metadata
-		meta, err := s.loadMetadata(path)
+		if meta == nil {
+			meta, err = s.loadMetadata(path)
+		}
+		if errors.Is(err, ErrNewerSchema) {
+			s.logger.Warn("Skipping metadata of a newer version", zap.String("path", path), zap.Error(err))
+			continue
+		}
 		if err != nil {
+			if s.options.ReadOnly {
+				s.logger.Warn("Skipping invalid JSON", zap.String("path", path), zap.Error(err))
+				continue
+			}
 			if err := os.Remove(path); err != nil {
 				s.logger.Warn("Failed to delete invalid JSON", zap.String("path", path), zap.Error(err))
 			} else {
@@ -161,6 +856,9 @@ func (s *Scanner) cleanupOrphanedJSON() error {
 				zap.String("filename_uuid", basename),
 				zap.String("json_uuid", meta.ID))
 			// Delete invalid JSON
+			if s.options.ReadOnly {
+				continue
+			}
 			if err := os.Remove(path); err != nil {
 				s.logger.Warn("Failed to delete invalid JSON", zap.String("path", path), zap.Error(err))
 			} else {
@@ -169,8 +867,16 @@ func (s *Scanner) cleanupOrphanedJSON() error {
 			continue
 		}
 
-		imagePath := s.getFilePath(meta.CurrentFilename)
+		imagePath := filepath.Join(dir, meta.CurrentFilename)
 		if _, err := os.Stat(imagePath); err != nil {
+			if isStaged(meta) {
+				result.files = append(result.files, scanFileJob{dir: dir, collection: collection, stored: meta})
+				s.fileDiscovered()
+				continue
+			}
+			if s.options.ReadOnly {
+				continue
+			}
 			if err := os.Remove(path); err != nil {
 				s.logger.Warn("Failed to delete orphaned JSON", zap.String("path", path), zap.Error(err))
 			} else {
@@ -183,6 +889,17 @@ func (s *Scanner) cleanupOrphanedJSON() error {
 }
 
 func (s *Scanner) scanImage(path string, info os.FileInfo) (*ImageInfo, error) {
+	if IsMosaic(path) {
+		imageInfo, err := s.scanMosaic(path, info)
+		if err != nil {
+			return nil, err
+		}
+		if err := s.checkSize(imageInfo.Width, imageInfo.Height); err != nil {
+			return nil, err
+		}
+		return imageInfo, nil
+	}
+
 	// Load image based on file extension
 	image, err := s.loadImage(path)
 	if err != nil {
@@ -194,17 +911,178 @@ func (s *Scanner) scanImage(path string, info os.FileInfo) (*ImageInfo, error) {
 	height := image.Height()
 	bytes := info.Size()
 
+	// EXIF orientations 5-8 rotate by 90°, so the displayed image has its
+	// width and height swapped. Record the logical (upright) dimensions.
+	orientation := scannedOrientation(image)
+	if orientation >= 5 && orientation <= 8 {
+		width, height = height, width
+	}
+	// Only the header has been read so far, reject bombs before decoding
+	if err := s.checkSize(width, height); err != nil {
+		return nil, err
+	}
+
+	var slide *SlideInfo
+	if IsSlide(path) {
+		slide = ReadSlideInfo(image)
+	}
+	photo := readPhotoInfo(image)
+
 	id := uuid.New().String()
 
-	return &ImageInfo{
-		ID:     id,
-		Width:  width,
-		Height: height,
-		Bytes:  bytes,
-	}, nil
+	imageInfo := &ImageInfo{
+		ID:          id,
+		Width:       width,
+		Height:      height,
+		Bytes:       bytes,
+		Orientation: orientation,
+		Slide:       slide,
+		Photo:       photo,
+		Fingerprint: fileFingerprint(info),
+	}
+
+	if isPDF(path) {
+		imageInfo.DPI = s.options.PDFDPI
+		if pages := image.Pages(); pages > 1 {
+			sizes, err := s.scanPDFPages(path, pages)
+			if err != nil {
+				return nil, err
+			}
+			imageInfo.Pages = sizes
+		}
+	}
+
+	if isSVG(path) {
+		if err := s.scaleSVG(path, imageInfo); err != nil {
+			return nil, err
+		}
+	}
+
+	if placeholder, err := makePlaceholder(path); err != nil {
+		s.logger.Warn("Failed to create placeholder", zap.String("path", path), zap.Error(err))
+	} else {
+		imageInfo.Placeholder = placeholder
+	}
+
+	if isTiff(path) {
+		if geo, err := readGeoInfo(path, width, height); err == nil {
+			imageInfo.Geo = geo
+		}
+
+		if pages := image.Pages(); pages > 1 {
+			sizes, err := s.scanTiffPages(path, pages)
+			if err != nil {
+				return nil, err
+			}
+			// Pages that halve in size are a resolution pyramid of page 0,
+			// not separate documents
+			if !isPagePyramid(sizes) {
+				imageInfo.Pages = sizes
+			}
+		}
+	}
+
+	for _, page := range imageInfo.Pages {
+		if err := s.checkSize(page.Width, page.Height); err != nil {
+			return nil, err
+		}
+	}
+
+	return imageInfo, nil
+}
+
+// scaleSVG picks the rasterization scale for an SVG from its natural size and
+// records the resulting virtual dimensions
+func (s *Scanner) scaleSVG(path string, imageInfo *ImageInfo) error {
+	longest := max(imageInfo.Width, imageInfo.Height)
+	if longest <= 0 {
+		return fmt.Errorf("svg has no size")
+	}
+	scale := float64(s.options.SVGTargetSize) / float64(longest)
+
+	image, err := LoadSVG(path, scale, vips.AccessSequential)
+	if err != nil {
+		return fmt.Errorf("failed to rasterize svg: %w", err)
+	}
+	defer image.Close()
+
+	imageInfo.Scale = scale
+	imageInfo.Width = image.Width()
+	imageInfo.Height = image.Height()
+	return nil
+}
+
+// scanTiffPages reads the upright size of every page of a multi-page TIFF
+func (s *Scanner) scanTiffPages(path string, pages int) ([]PageSize, error) {
+	// The IFD chain is what libvips counts as pages; if it doesn't agree,
+	// open every page instead
+	if sizes, err := readTiffPageSizes(path); err == nil && len(sizes) == pages {
+		return sizes, nil
+	}
+
+	sizes := make([]PageSize, 0, pages)
+	for page := 0; page < pages; page++ {
+		image, err := LoadTiffPage(path, page, vips.AccessSequential)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open page %d: %w", page, err)
+		}
+		width, height := image.Width(), image.Height()
+		if orientation := image.Orientation(); orientation >= 5 && orientation <= 8 {
+			width, height = height, width
+		}
+		sizes = append(sizes, PageSize{Width: width, Height: height})
+		image.Close()
+	}
+	return sizes, nil
+}
+
+// isPagePyramid reports whether every page is about half the previous one
+func isPagePyramid(sizes []PageSize) bool {
+	for i := 1; i < len(sizes); i++ {
+		if !isHalf(sizes[i].Width, sizes[i-1].Width) || !isHalf(sizes[i].Height, sizes[i-1].Height) {
+			return false
+		}
+	}
+	return true
+}
+
+func isHalf(size, prevSize int) bool {
+	return math.Abs(float64(size)-float64(prevSize)/2) <= 1
+}
+
+// scanPDFPages reads the size of every page, since pages of one document
+// (e.g. drawing sheets) often differ in format
+func (s *Scanner) scanPDFPages(path string, pages int) ([]PageSize, error) {
+	sizes := make([]PageSize, 0, pages)
+	for page := 0; page < pages; page++ {
+		image, err := LoadPDF(path, page, s.options.PDFDPI, vips.AccessSequential)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open page %d: %w", page, err)
+		}
+		sizes = append(sizes, PageSize{Width: image.Width(), Height: image.Height()})
+		image.Close()
+	}
+	return sizes, nil
 }
 
 // loadImage loads an image based on file extension
+// scannedOrientation returns the EXIF orientation of image, 1 (upright) for
+// images without one, so a recorded orientation of 0 marks metadata written
+// before orientations were recorded
+func scannedOrientation(image *vips.Image) int {
+	return max(image.Orientation(), 1)
+}
+
+// readOrientation reads the orientation of an image file from its header
+func (s *Scanner) readOrientation(path string) (int, error) {
+	image, err := s.loadImage(path)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open image: %w", err)
+	}
+	defer image.Close()
+	return scannedOrientation(image), nil
+}
+
 func (s *Scanner) loadImage(path string) (*vips.Image, error) {
 	ext := strings.ToLower(filepath.Ext(path))
 
@@ -213,9 +1091,7 @@ func (s *Scanner) loadImage(path string) (*vips.Image, error) {
 
 	switch ext {
 	case ".tif", ".tiff":
-		opts := vips.DefaultTiffloadOptions()
-		opts.Access = access
-		return vips.NewTiffload(path, opts)
+		return LoadTiffPage(path, 0, access)
 	case ".jpg", ".jpeg":
 		opts := vips.DefaultJpegloadOptions()
 		opts.Access = access
@@ -228,41 +1104,120 @@ func (s *Scanner) loadImage(path string) (*vips.Image, error) {
 		opts := vips.DefaultWebploadOptions()
 		opts.Access = access
 		return vips.NewWebpload(path, opts)
+	case ".heic", ".heif":
+		return LoadHEIF(path, access)
+	case ".jxl":
+		return LoadJXL(path, access)
+	case ".svg":
+		return LoadSVG(path, 1, access)
+	case ".pdf":
+		return LoadPDF(path, 0, s.options.PDFDPI, access)
+	case ".svs", ".ndpi", ".mrxs", ".scn", ".vms", ".bif":
+		return LoadSlide(path, 0, access)
 	default:
 		return nil, fmt.Errorf("unsupported image format: %s", ext)
 	}
 }
 
+// State returns the image list version and when it last changed
+func (s *Scanner) State() (uint64, time.Time) {
+	current := s.registry.Load()
+	return current.version, current.modifiedAt
+}
+
+// GetImages returns the image list of the last scan. Scans replace the list
+// rather than modify it, so it can be read without holding any lock, but
+// callers must not modify it.
 func (s *Scanner) GetImages() []ImageInfo {
-	return s.images
+	return s.registry.Load().images
 }
 
+// GetImageByID returns a copy of an image record, or nil for unknown IDs
 func (s *Scanner) GetImageByID(id string) *ImageInfo {
-	for _, img := range s.images {
-		if img.ID == id {
-			return &img
-		}
-	}
-	return nil
+	return s.registry.Load().get(id)
+}
+
+// FindBySHA256 returns a copy of an uploaded image with the given content
+// hash, or nil if there is none
+func (s *Scanner) FindBySHA256(checksum string) *ImageInfo {
+	return s.registry.Load().findBySHA256(checksum)
 }
 
+// GetImagePathByID returns the local file of an image, staging originals
+// kept in the storage backend or on remote servers first. It returns "" for unknown images and
+// originals that can't be staged.
 func (s *Scanner) GetImagePathByID(id string) string {
 	imageInfo := s.GetImageByID(id)
 	if imageInfo == nil {
 		return ""
 	}
-	return s.getFilePath(imageInfo.CurrentFilename)
+	if isStaged(imageInfo) {
+		path, err := s.stage(imageInfo)
+		if err != nil {
+			s.logger.Error("Failed to stage image", zap.String("uuid", id), zap.Error(err))
+			return ""
+		}
+		return path
+	}
+	return s.imagePath(imageInfo)
+}
+
+// imagePath returns the file of an image inside its collection directory
+func (s *Scanner) imagePath(imageInfo *ImageInfo) string {
+	return filepath.Join(s.dataDir, filepath.FromSlash(imageInfo.Collection), imageInfo.CurrentFilename)
+}
+
+// metadataPath returns the JSON sidecar of an image, next to its file or in
+// the metadata directory
+func (s *Scanner) metadataPath(imageInfo *ImageInfo) string {
+	return filepath.Join(s.sidecarDir(imageInfo.Collection), imageInfo.ID+".json")
+}
+
+// SetDisplayRange stores (or with nil, clears) the display range in the image
+// metadata. The change is picked up by the next Scan.
+func (s *Scanner) SetDisplayRange(id string, displayRange *DisplayRange) error {
+	return s.updateMetadata(id, func(meta *ImageInfo) error {
+		meta.DisplayRange = displayRange
+		return nil
+	})
+}
+
+// updateMetadata applies a change to the stored metadata of an image. An
+// error from update leaves the metadata unchanged.
+func (s *Scanner) updateMetadata(id string, update func(meta *ImageInfo) error) error {
+	imageInfo := s.GetImageByID(id)
+	if imageInfo == nil {
+		return fmt.Errorf("%w: %s", ErrImageNotFound, id)
+	}
+
+	s.metaMu.Lock()
+	defer s.metaMu.Unlock()
+
+	jsonPath := s.metadataPath(imageInfo)
+	meta, err := s.loadMetadata(jsonPath)
+	if err != nil {
+		return err
+	}
+	if err := update(meta); err != nil {
+		return err
+	}
+	return s.saveMetadata(jsonPath, meta)
 }
 
 func (s *Scanner) getFilePath(filename string) string {
 	return filepath.Join(s.dataDir, filename)
 }
 
+// loadMetadata reads a sidecar, migrating it to the current schema version
+// in memory
 func (s *Scanner) loadMetadata(path string) (*ImageInfo, error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
 		return nil, err
 	}
+	if data, _, err = migrateMetadata(data); err != nil {
+		return nil, err
+	}
 
 	var meta ImageInfo
 	if err := json.Unmarshal(data, &meta); err != nil {
@@ -272,16 +1227,32 @@ func (s *Scanner) loadMetadata(path string) (*ImageInfo, error) {
 	return &meta, nil
 }
 
+// saveMetadata writes the sidecar of an image and its index record
 func (s *Scanner) saveMetadata(path string, meta *ImageInfo) error {
+	meta.SchemaVersion = schemaVersion
+	meta.UpdatedAt = time.Now()
 	data, err := json.MarshalIndent(meta, "", "  ")
 	if err != nil {
 		return fmt.Errorf("failed to marshal metadata: %w", err)
 	}
 
+	if s.metadataDir != s.dataDir {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return fmt.Errorf("failed to write metadata: %w", err)
+		}
+	}
 	if err := os.WriteFile(path, data, 0644); err != nil {
 		return fmt.Errorf("failed to write metadata: %w", err)
 	}
+	// Scans compare UpdatedAt with the sidecar's modification time to
+	// tell whether it was edited since, so they must agree exactly
+	if info, err := os.Stat(path); err == nil {
+		meta.UpdatedAt = info.ModTime()
+	}
 
+	if s.index != nil {
+		return s.index.put(meta)
+	}
 	return nil
 }
 
@@ -331,16 +1302,30 @@ func copyFile(src, dst string) error {
 	return os.Remove(src)
 }
 
-// ProcessUploadedFile processes an uploaded file: generates UUID, saves as UUID.ext, creates metadata
-func (s *Scanner) ProcessUploadedFile(tempPath string, originalFilename string, copyrightText string, copyrightLink string) (string, error) {
+// ProcessUploadedFile processes an uploaded file: generates UUID, saves as UUID.ext, creates metadata.
+// checksum is the hex SHA-256 of the uploaded content, meta the fields given
+// with the upload, already normalized.
+func (s *Scanner) ProcessUploadedFile(ctx context.Context, tempPath string, originalFilename string, checksum string, meta UploadMetadata) (string, error) {
 	ext := strings.ToLower(filepath.Ext(originalFilename))
-	newUUID := uuid.New().String()
-	finalPath := s.getFilePath(newUUID + ext)
+	name, newUUID := s.uploadName(originalFilename, ext)
+	finalPath := s.getFilePath(name)
 
 	if err := moveFile(tempPath, finalPath); err != nil {
+		os.Remove(finalPath)
 		return "", fmt.Errorf("failed to move uploaded file: %w", err)
 	}
 
+	var rawFilename string
+	if isRaw(finalPath) {
+		rawFilename = newUUID + ext
+		tiffPath, err := s.convertRaw(finalPath, newUUID)
+		if err != nil {
+			os.Remove(finalPath)
+			return "", err
+		}
+		finalPath = tiffPath
+	}
+
 	info, err := os.Stat(finalPath)
 	if err != nil {
 		return "", fmt.Errorf("failed to stat file: %w", err)
@@ -348,24 +1333,113 @@ func (s *Scanner) ProcessUploadedFile(tempPath string, originalFilename string,
 
 	imageInfo, err := s.scanImage(finalPath, info)
 	if err != nil {
+		os.Remove(finalPath)
 		return "", fmt.Errorf("failed to scan image: %w", err)
 	}
 
 	imageInfo.ID = newUUID
 	imageInfo.OriginalFilename = originalFilename
 	imageInfo.CurrentFilename = filepath.Base(finalPath)
-	imageInfo.CopyrightText = copyrightText
-	imageInfo.CopyrightLink = copyrightLink
+	imageInfo.Title = meta.Title
+	imageInfo.CopyrightText = meta.CopyrightText
+	imageInfo.CopyrightLink = meta.CopyrightLink
+	imageInfo.Tags = meta.Tags
+	imageInfo.RawFilename = rawFilename
+	imageInfo.SHA256 = checksum
+	imageInfo.markUploaded(SourceUpload, meta.UploadedBy)
 
-	jsonPath := s.getFilePath(newUUID + ".json")
+	if err := s.makeThumbnail(finalPath, imageInfo); err != nil {
+		logger.FromContext(ctx, s.logger).Warn("Failed to create thumbnail", zap.String("path", finalPath), zap.Error(err))
+	}
+
+	if err := s.offload(ctx, imageInfo, finalPath); err != nil {
+		logger.FromContext(ctx, s.logger).Warn("Failed to store original, keeping it in the data directory",
+			zap.String("path", finalPath), zap.Error(err))
+	}
+
+	jsonPath := filepath.Join(s.sidecarDir(""), newUUID+".json")
 	if err := s.saveMetadata(jsonPath, imageInfo); err != nil {
+		// Without its record the upload would reappear as a new file
+		os.Remove(jsonPath)
+		os.Remove(finalPath)
+		if imageInfo.StorageKey != "" {
+			s.options.Storage.Delete(ctx, imageInfo.StorageKey)
+		}
 		return "", fmt.Errorf("failed to save metadata: %w", err)
 	}
+	if imageInfo.StorageKey != "" {
+		os.Remove(finalPath)
+	}
 
-	s.logger.Info("Processed uploaded file",
+	logger.FromContext(ctx, s.logger).Info("Processed uploaded file",
 		zap.String("uuid", newUUID),
 		zap.String("original_filename", originalFilename),
 		zap.String("final_path", finalPath))
 
 	return newUUID, nil
 }
+
+// DeleteImage removes an image with everything stored for it: the file, its
+// sidecar, thumbnail, RAW master or slide data, the index record and the
+// annotations. Images placed in a mosaic can't be deleted. The image list is
+// updated by the next Scan.
+func (s *Scanner) DeleteImage(ctx context.Context, id string) error {
+	// A concurrent scan could pick the half-deleted files up again
+	s.scanMu.Lock()
+	defer s.scanMu.Unlock()
+
+	imageInfo := s.GetImageByID(id)
+	if imageInfo == nil {
+		return fmt.Errorf("%w: %s", ErrImageNotFound, id)
+	}
+	for _, image := range s.GetImages() {
+		for _, source := range image.Mosaic {
+			if source.ImageID == id {
+				return fmt.Errorf("%w %s", ErrImageInUse, image.ID)
+			}
+		}
+	}
+
+	// Once the file is gone the image is deleted; the rest is cleanup that a
+	// later scan also takes care of (orphaned sidecars, stale index records)
+	path := s.imagePath(imageInfo)
+	if imageInfo.StorageKey != "" {
+		if s.options.Storage == nil {
+			return fmt.Errorf("image %s is stored in a backend, but none is configured", id)
+		}
+		if err := s.options.Storage.Delete(ctx, imageInfo.StorageKey); err != nil {
+			return err
+		}
+	}
+	// Remote files belong to their server and are only forgotten
+	s.unstage(imageInfo)
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to delete image file: %w", err)
+	}
+
+	log := logger.FromContext(ctx, s.logger)
+	cleanup := []string{s.metadataPath(imageInfo), s.thumbnailPath(id)}
+	if imageInfo.RawFilename != "" {
+		cleanup = append(cleanup, filepath.Join(s.statePath(rawDir), imageInfo.RawFilename))
+	}
+	// MIRAX slides keep their tiles in a sibling directory named after the file
+	if strings.ToLower(filepath.Ext(path)) == ".mrxs" {
+		cleanup = append(cleanup, strings.TrimSuffix(path, filepath.Ext(path)))
+	}
+	for _, leftover := range cleanup {
+		if err := os.RemoveAll(leftover); err != nil {
+			log.Warn("Failed to remove image data", zap.String("path", leftover), zap.Error(err))
+		}
+	}
+	if s.index != nil {
+		if err := s.index.delete(id); err != nil {
+			log.Warn("Failed to remove index record", zap.String("uuid", id), zap.Error(err))
+		}
+		if err := s.index.deleteAnnotations(id); err != nil {
+			log.Warn("Failed to remove annotations", zap.String("uuid", id), zap.Error(err))
+		}
+	}
+
+	log.Info("Deleted image", zap.String("uuid", id), zap.String("path", path))
+	return nil
+}
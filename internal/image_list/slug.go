@@ -0,0 +1,77 @@
+package image_list
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+const maxSlugLength = 100
+
+var (
+	// ErrInvalidSlug is returned for slugs that aren't lowercase words
+	// joined by hyphens
+	ErrInvalidSlug = errors.New("invalid slug")
+	// ErrSlugTaken is returned for slugs another image already has
+	ErrSlugTaken = errors.New("slug already in use")
+)
+
+var slugPattern = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+// NormalizeSlug trims and lowercases a slug and checks its format. Slugs
+// can't have the form of a UUID, so they never shadow an image ID.
+func NormalizeSlug(slug string) (string, error) {
+	slug = strings.ToLower(strings.TrimSpace(slug))
+	switch {
+	case len(slug) > maxSlugLength:
+		return "", fmt.Errorf("%w: slugs are limited to %d characters", ErrInvalidSlug, maxSlugLength)
+	case !slugPattern.MatchString(slug):
+		return "", fmt.Errorf("%w: use lowercase letters, digits and single hyphens", ErrInvalidSlug)
+	}
+	if _, err := uuid.Parse(slug); err == nil {
+		return "", fmt.Errorf("%w: slugs can't be UUIDs", ErrInvalidSlug)
+	}
+	return slug, nil
+}
+
+// ResolveID returns the ID of the image a reference names, which is either
+// its ID or its slug. Unknown references are returned unchanged, so looking
+// them up fails like for any unknown ID.
+func (s *Scanner) ResolveID(ref string) string {
+	return s.registry.Load().resolve(ref)
+}
+
+// SetSlug gives an image a slug, or with "" removes it, and returns the
+// normalized slug. It rescans before returning, so the slug resolves right
+// away and two images can't claim the same one.
+func (s *Scanner) SetSlug(id, slug string) (string, error) {
+	if slug != "" {
+		var err error
+		if slug, err = NormalizeSlug(slug); err != nil {
+			return "", err
+		}
+	}
+
+	s.scanMu.Lock()
+	defer s.scanMu.Unlock()
+
+	if owner := s.ResolveID(slug); slug != "" && owner != slug && owner != id {
+		return "", fmt.Errorf("%w: %s", ErrSlugTaken, slug)
+	}
+	err := s.updateMetadata(id, func(meta *ImageInfo) error {
+		meta.Slug = slug
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	if err := s.scan(context.Background()); err != nil {
+		s.logger.Warn("Failed to rescan after slug change", zap.Error(err))
+	}
+	return slug, nil
+}
@@ -0,0 +1,238 @@
+package image_list
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// stagingDir holds local copies of originals kept in the storage backend or
+// on remote servers, which libvips renders from. It is emptied on startup.
+// Structure: {dataDir}/staging/{uuid}-{fingerprint}.{ext}
+const stagingDir = "staging"
+
+// defaultStagingBytes keeps a few large slides staged at once
+const defaultStagingBytes = 10 << 30
+
+// stagingArea downloads originals on first use and evicts the least recently
+// used ones once they take more than maxBytes. Files are deleted while
+// libvips may still have them open, which is safe on POSIX systems; the next
+// render stages them again.
+type stagingArea struct {
+	dir      string
+	maxBytes int64
+
+	mu       sync.Mutex
+	files    map[string]*stagedFile
+	inflight map[string]*stageCall
+	size     int64
+}
+
+type stagedFile struct {
+	size     int64
+	lastUsed time.Time
+}
+
+// stageCall is a download other requests for the same file wait on
+type stageCall struct {
+	done chan struct{}
+	err  error
+}
+
+func newStagingArea(dir string, maxBytes int64) (*stagingArea, error) {
+	if err := os.RemoveAll(dir); err != nil {
+		return nil, fmt.Errorf("failed to clear staging directory: %w", err)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create staging directory: %w", err)
+	}
+	return &stagingArea{
+		dir:      dir,
+		maxBytes: maxBytes,
+		files:    make(map[string]*stagedFile),
+		inflight: make(map[string]*stageCall),
+	}, nil
+}
+
+// get returns the local path of a staged file, calling fetch to download it
+// unless it is staged already or being downloaded by another request
+func (a *stagingArea) get(name string, fetch func(path string) error) (string, error) {
+	path := filepath.Join(a.dir, name)
+
+	a.mu.Lock()
+	if file, ok := a.files[name]; ok {
+		file.lastUsed = time.Now()
+		a.mu.Unlock()
+		return path, nil
+	}
+	if call, ok := a.inflight[name]; ok {
+		a.mu.Unlock()
+		<-call.done
+		return path, call.err
+	}
+	call := &stageCall{done: make(chan struct{})}
+	a.inflight[name] = call
+	a.mu.Unlock()
+
+	call.err = fetch(path)
+	var info os.FileInfo
+	if call.err == nil {
+		info, call.err = os.Stat(path)
+	}
+
+	a.mu.Lock()
+	delete(a.inflight, name)
+	if call.err == nil {
+		a.files[name] = &stagedFile{size: info.Size(), lastUsed: time.Now()}
+		a.size += info.Size()
+		a.evict(name)
+	}
+	a.mu.Unlock()
+	close(call.done)
+
+	return path, call.err
+}
+
+// adopt stages a file downloaded by the caller, moving it into place
+func (a *stagingArea) adopt(name, path string) {
+	info, err := os.Stat(path)
+	if err != nil || os.Rename(path, filepath.Join(a.dir, name)) != nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.remove(name)
+	a.files[name] = &stagedFile{size: info.Size(), lastUsed: time.Now()}
+	a.size += info.Size()
+	a.evict(name)
+}
+
+// evict removes least recently used files until the area fits, keeping the
+// file just staged; the caller holds mu
+func (a *stagingArea) evict(keep string) {
+	if a.size <= a.maxBytes {
+		return
+	}
+	names := make([]string, 0, len(a.files))
+	for name := range a.files {
+		if name != keep {
+			names = append(names, name)
+		}
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return a.files[names[i]].lastUsed.Before(a.files[names[j]].lastUsed)
+	})
+	for _, name := range names {
+		if a.size <= a.maxBytes {
+			break
+		}
+		a.remove(name)
+	}
+}
+
+// remove deletes one staged file; the caller holds mu
+func (a *stagingArea) remove(name string) {
+	file, ok := a.files[name]
+	if !ok {
+		return
+	}
+	os.Remove(filepath.Join(a.dir, name))
+	a.size -= file.size
+	delete(a.files, name)
+}
+
+// stagedName identifies one version of a stored original
+func stagedName(imageInfo *ImageInfo) string {
+	return imageInfo.ID + "-" + imageInfo.Fingerprint + filepath.Ext(imageInfo.CurrentFilename)
+}
+
+// stage returns a local copy of a stored or remote original, downloading it
+// if needed
+func (s *Scanner) stage(imageInfo *ImageInfo) (string, error) {
+	if s.staging == nil {
+		return "", fmt.Errorf("image %s is not in the data directory, but staging is unavailable", imageInfo.ID)
+	}
+	return s.staging.get(stagedName(imageInfo), func(path string) error {
+		start := time.Now()
+		if imageInfo.SourceURL != "" {
+			if s.options.Remote == nil {
+				return fmt.Errorf("image %s is remote, but remote images are disabled", imageInfo.ID)
+			}
+			if _, err := s.options.Remote.Fetch(context.Background(), imageInfo.SourceURL, imageInfo.SourceVersion, path); err != nil {
+				return err
+			}
+		} else if err := s.options.Storage.Fetch(context.Background(), imageInfo.StorageKey, path); err != nil {
+			return err
+		}
+		s.logger.Debug("Staged image",
+			zap.String("uuid", imageInfo.ID),
+			zap.String("key", imageInfo.StorageKey),
+			zap.String("url", imageInfo.SourceURL),
+			zap.Duration("duration", time.Since(start)))
+		return nil
+	})
+}
+
+// isStaged reports whether an image is rendered from a staged copy
+func isStaged(imageInfo *ImageInfo) bool {
+	return imageInfo.StorageKey != "" || imageInfo.SourceURL != ""
+}
+
+// unstage drops the staged copies of an image
+func (s *Scanner) unstage(imageInfo *ImageInfo) {
+	if s.staging == nil {
+		return
+	}
+	s.staging.mu.Lock()
+	defer s.staging.mu.Unlock()
+	s.staging.remove(stagedName(imageInfo))
+}
+
+// offload copies the original of a scanned image into the storage backend
+// and records its key. The caller deletes the local file once the sidecar
+// with the key is written. Without a backend it does nothing.
+func (s *Scanner) offload(ctx context.Context, imageInfo *ImageInfo, path string) error {
+	if s.options.Storage == nil {
+		return nil
+	}
+	key := imageInfo.ID + strings.ToLower(filepath.Ext(path))
+	if err := s.options.Storage.Put(ctx, key, path); err != nil {
+		return fmt.Errorf("failed to store original: %w", err)
+	}
+	imageInfo.StorageKey = key
+	return nil
+}
+
+// scanStored returns the record of an image whose original is in the storage
+// backend or on a remote server. With no local file to look at, the sidecar is all there is.
+func (s *Scanner) scanStored(dir, collection string, imageInfo *ImageInfo) *ImageInfo {
+	jsonPath := filepath.Join(s.sidecarDir(collection), imageInfo.ID+".json")
+	if imageInfo.Collection != collection {
+		imageInfo.Collection = collection
+		if err := s.saveMetadata(jsonPath, imageInfo); err != nil {
+			s.logger.Warn("Failed to save metadata", zap.String("json_path", jsonPath), zap.Error(err))
+		}
+	}
+	if imageInfo.StorageKey != "" && s.options.Storage == nil {
+		s.logger.Warn("Skipping stored image, no storage backend is configured",
+			zap.String("json_path", jsonPath), zap.String("key", imageInfo.StorageKey))
+		return nil
+	}
+	if imageInfo.SourceURL != "" && (s.options.Remote == nil || !s.options.Remote.Allowed(imageInfo.SourceURL)) {
+		s.logger.Warn("Skipping remote image, its URL is no longer allowed",
+			zap.String("json_path", jsonPath), zap.String("url", imageInfo.SourceURL))
+		return nil
+	}
+	if err := s.checkImageSize(imageInfo); err != nil {
+		s.logger.Warn("Skipping image over the size limits", zap.String("json_path", jsonPath), zap.Error(err))
+		return nil
+	}
+	return imageInfo
+}
@@ -0,0 +1,129 @@
+package image_list
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	maxTags      = 64
+	maxTagLength = 64
+)
+
+// ErrInvalidTags is returned for tag lists over the limits
+var ErrInvalidTags = errors.New("invalid tags")
+
+// NormalizeTags trims and lowercases tags and drops empty ones and
+// duplicates, keeping the first occurrence order
+func NormalizeTags(tags []string) ([]string, error) {
+	normalized := make([]string, 0, len(tags))
+	seen := make(map[string]bool, len(tags))
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || seen[tag] {
+			continue
+		}
+		if utf8.RuneCountInString(tag) > maxTagLength {
+			return nil, fmt.Errorf("%w: tags are limited to %d characters", ErrInvalidTags, maxTagLength)
+		}
+		seen[tag] = true
+		normalized = append(normalized, tag)
+	}
+	if len(normalized) > maxTags {
+		return nil, fmt.Errorf("%w: at most %d tags per image", ErrInvalidTags, maxTags)
+	}
+	return normalized, nil
+}
+
+// SetTags replaces the tags of an image and returns them normalized. Tag
+// changes are picked up by the next Scan.
+func (s *Scanner) SetTags(id string, tags []string) ([]string, error) {
+	tags, err := NormalizeTags(tags)
+	if err != nil {
+		return nil, err
+	}
+	err = s.updateMetadata(id, func(meta *ImageInfo) error {
+		meta.Tags = tags
+		return nil
+	})
+	return tags, err
+}
+
+// AddTags adds tags to an image, keeping the ones it has, and returns the
+// resulting tags
+func (s *Scanner) AddTags(id string, tags []string) ([]string, error) {
+	var result []string
+	err := s.updateMetadata(id, func(meta *ImageInfo) error {
+		merged, err := NormalizeTags(append(slices.Clone(meta.Tags), tags...))
+		if err != nil {
+			return err
+		}
+		meta.Tags, result = merged, merged
+		return nil
+	})
+	return result, err
+}
+
+// RemoveTags removes tags from an image and returns the remaining ones.
+// Tags the image doesn't carry are ignored.
+func (s *Scanner) RemoveTags(id string, tags []string) ([]string, error) {
+	removed, err := NormalizeTags(tags)
+	if err != nil {
+		return nil, err
+	}
+	var result []string
+	err = s.updateMetadata(id, func(meta *ImageInfo) error {
+		result = []string{}
+		for _, tag := range meta.Tags {
+			if !slices.Contains(removed, tag) {
+				result = append(result, tag)
+			}
+		}
+		meta.Tags = result
+		return nil
+	})
+	return result, err
+}
+
+// HasTag reports whether an image carries a tag
+func (i *ImageInfo) HasTag(tag string) bool {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	for _, candidate := range i.Tags {
+		if candidate == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// TagCount is a tag with the number of images carrying it
+type TagCount struct {
+	Tag    string `json:"tag"`
+	Images int    `json:"images"`
+}
+
+// Tags lists every tag in use, most used first
+func (s *Scanner) Tags() []TagCount {
+	counts := make(map[string]int)
+	for _, image := range s.GetImages() {
+		for _, tag := range image.Tags {
+			counts[tag]++
+		}
+	}
+
+	tags := make([]TagCount, 0, len(counts))
+	for tag, images := range counts {
+		tags = append(tags, TagCount{Tag: tag, Images: images})
+	}
+	sort.Slice(tags, func(i, j int) bool {
+		if tags[i].Images != tags[j].Images {
+			return tags[i].Images > tags[j].Images
+		}
+		return tags[i].Tag < tags[j].Tag
+	})
+	return tags
+}
@@ -0,0 +1,91 @@
+package image_list
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/cshum/vipsgen/vips"
+)
+
+// defaultThumbnailSize is the side of the square gallery thumbnails
+const defaultThumbnailSize = 256
+
+// thumbnailDir keeps gallery thumbnails out of the scanned top level of the
+// data directory, where {id}.jpg would be picked up as a new image
+const thumbnailDir = "thumbnails"
+
+// ThumbnailPath returns the gallery thumbnail of an image, or "" if it has none
+func (s *Scanner) ThumbnailPath(id string) string {
+	if s.GetImageByID(id) == nil {
+		return ""
+	}
+	path := s.thumbnailPath(id)
+	if _, err := os.Stat(path); err != nil {
+		return ""
+	}
+	return path
+}
+
+func (s *Scanner) thumbnailPath(id string) string {
+	return filepath.Join(s.statePath(thumbnailDir), id+".jpg")
+}
+
+// thumbnailCrops maps THUMBNAIL_CROP values to libvips strategies: attention
+// favours skin tones, saturated colour and edges, entropy the busiest region
+var thumbnailCrops = map[string]vips.Interesting{
+	"attention": vips.InterestingAttention,
+	"entropy":   vips.InterestingEntropy,
+	"centre":    vips.InterestingCentre,
+}
+
+// makeThumbnail writes a square thumbnail cropped around the most interesting
+// part of the image and records its URL in imageInfo. Mosaics get none.
+func (s *Scanner) makeThumbnail(path string, imageInfo *ImageInfo) error {
+	if IsMosaic(path) {
+		return nil
+	}
+
+	size := s.options.ThumbnailSize
+
+	opts := vips.DefaultThumbnailOptions()
+	opts.Height = size
+	opts.Crop = thumbnailCrops[s.options.ThumbnailCrop]
+	image, err := vips.NewThumbnail(path, size, opts)
+	if err != nil {
+		return fmt.Errorf("failed to create thumbnail: %w", err)
+	}
+	defer image.Close()
+
+	if image.HasAlpha() {
+		flatten := vips.DefaultFlattenOptions()
+		flatten.Background = []float64{255, 255, 255}
+		if err := image.Flatten(flatten); err != nil {
+			return fmt.Errorf("failed to create thumbnail: %w", err)
+		}
+	}
+
+	saveOpts := vips.DefaultJpegsaveBufferOptions()
+	saveOpts.Q = 80
+	saveOpts.Keep = vips.KeepOther
+	data, err := image.JpegsaveBuffer(saveOpts)
+	if err != nil {
+		return fmt.Errorf("failed to encode thumbnail: %w", err)
+	}
+
+	target := s.thumbnailPath(imageInfo.ID)
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return fmt.Errorf("failed to create thumbnail directory: %w", err)
+	}
+	// Write then rename so the gallery never serves a half-written file
+	if err := os.WriteFile(target+".tmp", data, 0644); err != nil {
+		return fmt.Errorf("failed to write thumbnail: %w", err)
+	}
+	if err := os.Rename(target+".tmp", target); err != nil {
+		os.Remove(target + ".tmp")
+		return fmt.Errorf("failed to write thumbnail: %w", err)
+	}
+
+	imageInfo.ThumbnailURL = fmt.Sprintf("/api/images/%s/thumbnail", imageInfo.ID)
+	return nil
+}
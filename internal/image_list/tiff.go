@@ -0,0 +1,227 @@
+package image_list
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"os"
+)
+
+// Baseline TIFF tags read without opening the file with libvips
+const (
+	tagImageWidth  = 256
+	tagImageLength = 257
+	tagOrientation = 274
+)
+
+// maxTiffPages bounds the IFD chain walked by readTiffPageSizes, which also
+// stops corrupt files with looping chains
+const maxTiffPages = 10000
+
+var errNotTIFF = errors.New("not a valid tiff")
+
+// tiffReader reads the image file directories (IFDs) of a classic or BigTIFF
+// file
+type tiffReader struct {
+	r     io.ReadSeeker
+	order binary.ByteOrder
+	big   bool
+	// first is the offset of the first IFD
+	first uint64
+}
+
+func newTiffReader(r io.ReadSeeker) (*tiffReader, error) {
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(r, header[:8]); err != nil {
+		return nil, errNotTIFF
+	}
+
+	t := &tiffReader{r: r}
+	switch string(header[:2]) {
+	case "II":
+		t.order = binary.LittleEndian
+	case "MM":
+		t.order = binary.BigEndian
+	default:
+		return nil, errNotTIFF
+	}
+
+	switch t.order.Uint16(header[2:4]) {
+	case 42:
+		t.first = uint64(t.order.Uint32(header[4:8]))
+	case 43:
+		t.big = true
+		if _, err := io.ReadFull(r, header[8:16]); err != nil {
+			return nil, errNotTIFF
+		}
+		t.first = t.order.Uint64(header[8:16])
+	default:
+		return nil, errNotTIFF
+	}
+	return t, nil
+}
+
+// readIFD reads numeric values of the requested tags from the IFD at offset
+// and returns the offset of the next IFD (0 after the last one)
+func (t *tiffReader) readIFD(offset uint64, wanted ...uint16) (map[uint16][]float64, uint64, error) {
+	if _, err := t.r.Seek(int64(offset), io.SeekStart); err != nil {
+		return nil, 0, err
+	}
+
+	countSize, entrySize, nextSize := 2, 12, 4
+	if t.big {
+		countSize, entrySize, nextSize = 8, 20, 8
+	}
+	buf := make([]byte, countSize)
+	if _, err := io.ReadFull(t.r, buf); err != nil {
+		return nil, 0, errNotTIFF
+	}
+	var count uint64
+	if t.big {
+		count = t.order.Uint64(buf)
+	} else {
+		count = uint64(t.order.Uint16(buf))
+	}
+	if count > 4096 {
+		return nil, 0, errNotTIFF
+	}
+
+	entries := make([]byte, int(count)*entrySize+nextSize)
+	if _, err := io.ReadFull(t.r, entries); err != nil {
+		return nil, 0, errNotTIFF
+	}
+	var next uint64
+	if t.big {
+		next = t.order.Uint64(entries[len(entries)-nextSize:])
+	} else {
+		next = uint64(t.order.Uint32(entries[len(entries)-nextSize:]))
+	}
+
+	want := map[uint16]bool{}
+	for _, tag := range wanted {
+		want[tag] = true
+	}
+
+	result := map[uint16][]float64{}
+	for i := 0; i < int(count); i++ {
+		entry := entries[i*entrySize : (i+1)*entrySize]
+		tag := t.order.Uint16(entry[0:2])
+		if !want[tag] {
+			continue
+		}
+
+		typ := t.order.Uint16(entry[2:4])
+		var n uint64
+		var inline []byte
+		if t.big {
+			n = t.order.Uint64(entry[4:12])
+			inline = entry[12:20]
+		} else {
+			n = uint64(t.order.Uint32(entry[4:8]))
+			inline = entry[8:12]
+		}
+
+		size := tiffTypeSize(typ)
+		if size == 0 || n > 1<<16 {
+			continue
+		}
+
+		data := inline
+		if total := n * uint64(size); total > uint64(len(inline)) {
+			var valueOffset uint64
+			if t.big {
+				valueOffset = t.order.Uint64(inline)
+			} else {
+				valueOffset = uint64(t.order.Uint32(inline))
+			}
+			data = make([]byte, total)
+			if _, err := t.r.Seek(int64(valueOffset), io.SeekStart); err != nil {
+				return nil, 0, err
+			}
+			if _, err := io.ReadFull(t.r, data); err != nil {
+				return nil, 0, errNotTIFF
+			}
+		}
+
+		values := make([]float64, n)
+		for j := range values {
+			value := data[j*size : (j+1)*size]
+			switch typ {
+			case 3:
+				values[j] = float64(t.order.Uint16(value))
+			case 4:
+				values[j] = float64(t.order.Uint32(value))
+			case 12:
+				values[j] = math.Float64frombits(t.order.Uint64(value))
+			case 16:
+				values[j] = float64(t.order.Uint64(value))
+			}
+		}
+		result[tag] = values
+	}
+
+	return result, next, nil
+}
+
+// readTiffTags reads numeric values of the requested tags from the first IFD
+func readTiffTags(r io.ReadSeeker, wanted ...uint16) (map[uint16][]float64, error) {
+	t, err := newTiffReader(r)
+	if err != nil {
+		return nil, err
+	}
+	tags, _, err := t.readIFD(t.first, wanted...)
+	return tags, err
+}
+
+// readTiffPageSizes reads the upright size of every page (IFD) from the file
+// header alone, which is much cheaper than opening each page with libvips
+func readTiffPageSizes(path string) ([]PageSize, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	t, err := newTiffReader(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var sizes []PageSize
+	for offset := t.first; offset != 0; {
+		if len(sizes) == maxTiffPages {
+			return nil, errNotTIFF
+		}
+		tags, next, err := t.readIFD(offset, tagImageWidth, tagImageLength, tagOrientation)
+		if err != nil {
+			return nil, err
+		}
+		if len(tags[tagImageWidth]) != 1 || len(tags[tagImageLength]) != 1 {
+			return nil, errNotTIFF
+		}
+
+		size := PageSize{Width: int(tags[tagImageWidth][0]), Height: int(tags[tagImageLength][0])}
+		// Orientations 5-8 rotate by 90°, as in scanImage
+		if orientation := tags[tagOrientation]; len(orientation) == 1 && orientation[0] >= 5 && orientation[0] <= 8 {
+			size.Width, size.Height = size.Height, size.Width
+		}
+		sizes = append(sizes, size)
+		offset = next
+	}
+	return sizes, nil
+}
+
+// tiffTypeSize returns the byte size of the numeric TIFF field types we read
+// (SHORT, LONG, DOUBLE, LONG8), or 0 for anything else
+func tiffTypeSize(typ uint16) int {
+	switch typ {
+	case 3:
+		return 2
+	case 4:
+		return 4
+	case 12, 16:
+		return 8
+	}
+	return 0
+}
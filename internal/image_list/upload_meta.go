@@ -0,0 +1,58 @@
+package image_list
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	maxTitleLength     = 200
+	maxCopyrightLength = 1000
+)
+
+// ErrInvalidMetadata is returned for upload fields over their limits or
+// copyright links that aren't web addresses
+var ErrInvalidMetadata = errors.New("invalid metadata")
+
+// UploadMetadata are the descriptive fields that can be given with an upload
+type UploadMetadata struct {
+	Title         string
+	CopyrightText string
+	CopyrightLink string
+	Tags          []string
+	// UploadedBy identifies the uploader; it is set by the server and left
+	// alone by Normalize
+	UploadedBy string
+}
+
+// Normalize trims the fields, normalizes the tags and checks the limits.
+// Copyright links are shown as links in the viewer, so only http(s) URLs
+// are accepted.
+func (m *UploadMetadata) Normalize() error {
+	m.Title = strings.TrimSpace(m.Title)
+	m.CopyrightText = strings.TrimSpace(m.CopyrightText)
+	m.CopyrightLink = strings.TrimSpace(m.CopyrightLink)
+
+	if utf8.RuneCountInString(m.Title) > maxTitleLength {
+		return fmt.Errorf("%w: titles are limited to %d characters", ErrInvalidMetadata, maxTitleLength)
+	}
+	if utf8.RuneCountInString(m.CopyrightText) > maxCopyrightLength {
+		return fmt.Errorf("%w: copyright text is limited to %d characters", ErrInvalidMetadata, maxCopyrightLength)
+	}
+	if m.CopyrightLink != "" {
+		link, err := url.Parse(m.CopyrightLink)
+		if err != nil || (link.Scheme != "http" && link.Scheme != "https") || link.Host == "" {
+			return fmt.Errorf("%w: copyright link must be an http or https URL", ErrInvalidMetadata)
+		}
+	}
+
+	tags, err := NormalizeTags(m.Tags)
+	if err != nil {
+		return err
+	}
+	m.Tags = tags
+	return nil
+}
@@ -0,0 +1,141 @@
+package image_list
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrInvalidContent is returned for uploads whose content isn't an image of
+// the type their extension claims
+var ErrInvalidContent = errors.New("file content doesn't match its type")
+
+// sniffLength is how much of a file is read to recognize it; SVGs may start
+// with a long prolog before the <svg> element
+const sniffLength = 8 << 10
+
+// ValidateUpload checks that an uploaded file is what its extension says
+// before it is moved into the data directory: its leading bytes must carry
+// the signature of that format, libvips must be able to open it and it must
+// be within the size limits (ErrImageTooLarge). RAW
+// files are only checked by signature, decoding them is what the conversion
+// does anyway.
+func (s *Scanner) ValidateUpload(path string) error {
+	ext := strings.ToLower(filepath.Ext(path))
+
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open upload: %w", err)
+	}
+	head := make([]byte, sniffLength)
+	n, err := io.ReadFull(file, head)
+	file.Close()
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("failed to read upload: %w", err)
+	}
+	if !matchesSignature(ext, head[:n]) {
+		return fmt.Errorf("%w: not a %s file", ErrInvalidContent, strings.TrimPrefix(ext, "."))
+	}
+
+	if isRaw(path) {
+		return nil
+	}
+	image, err := s.loadImage(path)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidContent, err)
+	}
+	defer image.Close()
+	if image.Width() <= 0 || image.Height() <= 0 {
+		return fmt.Errorf("%w: image has no pixels", ErrInvalidContent)
+	}
+	return s.checkSize(image.Width(), image.Height())
+}
+
+// matchesSignature reports whether the leading bytes of a file fit its
+// extension. Extensions without a known signature, such as mosaic
+// definitions, never match.
+func matchesSignature(ext string, head []byte) bool {
+	switch ext {
+	case ".tif", ".tiff", ".svs", ".ndpi", ".scn", ".bif", ".dng", ".cr2", ".nef", ".arw":
+		return isTiffHeader(head)
+	case ".orf":
+		// Olympus uses its own byte order marks on a TIFF structure
+		return hasAnyPrefix(head, "IIRO", "IIRS", "MMOR") || isTiffHeader(head)
+	case ".rw2":
+		return hasAnyPrefix(head, "IIU\x00") || isTiffHeader(head)
+	case ".raf":
+		return hasAnyPrefix(head, "FUJIFILMCCD-RAW")
+	case ".cr3":
+		return hasISOBrand(head, "crx ")
+	case ".heic", ".heif":
+		return hasISOBrand(head, "heic", "heix", "hevc", "hevx", "heim", "heis", "mif1", "msf1")
+	case ".jpg", ".jpeg":
+		return hasAnyPrefix(head, "\xff\xd8\xff")
+	case ".png":
+		return hasAnyPrefix(head, "\x89PNG\r\n\x1a\n")
+	case ".webp":
+		return len(head) >= 12 && string(head[:4]) == "RIFF" && string(head[8:12]) == "WEBP"
+	case ".jxl":
+		// Bare codestream or ISO BMFF container
+		return hasAnyPrefix(head, "\xff\x0a", "\x00\x00\x00\x0cJXL \r\n\x87\n")
+	case ".pdf":
+		// Readers accept a few bytes of junk before the header
+		return bytes.Contains(head[:min(len(head), 1024)], []byte("%PDF-"))
+	case ".svg":
+		return isSVGText(head)
+	case ".vms":
+		return hasAnyPrefix(bytes.TrimPrefix(head, []byte("\xef\xbb\xbf")), "[Virtual Microscope Specimen]")
+	}
+	return false
+}
+
+// isTiffHeader matches classic and BigTIFF headers in either byte order
+func isTiffHeader(head []byte) bool {
+	return hasAnyPrefix(head, "II*\x00", "MM\x00*", "II+\x00", "MM\x00+")
+}
+
+func hasAnyPrefix(head []byte, prefixes ...string) bool {
+	for _, prefix := range prefixes {
+		if bytes.HasPrefix(head, []byte(prefix)) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasISOBrand reports whether head starts with an ISO BMFF ftyp box whose
+// major or one of whose compatible brands is in brands
+func hasISOBrand(head []byte, brands ...string) bool {
+	if len(head) < 12 || string(head[4:8]) != "ftyp" {
+		return false
+	}
+	size := int(binary.BigEndian.Uint32(head[:4]))
+	if size < 12 || size > len(head) {
+		size = min(len(head), 12)
+	}
+	// The major brand at 8, a minor version at 12, compatible brands after
+	for offset := 8; offset+4 <= size; offset += 4 {
+		if offset == 12 {
+			continue
+		}
+		for _, brand := range brands {
+			if string(head[offset:offset+4]) == brand {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isSVGText reports whether head is text that opens an <svg> element
+func isSVGText(head []byte) bool {
+	if bytes.IndexByte(head, 0) >= 0 {
+		return false
+	}
+	return bytes.Contains(bytes.ToLower(head), []byte("<svg"))
+}
@@ -0,0 +1,215 @@
+package image_list
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Problems found by Verify
+const (
+	ProblemMissing    = "missing"    // the file of an indexed image is gone
+	ProblemUnreadable = "unreadable" // libvips can't open the file
+	ProblemTooLarge   = "too_large"  // the file exceeds the size limits
+	ProblemSidecar    = "sidecar"    // the sidecar is missing or can't be read
+	ProblemID         = "id"         // file, sidecar and record disagree on the ID
+	ProblemDimensions = "dimensions" // the sidecar disagrees with the file on its size
+	ProblemStale      = "stale"      // the file changed since the last scan
+)
+
+// Actions Verify takes on problems
+const (
+	ActionRepaired    = "repaired"
+	ActionQuarantined = "quarantined"
+)
+
+// VerifyOptions selects what Verify fixes besides reporting
+type VerifyOptions struct {
+	// Repair rewrites sidecars whose dimensions don't match their file
+	Repair bool
+	// Quarantine moves files that can't be opened or exceed the size
+	// limits out of the library, like scans do with new files over the
+	// limits. Preserve mode leaves files alone.
+	Quarantine bool
+}
+
+// Problem is one inconsistency between an image record, its sidecar and
+// its file
+type Problem struct {
+	ImageID string
+	Path    string
+	Kind    string
+	Detail  string
+	// Action is what Verify did about it, "" if nothing
+	Action string
+}
+
+// VerifyReport is the result of Verify
+type VerifyReport struct {
+	Checked int
+	// Skipped counts originals in the storage backend or on remote
+	// servers, which would have to be downloaded to be checked
+	Skipped  int
+	Problems []Problem
+}
+
+// Unresolved counts the problems no action was taken on
+func (r *VerifyReport) Unresolved() int {
+	n := 0
+	for _, problem := range r.Problems {
+		if problem.Action == "" {
+			n++
+		}
+	}
+	return n
+}
+
+// Verify checks every indexed image: that its file exists and opens with
+// libvips, that its sidecar is named after its ID and records the
+// dimensions of the file. Images are decoded the way a scan does, so this
+// takes about as long as scanning the library from scratch. If anything was
+// repaired or quarantined, the library is scanned again at the end.
+func (s *Scanner) Verify(ctx context.Context, options VerifyOptions) (*VerifyReport, error) {
+	images := s.GetImages()
+	report := &VerifyReport{}
+
+	workers := s.options.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, workers)
+	for i := range images {
+		if ctx.Err() != nil {
+			break
+		}
+		imageInfo := images[i]
+		if isStaged(&imageInfo) {
+			report.Skipped++
+			continue
+		}
+		report.Checked++
+
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			problems := s.verifyImage(&imageInfo, options)
+			mu.Lock()
+			report.Problems = append(report.Problems, problems...)
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return report, err
+	}
+
+	slices.SortFunc(report.Problems, func(a, b Problem) int {
+		return strings.Compare(a.Path, b.Path)
+	})
+	if len(report.Problems) > report.Unresolved() {
+		if err := s.Scan(); err != nil {
+			return report, fmt.Errorf("failed to rescan after repairs: %w", err)
+		}
+	}
+	return report, nil
+}
+
+// verifyImage checks one image, see Verify
+func (s *Scanner) verifyImage(imageInfo *ImageInfo, options VerifyOptions) []Problem {
+	var problems []Problem
+	path := s.imagePath(imageInfo)
+	report := func(kind, format string, args ...any) *Problem {
+		problems = append(problems, Problem{
+			ImageID: imageInfo.ID,
+			Path:    path,
+			Kind:    kind,
+			Detail:  fmt.Sprintf(format, args...),
+		})
+		return &problems[len(problems)-1]
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		report(ProblemMissing, "%v", err)
+		return problems
+	}
+
+	// Preserve mode derives IDs from paths, otherwise files are renamed to
+	// their ID
+	ext := filepath.Ext(imageInfo.CurrentFilename)
+	if s.options.PreserveNames {
+		if id := pathID(imageInfo.Collection, imageInfo.CurrentFilename); id != imageInfo.ID {
+			report(ProblemID, "the path gives ID %s", id)
+		}
+	} else if name := strings.TrimSuffix(imageInfo.CurrentFilename, ext); name != imageInfo.ID {
+		report(ProblemID, "the file is named %s", imageInfo.CurrentFilename)
+	}
+
+	jsonPath := s.metadataPath(imageInfo)
+	meta, err := s.loadMetadata(jsonPath)
+	if err != nil {
+		report(ProblemSidecar, "%s: %v", jsonPath, err)
+	} else if meta.ID != imageInfo.ID {
+		report(ProblemID, "the sidecar has ID %s", meta.ID)
+	}
+
+	// A changed file is scanned again by the next scan; comparing it with
+	// the old record would only report the change
+	if imageInfo.Fingerprint != "" && imageInfo.Fingerprint != fileFingerprint(info) {
+		report(ProblemStale, "changed since the last scan")
+		return problems
+	}
+
+	scanned, err := s.scanImage(path, info)
+	if err != nil {
+		kind := ProblemUnreadable
+		if errors.Is(err, ErrImageTooLarge) {
+			kind = ProblemTooLarge
+		}
+		problem := report(kind, "%v", err)
+		// Like scans, preserve mode leaves the files alone
+		if options.Quarantine && !s.options.PreserveNames {
+			name := imageInfo.OriginalFilename
+			if name == "" {
+				name = imageInfo.CurrentFilename
+			}
+			if s.quarantine(path, imageInfo.RawFilename, name, err) {
+				problem.Action = ActionQuarantined
+			}
+		}
+		return problems
+	}
+
+	if meta == nil {
+		return problems
+	}
+	if scanned.Width != meta.Width || scanned.Height != meta.Height || !slices.Equal(scanned.Pages, meta.Pages) {
+		problem := report(ProblemDimensions, "the sidecar has %d×%d and %d pages, the file %d×%d and %d pages",
+			meta.Width, meta.Height, len(meta.Pages), scanned.Width, scanned.Height, len(scanned.Pages))
+		if options.Repair {
+			err := s.updateMetadata(imageInfo.ID, func(meta *ImageInfo) error {
+				meta.Width = scanned.Width
+				meta.Height = scanned.Height
+				meta.Pages = scanned.Pages
+				meta.Scale = scanned.Scale
+				return nil
+			})
+			if err == nil {
+				problem.Action = ActionRepaired
+			} else {
+				problem.Detail += fmt.Sprintf(" (repair failed: %v)", err)
+			}
+		}
+	}
+	return problems
+}
@@ -0,0 +1,91 @@
+package image_list
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"go.uber.org/zap"
+)
+
+// Watch rescans the data directory when files in it or in its collections are
+// added, removed or modified, once no further change arrived for debounce, so
+// a file being copied in triggers one scan. Scans are incremental: only new
+// or replaced files are opened, everything else comes from the metadata
+// sidecars. Images a scan finds removed or replaced are passed to the
+// OnInvalidate functions. Watch blocks until ctx is done.
+func (s *Scanner) Watch(ctx context.Context, debounce time.Duration) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to create watcher: %w", err)
+	}
+	defer watcher.Close()
+
+	watched := make(map[string]bool)
+	s.syncWatches(watcher, watched)
+
+	rescan := time.NewTimer(debounce)
+	rescan.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			// Permission and timestamp changes don't affect the image list
+			if event.Op == fsnotify.Chmod {
+				continue
+			}
+			rescan.Reset(debounce)
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			// Usually an event queue overflow, so changes may have been missed
+			s.logger.Warn("Data directory watcher error", zap.Error(err))
+			rescan.Reset(debounce)
+		case <-rescan.C:
+			s.logger.Debug("Data directory changed, rescanning")
+			if err := s.ScanContext(ctx); err != nil && ctx.Err() == nil {
+				s.logger.Warn("Rescan after change failed", zap.Error(err))
+			}
+			s.syncWatches(watcher, watched)
+		}
+	}
+}
+
+// syncWatches watches the data directory and every scanned collection, and
+// stops watching collections that are gone. fsnotify isn't recursive, so new
+// subdirectories are only watched once a scan has found them.
+func (s *Scanner) syncWatches(watcher *fsnotify.Watcher, watched map[string]bool) {
+	dirs := []string{s.dataDir}
+	for _, collection := range s.registry.Load().collections {
+		dirs = append(dirs, s.getFilePath(filepath.FromSlash(collection)))
+	}
+
+	wanted := make(map[string]bool, len(dirs))
+	for _, dir := range dirs {
+		wanted[dir] = true
+		if watched[dir] {
+			continue
+		}
+		if err := watcher.Add(dir); err != nil {
+			s.logger.Warn("Failed to watch directory", zap.String("dir", dir), zap.Error(err))
+			continue
+		}
+		watched[dir] = true
+	}
+
+	for dir := range watched {
+		if !wanted[dir] {
+			// Removing a deleted directory fails, its watch is already gone
+			watcher.Remove(dir)
+			delete(watched, dir)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package image_renderer
+
+import (
+	"fmt"
+
+	"github.com/cshum/vipsgen/vips"
+
+	"gigaview/internal/render"
+)
+
+// applyAdjustments runs on a normalized 8-bit sRGB tile before padding
+func applyAdjustments(image *vips.Image, a render.Adjustments) error {
+	if a.IsNeutral() {
+		return nil
+	}
+
+	if a.Grayscale {
+		if err := image.Colourspace(vips.InterpretationBW, nil); err != nil {
+			return fmt.Errorf("failed to convert to greyscale: %w", err)
+		}
+	} else if a.Saturation != 1 {
+		if err := image.Colourspace(vips.InterpretationLch, nil); err != nil {
+			return fmt.Errorf("failed to convert to LCh: %w", err)
+		}
+		if err := image.Linear(withAlpha(image, []float64{1, a.Saturation, 1}, 1), withAlpha(image, []float64{0, 0, 0}, 0), nil); err != nil {
+			return fmt.Errorf("failed to adjust saturation: %w", err)
+		}
+	}
+
+	if err := image.Colourspace(vips.InterpretationSrgb, nil); err != nil {
+		return fmt.Errorf("failed to convert to sRGB: %w", err)
+	}
+
+	if a.Gamma != 1 {
+		if err := image.Gamma(&vips.GammaOptions{Exponent: a.Gamma}); err != nil {
+			return fmt.Errorf("failed to adjust gamma: %w", err)
+		}
+	}
+
+	if a.Contrast != 1 || a.Brightness != 0 {
+		// Scale around mid-grey, then shift
+		offset := (1-a.Contrast)*128 + a.Brightness*255
+		colorBands := image.Bands()
+		if image.HasAlpha() {
+			colorBands--
+		}
+		if err := image.Linear(withAlpha(image, repeat(a.Contrast, colorBands), 1), withAlpha(image, repeat(offset, colorBands), 0), nil); err != nil {
+			return fmt.Errorf("failed to adjust contrast: %w", err)
+		}
+	}
+
+	if image.BandFormat() != vips.BandFormatUchar {
+		if err := image.Cast(vips.BandFormatUchar, nil); err != nil {
+			return fmt.Errorf("failed to cast adjusted tile: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// withAlpha appends alphaValue for the alpha band, if the image has one, so
+// Linear leaves alpha unchanged (factor 1, offset 0)
+func withAlpha(image *vips.Image, values []float64, alphaValue float64) []float64 {
+	if !image.HasAlpha() {
+		return values
+	}
+	return append(append([]float64{}, values...), alphaValue)
+}
+
+func repeat(value float64, n int) []float64 {
+	values := make([]float64, n)
+	for i := range values {
+		values[i] = value
+	}
+	return values
+}
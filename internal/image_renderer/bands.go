@@ -0,0 +1,130 @@
+package image_renderer
+
+import (
+	"fmt"
+
+	"github.com/cshum/vipsgen/vips"
+
+	"gigaview/internal/render"
+)
+
+// selectBands returns a new image made of the requested 1-based bands, in
+// order. The result is tagged as plain greyscale or RGB so normalizeTile
+// doesn't apply the colorimetry of the source interpretation.
+func selectBands(image *vips.Image, bands []int) (*vips.Image, error) {
+	for _, band := range bands {
+		if band > image.Bands() {
+			return nil, fmt.Errorf("%w: band %d of %d", render.ErrInvalidOptions, band, image.Bands())
+		}
+	}
+
+	parts := make([]*vips.Image, 0, len(bands))
+	defer func() {
+		for _, part := range parts {
+			part.Close()
+		}
+	}()
+	for _, band := range bands {
+		part, err := image.Copy(nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to select bands: %w", err)
+		}
+		parts = append(parts, part)
+		if err := part.ExtractBand(band-1, nil); err != nil {
+			return nil, fmt.Errorf("failed to select bands: %w", err)
+		}
+	}
+
+	joined := parts[0]
+	if len(parts) > 1 {
+		var err error
+		if joined, err = vips.NewBandjoin(parts); err != nil {
+			return nil, fmt.Errorf("failed to select bands: %w", err)
+		}
+		defer joined.Close()
+	}
+
+	interpretation := vips.InterpretationBW
+	if image.BandFormat() == vips.BandFormatUshort {
+		interpretation = vips.InterpretationGrey16
+	}
+	if len(bands) == 3 {
+		interpretation = vips.InterpretationSrgb
+		if image.BandFormat() == vips.BandFormatUshort {
+			interpretation = vips.InterpretationRgb16
+		}
+	}
+
+	selected, err := joined.Copy(&vips.CopyOptions{Interpretation: interpretation})
+	if err != nil {
+		return nil, fmt.Errorf("failed to select bands: %w", err)
+	}
+	return selected, nil
+}
+
+// applyColormap returns a new image with the first band of a normalized 8-bit
+// tile mapped through a colormap, keeping alpha
+func applyColormap(image *vips.Image, colormap string) (*vips.Image, error) {
+	lut, err := colormapLUT(colormap)
+	if err != nil {
+		return nil, err
+	}
+	defer lut.Close()
+
+	mapped, err := image.Copy(nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to apply colormap: %w", err)
+	}
+	if err := mapped.ExtractBand(0, nil); err != nil {
+		mapped.Close()
+		return nil, fmt.Errorf("failed to apply colormap: %w", err)
+	}
+	if err := mapped.Maplut(lut, nil); err != nil {
+		mapped.Close()
+		return nil, fmt.Errorf("failed to apply colormap: %w", err)
+	}
+	if !image.HasAlpha() {
+		return mapped, nil
+	}
+	defer mapped.Close()
+
+	alpha, err := image.Copy(nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to apply colormap: %w", err)
+	}
+	defer alpha.Close()
+	if err := alpha.ExtractBand(image.Bands()-1, nil); err != nil {
+		return nil, fmt.Errorf("failed to apply colormap: %w", err)
+	}
+	joined, err := vips.NewBandjoin([]*vips.Image{mapped, alpha})
+	if err != nil {
+		return nil, fmt.Errorf("failed to apply colormap: %w", err)
+	}
+	return joined, nil
+}
+
+// colormapLUT builds the 256×1 RGB lookup table of a colormap
+func colormapLUT(colormap string) (*vips.Image, error) {
+	stops := render.ColormapStops(colormap)
+	if stops == nil {
+		return nil, fmt.Errorf("%w: unknown colormap %q", render.ErrInvalidOptions, colormap)
+	}
+
+	buf := make([]byte, 256*3)
+	segments := float64(len(stops) - 1)
+	for i := 0; i < 256; i++ {
+		position := float64(i) / 255 * segments
+		stop := min(int(position), len(stops)-2)
+		t := position - float64(stop)
+		for c := 0; c < 3; c++ {
+			value := stops[stop][c]*(1-t) + stops[stop+1][c]*t
+			buf[i*3+c] = byte(value + 0.5)
+		}
+	}
+
+	lut, err := vips.NewImageFromMemory(buf, 256, 1, 3)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build colormap: %w", err)
+	}
+	return lut, nil
+}
@@ -0,0 +1,263 @@
+package image_renderer
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/cshum/vipsgen/vips"
+	"go.uber.org/zap"
+
+	"gigaview/internal/cache"
+	"gigaview/internal/image_list"
+	"gigaview/internal/logger"
+	"gigaview/internal/render"
+)
+
+// comparePair looks up two images and checks they can be compared on a page
+func (r *Renderer) comparePair(imageA, imageB string, page int) (infoA, infoB *image_list.ImageInfo, width, height int, err error) {
+	infoA = r.scanner.GetImageByID(imageA)
+	if infoA == nil {
+		return nil, nil, 0, 0, fmt.Errorf("%w: %s", render.ErrImageNotFound, imageA)
+	}
+	infoB = r.scanner.GetImageByID(imageB)
+	if infoB == nil {
+		return nil, nil, 0, 0, fmt.Errorf("%w: %s", render.ErrImageNotFound, imageB)
+	}
+
+	width, height, ok := infoA.PageSize(page)
+	if !ok {
+		return nil, nil, 0, 0, fmt.Errorf("%w: page %d of %d", render.ErrTileOutOfBounds, page, infoA.PageCount())
+	}
+	widthB, heightB, ok := infoB.PageSize(page)
+	if !ok {
+		return nil, nil, 0, 0, fmt.Errorf("%w: page %d of %d", render.ErrTileOutOfBounds, page, infoB.PageCount())
+	}
+	if width != widthB || height != heightB {
+		return nil, nil, 0, 0, fmt.Errorf("%w: images differ in size (%dx%d and %dx%d)", render.ErrInvalidOptions, width, height, widthB, heightB)
+	}
+	return infoA, infoB, width, height, nil
+}
+
+// CompareMeta describes the tile pyramid shared by two images of the same size
+func (r *Renderer) CompareMeta(imageA, imageB string, page int) (map[string]interface{}, error) {
+	infoA, infoB, width, height, err := r.comparePair(imageA, imageB, page)
+	if err != nil {
+		return nil, err
+	}
+
+	maxZoom := r.CalculateMaxZoom(width, height)
+	return map[string]interface{}{
+		"width":       width,
+		"height":      height,
+		"page":        page,
+		"tileSize":    256,
+		"maxZoom":     maxZoom,
+		"maxOverzoom": maxZoom + r.options.OverzoomLevels,
+		"modes":       []string{render.CompareDiff, render.CompareBlend},
+		"images": []map[string]interface{}{
+			{"id": infoA.ID, "filename": infoA.OriginalFilename, "placeholder": infoA.Placeholder},
+			{"id": infoB.ID, "filename": infoB.OriginalFilename, "placeholder": infoB.Placeholder},
+		},
+	}, nil
+}
+
+// RenderCompareTile renders tile z/x/y of the difference or blend of two
+// images of the same dimensions, e.g. before/after restoration scans. Both
+// images are normalized to 8-bit sRGB first; adjustments in opts apply to the
+// result, so a faint difference can be amplified with contrast.
+func (r *Renderer) RenderCompareTile(ctx context.Context, imageA, imageB string, z, x, y int, opts render.TileOptions, compare render.CompareOptions) (*render.TileResult, error) {
+	if err := compare.Validate(); err != nil {
+		return nil, fmt.Errorf("%w: %s", render.ErrInvalidOptions, err.Error())
+	}
+	if len(opts.Bands) > 0 || opts.Colormap != "" {
+		return nil, fmt.Errorf("%w: bands and colormaps are not supported when comparing", render.ErrInvalidOptions)
+	}
+
+	infoA, infoB, width, height, err := r.comparePair(imageA, imageB, opts.Page)
+	if err != nil {
+		return nil, err
+	}
+
+	format := opts.Format
+	if format == "" {
+		format = render.DefaultTileOptions.Format
+	}
+
+	maxZoom := r.CalculateMaxZoom(width, height)
+	region, err := r.pageTileRegion(width, height, maxZoom, z, x, y)
+	if err != nil {
+		return nil, err
+	}
+
+	variant := compare.Key()
+	if key := opts.Variant(nil); key != "" {
+		variant += "-" + key
+	}
+	cacheKey := cache.TileKey{
+		ImageID:  cache.ComparePairID(imageA, imageB),
+		TileSize: 256,
+		MaxZoom:  maxZoom,
+		Z:        z,
+		X:        x,
+		Y:        y,
+		Format:   format,
+		Variant:  variant,
+
+		Fingerprint: compareFingerprint(infoA.Fingerprint, infoB.Fingerprint),
+	}
+
+	lookupStart := time.Now()
+	cached, ok := r.tileCache.Get(ctx, cacheKey)
+	lookup := time.Since(lookupStart)
+	if ok {
+		return withCacheLookup(&render.TileResult{
+			Data: cached,
+			ETag: r.generateETag(cacheKey),
+			Size: len(cached),
+		}, lookup), nil
+	}
+
+	result, err := r.inflight.do(ctx, cacheKey, func(ctx context.Context) (*render.TileResult, error) {
+		if r.options.RenderTimeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, r.options.RenderTimeout)
+			defer cancel()
+		}
+		return r.renderCompareUncached(ctx, infoA, infoB, cacheKey, region, opts, compare)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return withCacheLookup(result, lookup), nil
+}
+
+// compareFingerprint combines the fingerprints of both images, empty when
+// either is unknown
+func compareFingerprint(a, b string) string {
+	if a == "" || b == "" {
+		return ""
+	}
+	return a + b
+}
+
+func (r *Renderer) renderCompareUncached(ctx context.Context, infoA, infoB *image_list.ImageInfo, cacheKey cache.TileKey, region tileRegion, opts render.TileOptions, compare render.CompareOptions) (*render.TileResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	timer := newStageTimer()
+
+	// Transparent areas are flattened onto the same color in both images, so
+	// they don't show up as differences
+	background := r.paddingColor(infoA)
+
+	imageA, err := r.openComparePiece(infoA, opts.Page, region, background)
+	if err != nil {
+		return nil, fmt.Errorf("image %s: %w", infoA.ID, err)
+	}
+	defer imageA.Close()
+	imageB, err := r.openComparePiece(infoB, opts.Page, region, background)
+	if err != nil {
+		return nil, fmt.Errorf("image %s: %w", infoB.ID, err)
+	}
+	defer imageB.Close()
+	timer.mark("load")
+
+	// Different pyramid levels can round the scaled size differently
+	width := min(imageA.Width(), imageB.Width())
+	height := min(imageA.Height(), imageB.Height())
+	for _, image := range []*vips.Image{imageA, imageB} {
+		if image.Width() != width || image.Height() != height {
+			if err := image.ExtractArea(0, 0, width, height); err != nil {
+				return nil, fmt.Errorf("failed to align images: %w", err)
+			}
+		}
+	}
+	timer.mark("resize")
+
+	switch compare.Mode {
+	case render.CompareDiff:
+		if err := imageA.Subtract(imageB); err != nil {
+			return nil, fmt.Errorf("failed to compute difference: %w", err)
+		}
+		if err := imageA.Abs(); err != nil {
+			return nil, fmt.Errorf("failed to compute difference: %w", err)
+		}
+	case render.CompareBlend:
+		if err := imageA.Linear([]float64{1 - compare.Opacity}, []float64{0}, nil); err != nil {
+			return nil, fmt.Errorf("failed to blend: %w", err)
+		}
+		if err := imageB.Linear([]float64{compare.Opacity}, []float64{0}, nil); err != nil {
+			return nil, fmt.Errorf("failed to blend: %w", err)
+		}
+		if err := imageA.Add(imageB); err != nil {
+			return nil, fmt.Errorf("failed to blend: %w", err)
+		}
+	}
+	if err := imageA.Cast(vips.BandFormatUchar, nil); err != nil {
+		return nil, fmt.Errorf("failed to reduce bit depth: %w", err)
+	}
+	image, err := imageA.Copy(&vips.CopyOptions{Interpretation: vips.InterpretationSrgb})
+	if err != nil {
+		return nil, fmt.Errorf("failed to compare: %w", err)
+	}
+	defer image.Close()
+
+	if err := applyAdjustments(image, opts.Adjustments); err != nil {
+		return nil, err
+	}
+	if err := padTile(image, background, formatSupportsAlpha(cacheKey.Format)); err != nil {
+		return nil, err
+	}
+	timer.mark("process")
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	tileData, err := encodeTile(image, cacheKey.Format)
+	if err != nil {
+		return nil, err
+	}
+	timer.mark("encode")
+
+	r.tileCache.Set(ctx, cacheKey, tileData)
+
+	logger.FromContext(ctx, r.logger).Debug("Rendered compare tile",
+		zap.String("image", infoA.ID), zap.String("compare", infoB.ID),
+		zap.String("mode", compare.Mode),
+		zap.Int("z", cacheKey.Z), zap.Int("x", cacheKey.X), zap.Int("y", cacheKey.Y),
+		zap.Int("bytes", len(tileData)))
+	r.logSlowTile(ctx, cacheKey, timer)
+
+	return &render.TileResult{
+		Data:   tileData,
+		ETag:   r.generateETag(cacheKey),
+		Size:   len(tileData),
+		Timing: timer.stages,
+	}, nil
+}
+
+// openComparePiece returns the tile region of one image at the output scale,
+// as flattened 8-bit sRGB
+func (r *Renderer) openComparePiece(imageInfo *image_list.ImageInfo, page int, region tileRegion, background []float64) (*vips.Image, error) {
+	pageWidth, _, _ := imageInfo.PageSize(page)
+	image, level, err := r.openRegion(source{
+		path:  r.scanner.GetImagePathByID(imageInfo.ID),
+		page:  page,
+		dpi:   imageInfo.DPI,
+		scale: imageInfo.Scale,
+		width: pageWidth,
+
+		fingerprint: imageInfo.Fingerprint,
+	}, region)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open image: %w", err)
+	}
+
+	if err := preparePiece(image, level, background, false); err != nil {
+		image.Close()
+		return nil, err
+	}
+	return image, nil
+}
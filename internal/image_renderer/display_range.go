@@ -0,0 +1,154 @@
+package image_renderer
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/cshum/vipsgen/vips"
+
+	"gigaview/internal/image_list"
+	"gigaview/internal/render"
+)
+
+// autoRangeClip is the fraction of samples clipped at each end of the
+// automatic display range, so a few hot or dead pixels don't flatten it
+const autoRangeClip = 0.005
+
+// stretchDisplayRange maps a high bit-depth tile from its display range onto
+// the full 16-bit range, which normalizeTile then reduces to 8 bits. Without
+// it, 16-bit microscopy and astronomy data that only uses a small part of the
+// range renders nearly black or blown out. 8-bit and colorimetric (CMYK, Lab,
+// scRGB) images are left alone.
+func (r *Renderer) stretchDisplayRange(ctx context.Context, imageID string, page int, image *vips.Image, displayRange *image_list.DisplayRange, bands []int) error {
+	if image.BandFormat() == vips.BandFormatUchar {
+		return nil
+	}
+	switch image.Interpretation() {
+	case vips.InterpretationCmyk, vips.InterpretationLab, vips.InterpretationScrgb:
+		return nil
+	}
+
+	if displayRange == nil {
+		if !r.options.AutoDisplayRange {
+			return nil
+		}
+		stats, err := r.ImageStats(ctx, imageID, page)
+		if err != nil {
+			return fmt.Errorf("failed to compute display range: %w", err)
+		}
+		displayRange = autoDisplayRange(stats, bands)
+	}
+
+	span := displayRange.Max - displayRange.Min
+	if span <= 0 {
+		return nil
+	}
+	a := make([]float64, image.Bands())
+	b := make([]float64, image.Bands())
+	for i := range a {
+		a[i] = 65535 / span
+		b[i] = -displayRange.Min * a[i]
+	}
+	// Alpha keeps its own scale
+	if image.HasAlpha() {
+		last := len(a) - 1
+		rangeMin, rangeMax := formatRange(image.BandFormat())
+		a[last], b[last] = 1, 0
+		if rangeMax > rangeMin {
+			a[last] = 65535 / (rangeMax - rangeMin)
+			b[last] = -rangeMin * a[last]
+		}
+	}
+
+	if err := image.Linear(a, b, nil); err != nil {
+		return fmt.Errorf("failed to apply display range: %w", err)
+	}
+	// Cast clips values outside the display range
+	if err := image.Cast(vips.BandFormatUshort, nil); err != nil {
+		return fmt.Errorf("failed to apply display range: %w", err)
+	}
+	return nil
+}
+
+// autoDisplayRange picks the value range holding all but autoRangeClip of the
+// samples at each end, over the selected (1-based) bands or all color
+// channels combined
+func autoDisplayRange(stats *render.ImageStats, bands []int) *image_list.DisplayRange {
+	channels := stats.Channels
+	if len(bands) > 0 {
+		channels = make([]render.ChannelStats, 0, len(bands))
+		for _, band := range bands {
+			if band <= len(stats.Channels) {
+				channels = append(channels, stats.Channels[band-1])
+			}
+		}
+	} else if len(channels) == 2 || len(channels) == 4 {
+		// Alpha doesn't describe the image content
+		channels = channels[:len(channels)-1]
+	}
+	if len(channels) == 0 {
+		return &image_list.DisplayRange{Min: stats.RangeMin, Max: stats.RangeMax}
+	}
+
+	var counts [histogramBins]uint64
+	var total uint64
+	for _, channel := range channels {
+		for bin, count := range channel.Histogram {
+			counts[bin] += uint64(count)
+			total += uint64(count)
+		}
+	}
+
+	binWidth := (stats.RangeMax - stats.RangeMin) / float64(histogramBins-1)
+	low, high := 0, histogramBins-1
+	var cumulative uint64
+	for bin, count := range counts {
+		cumulative += count
+		if float64(cumulative) <= float64(total)*autoRangeClip {
+			low = bin + 1
+		}
+		if float64(cumulative) >= float64(total)*(1-autoRangeClip) {
+			high = bin
+			break
+		}
+	}
+
+	displayRange := &image_list.DisplayRange{
+		Min: stats.RangeMin + float64(low)*binWidth,
+		Max: stats.RangeMin + float64(high+1)*binWidth,
+	}
+	if displayRange.Max <= displayRange.Min {
+		// Near-constant images fall back to their actual extremes
+		displayRange.Min, displayRange.Max = channels[0].Min, channels[0].Max
+		for _, channel := range channels[1:] {
+			displayRange.Min = min(displayRange.Min, channel.Min)
+			displayRange.Max = max(displayRange.Max, channel.Max)
+		}
+	}
+	return displayRange
+}
+
+// DisplayRange returns the range an image page is stretched from: the
+// explicit one when set, otherwise the automatic one (nil for 8-bit images or
+// with AUTO_DISPLAY_RANGE off)
+func (r *Renderer) DisplayRange(ctx context.Context, imageID string, page int) (displayRange *image_list.DisplayRange, auto bool, err error) {
+	imageInfo := r.scanner.GetImageByID(imageID)
+	if imageInfo == nil {
+		return nil, false, fmt.Errorf("%w: %s", render.ErrImageNotFound, imageID)
+	}
+	if imageInfo.DisplayRange != nil {
+		return imageInfo.DisplayRange, false, nil
+	}
+	if !r.options.AutoDisplayRange {
+		return nil, true, nil
+	}
+
+	stats, err := r.ImageStats(ctx, imageID, page)
+	if err != nil {
+		return nil, true, err
+	}
+	if stats.Format == "uchar" {
+		return nil, true, nil
+	}
+	return autoDisplayRange(stats, nil), true, nil
+}
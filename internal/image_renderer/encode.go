@@ -0,0 +1,34 @@
+package image_renderer
+
+import (
+	"fmt"
+
+	"github.com/cshum/vipsgen/vips"
+)
+
+// encodeTile exports the finished tile in the requested format
+func encodeTile(image *vips.Image, format string) ([]byte, error) {
+	var (
+		data []byte
+		err  error
+	)
+
+	switch format {
+	case "webp":
+		opts := vips.DefaultWebpsaveBufferOptions()
+		opts.Q = 82
+		data, err = image.WebpsaveBuffer(opts)
+	case "png":
+		data, err = image.PngsaveBuffer(vips.DefaultPngsaveBufferOptions())
+	default:
+		opts := vips.DefaultJpegsaveBufferOptions()
+		opts.Q = 82
+		opts.Interlace = false
+		data, err = image.JpegsaveBuffer(opts)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to export: %w", err)
+	}
+	return data, nil
+}
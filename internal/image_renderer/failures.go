@@ -0,0 +1,76 @@
+package image_renderer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"gigaview/internal/image_list"
+	"gigaview/internal/render"
+)
+
+// errOpenFailed wraps errors opening the source file of a tile, the only
+// ones that say the whole image is broken rather than one tile or one
+// combination of options
+var errOpenFailed = errors.New("failed to open image")
+
+// renderFailure is the last error of an image and how long it is served
+type renderFailure struct {
+	fingerprint string
+	err         error
+	until       time.Time
+}
+
+// failureCache remembers errors opening the source file per image, so a
+// broken file isn't reopened for every tile. Entries are bound to the file fingerprint:
+// a replaced file is tried again right away.
+type failureCache struct {
+	mu       sync.Mutex
+	failures map[string]renderFailure
+}
+
+func newFailureCache() *failureCache {
+	return &failureCache{failures: make(map[string]renderFailure)}
+}
+
+// check returns ErrRenderFailed while a failure of the image is remembered
+func (f *failureCache) check(imageInfo *image_list.ImageInfo) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	failure, ok := f.failures[imageInfo.ID]
+	if !ok {
+		return nil
+	}
+	if failure.fingerprint != imageInfo.Fingerprint || time.Now().After(failure.until) {
+		delete(f.failures, imageInfo.ID)
+		return nil
+	}
+	return fmt.Errorf("%w: %v", render.ErrRenderFailed, failure.err)
+}
+
+// record remembers err for ttl if the source file failed to open.
+// Cancellation, timeouts and bad requests while opening it don't count.
+func (f *failureCache) record(imageInfo *image_list.ImageInfo, err error, ttl time.Duration) {
+	if ttl <= 0 || !errors.Is(err, errOpenFailed) ||
+		errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, render.ErrTileOutOfBounds) || errors.Is(err, render.ErrInvalidOptions) || errors.Is(err, render.ErrImageNotFound) {
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.failures[imageInfo.ID] = renderFailure{
+		fingerprint: imageInfo.Fingerprint,
+		err:         err,
+		until:       time.Now().Add(ttl),
+	}
+}
+
+func (f *failureCache) forget(imageID string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.failures, imageID)
+}
@@ -0,0 +1,363 @@
+package image_renderer
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/cshum/vipsgen/vips"
+	"go.uber.org/zap"
+
+	"gigaview/internal/cache"
+	"gigaview/internal/image_list"
+	"gigaview/internal/logger"
+	"gigaview/internal/render"
+)
+
+// XYZ tiles are standard slippy-map tiles in web-mercator (EPSG:3857).
+// Tile (0,0) at zoom 0 covers the whole world, y grows southwards.
+const (
+	earthRadius = 6378137.0
+	worldSize   = 2 * math.Pi * earthRadius
+	xyzVariant  = "xyz"
+)
+
+// xyzWindow is the source area covered by an XYZ tile, in source pixels
+type xyzWindow struct {
+	minMX, maxMY float64 // top-left of the tile in mercator meters
+	resolution   float64 // mercator meters per output pixel
+	x0, y0       float64 // top-left of the tile in source pixels
+	x1, y1       float64 // bottom-right of the tile in source pixels
+}
+
+// xyzWindowFor projects the tile bounds onto the source image
+func xyzWindowFor(geo *image_list.GeoInfo, z, x, y int) xyzWindow {
+	size := worldSize / math.Pow(2, float64(z))
+	w := xyzWindow{
+		minMX:      -worldSize/2 + float64(x)*size,
+		maxMY:      worldSize/2 - float64(y)*size,
+		resolution: size / 256,
+	}
+	w.x0, w.y0 = sourcePixel(geo, w.minMX, w.maxMY)
+	w.x1, w.y1 = sourcePixel(geo, w.minMX+size, w.maxMY-size)
+	return w
+}
+
+// sourcePixel converts mercator meters to source pixel coordinates
+func sourcePixel(geo *image_list.GeoInfo, mx, my float64) (float64, float64) {
+	cx, cy := mx, my
+	if geo.EPSG == 4326 {
+		cx = mx / earthRadius * 180 / math.Pi
+		cy = (2*math.Atan(math.Exp(my/earthRadius)) - math.Pi/2) * 180 / math.Pi
+	}
+	return (cx - geo.OriginX) / geo.PixelWidth, (geo.OriginY - cy) / geo.PixelHeight
+}
+
+// RenderXYZTile renders a web-mercator tile of a georeferenced image. Tiles
+// that don't intersect the image return ErrTileOutOfBounds.
+func (r *Renderer) RenderXYZTile(ctx context.Context, imageID string, z, x, y int, opts render.TileOptions) (*render.TileResult, error) {
+	imageInfo := r.scanner.GetImageByID(imageID)
+	if imageInfo == nil {
+		return nil, fmt.Errorf("%w: %s", render.ErrImageNotFound, imageID)
+	}
+	geo := imageInfo.Geo
+	if geo == nil {
+		return nil, fmt.Errorf("%w: image %s is not georeferenced", render.ErrTileOutOfBounds, imageID)
+	}
+
+	format := opts.Format
+	if format == "" {
+		format = render.DefaultTileOptions.Format
+	}
+
+	maxZoom := geo.MaxZoom()
+	if z > r.xyzMaxZoom(imageInfo) || x >= 1<<z || y >= 1<<z {
+		return nil, fmt.Errorf("%w: tile %d/%d/%d", render.ErrTileOutOfBounds, z, x, y)
+	}
+
+	window := xyzWindowFor(geo, z, x, y)
+	if window.x1 <= 0 || window.y1 <= 0 || window.x0 >= float64(imageInfo.Width) || window.y0 >= float64(imageInfo.Height) {
+		return nil, fmt.Errorf("%w: tile %d/%d/%d", render.ErrTileOutOfBounds, z, x, y)
+	}
+
+	variant := xyzVariant
+	if v := opts.Variant(imageInfo.DisplayRange); v != "" {
+		variant += "-" + v
+	}
+	cacheKey := cache.TileKey{
+		ImageID:  imageID,
+		TileSize: 256,
+		MaxZoom:  maxZoom,
+		Z:        z,
+		X:        x,
+		Y:        y,
+		Format:   format,
+		Variant:  variant,
+
+		Fingerprint: imageInfo.Fingerprint,
+	}
+
+	lookupStart := time.Now()
+	cached, ok := r.tileCache.Get(ctx, cacheKey)
+	lookup := time.Since(lookupStart)
+	if ok {
+		return withCacheLookup(&render.TileResult{
+			Data: cached,
+			ETag: r.generateETag(cacheKey),
+			Size: len(cached),
+		}, lookup), nil
+	}
+
+	if err := r.failures.check(imageInfo); err != nil {
+		return nil, err
+	}
+
+	result, err := r.inflight.do(ctx, cacheKey, func(ctx context.Context) (*render.TileResult, error) {
+		if r.options.RenderTimeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, r.options.RenderTimeout)
+			defer cancel()
+		}
+		result, err := r.renderXYZUncached(ctx, imageInfo, window, cacheKey, opts)
+		r.failures.record(imageInfo, err, r.options.FailureTTL)
+		return result, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return withCacheLookup(result, lookup), nil
+}
+
+// renderXYZUncached reads the source window (from a pyramid level when it is
+// much larger than the tile) and warps it onto the mercator grid with mapim.
+// Web-mercator x is linear in both supported CRSs; y is linear for EPSG:3857
+// sources and follows the mercator curve for EPSG:4326 sources.
+func (r *Renderer) renderXYZUncached(ctx context.Context, imageInfo *image_list.ImageInfo, window xyzWindow, cacheKey cache.TileKey, opts render.TileOptions) (*render.TileResult, error) {
+	src := source{
+		path:  r.scanner.GetImagePathByID(imageInfo.ID),
+		width: imageInfo.Width,
+
+		fingerprint: imageInfo.Fingerprint,
+	}
+	if src.path == "" {
+		return nil, fmt.Errorf("image path not found for id: %s", imageInfo.ID)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	// Source window clamped to the image, with a pixel of margin for interpolation
+	x0 := max(0, int(math.Floor(window.x0))-1)
+	y0 := max(0, int(math.Floor(window.y0))-1)
+	x1 := min(imageInfo.Width, int(math.Ceil(window.x1))+1)
+	y1 := min(imageInfo.Height, int(math.Ceil(window.y1))+1)
+
+	// Downscale before warping when the tile covers many source pixels
+	scale := math.Min(1, 256/math.Max(window.x1-window.x0, window.y1-window.y0))
+	region := tileRegion{x: x0, y: y0, width: x1 - x0, height: y1 - y0, scale: scale}
+
+	timer := newStageTimer()
+	image, level, err := r.openRegion(src, region)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errOpenFailed, err)
+	}
+	defer image.Close()
+	timer.mark("load")
+
+	if err := image.ExtractArea(level.x, level.y, level.width, level.height); err != nil {
+		return nil, fmt.Errorf("failed to extract area: %w", err)
+	}
+	timer.mark("extract")
+	if level.scale < 1 {
+		if err := image.Resize(level.scale, nil); err != nil {
+			return nil, fmt.Errorf("failed to resize: %w", err)
+		}
+	}
+	timer.mark("resize")
+
+	if len(opts.Bands) > 0 {
+		selected, err := selectBands(image, opts.Bands)
+		if err != nil {
+			return nil, err
+		}
+		defer selected.Close()
+		image = selected
+	}
+
+	if err := r.stretchDisplayRange(ctx, imageInfo.ID, 0, image, imageInfo.DisplayRange, opts.Bands); err != nil {
+		return nil, err
+	}
+
+	background := r.paddingColor(imageInfo)
+	keepAlpha := formatSupportsAlpha(cacheKey.Format)
+	if err := normalizeTile(image, background, keepAlpha); err != nil {
+		return nil, err
+	}
+
+	if opts.Colormap != "" {
+		mapped, err := applyColormap(image, opts.Colormap)
+		if err != nil {
+			return nil, err
+		}
+		defer mapped.Close()
+		image = mapped
+	}
+	if err := applyAdjustments(image, opts.Adjustments); err != nil {
+		return nil, err
+	}
+
+	mapOpts := vips.DefaultMapimOptions()
+	mapOpts.Interpolate = vips.NewInterpolate(vips.InterpolateBilinear)
+	defer mapOpts.Interpolate.Close()
+	mapOpts.Extend = vips.ExtendBackground
+	mapOpts.Background = background
+	if keepAlpha {
+		// Areas outside the image are transparent
+		if !image.HasAlpha() {
+			if err := image.BandjoinConst([]float64{255}); err != nil {
+				return nil, fmt.Errorf("failed to add alpha: %w", err)
+			}
+		}
+		mapOpts.Background = append(append([]float64{}, background...), 0)
+	}
+
+	// A pyramid level's window starts at a whole level pixel, which may sit
+	// slightly before x0/y0 in source coordinates
+	ratio := scale / level.scale
+	index, err := xyzIndex(imageInfo.Geo, window, float64(level.x)/ratio, float64(level.y)/ratio, scale)
+	if err != nil {
+		return nil, err
+	}
+	defer index.Close()
+
+	if err := image.Mapim(index, mapOpts); err != nil {
+		return nil, fmt.Errorf("failed to warp tile: %w", err)
+	}
+	timer.mark("process")
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	tileData, err := encodeTile(image, cacheKey.Format)
+	if err != nil {
+		return nil, err
+	}
+	timer.mark("encode")
+
+	r.tileCache.Set(ctx, cacheKey, tileData)
+
+	logger.FromContext(ctx, r.logger).Debug("Rendered xyz tile",
+		zap.String("image", imageInfo.ID),
+		zap.Int("z", cacheKey.Z), zap.Int("x", cacheKey.X), zap.Int("y", cacheKey.Y),
+		zap.Int("bytes", len(tileData)))
+	r.logSlowTile(ctx, cacheKey, timer)
+
+	return &render.TileResult{
+		Data:   tileData,
+		ETag:   r.generateETag(cacheKey),
+		Size:   len(tileData),
+		Timing: timer.stages,
+	}, nil
+}
+
+// xyzIndex builds the 256×256 mapim index: for every output pixel, the
+// position in the extracted (and scaled) source window. mapim addresses pixel
+// centers at integer coordinates, hence the final -0.5.
+func xyzIndex(geo *image_list.GeoInfo, window xyzWindow, windowX, windowY, scale float64) (*vips.Image, error) {
+	// Output pixel centers in mercator meters:
+	//   mx = minMX + (i+0.5)*res,  my = maxMY - (j+0.5)*res
+	res := window.resolution
+	unitX := 1.0 // CRS x units per mercator meter
+	if geo.EPSG == 4326 {
+		unitX = 180 / math.Pi / earthRadius
+	}
+
+	xs, err := vips.NewXyz(256, 256, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build index: %w", err)
+	}
+	if err := xs.ExtractBand(0, nil); err != nil {
+		xs.Close()
+		return nil, fmt.Errorf("failed to build index: %w", err)
+	}
+	// source x = ((minMX + (i+0.5)*res)*unitX - originX) / pixelWidth, relative to the window
+	ax := res * unitX / geo.PixelWidth * scale
+	bx := ((window.minMX+res/2)*unitX-geo.OriginX)/geo.PixelWidth*scale - windowX*scale - 0.5
+	if err := xs.Linear([]float64{ax}, []float64{bx}, nil); err != nil {
+		xs.Close()
+		return nil, fmt.Errorf("failed to build index: %w", err)
+	}
+
+	ys, err := vips.NewXyz(256, 256, nil)
+	if err != nil {
+		xs.Close()
+		return nil, fmt.Errorf("failed to build index: %w", err)
+	}
+	defer ys.Close()
+	defer xs.Close()
+	if err := ys.ExtractBand(1, nil); err != nil {
+		return nil, fmt.Errorf("failed to build index: %w", err)
+	}
+
+	if geo.EPSG == 4326 {
+		// lat = 2*atan(exp(my/R)) - 90°, with vips trigonometry in degrees
+		if err := ys.Linear([]float64{-res / earthRadius}, []float64{(window.maxMY - res/2) / earthRadius}, nil); err != nil {
+			return nil, fmt.Errorf("failed to build index: %w", err)
+		}
+		if err := ys.Math(vips.OperationMathExp); err != nil {
+			return nil, fmt.Errorf("failed to build index: %w", err)
+		}
+		if err := ys.Math(vips.OperationMathAtan); err != nil {
+			return nil, fmt.Errorf("failed to build index: %w", err)
+		}
+		// source y = (originY - (2*atan - 90)) / pixelHeight
+		ay := -2 / geo.PixelHeight * scale
+		by := (geo.OriginY+90)/geo.PixelHeight*scale - windowY*scale - 0.5
+		if err := ys.Linear([]float64{ay}, []float64{by}, nil); err != nil {
+			return nil, fmt.Errorf("failed to build index: %w", err)
+		}
+	} else {
+		ay := res / geo.PixelHeight * scale
+		by := (geo.OriginY-(window.maxMY-res/2))/geo.PixelHeight*scale - windowY*scale - 0.5
+		if err := ys.Linear([]float64{ay}, []float64{by}, nil); err != nil {
+			return nil, fmt.Errorf("failed to build index: %w", err)
+		}
+	}
+
+	index, err := vips.NewBandjoin([]*vips.Image{xs, ys})
+	if err != nil {
+		return nil, fmt.Errorf("failed to build index: %w", err)
+	}
+	return index, nil
+}
+
+// XYZMaxZoom is the deepest XYZ zoom level served for a georeferenced image,
+// including overzoom, and 0 for other images
+func (r *Renderer) XYZMaxZoom(imageID string) int {
+	imageInfo := r.scanner.GetImageByID(imageID)
+	if imageInfo == nil || imageInfo.Geo == nil {
+		return 0
+	}
+	return r.xyzMaxZoom(imageInfo)
+}
+
+func (r *Renderer) xyzMaxZoom(imageInfo *image_list.ImageInfo) int {
+	return imageInfo.Geo.MaxZoom() + r.options.OverzoomLevels
+}
+
+// geoMeta describes the XYZ tile set of a georeferenced image for /meta
+func (r *Renderer) geoMeta(imageInfo *image_list.ImageInfo) map[string]interface{} {
+	geo := imageInfo.Geo
+	maxZoom := geo.MaxZoom()
+	return map[string]interface{}{
+		"epsg":        geo.EPSG,
+		"bounds":      geo.Bounds,
+		"minZoom":     0,
+		"maxZoom":     maxZoom,
+		"maxOverzoom": r.xyzMaxZoom(imageInfo),
+		"tiles":       fmt.Sprintf("/api/images/%s/xyz/{z}/{x}/{y}.%s", imageInfo.ID, render.DefaultTileOptions.Format),
+	}
+}
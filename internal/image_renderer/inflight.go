@@ -0,0 +1,76 @@
+package image_renderer
+
+import (
+	"context"
+	"sync"
+
+	"gigaview/internal/cache"
+	"gigaview/internal/render"
+)
+
+// inflightCall is a render in progress that other callers can wait on
+type inflightCall struct {
+	done    chan struct{}
+	result  *render.TileResult
+	err     error
+	waiters int
+	cancel  context.CancelFunc
+}
+
+// inflightGroup deduplicates concurrent renders of the same tile (singleflight).
+// The first caller for a key starts the render, later callers wait for it and
+// receive the same result. The render is detached from any single caller and
+// is only canceled once every waiter has given up.
+type inflightGroup struct {
+	mu    sync.Mutex
+	calls map[cache.TileKey]*inflightCall
+}
+
+func newInflightGroup() *inflightGroup {
+	return &inflightGroup{
+		calls: make(map[cache.TileKey]*inflightCall),
+	}
+}
+
+func (g *inflightGroup) do(ctx context.Context, key cache.TileKey, render func(ctx context.Context) (*render.TileResult, error)) (*render.TileResult, error) {
+	g.mu.Lock()
+	call, ok := g.calls[key]
+	if !ok {
+		renderCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		call = &inflightCall{done: make(chan struct{}), cancel: cancel}
+		g.calls[key] = call
+
+		go func() {
+			call.result, call.err = render(renderCtx)
+
+			g.mu.Lock()
+			if g.calls[key] == call {
+				delete(g.calls, key)
+			}
+			g.mu.Unlock()
+
+			cancel()
+			close(call.done)
+		}()
+	}
+	call.waiters++
+	g.mu.Unlock()
+
+	select {
+	case <-call.done:
+		return call.result, call.err
+	case <-ctx.Done():
+		g.mu.Lock()
+		call.waiters--
+		if call.waiters == 0 {
+			// Nobody is interested anymore: stop the render and let the
+			// next request for this tile start from scratch
+			call.cancel()
+			if g.calls[key] == call {
+				delete(g.calls, key)
+			}
+		}
+		g.mu.Unlock()
+		return nil, ctx.Err()
+	}
+}
@@ -0,0 +1,23 @@
+package image_renderer
+
+import (
+	"fmt"
+
+	"go.uber.org/zap"
+
+	"gigaview/internal/cache"
+	"gigaview/internal/image_list"
+	"gigaview/internal/render"
+)
+
+var _ render.TileRenderer = (*Renderer)(nil)
+
+// NewTileRenderer creates the renderer of options.Backend
+func NewTileRenderer(dataDir string, scanner *image_list.Scanner, tileCache cache.Cache, options Options, log *zap.Logger) (render.TileRenderer, error) {
+	switch options.Backend {
+	case "", "vips":
+		return New(dataDir, scanner, tileCache, options, log), nil
+	default:
+		return nil, fmt.Errorf("unknown renderer backend: %s (supported: vips)", options.Backend)
+	}
+}
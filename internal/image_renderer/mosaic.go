@@ -0,0 +1,125 @@
+package image_renderer
+
+import (
+	"fmt"
+	"math"
+	"path/filepath"
+	"strings"
+
+	"github.com/cshum/vipsgen/vips"
+
+	"gigaview/internal/image_list"
+	"gigaview/internal/render"
+)
+
+// openMosaicRegion composes the part of a mosaic covered by region. Only the
+// sources intersecting it are opened, each through openRegion so their own
+// pyramids are used, and they are reduced to the output scale before being
+// placed. The result is an 8-bit sRGB canvas with alpha (gaps between sources
+// are transparent) and a region that covers all of it.
+func (r *Renderer) openMosaicRegion(src source, region tileRegion) (*vips.Image, tileRegion, error) {
+	id := strings.TrimSuffix(filepath.Base(src.path), filepath.Ext(src.path))
+	mosaicInfo := r.scanner.GetImageByID(id)
+	if mosaicInfo == nil {
+		return nil, region, fmt.Errorf("%w: %s", render.ErrImageNotFound, id)
+	}
+
+	// Overzoomed tiles are composed at full resolution and upscaled afterwards
+	scale := math.Min(region.scale, 1)
+	width := max(1, int(math.Ceil(float64(region.width)*scale)))
+	height := max(1, int(math.Ceil(float64(region.height)*scale)))
+
+	canvas, err := vips.NewBlack(width, height, &vips.BlackOptions{Bands: 4})
+	if err != nil {
+		return nil, region, fmt.Errorf("failed to create mosaic canvas: %w", err)
+	}
+	defer canvas.Close()
+
+	for _, placed := range mosaicInfo.Mosaic {
+		x0 := max(region.x, placed.X)
+		y0 := max(region.y, placed.Y)
+		x1 := min(region.x+region.width, placed.X+placed.Width)
+		y1 := min(region.y+region.height, placed.Y+placed.Height)
+		if x0 >= x1 || y0 >= y1 {
+			continue
+		}
+
+		piece, err := r.openMosaicPiece(placed, tileRegion{
+			x:      x0 - placed.X,
+			y:      y0 - placed.Y,
+			width:  x1 - x0,
+			height: y1 - y0,
+			scale:  scale,
+		})
+		if err != nil {
+			return nil, region, fmt.Errorf("mosaic source %s: %w", placed.ImageID, err)
+		}
+		left := int(math.Round(float64(x0-region.x) * scale))
+		top := int(math.Round(float64(y0-region.y) * scale))
+		err = canvas.Insert(piece, left, top, nil)
+		piece.Close()
+		if err != nil {
+			return nil, region, fmt.Errorf("failed to place mosaic source: %w", err)
+		}
+	}
+
+	composed, err := canvas.Copy(&vips.CopyOptions{Interpretation: vips.InterpretationSrgb})
+	if err != nil {
+		return nil, region, fmt.Errorf("failed to compose mosaic: %w", err)
+	}
+	return composed, tileRegion{width: width, height: height, scale: region.scale / scale}, nil
+}
+
+// openMosaicPiece returns a region of one mosaic source at the output scale,
+// as 8-bit sRGB with alpha so every piece matches the canvas
+func (r *Renderer) openMosaicPiece(placed image_list.MosaicSource, region tileRegion) (*vips.Image, error) {
+	imageInfo := r.scanner.GetImageByID(placed.ImageID)
+	if imageInfo == nil {
+		return nil, fmt.Errorf("%w: %s", render.ErrImageNotFound, placed.ImageID)
+	}
+
+	image, level, err := r.openRegion(source{
+		path:  r.scanner.GetImagePathByID(placed.ImageID),
+		dpi:   imageInfo.DPI,
+		scale: imageInfo.Scale,
+		width: imageInfo.Width,
+
+		fingerprint: imageInfo.Fingerprint,
+	}, region)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := preparePiece(image, level, defaultPaddingColor, true); err != nil {
+		image.Close()
+		return nil, err
+	}
+	return image, nil
+}
+
+// preparePiece orients, extracts and scales a region opened by openRegion and
+// normalizes it to 8-bit sRGB, with alpha when keepAlpha is set
+func preparePiece(image *vips.Image, level tileRegion, background []float64, keepAlpha bool) error {
+	if image.Orientation() > 1 {
+		if err := image.Autorot(nil); err != nil {
+			return fmt.Errorf("failed to apply orientation: %w", err)
+		}
+	}
+	if err := image.ExtractArea(level.x, level.y, level.width, level.height); err != nil {
+		return fmt.Errorf("failed to extract area: %w", err)
+	}
+	if level.scale != 1 {
+		if err := image.Resize(level.scale, nil); err != nil {
+			return fmt.Errorf("failed to resize: %w", err)
+		}
+	}
+	if err := normalizeTile(image, background, keepAlpha); err != nil {
+		return err
+	}
+	if keepAlpha && !image.HasAlpha() {
+		if err := image.BandjoinConst([]float64{255}); err != nil {
+			return fmt.Errorf("failed to add alpha: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package image_renderer
+
+import (
+	"fmt"
+
+	"github.com/cshum/vipsgen/vips"
+)
+
+// normalizeTile converts a tile to 8-bit sRGB so it can be padded and encoded
+// whatever the source depth and colourspace is (16-bit scientific TIFFs, CMYK
+// print scans, greyscale, images with alpha). Alpha is kept only when the
+// output format supports it, otherwise it is flattened onto background.
+// It runs after the resize, so only tile-sized data is converted.
+func normalizeTile(image *vips.Image, background []float64, keepAlpha bool) error {
+	switch image.Interpretation() {
+	case vips.InterpretationCmyk, vips.InterpretationRgb16, vips.InterpretationLab, vips.InterpretationScrgb:
+		// colourspace handles CMYK (via the built-in profile) and scales
+		// 16-bit RGB down to 8 bits
+		if err := image.Colourspace(vips.InterpretationSrgb, nil); err != nil {
+			return fmt.Errorf("failed to convert to sRGB: %w", err)
+		}
+	case vips.InterpretationGrey16:
+		if err := image.Colourspace(vips.InterpretationBW, nil); err != nil {
+			return fmt.Errorf("failed to convert to 8-bit grey: %w", err)
+		}
+	}
+
+	// Anything still wider than 8 bits (e.g. untagged 16-bit data) is shifted
+	// down rather than clipped, so the full range stays visible
+	switch image.BandFormat() {
+	case vips.BandFormatUchar:
+	case vips.BandFormatUshort, vips.BandFormatShort, vips.BandFormatUint, vips.BandFormatInt:
+		if err := image.Cast(vips.BandFormatUchar, &vips.CastOptions{Shift: true}); err != nil {
+			return fmt.Errorf("failed to reduce bit depth: %w", err)
+		}
+	default:
+		if err := image.Cast(vips.BandFormatUchar, nil); err != nil {
+			return fmt.Errorf("failed to reduce bit depth: %w", err)
+		}
+	}
+
+	if image.HasAlpha() && !keepAlpha {
+		opts := vips.DefaultFlattenOptions()
+		opts.Background = background
+		if err := image.Flatten(opts); err != nil {
+			return fmt.Errorf("failed to flatten alpha: %w", err)
+		}
+	}
+
+	if image.Bands() < 3 || (image.Bands() == 3 && image.HasAlpha()) {
+		// Greyscale (with or without alpha) becomes 3-band sRGB
+		if err := image.Colourspace(vips.InterpretationSrgb, nil); err != nil {
+			return fmt.Errorf("failed to convert to sRGB: %w", err)
+		}
+	}
+
+	return nil
+}
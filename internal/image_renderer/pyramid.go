@@ -0,0 +1,222 @@
+package image_renderer
+
+import (
+	"math"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/cshum/vipsgen/vips"
+	"go.uber.org/zap"
+
+	"gigaview/internal/image_list"
+)
+
+// pyramidLevel is one resolution level embedded in a pyramidal TIFF, stored
+// either as a separate page or as a sub-IFD of page 0, or one OpenSlide level
+// of a whole-slide image
+type pyramidLevel struct {
+	page   int
+	subifd int // -1 when the level is a page
+	slide  int // OpenSlide level, only used for slides
+	width  int
+	height int
+}
+
+// pyramidIndex caches detected pyramid levels per file version so the TIFF
+// structure is only inspected once
+type pyramidIndex struct {
+	mu     sync.Mutex
+	levels map[string][]pyramidLevel
+}
+
+func newPyramidIndex() *pyramidIndex {
+	return &pyramidIndex{
+		levels: make(map[string][]pyramidLevel),
+	}
+}
+
+// get returns the reduced-resolution levels of a TIFF or slide, largest first.
+// Files that aren't pyramidal have no levels. A replaced file has a new
+// fingerprint and is inspected again.
+func (p *pyramidIndex) get(path, fingerprint string, logger *zap.Logger) []pyramidLevel {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	key := path + "@" + fingerprint
+	if levels, ok := p.levels[key]; ok {
+		return levels
+	}
+
+	levels := detectPyramid(path)
+	if len(levels) > 0 {
+		logger.Debug("Detected image pyramid", zap.String("path", path), zap.Int("levels", len(levels)))
+	}
+	p.levels[key] = levels
+	return levels
+}
+
+// detectPyramid looks for sub-IFD levels first (OME-TIFF / libvips
+// subifd pyramids), then for pages that halve in size (classic page pyramids).
+// Whole-slide images list their levels in OpenSlide properties.
+func detectPyramid(path string) []pyramidLevel {
+	if image_list.IsSlide(path) {
+		return detectSlideLevels(path)
+	}
+
+	base, err := loadTiffLevel(path, pyramidLevel{subifd: -1})
+	if err != nil {
+		return nil
+	}
+	baseWidth := base.Width()
+	baseHeight := base.Height()
+	pages := base.Pages()
+	subifds, _ := base.GetInt("n-subifds")
+	orientation := base.Orientation()
+	base.Close()
+
+	// Tiles are addressed upright, but levels carry no orientation of their
+	// own and would be read unrotated, so rotated TIFFs decode the base
+	if orientation > 1 {
+		return nil
+	}
+
+	var levels []pyramidLevel
+
+	for i := 0; i < subifds; i++ {
+		level := pyramidLevel{page: 0, subifd: i}
+		if !probeLevel(path, &level) {
+			break
+		}
+		levels = append(levels, level)
+	}
+	if len(levels) > 0 {
+		return levels
+	}
+
+	prevWidth, prevHeight := baseWidth, baseHeight
+	for page := 1; page < pages; page++ {
+		level := pyramidLevel{page: page, subifd: -1}
+		if !probeLevel(path, &level) {
+			break
+		}
+		// Each level must be roughly half of the previous one, otherwise
+		// this is a multi-page document rather than a pyramid
+		if !isHalf(level.width, prevWidth) || !isHalf(level.height, prevHeight) {
+			break
+		}
+		levels = append(levels, level)
+		prevWidth, prevHeight = level.width, level.height
+	}
+
+	return levels
+}
+
+// detectSlideLevels reads the reduced levels of a whole-slide image.
+// Slide levels aren't necessarily powers of two (NDPI often uses 4×).
+func detectSlideLevels(path string) []pyramidLevel {
+	base, err := image_list.LoadSlide(path, 0, vips.AccessRandom)
+	if err != nil {
+		return nil
+	}
+	count := image_list.ReadSlideInfo(base).Levels
+	base.Close()
+
+	var levels []pyramidLevel
+	for i := 1; i < count; i++ {
+		level := pyramidLevel{subifd: -1, slide: i}
+		if !probeLevel(path, &level) {
+			break
+		}
+		levels = append(levels, level)
+	}
+	return levels
+}
+
+func probeLevel(path string, level *pyramidLevel) bool {
+	image, err := loadLevel(path, *level)
+	if err != nil {
+		return false
+	}
+	defer image.Close()
+
+	level.width = image.Width()
+	level.height = image.Height()
+	return true
+}
+
+func isHalf(size, prevSize int) bool {
+	return math.Abs(float64(size)-float64(prevSize)/2) <= 1
+}
+
+// loadLevel opens a detected pyramid level of a TIFF or slide
+func loadLevel(path string, level pyramidLevel) (*vips.Image, error) {
+	if image_list.IsSlide(path) {
+		return image_list.LoadSlide(path, level.slide, vips.AccessRandom)
+	}
+	return loadTiffLevel(path, level)
+}
+
+func loadTiffLevel(path string, level pyramidLevel) (*vips.Image, error) {
+	opts := vips.DefaultTiffloadOptions()
+	opts.Access = vips.AccessRandom
+	opts.Page = level.page
+	opts.Subifd = level.subifd
+	return vips.NewTiffload(path, opts)
+}
+
+func isTiff(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	return ext == ".tif" || ext == ".tiff"
+}
+
+// openRegion opens the source for a tile. For pyramidal TIFFs and slides it picks the
+// smallest level that still has at least the output resolution and maps the
+// region onto it, so low-zoom tiles don't decode the full-resolution base.
+func (r *Renderer) openRegion(src source, region tileRegion) (*vips.Image, tileRegion, error) {
+	path := src.path
+	baseWidth := src.width
+	if image_list.IsMosaic(path) {
+		return r.openMosaicRegion(src, region)
+	}
+	if region.scale >= 1 || src.page > 0 || (!isTiff(path) && !image_list.IsSlide(path)) {
+		image, err := r.loadImage(src)
+		return image, region, err
+	}
+
+	var best *pyramidLevel
+	levels := r.pyramids.get(path, src.fingerprint, r.logger)
+	for i := range levels {
+		ratio := float64(levels[i].width) / float64(baseWidth)
+		if ratio < region.scale {
+			break
+		}
+		best = &levels[i]
+	}
+
+	if best == nil {
+		image, err := r.loadImage(src)
+		return image, region, err
+	}
+
+	image, err := loadLevel(path, *best)
+	if err != nil {
+		return nil, region, err
+	}
+
+	ratio := float64(best.width) / float64(baseWidth)
+	mapped := tileRegion{
+		x:     int(float64(region.x) * ratio),
+		y:     int(float64(region.y) * ratio),
+		scale: region.scale / ratio,
+	}
+	mapped.width = min(int(math.Ceil(float64(region.width)*ratio)), best.width-mapped.x)
+	mapped.height = min(int(math.Ceil(float64(region.height)*ratio)), best.height-mapped.y)
+	if mapped.width <= 0 || mapped.height <= 0 {
+		image.Close()
+		image, err := r.loadImage(src)
+		return image, region, err
+	}
+
+	return image, mapped, nil
+}
@@ -1,40 +1,181 @@
 package image_renderer
 
 import (
+	"context"
 	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
 	"math"
+	"os"
 	"path/filepath"
 	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/cshum/vipsgen/vips"
 	"go.uber.org/zap"
 
 	"gigaview/internal/cache"
 	"gigaview/internal/image_list"
+	"gigaview/internal/logger"
+	"gigaview/internal/render"
 )
 
+// Options tunes rendering behaviour
+type Options struct {
+	// Backend selects the TileRenderer of NewTileRenderer: "vips"
+	Backend string
+	// RenderTimeout caps a single tile render (0 = unlimited)
+	RenderTimeout time.Duration
+	// OverzoomLevels is how many zoom levels past the native maxZoom are
+	// served by upscaling the deepest native level
+	OverzoomLevels int
+	// PaddingColor fills edge tiles in formats without alpha and replaces
+	// transparency, as RGB 0-255. Images may override it via ImageInfo.PaddingColor.
+	PaddingColor []float64
+	// PyramidDir holds pre-generated static tile pyramids ("" = disabled)
+	PyramidDir string
+	// SlowTileThreshold logs the stage timings of renders taking longer (0 = off)
+	SlowTileThreshold time.Duration
+	// AutoDisplayRange stretches high bit-depth images from their percentile
+	// range when no display range is set on the image
+	AutoDisplayRange bool
+	// FailureTTL answers tiles of an image with ErrRenderFailed for that
+	// long after its file failed to open (0 = always retry)
+	FailureTTL time.Duration
+}
+
+// defaultPaddingColor is #ddd
+var defaultPaddingColor = []float64{221, 221, 221}
+
+// maxOverzoomLevels keeps overzoomed tiles aligned to whole source pixels (256 = 2^8)
+const maxOverzoomLevels = 8
+
+// formatSupportsAlpha reports whether edge padding can be transparent
+func formatSupportsAlpha(format string) bool {
+	return format == "png" || format == "webp"
+}
+
+// source identifies the decoded page of an image file
+type source struct {
+	path string
+	page int
+	// dpi is the PDF rasterization resolution recorded at scan time
+	dpi float64
+	// scale is the SVG rasterization scale recorded at scan time
+	scale float64
+	// width is the page width in displayed pixels
+	width int
+	// fingerprint identifies the file version, see ImageInfo.Fingerprint
+	fingerprint string
+}
+
 type Renderer struct {
 	dataDir   string
 	scanner   *image_list.Scanner
 	tileCache cache.Cache
 	logger    *zap.Logger
+	inflight  *inflightGroup
+	pyramids  *pyramidIndex
+	stats     *statsCache
+	failures  *failureCache
+	options   Options
+
+	// pyramidSlot serializes static pyramid generation
+	pyramidSlot chan struct{}
+	// live counts the tiles rendered for requests right now, which warmup
+	// yields to
+	live atomic.Int64
 }
 
-type TileResult struct {
-	Data []byte
-	ETag string
-	Size int
-}
+func New(dataDir string, scanner *image_list.Scanner, tileCache cache.Cache, options Options, logger *zap.Logger) *Renderer {
+	if options.OverzoomLevels < 0 {
+		options.OverzoomLevels = 0
+	}
+	if options.OverzoomLevels > maxOverzoomLevels {
+		options.OverzoomLevels = maxOverzoomLevels
+	}
+	if len(options.PaddingColor) != 3 {
+		options.PaddingColor = defaultPaddingColor
+	}
 
-func New(dataDir string, scanner *image_list.Scanner, tileCache cache.Cache, logger *zap.Logger) *Renderer {
 	return &Renderer{
 		dataDir:   dataDir,
 		scanner:   scanner,
 		tileCache: tileCache,
 		logger:    logger,
+		inflight:  newInflightGroup(),
+		pyramids:  newPyramidIndex(),
+		stats:     newStatsCache(),
+		failures:  newFailureCache(),
+		options:   options,
+
+		pyramidSlot: make(chan struct{}, 1),
+	}
+}
+
+// Rendering counts the tiles being rendered for requests right now
+func (r *Renderer) Rendering() int64 {
+	return r.live.Load()
+}
+
+// CacheStats reports the usage of the tile cache
+func (r *Renderer) CacheStats() cache.Stats {
+	return r.tileCache.Stats()
+}
+
+// SeedTile stores a tile rendered elsewhere, e.g. by another instance. Tiles
+// of unknown images or of another version of the file return
+// ErrImageNotFound.
+func (r *Renderer) SeedTile(ctx context.Context, key cache.TileKey, data []byte) error {
+	if !r.IsCurrent(key.ImageID, key.Fingerprint) {
+		return fmt.Errorf("%w: %s with fingerprint %q", render.ErrImageNotFound, key.ImageID, key.Fingerprint)
+	}
+	r.tileCache.Set(ctx, key, data)
+	return nil
+}
+
+// IsCurrent reports whether tiles cached under an image ID (or comparison
+// pair) and fingerprint belong to images as they are now
+func (r *Renderer) IsCurrent(imageID, fingerprint string) bool {
+	current := ""
+	for n, id := range strings.Split(imageID, "_vs_") {
+		imageInfo := r.scanner.GetImageByID(id)
+		if imageInfo == nil {
+			return false
+		}
+		if n == 0 {
+			current = imageInfo.Fingerprint
+		} else {
+			current = compareFingerprint(current, imageInfo.Fingerprint)
+		}
+	}
+	return fingerprint == current
+}
+
+// InvalidateImage drops everything rendered from an image: cached tiles,
+// statistics and its static pyramid. The scanner calls it for images that
+// were removed or replaced on disk, the handlers right after deleting or
+// replacing one.
+func (r *Renderer) InvalidateImage(imageID string) {
+	r.tileCache.DeleteImage(imageID)
+	r.failures.forget(imageID)
+
+	r.stats.mu.Lock()
+	for key := range r.stats.stats {
+		if strings.HasPrefix(key, imageID+"@") {
+			delete(r.stats.stats, key)
+		}
+	}
+	r.stats.mu.Unlock()
+
+	if r.options.PyramidDir != "" {
+		if err := os.RemoveAll(filepath.Join(r.options.PyramidDir, imageID)); err != nil {
+			r.logger.Warn("Failed to remove static pyramid", zap.String("image", imageID), zap.Error(err))
+		}
 	}
+
+	r.logger.Debug("Invalidated image", zap.String("image", imageID))
 }
 
 func (r *Renderer) CalculateMaxZoom(width, height int) int {
@@ -47,146 +188,399 @@ func (r *Renderer) CalculateMaxZoom(width, height int) int {
 	return maxZoom
 }
 
-func (r *Renderer) RenderTile(imageID string, z, x, y int) (*TileResult, error) {
+// TileRows returns how many tile rows a page of an image has at zoom z, used
+// to flip y for TMS clients (origin at the bottom-left)
+func (r *Renderer) TileRows(imageID string, page, z int) (int, error) {
 	imageInfo := r.scanner.GetImageByID(imageID)
 	if imageInfo == nil {
-		return nil, fmt.Errorf("image not found: %s", imageID)
+		return 0, fmt.Errorf("%w: %s", render.ErrImageNotFound, imageID)
+	}
+	width, height, ok := imageInfo.PageSize(page)
+	if !ok {
+		return 0, fmt.Errorf("%w: page %d of %d", render.ErrTileOutOfBounds, page, imageInfo.PageCount())
 	}
 
-	format := "jpeg"
+	maxZoom := r.CalculateMaxZoom(width, height)
+	if z > maxZoom+r.options.OverzoomLevels {
+		return 0, fmt.Errorf("%w: zoom level %d exceeds max zoom %d", render.ErrTileOutOfBounds, z, maxZoom+r.options.OverzoomLevels)
+	}
 
-	maxZoom := r.CalculateMaxZoom(imageInfo.Width, imageInfo.Height)
-	tileSize := 256.0
+	pixelsPerTile := 256 * math.Pow(2, float64(maxZoom-z))
+	return int(math.Ceil(float64(height) / pixelsPerTile)), nil
+}
 
-	cacheKey := cache.TileKey{
-		ImageID:  imageID,
-		TileSize: int(tileSize),
+// tileKey is the cache key of tile z/x/y of a page
+func tileKey(imageInfo *image_list.ImageInfo, maxZoom, z, x, y int, opts render.TileOptions) cache.TileKey {
+	format := opts.Format
+	if format == "" {
+		format = render.DefaultTileOptions.Format
+	}
+	return cache.TileKey{
+		ImageID:  imageInfo.ID,
+		TileSize: 256,
 		MaxZoom:  maxZoom,
 		Z:        z,
 		X:        x,
 		Y:        y,
 		Format:   format,
+		Variant:  opts.Variant(imageInfo.DisplayRange),
+
+		Fingerprint: imageInfo.Fingerprint,
+	}
+}
+
+// IsTileCached reports whether RenderTile would answer from the cache,
+// without reading the tile
+func (r *Renderer) IsTileCached(ctx context.Context, imageID string, z, x, y int, opts render.TileOptions) bool {
+	imageInfo := r.scanner.GetImageByID(imageID)
+	if imageInfo == nil {
+		return false
+	}
+	pageWidth, pageHeight, ok := imageInfo.PageSize(opts.Page)
+	if !ok {
+		return false
+	}
+	return r.tileCache.Has(ctx, tileKey(imageInfo, r.CalculateMaxZoom(pageWidth, pageHeight), z, x, y, opts))
+}
+
+// RenderTile returns the tile from cache or renders it. Rendering stops early
+// when ctx is done or RENDER_TIMEOUT elapses.
+func (r *Renderer) RenderTile(ctx context.Context, imageID string, z, x, y int, opts render.TileOptions) (*render.TileResult, error) {
+	imageInfo := r.scanner.GetImageByID(imageID)
+	if imageInfo == nil {
+		return nil, fmt.Errorf("%w: %s", render.ErrImageNotFound, imageID)
+	}
+
+	pageWidth, pageHeight, ok := imageInfo.PageSize(opts.Page)
+	if !ok {
+		return nil, fmt.Errorf("%w: page %d of %d", render.ErrTileOutOfBounds, opts.Page, imageInfo.PageCount())
+	}
+
+	maxZoom := r.CalculateMaxZoom(pageWidth, pageHeight)
+
+	region, err := r.pageTileRegion(pageWidth, pageHeight, maxZoom, z, x, y)
+	if err != nil {
+		return nil, err
 	}
 
-	if cached, ok := r.tileCache.Get(cacheKey); ok {
+	cacheKey := tileKey(imageInfo, maxZoom, z, x, y, opts)
+
+	lookupStart := time.Now()
+	cached, ok := r.tileCache.Get(ctx, cacheKey)
+	lookup := time.Since(lookupStart)
+	if ok {
 		etag := r.generateETag(cacheKey)
-		return &TileResult{
+		return withCacheLookup(&render.TileResult{
 			Data: cached,
 			ETag: etag,
 			Size: len(cached),
-		}, nil
+		}, lookup), nil
 	}
 
-	imagePath := r.scanner.GetImagePathByID(imageID)
-	if imagePath == "" {
-		return nil, fmt.Errorf("image path not found for id: %s", imageID)
+	if err := r.failures.check(imageInfo); err != nil {
+		return nil, err
+	}
+
+	if !isWarmup(ctx) {
+		r.live.Add(1)
+		defer r.live.Add(-1)
 	}
 
-	// Load image based on file extension
-	image, err := r.loadImage(imagePath)
+	// Concurrent requests for the same uncached tile share a single render
+	result, err := r.inflight.do(ctx, cacheKey, func(ctx context.Context) (*render.TileResult, error) {
+		if r.options.RenderTimeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, r.options.RenderTimeout)
+			defer cancel()
+		}
+		src := source{
+			path:  r.scanner.GetImagePathByID(imageID),
+			page:  opts.Page,
+			dpi:   imageInfo.DPI,
+			scale: imageInfo.Scale,
+			width: pageWidth,
+
+			fingerprint: imageInfo.Fingerprint,
+		}
+		result, err := r.renderUncached(ctx, imageID, src, cacheKey, region, r.paddingColor(imageInfo), imageInfo.DisplayRange, opts)
+		r.failures.record(imageInfo, err, r.options.FailureTTL)
+		return result, err
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to open image: %w", err)
+		return nil, err
 	}
-	defer image.Close()
+	return withCacheLookup(result, lookup), nil
+}
 
-	if z > maxZoom {
-		return nil, fmt.Errorf("zoom level %d exceeds max zoom %d", z, maxZoom)
+// pageTileRegion returns the source area of a page covered by tile z/x/y
+func (r *Renderer) pageTileRegion(pageWidth, pageHeight, maxZoom, z, x, y int) (tileRegion, error) {
+	if z > maxZoom+r.options.OverzoomLevels {
+		return tileRegion{}, fmt.Errorf("%w: zoom level %d exceeds max zoom %d", render.ErrTileOutOfBounds, z, maxZoom+r.options.OverzoomLevels)
 	}
 
 	// Calculate how many source pixels map to one tile at this zoom level.
 	// At zoom 0, one tile = full image. Each zoom level halves the pixels per tile.
+	// Past maxZoom (overzoom) a tile covers fewer than 256 source pixels and is upscaled.
+	tileSize := 256.0
 	pixelsPerTile := tileSize * math.Pow(2, float64(maxZoom-z))
 
 	// Calculate tile boundaries in source image pixel coordinates.
 	// Clamp to image dimensions to handle edge tiles that extend beyond the image.
 	startX := int(float64(x) * pixelsPerTile)
 	startY := int(float64(y) * pixelsPerTile)
-	endX := int(math.Min(float64(startX)+pixelsPerTile, float64(imageInfo.Width)))
-	endY := int(math.Min(float64(startY)+pixelsPerTile, float64(imageInfo.Height)))
+	endX := int(math.Min(float64(startX)+pixelsPerTile, float64(pageWidth)))
+	endY := int(math.Min(float64(startY)+pixelsPerTile, float64(pageHeight)))
 
 	width := endX - startX
 	height := endY - startY
 	if width <= 0 || height <= 0 {
-		return nil, fmt.Errorf("invalid tile bounds")
+		return tileRegion{}, fmt.Errorf("%w: tile %d/%d/%d", render.ErrTileOutOfBounds, z, x, y)
+	}
+
+	return tileRegion{
+		x:      startX,
+		y:      startY,
+		width:  width,
+		height: height,
+		scale:  tileSize / pixelsPerTile,
+	}, nil
+}
+
+// tileRegion is the source image area covered by a tile and the scale that
+// maps it onto the output tile
+type tileRegion struct {
+	x      int
+	y      int
+	width  int
+	height int
+	scale  float64
+}
+
+// paddingColor returns the image's own padding color, falling back to the global one
+func (r *Renderer) paddingColor(imageInfo *image_list.ImageInfo) []float64 {
+	if imageInfo.PaddingColor != "" {
+		if c, err := render.ParseHexColor(imageInfo.PaddingColor); err == nil {
+			return []float64{float64(c.R), float64(c.G), float64(c.B)}
+		}
+		r.logger.Warn("Invalid padding color in metadata, using default",
+			zap.String("image", imageInfo.ID), zap.String("padding_color", imageInfo.PaddingColor))
+	}
+	return r.options.PaddingColor
+}
+
+// renderUncached decodes the source region, encodes the tile and stores it in the cache.
+// libvips evaluates lazily and can't be interrupted mid-pipeline, so ctx is
+// checked before the expensive stages (open and encode).
+func (r *Renderer) renderUncached(ctx context.Context, imageID string, src source, cacheKey cache.TileKey, region tileRegion, background []float64, displayRange *image_list.DisplayRange, opts render.TileOptions) (*render.TileResult, error) {
+	if src.path == "" {
+		return nil, fmt.Errorf("image path not found for id: %s", imageID)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	timer := newStageTimer()
+
+	// Load image based on file extension, using an embedded pyramid level when available
+	image, region, err := r.openRegion(src, region)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errOpenFailed, err)
+	}
+	defer image.Close()
+
+	// Tile coordinates are in the upright (displayed) orientation, which is
+	// what the scanner records as the image dimensions
+	if image.Orientation() > 1 {
+		if err := image.Autorot(nil); err != nil {
+			return nil, fmt.Errorf("failed to apply orientation: %w", err)
+		}
 	}
+	timer.mark("load")
 
 	// Step 1: Extract the tile region from the source image. This is memory efficient because it doesn't load the entire image into memory.
-	if err := image.ExtractArea(startX, startY, width, height); err != nil {
+	if err := image.ExtractArea(region.x, region.y, region.width, region.height); err != nil {
 		return nil, fmt.Errorf("failed to extract area: %w", err)
 	}
+	timer.mark("extract")
 
 	// Step 2: Scale down to tile size using level-specific scale factor.
 	// This ensures all tiles at the same zoom level have consistent scale.
-	resizeScale := tileSize / pixelsPerTile
-
 	resizeOpts := vips.DefaultResizeOptions()
 	resizeOpts.Kernel = vips.KernelLanczos3
-	if err := image.Resize(resizeScale, resizeOpts); err != nil {
+	if region.scale > 1 {
+		// Overzoom: keep source pixels crisp so they can be inspected individually
+		resizeOpts.Kernel = vips.KernelNearest
+	}
+	if err := image.Resize(region.scale, resizeOpts); err != nil {
 		return nil, fmt.Errorf("failed to resize: %w", err)
 	}
+	timer.mark("resize")
 
-	// Step 3: Pad to exactly 256×256 if needed (edge tiles may be smaller)
-	// Anchor at top-left (0,0) to maintain tile alignment.
-	w := image.Width()
-	h := image.Height()
-	if w < 256 || h < 256 {
-		embedOpts := vips.DefaultEmbedOptions()
-		embedOpts.Extend = vips.ExtendBackground
-		// Use background color for padding, as there is no alpha channel in JPEG
-		embedOpts.Background = []float64{221, 221, 221} // #ddd
-		if err := image.Embed(0, 0, 256, 256, embedOpts); err != nil {
-			return nil, fmt.Errorf("failed to pad: %w", err)
+	keepAlpha := formatSupportsAlpha(cacheKey.Format)
+
+	if len(opts.Bands) > 0 {
+		selected, err := selectBands(image, opts.Bands)
+		if err != nil {
+			return nil, err
+		}
+		defer selected.Close()
+		image = selected
+	}
+
+	if err := r.stretchDisplayRange(ctx, imageID, src.page, image, displayRange, opts.Bands); err != nil {
+		return nil, err
+	}
+
+	// Normalize depth/colourspace so 16-bit, CMYK and alpha sources encode correctly
+	if err := normalizeTile(image, background, keepAlpha); err != nil {
+		return nil, err
+	}
+
+	if opts.Colormap != "" {
+		mapped, err := applyColormap(image, opts.Colormap)
+		if err != nil {
+			return nil, err
 		}
+		defer mapped.Close()
+		image = mapped
+	}
+
+	if err := applyAdjustments(image, opts.Adjustments); err != nil {
+		return nil, err
+	}
+
+	// Step 3: Pad to exactly 256×256 if needed (edge tiles may be smaller)
+	if err := padTile(image, background, keepAlpha); err != nil {
+		return nil, err
 	}
 
-	// Step 4: Export as JPEG, save to cache and return the result
-	jpegOpts := vips.DefaultJpegsaveBufferOptions()
-	jpegOpts.Q = 82
-	jpegOpts.Interlace = false
+	timer.mark("process")
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 
-	tileData, err := image.JpegsaveBuffer(jpegOpts)
+	// Step 4: Encode, save to cache and return the result
+	tileData, err := encodeTile(image, cacheKey.Format)
 	if err != nil {
-		return nil, fmt.Errorf("failed to export: %w", err)
+		return nil, err
 	}
+	timer.mark("encode")
 
-	r.tileCache.Set(cacheKey, tileData)
+	r.tileCache.Set(ctx, cacheKey, tileData)
+
+	logger.FromContext(ctx, r.logger).Debug("Rendered tile",
+		zap.String("image", imageID),
+		zap.Int("z", cacheKey.Z), zap.Int("x", cacheKey.X), zap.Int("y", cacheKey.Y),
+		zap.Int("bytes", len(tileData)))
+	r.logSlowTile(ctx, cacheKey, timer)
 
 	etag := r.generateETag(cacheKey)
-	return &TileResult{
-		Data: tileData,
-		ETag: etag,
-		Size: len(tileData),
+	return &render.TileResult{
+		Data:   tileData,
+		ETag:   etag,
+		Size:   len(tileData),
+		Timing: timer.stages,
 	}, nil
 }
 
+// padTile pads an edge tile to exactly 256×256, anchored at the top-left to
+// maintain tile alignment. Formats with alpha get transparent padding instead
+// of a solid color.
+func padTile(image *vips.Image, background []float64, keepAlpha bool) error {
+	if image.Width() >= 256 && image.Height() >= 256 {
+		return nil
+	}
+
+	embedOpts := vips.DefaultEmbedOptions()
+	embedOpts.Extend = vips.ExtendBackground
+	embedOpts.Background = background
+	if keepAlpha {
+		if !image.HasAlpha() {
+			if err := image.BandjoinConst([]float64{255}); err != nil {
+				return fmt.Errorf("failed to add alpha: %w", err)
+			}
+		}
+		embedOpts.Background = append(append([]float64{}, background...), 0)
+	}
+	if err := image.Embed(0, 0, 256, 256, embedOpts); err != nil {
+		return fmt.Errorf("failed to pad: %w", err)
+	}
+	return nil
+}
+
 func (r *Renderer) generateETag(key cache.TileKey) string {
 	keyStr := fmt.Sprintf("%s_%d_%d/%d/%d/%d.%s", key.ImageID, key.TileSize, key.MaxZoom, key.Z, key.X, key.Y, key.Format)
+	if key.Fingerprint != "" {
+		keyStr += "@" + key.Fingerprint
+	}
+	if key.Variant != "" {
+		keyStr += "?" + key.Variant
+	}
 	hash := sha256.Sum256([]byte(keyStr))
 	return hex.EncodeToString(hash[:])[:16]
 }
 
-func (r *Renderer) GetImageMeta(imageID string) (map[string]interface{}, error) {
+// GetImageMeta describes the tile pyramid of one page of an image
+func (r *Renderer) GetImageMeta(imageID string, page int) (map[string]interface{}, error) {
 	imageInfo := r.scanner.GetImageByID(imageID)
 	if imageInfo == nil {
-		return nil, fmt.Errorf("image not found: %s", imageID)
+		return nil, fmt.Errorf("%w: %s", render.ErrImageNotFound, imageID)
+	}
+
+	width, height, ok := imageInfo.PageSize(page)
+	if !ok {
+		return nil, fmt.Errorf("%w: page %d of %d", render.ErrImageNotFound, page, imageInfo.PageCount())
 	}
 
-	maxZoom := r.CalculateMaxZoom(imageInfo.Width, imageInfo.Height)
+	maxZoom := r.CalculateMaxZoom(width, height)
 
-	return map[string]interface{}{
-		"width":          imageInfo.Width,
-		"height":         imageInfo.Height,
+	meta := map[string]interface{}{
+		"width":          width,
+		"height":         height,
+		"page":           page,
+		"pages":          imageInfo.PageCount(),
 		"tileSize":       256,
 		"maxZoom":        maxZoom,
+		"maxOverzoom":    maxZoom + r.options.OverzoomLevels,
 		"bytes":          imageInfo.Bytes,
-		"format":         "jpeg",
+		"format":         render.DefaultTileOptions.Format,
+		"title":          imageInfo.Title,
 		"copyright_text": imageInfo.CopyrightText,
 		"copyright_link": imageInfo.CopyrightLink,
-	}, nil
+		"placeholder":    imageInfo.Placeholder,
+	}
+	if imageInfo.Slide != nil {
+		meta["slide"] = imageInfo.Slide
+	}
+	if imageInfo.Geo != nil {
+		meta["geo"] = r.geoMeta(imageInfo)
+	}
+	if imageInfo.DisplayRange != nil {
+		meta["display_range"] = imageInfo.DisplayRange
+	}
+	if imageInfo.Photo != nil {
+		meta["photo"] = imageInfo.Photo
+	}
+
+	return meta, nil
+}
+
+// loadMosaic composes a whole mosaic at full resolution. Composition is lazy,
+// so only the pixels later extracted are actually read.
+func (r *Renderer) loadMosaic(src source) (*vips.Image, error) {
+	id := strings.TrimSuffix(filepath.Base(src.path), filepath.Ext(src.path))
+	imageInfo := r.scanner.GetImageByID(id)
+	if imageInfo == nil {
+		return nil, fmt.Errorf("%w: %s", render.ErrImageNotFound, id)
+	}
+	image, _, err := r.openMosaicRegion(src, tileRegion{width: imageInfo.Width, height: imageInfo.Height, scale: 1})
+	return image, err
 }
 
-// loadImage loads an image based on file extension
-func (r *Renderer) loadImage(path string) (*vips.Image, error) {
+// loadImage loads a page of an image based on file extension
+func (r *Renderer) loadImage(src source) (*vips.Image, error) {
+	path := src.path
 	ext := strings.ToLower(filepath.Ext(path))
 
 	// Use AccessRandom for efficient tile extraction from large files
@@ -194,9 +588,7 @@ func (r *Renderer) loadImage(path string) (*vips.Image, error) {
 
 	switch ext {
 	case ".tif", ".tiff":
-		opts := vips.DefaultTiffloadOptions()
-		opts.Access = access
-		return vips.NewTiffload(path, opts)
+		return image_list.LoadTiffPage(path, src.page, access)
 	case ".jpg", ".jpeg":
 		opts := vips.DefaultJpegloadOptions()
 		opts.Access = access
@@ -209,6 +601,18 @@ func (r *Renderer) loadImage(path string) (*vips.Image, error) {
 		opts := vips.DefaultWebploadOptions()
 		opts.Access = access
 		return vips.NewWebpload(path, opts)
+	case ".heic", ".heif":
+		return image_list.LoadHEIF(path, access)
+	case ".jxl":
+		return image_list.LoadJXL(path, access)
+	case ".svg":
+		return image_list.LoadSVG(path, src.scale, access)
+	case ".pdf":
+		return image_list.LoadPDF(path, src.page, src.dpi, access)
+	case ".svs", ".ndpi", ".mrxs", ".scn", ".vms", ".bif":
+		return image_list.LoadSlide(path, 0, access)
+	case ".mosaic":
+		return r.loadMosaic(src)
 	default:
 		return nil, fmt.Errorf("unsupported image format: %s", ext)
 	}
@@ -0,0 +1,220 @@
+package image_renderer
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/cshum/vipsgen/vips"
+	"go.uber.org/zap"
+
+	"gigaview/internal/image_list"
+	"gigaview/internal/logger"
+	"gigaview/internal/render"
+)
+
+// Static pyramids are pre-generated once with dzsave in Google layout, which
+// matches the dynamic tile scheme: 256×256 tiles, no overlap, zoom 0 is a
+// single tile and edge tiles are padded.
+// Structure: {pyramidDir}/{imageID}/{z}/{y}/{x}.jpg
+// The ".complete" marker holds the fingerprint of the source it was built from.
+const staticPyramidComplete = ".complete"
+
+// StaticTilePath returns the pre-generated JPEG for a tile, or "" when the
+// image has no finished static pyramid. Pyramids are built without a display
+// range, so images that have one are always rendered dynamically.
+func (r *Renderer) StaticTilePath(imageID string, z, x, y int) string {
+	if r.options.PyramidDir == "" {
+		return ""
+	}
+	imageInfo := r.scanner.GetImageByID(imageID)
+	if imageInfo == nil || imageInfo.DisplayRange != nil {
+		return ""
+	}
+
+	// A pyramid of a since-replaced file is stale
+	dir := filepath.Join(r.options.PyramidDir, imageID)
+	fingerprint, err := os.ReadFile(filepath.Join(dir, staticPyramidComplete))
+	if err != nil || string(fingerprint) != imageInfo.Fingerprint {
+		return ""
+	}
+
+	path := filepath.Join(dir, fmt.Sprintf("%d", z), fmt.Sprintf("%d", y), fmt.Sprintf("%d.jpg", x))
+	if _, err := os.Stat(path); err != nil {
+		return ""
+	}
+	return path
+}
+
+// GeneratePyramid writes the static tile pyramid for an image. Only one
+// pyramid is generated at a time since dzsave is CPU and I/O heavy.
+func (r *Renderer) GeneratePyramid(ctx context.Context, imageID string) error {
+	if r.options.PyramidDir == "" {
+		return fmt.Errorf("static pyramids are disabled")
+	}
+
+	imageInfo := r.scanner.GetImageByID(imageID)
+	if imageInfo == nil {
+		return fmt.Errorf("%w: %s", render.ErrImageNotFound, imageID)
+	}
+
+	select {
+	case r.pyramidSlot <- struct{}{}:
+		defer func() { <-r.pyramidSlot }()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	log := logger.FromContext(ctx, r.logger)
+	start := time.Now()
+
+	image, background, err := r.openPyramidSource(imageInfo, false)
+	if err != nil {
+		return err
+	}
+	defer image.Close()
+
+	if err := os.MkdirAll(r.options.PyramidDir, 0755); err != nil {
+		return fmt.Errorf("failed to create pyramid directory: %w", err)
+	}
+
+	// Build into a temp directory and swap it in, so readers never see a
+	// half-written pyramid
+	target := filepath.Join(r.options.PyramidDir, imageID)
+	building := target + ".building"
+	os.RemoveAll(building)
+
+	opts := vips.DefaultDzsaveOptions()
+	opts.Layout = vips.DzLayoutGoogle
+	opts.TileSize = 256
+	opts.Overlap = 0
+	opts.Depth = vips.DzDepthOnetile
+	opts.Suffix = ".jpg[Q=82]"
+	opts.Background = background
+
+	if err := image.Dzsave(building, opts); err != nil {
+		os.RemoveAll(building)
+		return fmt.Errorf("failed to generate pyramid: %w", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(building, staticPyramidComplete), []byte(imageInfo.Fingerprint), 0644); err != nil {
+		os.RemoveAll(building)
+		return fmt.Errorf("failed to finalize pyramid: %w", err)
+	}
+
+	os.RemoveAll(target)
+	if err := os.Rename(building, target); err != nil {
+		os.RemoveAll(building)
+		return fmt.Errorf("failed to install pyramid: %w", err)
+	}
+
+	log.Info("Generated static pyramid",
+		zap.String("image", imageID),
+		zap.Duration("duration", time.Since(start)))
+
+	return nil
+}
+
+// openPyramidSource opens the first page of an image upright and as 8-bit
+// sRGB for dzsave; static pyramids only cover the first page of multi-page
+// documents
+func (r *Renderer) openPyramidSource(imageInfo *image_list.ImageInfo, keepAlpha bool) (*vips.Image, []float64, error) {
+	image, err := r.loadImage(source{
+		path:  r.scanner.GetImagePathByID(imageInfo.ID),
+		dpi:   imageInfo.DPI,
+		scale: imageInfo.Scale,
+		width: imageInfo.Width,
+
+		fingerprint: imageInfo.Fingerprint,
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open image: %w", err)
+	}
+
+	if image.Orientation() > 1 {
+		if err := image.Autorot(nil); err != nil {
+			image.Close()
+			return nil, nil, fmt.Errorf("failed to apply orientation: %w", err)
+		}
+	}
+
+	background := r.paddingColor(imageInfo)
+	if err := normalizeTile(image, background, keepAlpha); err != nil {
+		image.Close()
+		return nil, nil, err
+	}
+	return image, background, nil
+}
+
+// Layouts of ExportPyramid
+const (
+	// ExportXYZ writes tiles/{z}/{y}/{x}.{format} in the grid of dynamic
+	// tiles
+	ExportXYZ = "xyz"
+	// ExportDZI writes image.dzi and image_files/ for Deep Zoom viewers
+	// like OpenSeadragon
+	ExportDZI = "dzi"
+)
+
+// ExportPyramid writes a complete static pyramid of an image into dir in
+// layout, with tiles encoded as format ("jpeg", "webp" or "png"), for
+// hosting without a server. It ignores PYRAMID_DIR and the display range.
+func (r *Renderer) ExportPyramid(ctx context.Context, imageID, dir, layout, format string) error {
+	imageInfo := r.scanner.GetImageByID(imageID)
+	if imageInfo == nil {
+		return fmt.Errorf("%w: %s", render.ErrImageNotFound, imageID)
+	}
+
+	var suffix string
+	switch format {
+	case "jpeg":
+		suffix = ".jpg[Q=82]"
+	case "webp":
+		suffix = ".webp[Q=82]"
+	case "png":
+		suffix = ".png"
+	default:
+		return fmt.Errorf("%w: unknown format %q", render.ErrInvalidOptions, format)
+	}
+
+	opts := vips.DefaultDzsaveOptions()
+	opts.TileSize = 256
+	opts.Overlap = 0
+	opts.Suffix = suffix
+	var target string
+	switch layout {
+	case ExportXYZ:
+		opts.Layout = vips.DzLayoutGoogle
+		opts.Depth = vips.DzDepthOnetile
+		target = filepath.Join(dir, "tiles")
+		os.RemoveAll(target)
+	case ExportDZI:
+		opts.Layout = vips.DzLayoutDz
+		// dzsave adds .dzi and _files
+		target = filepath.Join(dir, "image")
+		os.Remove(target + ".dzi")
+		os.RemoveAll(target + "_files")
+	default:
+		return fmt.Errorf("%w: unknown layout %q", render.ErrInvalidOptions, layout)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	image, background, err := r.openPyramidSource(imageInfo, formatSupportsAlpha(format))
+	if err != nil {
+		return err
+	}
+	defer image.Close()
+	opts.Background = background
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create export directory: %w", err)
+	}
+	if err := image.Dzsave(target, opts); err != nil {
+		return fmt.Errorf("failed to export pyramid: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,292 @@
+package image_renderer
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sync"
+
+	"github.com/cshum/vipsgen/vips"
+
+	"gigaview/internal/render"
+)
+
+// statsSampleSize is the longer side of the image sample statistics are
+// computed on. Decoding a gigapixel base for a histogram isn't worth it; the
+// sample keeps native pixel values (nearest-neighbour) so min/max stay real.
+const statsSampleSize = 2048
+
+// histogramBins is the number of histogram buckets per channel
+const histogramBins = 256
+
+// statsCache keeps computed statistics per image version
+type statsCache struct {
+	mu    sync.Mutex
+	stats map[string]*render.ImageStats
+}
+
+func newStatsCache() *statsCache {
+	return &statsCache{stats: make(map[string]*render.ImageStats)}
+}
+
+// ImageStats returns per-channel min/max/mean/stddev and histograms of a page
+func (r *Renderer) ImageStats(ctx context.Context, imageID string, page int) (*render.ImageStats, error) {
+	imageInfo := r.scanner.GetImageByID(imageID)
+	if imageInfo == nil {
+		return nil, fmt.Errorf("%w: %s", render.ErrImageNotFound, imageID)
+	}
+	width, height, ok := imageInfo.PageSize(page)
+	if !ok {
+		return nil, fmt.Errorf("%w: page %d of %d", render.ErrImageNotFound, page, imageInfo.PageCount())
+	}
+
+	key := fmt.Sprintf("%s@%s/%d", imageID, imageInfo.Fingerprint, page)
+	r.stats.mu.Lock()
+	cached, ok := r.stats.stats[key]
+	r.stats.mu.Unlock()
+	if ok {
+		return cached, nil
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	src := source{
+		path:  r.scanner.GetImagePathByID(imageID),
+		page:  page,
+		dpi:   imageInfo.DPI,
+		scale: imageInfo.Scale,
+		width: width,
+
+		fingerprint: imageInfo.Fingerprint,
+	}
+	sample, err := r.openSample(src, width, height)
+	if err != nil {
+		return nil, err
+	}
+	defer sample.Close()
+
+	stats, err := computeStats(sample)
+	if err != nil {
+		return nil, err
+	}
+
+	r.stats.mu.Lock()
+	r.stats.stats[key] = stats
+	r.stats.mu.Unlock()
+
+	return stats, nil
+}
+
+// openSample returns the whole page reduced to at most statsSampleSize,
+// reading from an embedded pyramid level when possible
+func (r *Renderer) openSample(src source, width, height int) (*vips.Image, error) {
+	scale := math.Min(1, statsSampleSize/float64(max(width, height)))
+	region := tileRegion{width: width, height: height, scale: scale}
+
+	image, level, err := r.openRegion(src, region)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open image: %w", err)
+	}
+
+	if err := image.ExtractArea(level.x, level.y, level.width, level.height); err != nil {
+		image.Close()
+		return nil, fmt.Errorf("failed to extract area: %w", err)
+	}
+	if level.scale < 1 {
+		resizeOpts := vips.DefaultResizeOptions()
+		resizeOpts.Kernel = vips.KernelNearest
+		if err := image.Resize(level.scale, resizeOpts); err != nil {
+			image.Close()
+			return nil, fmt.Errorf("failed to resize: %w", err)
+		}
+	}
+	return image, nil
+}
+
+func computeStats(image *vips.Image) (*render.ImageStats, error) {
+	bands := image.Bands()
+	format := image.BandFormat()
+
+	matrix, err := image.Copy(nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute stats: %w", err)
+	}
+	defer matrix.Close()
+	if err := matrix.Stats(); err != nil {
+		return nil, fmt.Errorf("failed to compute stats: %w", err)
+	}
+	// Stats is a (bands+1)×10 double matrix; row 0 covers all bands, then one
+	// row per band: min, max, sum, sum², mean, deviation, xmin, ymin, xmax, ymax
+	values, err := rawFloat64s(matrix)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := &render.ImageStats{
+		Format:       bandFormatName(format),
+		SampleWidth:  image.Width(),
+		SampleHeight: image.Height(),
+		Channels:     make([]render.ChannelStats, bands),
+	}
+	for band := 0; band < bands; band++ {
+		row := values[(band+1)*10 : (band+2)*10]
+		stats.Channels[band] = render.ChannelStats{Min: row[0], Max: row[1], Mean: row[4], StdDev: row[5]}
+	}
+
+	// Integer formats are binned over their full range, floats over the data range
+	stats.RangeMin, stats.RangeMax = formatRange(format)
+	if math.IsNaN(stats.RangeMin) {
+		stats.RangeMin, stats.RangeMax = values[0], values[1]
+	}
+
+	hist, err := image.Copy(nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute histogram: %w", err)
+	}
+	defer hist.Close()
+	if format != vips.BandFormatUchar {
+		span := stats.RangeMax - stats.RangeMin
+		if span <= 0 {
+			span = 1
+		}
+		a := float64(histogramBins-1) / span
+		repeatA := make([]float64, bands)
+		repeatB := make([]float64, bands)
+		for i := range repeatA {
+			repeatA[i] = a
+			repeatB[i] = -stats.RangeMin * a
+		}
+		if err := hist.Linear(repeatA, repeatB, &vips.LinearOptions{Uchar: true}); err != nil {
+			return nil, fmt.Errorf("failed to compute histogram: %w", err)
+		}
+	}
+	if err := hist.HistFind(nil); err != nil {
+		return nil, fmt.Errorf("failed to compute histogram: %w", err)
+	}
+	counts, err := hist.RawsaveBuffer(nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read histogram: %w", err)
+	}
+	if len(counts) < histogramBins*bands*4 {
+		return nil, fmt.Errorf("unexpected histogram size %d", len(counts))
+	}
+	// Band-interleaved: bin 0 of every band, then bin 1, ...
+	for band := 0; band < bands; band++ {
+		histogram := make([]uint32, histogramBins)
+		for bin := range histogram {
+			offset := (bin*bands + band) * 4
+			histogram[bin] = binary.NativeEndian.Uint32(counts[offset : offset+4])
+		}
+		stats.Channels[band].Histogram = histogram
+	}
+
+	return stats, nil
+}
+
+func rawFloat64s(image *vips.Image) ([]float64, error) {
+	data, err := image.RawsaveBuffer(nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read stats: %w", err)
+	}
+	values := make([]float64, len(data)/8)
+	for i := range values {
+		values[i] = math.Float64frombits(binary.NativeEndian.Uint64(data[i*8:]))
+	}
+	return values, nil
+}
+
+// formatRange is the nominal value range of integer band formats, NaN for floats
+func formatRange(format vips.BandFormat) (float64, float64) {
+	switch format {
+	case vips.BandFormatUchar:
+		return 0, math.MaxUint8
+	case vips.BandFormatChar:
+		return math.MinInt8, math.MaxInt8
+	case vips.BandFormatUshort:
+		return 0, math.MaxUint16
+	case vips.BandFormatShort:
+		return math.MinInt16, math.MaxInt16
+	case vips.BandFormatUint:
+		return 0, math.MaxUint32
+	case vips.BandFormatInt:
+		return math.MinInt32, math.MaxInt32
+	}
+	return math.NaN(), math.NaN()
+}
+
+func bandFormatName(format vips.BandFormat) string {
+	switch format {
+	case vips.BandFormatUchar:
+		return "uchar"
+	case vips.BandFormatChar:
+		return "char"
+	case vips.BandFormatUshort:
+		return "ushort"
+	case vips.BandFormatShort:
+		return "short"
+	case vips.BandFormatUint:
+		return "uint"
+	case vips.BandFormatInt:
+		return "int"
+	case vips.BandFormatFloat:
+		return "float"
+	case vips.BandFormatDouble:
+		return "double"
+	}
+	return "other"
+}
+
+// PixelValue reads the native band values at (x, y) in displayed
+// (orientation-corrected) full-resolution coordinates
+func (r *Renderer) PixelValue(ctx context.Context, imageID string, page, x, y int) (*render.PixelValue, error) {
+	imageInfo := r.scanner.GetImageByID(imageID)
+	if imageInfo == nil {
+		return nil, fmt.Errorf("%w: %s", render.ErrImageNotFound, imageID)
+	}
+	width, height, ok := imageInfo.PageSize(page)
+	if !ok {
+		return nil, fmt.Errorf("%w: page %d of %d", render.ErrImageNotFound, page, imageInfo.PageCount())
+	}
+	if x < 0 || y < 0 || x >= width || y >= height {
+		return nil, fmt.Errorf("%w: pixel %d,%d outside %dx%d", render.ErrTileOutOfBounds, x, y, width, height)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	image, err := r.loadImage(source{
+		path:  r.scanner.GetImagePathByID(imageID),
+		page:  page,
+		dpi:   imageInfo.DPI,
+		scale: imageInfo.Scale,
+		width: width,
+
+		fingerprint: imageInfo.Fingerprint,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open image: %w", err)
+	}
+	defer image.Close()
+
+	if image.Orientation() > 1 {
+		if err := image.Autorot(nil); err != nil {
+			return nil, fmt.Errorf("failed to apply orientation: %w", err)
+		}
+	}
+
+	values, err := image.Getpoint(x, y, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pixel: %w", err)
+	}
+
+	return &render.PixelValue{
+		X:      x,
+		Y:      y,
+		Format: bandFormatName(image.BandFormat()),
+		Values: values,
+	}, nil
+}
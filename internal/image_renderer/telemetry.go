@@ -0,0 +1,58 @@
+package image_renderer
+
+import (
+	"context"
+	"runtime"
+	"runtime/pprof"
+	"time"
+
+	"github.com/cshum/vipsgen/vips"
+	"go.uber.org/zap"
+
+	"gigaview/internal/metrics"
+)
+
+// SampleVips reads the memory and file usage of libvips and the threads of
+// the process every interval until ctx is canceled, logs them at debug
+// level and keeps them as gauges in registry. Sampling a running server
+// shows how VIPS_MAX_CACHE_MB and VIPS_CONCURRENCY play out.
+func SampleVips(ctx context.Context, interval time.Duration, registry *metrics.Registry, log *zap.Logger) {
+	if interval <= 0 {
+		return
+	}
+
+	memory := registry.Gauge("gigaview_vips_memory_bytes", "Memory allocated by libvips, including its operation cache")
+	memoryHigh := registry.Gauge("gigaview_vips_memory_highwater_bytes", "Highest memory allocated by libvips since startup")
+	allocations := registry.Gauge("gigaview_vips_allocations", "Live allocations tracked by libvips")
+	files := registry.Gauge("gigaview_vips_open_files", "Files held open by libvips")
+	threads := registry.Gauge("gigaview_os_threads", "Operating system threads created by the process, including libvips workers")
+	goroutines := registry.Gauge("gigaview_goroutines", "Goroutines of the process")
+	threadProfile := pprof.Lookup("threadcreate")
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		var stats vips.MemoryStats
+		vips.ReadVipsMemStats(&stats)
+		memory.Set(float64(stats.Mem))
+		memoryHigh.Set(float64(stats.MemHigh))
+		allocations.Set(float64(stats.Allocs))
+		files.Set(float64(stats.Files))
+		threads.Set(float64(threadProfile.Count()))
+		goroutines.Set(float64(runtime.NumGoroutine()))
+
+		log.Debug("vips usage",
+			zap.Int64("memory", stats.Mem),
+			zap.Int64("memory_highwater", stats.MemHigh),
+			zap.Int64("allocations", stats.Allocs),
+			zap.Int64("open_files", stats.Files),
+			zap.Int("threads", threadProfile.Count()),
+			zap.Int("goroutines", runtime.NumGoroutine()))
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package image_renderer
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+
+	"gigaview/internal/cache"
+	"gigaview/internal/logger"
+	"gigaview/internal/render"
+)
+
+// stageTimer records consecutive stages of a render
+type stageTimer struct {
+	start  time.Time
+	last   time.Time
+	stages []render.TimingStage
+}
+
+func newStageTimer() *stageTimer {
+	now := time.Now()
+	return &stageTimer{start: now, last: now}
+}
+
+// mark ends the current stage under name and starts the next one
+func (t *stageTimer) mark(name string) {
+	now := time.Now()
+	t.stages = append(t.stages, render.TimingStage{Name: name, Duration: now.Sub(t.last)})
+	t.last = now
+}
+
+func (t *stageTimer) total() time.Duration {
+	return t.last.Sub(t.start)
+}
+
+// withCacheLookup returns a copy of a (possibly shared) render result with the
+// cache lookup of this request prepended to its timing
+func withCacheLookup(result *render.TileResult, lookup time.Duration) *render.TileResult {
+	timed := *result
+	timed.Timing = append([]render.TimingStage{{Name: "cache", Duration: lookup}}, result.Timing...)
+	return &timed
+}
+
+// logSlowTile logs the stage breakdown of renders slower than SlowTileThreshold
+func (r *Renderer) logSlowTile(ctx context.Context, key cache.TileKey, timer *stageTimer) {
+	if r.options.SlowTileThreshold <= 0 || timer.total() < r.options.SlowTileThreshold {
+		return
+	}
+
+	fields := []zap.Field{
+		zap.String("image", key.ImageID),
+		zap.Int("z", key.Z), zap.Int("x", key.X), zap.Int("y", key.Y),
+		zap.String("format", key.Format),
+		zap.String("variant", key.Variant),
+		zap.Int64("total_ms", timer.total().Milliseconds()),
+	}
+	for _, stage := range timer.stages {
+		fields = append(fields, zap.Float64(stage.Name+"_ms", float64(stage.Duration.Microseconds())/1000))
+	}
+	logger.FromContext(ctx, r.logger).Info("Slow tile render", fields...)
+}
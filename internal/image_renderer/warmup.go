@@ -0,0 +1,483 @@
+package image_renderer
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"gigaview/internal/image_list"
+	"gigaview/internal/metrics"
+	"gigaview/internal/render"
+)
+
+// WarmupOptions configures a Warmer
+type WarmupOptions struct {
+	// Zoom is the range of zoom levels rendered, see ParseWarmupZoom
+	Zoom WarmupZoom
+	// Images limits warmup to these image IDs (nil = all images). The
+	// Warmup flag of an image overrides it either way.
+	Images []string
+	// Workers is how many tiles are rendered at once (default 1)
+	Workers int
+	// TileOptions selects the format of the rendered tiles
+	TileOptions render.TileOptions
+	// Rate caps the tiles rendered per second (0 = no cap)
+	Rate int
+	// Metrics receives the warmup counters, nil to keep them to Status
+	Metrics *metrics.Registry
+}
+
+// Warmup yields to tiles rendered for requests: before each tile it waits
+// until none are in flight, polling every yieldPoll, but at most maxYield so
+// it still gets on under constant traffic
+const (
+	yieldPoll = 20 * time.Millisecond
+	maxYield  = time.Second
+)
+
+// ZoomBound is a zoom level counted up from 0, the whole image in one tile,
+// or with FromDeepest down from the deepest level of each image
+type ZoomBound struct {
+	Level       int
+	FromDeepest bool
+}
+
+func (b ZoomBound) resolve(maxZoom int) int {
+	if b.FromDeepest {
+		return maxZoom - b.Level
+	}
+	return b.Level
+}
+
+func (b ZoomBound) String() string {
+	switch {
+	case !b.FromDeepest:
+		return strconv.Itoa(b.Level)
+	case b.Level == 0:
+		return "deepest"
+	default:
+		return "deepest-" + strconv.Itoa(b.Level)
+	}
+}
+
+// WarmupZoom is a range of zoom levels, both ends included and clamped to
+// the levels of each image
+type WarmupZoom struct {
+	From, To ZoomBound
+}
+
+// WarmupLevels is the range from the whole image down n levels
+func WarmupLevels(n int) WarmupZoom {
+	return WarmupZoom{To: ZoomBound{Level: n}}
+}
+
+func (z WarmupZoom) String() string {
+	return z.From.String() + ".." + z.To.String()
+}
+
+// ParseWarmupZoom parses a zoom range: "0-3" or "0..3", bounds counted
+// from the deepest level like "deepest-2..deepest", or a single level
+func ParseWarmupZoom(spec string) (WarmupZoom, error) {
+	from, to, ok := strings.Cut(spec, "..")
+	if !ok {
+		// "-" only separates plain numbers, "deepest-2" is one bound
+		if a, b, found := strings.Cut(spec, "-"); found && a != "" && !strings.HasPrefix(a, "deepest") {
+			from, to = a, b
+		} else {
+			to = from
+		}
+	}
+	var zoom WarmupZoom
+	var err error
+	if zoom.From, err = parseZoomBound(from); err != nil {
+		return WarmupZoom{}, err
+	}
+	if zoom.To, err = parseZoomBound(to); err != nil {
+		return WarmupZoom{}, err
+	}
+	return zoom, nil
+}
+
+func parseZoomBound(spec string) (ZoomBound, error) {
+	spec = strings.TrimSpace(spec)
+	if rest, ok := strings.CutPrefix(spec, "deepest"); ok {
+		if rest == "" {
+			return ZoomBound{FromDeepest: true}, nil
+		}
+		if n, err := strconv.Atoi(strings.TrimPrefix(rest, "-")); err == nil && n >= 0 && strings.HasPrefix(rest, "-") {
+			return ZoomBound{Level: n, FromDeepest: true}, nil
+		}
+	} else if n, err := strconv.Atoi(spec); err == nil && n >= 0 {
+		return ZoomBound{Level: n}, nil
+	}
+	return ZoomBound{}, fmt.Errorf("%w: invalid zoom level %q (a number, deepest or deepest-N)", render.ErrInvalidOptions, spec)
+}
+
+// selected reports whether warmup takes an image
+func (o WarmupOptions) selected(imageInfo *image_list.ImageInfo) bool {
+	if imageInfo.Warmup != nil {
+		return *imageInfo.Warmup
+	}
+	return o.Images == nil || slices.Contains(o.Images, imageInfo.ID)
+}
+
+type warmupKey struct{}
+
+// isWarmup reports whether a render was started by warmup
+func isWarmup(ctx context.Context) bool {
+	return ctx.Value(warmupKey{}) != nil
+}
+
+// WarmupStatus reports the progress of the running warmup, or of the last
+// one once it finished
+type WarmupStatus struct {
+	Running bool `json:"running"`
+	// Paused is set while Pause holds warmup, Canceled once a run was
+	// stopped before it finished
+	Paused     bool      `json:"paused"`
+	Canceled   bool      `json:"canceled,omitempty"`
+	StartedAt  time.Time `json:"started_at,omitzero"`
+	FinishedAt time.Time `json:"finished_at,omitzero"`
+	// Images counts the images of the run, ImagesDone those whose tiles
+	// are all cached, rendered or failed
+	Images     int `json:"images"`
+	ImagesDone int `json:"images_done"`
+	// Tiles counts the tiles of the run; Cached were skipped, Rendered and
+	// Failed were rendered by the run
+	Tiles    int `json:"tiles"`
+	Cached   int `json:"cached"`
+	Rendered int `json:"rendered"`
+	Failed   int `json:"failed"`
+	// Levels breaks the tiles down by zoom level, lowest first
+	Levels []WarmupLevel `json:"levels"`
+	// EstimatedCompletion extrapolates the rate tiles were done at so far,
+	// it is only set while a warmup is running
+	EstimatedCompletion time.Time `json:"estimated_completion,omitzero"`
+}
+
+// WarmupLevel is the progress of one zoom level across all images
+type WarmupLevel struct {
+	Zoom  int `json:"zoom"`
+	Tiles int `json:"tiles"`
+	Done  int `json:"done"`
+}
+
+// Warmer renders the first zoom levels of images ahead of requests, so the
+// overview of every image is served from the cache
+type Warmer struct {
+	renderer render.TileRenderer
+	options  WarmupOptions
+	logger   *zap.Logger
+
+	// runMu lets one run go at a time
+	runMu sync.Mutex
+	// ctx and cancel stop the runs started by Enqueue, which runs counts
+	ctx    context.Context
+	cancel context.CancelFunc
+	runs   sync.WaitGroup
+
+	mu     sync.Mutex
+	status WarmupStatus
+	// resumed is closed by Resume, nil while not paused
+	resumed chan struct{}
+
+	rendered, cached, failed *metrics.Counter
+	running                  *metrics.Gauge
+}
+
+func NewWarmer(renderer render.TileRenderer, options WarmupOptions, logger *zap.Logger) *Warmer {
+	if options.Workers <= 0 {
+		options.Workers = 1
+	}
+	registry := options.Metrics
+	if registry == nil {
+		registry = metrics.NewRegistry()
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Warmer{
+		ctx:      ctx,
+		cancel:   cancel,
+		renderer: renderer,
+		options:  options,
+		logger:   logger,
+		rendered: registry.Counter("gigaview_warmup_tiles_rendered_total", "Tiles rendered by warmup"),
+		cached:   registry.Counter("gigaview_warmup_tiles_cached_total", "Tiles warmup found in the cache"),
+		failed:   registry.Counter("gigaview_warmup_tiles_failed_total", "Tiles warmup failed to render"),
+		running:  registry.Gauge("gigaview_warmup_running", "1 while a warmup runs"),
+	}
+}
+
+// Status returns a snapshot of the warmup progress
+func (w *Warmer) Status() WarmupStatus {
+	w.mu.Lock()
+	status := w.status
+	status.Paused = w.resumed != nil
+	// Levels above the range of every image stay empty
+	status.Levels = nil
+	for _, level := range w.status.Levels {
+		if level.Tiles > 0 {
+			status.Levels = append(status.Levels, level)
+		}
+	}
+	w.mu.Unlock()
+
+	done := status.Cached + status.Rendered + status.Failed
+	if status.Running && done > 0 && status.Tiles > done {
+		elapsed := time.Since(status.StartedAt)
+		remaining := time.Duration(float64(elapsed) / float64(done) * float64(status.Tiles-done))
+		status.EstimatedCompletion = time.Now().Add(remaining).Truncate(time.Second)
+	}
+	return status
+}
+
+// warmupTile is one tile to check and render
+type warmupTile struct {
+	image   *warmupImage
+	z, x, y int
+}
+
+// warmupImage counts the tiles of an image still to do
+type warmupImage struct {
+	id        string
+	remaining int
+}
+
+// Run renders the zoom range of the images warmup takes unless they are
+// cached and returns how many tiles failed. Runs started meanwhile wait for
+// it. Canceling ctx stops the run, including the tiles being rendered.
+func (w *Warmer) Run(ctx context.Context, images []image_list.ImageInfo) int {
+	var selected []image_list.ImageInfo
+	for i := range images {
+		if w.options.selected(&images[i]) {
+			selected = append(selected, images[i])
+		}
+	}
+	images = selected
+	if len(images) == 0 {
+		return 0
+	}
+	w.runMu.Lock()
+	defer w.runMu.Unlock()
+	if ctx.Err() != nil {
+		return 0
+	}
+
+	tiles := w.plan(images)
+	status := w.Status()
+	w.logger.Info("Starting tile warmup", zap.Stringer("zoom", w.options.Zoom), zap.Int("images", status.Images), zap.Int("tiles", status.Tiles))
+
+	ctx = context.WithValue(ctx, warmupKey{}, true)
+	var tick <-chan time.Time
+	if w.options.Rate > 0 {
+		ticker := time.NewTicker(time.Second / time.Duration(w.options.Rate))
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	workers := make(chan struct{}, w.options.Workers)
+	var wg sync.WaitGroup
+	for _, tile := range tiles {
+		if !w.waitResumed(ctx) {
+			break
+		}
+		if w.renderer.IsTileCached(ctx, tile.image.id, tile.z, tile.x, tile.y, w.options.TileOptions) {
+			w.done(tile, warmupCached)
+			continue
+		}
+
+		select {
+		case workers <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if tick != nil {
+			select {
+			case <-tick:
+			case <-ctx.Done():
+			}
+		}
+		w.yield(ctx)
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-workers }()
+
+			_, err := w.renderer.RenderTile(ctx, tile.image.id, tile.z, tile.x, tile.y, w.options.TileOptions)
+			if ctx.Err() != nil {
+				// Canceled, neither done nor failed
+				return
+			}
+			if err != nil {
+				w.logger.Debug("Warmup tile failed", zap.String("image", tile.image.id), zap.Int("z", tile.z), zap.Int("x", tile.x), zap.Int("y", tile.y), zap.Error(err))
+				w.done(tile, warmupFailed)
+				return
+			}
+			w.done(tile, warmupRendered)
+		}()
+	}
+	wg.Wait()
+
+	w.mu.Lock()
+	w.status.Running = false
+	w.status.Canceled = ctx.Err() != nil
+	w.status.FinishedAt = time.Now()
+	status = w.status
+	w.mu.Unlock()
+	w.running.Set(0)
+
+	if status.Canceled {
+		w.logger.Info("Tile warmup canceled", zap.Int("total_tiles", status.Tiles), zap.Int("skipped_cached", status.Cached), zap.Int("rendered", status.Rendered), zap.Int("failed", status.Failed))
+		return status.Failed
+	}
+
+	w.logger.Info("Tile warmup completed", zap.Int("total_tiles", status.Tiles), zap.Int("skipped_cached", status.Cached), zap.Int("rendered", status.Rendered), zap.Int("failed", status.Failed))
+	return status.Failed
+}
+
+// yield waits while tiles are rendered for requests, see maxYield
+func (w *Warmer) yield(ctx context.Context) {
+	deadline := time.Now().Add(maxYield)
+	for w.renderer.Rendering() > 0 && time.Now().Before(deadline) && ctx.Err() == nil {
+		time.Sleep(yieldPoll)
+	}
+}
+
+// Pause keeps warmup from starting more tiles until Resume; the tiles being
+// rendered finish
+func (w *Warmer) Pause() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.resumed == nil {
+		w.resumed = make(chan struct{})
+		w.logger.Info("Tile warmup paused")
+	}
+}
+
+// Resume lets a paused warmup go on
+func (w *Warmer) Resume() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.resumed != nil {
+		close(w.resumed)
+		w.resumed = nil
+		w.logger.Info("Tile warmup resumed")
+	}
+}
+
+// waitResumed blocks while warmup is paused and reports whether to go on,
+// false once ctx is done
+func (w *Warmer) waitResumed(ctx context.Context) bool {
+	w.mu.Lock()
+	resumed := w.resumed
+	w.mu.Unlock()
+	if resumed != nil {
+		select {
+		case <-resumed:
+		case <-ctx.Done():
+		}
+	}
+	return ctx.Err() == nil
+}
+
+// Enqueue warms up images in the background once the runs before them are
+// done, e.g. the library after the first scan or an image right after its
+// upload. Close cancels it.
+func (w *Warmer) Enqueue(images ...image_list.ImageInfo) {
+	w.runs.Add(1)
+	go func() {
+		defer w.runs.Done()
+		w.Run(w.ctx, images)
+	}()
+}
+
+// Close cancels the runs started by Enqueue and waits for them to stop, so
+// no tile is rendered past it (e.g. while libvips shuts down)
+func (w *Warmer) Close() {
+	w.cancel()
+	w.runs.Wait()
+}
+
+// plan lists the tiles of a run, image by image and level by level, and
+// resets the status
+func (w *Warmer) plan(images []image_list.ImageInfo) []warmupTile {
+	var tiles []warmupTile
+	var levels []WarmupLevel
+	imagesDone := 0
+	for _, img := range images {
+		maxZoom := w.renderer.CalculateMaxZoom(img.Width, img.Height)
+		from := max(w.options.Zoom.From.resolve(maxZoom), 0)
+		to := min(w.options.Zoom.To.resolve(maxZoom), maxZoom)
+		image := &warmupImage{id: img.ID}
+
+		for z := from; z <= to; z++ {
+			scale := 256 * math.Pow(2, float64(maxZoom-z))
+			tilesX := int(math.Ceil(float64(img.Width) / scale))
+			tilesY := int(math.Ceil(float64(img.Height) / scale))
+
+			for len(levels) <= z {
+				levels = append(levels, WarmupLevel{Zoom: len(levels)})
+			}
+			levels[z].Tiles += tilesX * tilesY
+			image.remaining += tilesX * tilesY
+			for x := 0; x < tilesX; x++ {
+				for y := 0; y < tilesY; y++ {
+					tiles = append(tiles, warmupTile{image: image, z: z, x: x, y: y})
+				}
+			}
+		}
+		if image.remaining == 0 {
+			imagesDone++
+		}
+	}
+
+	w.mu.Lock()
+	w.status = WarmupStatus{
+		Running:    true,
+		StartedAt:  time.Now(),
+		Images:     len(images),
+		ImagesDone: imagesDone,
+		Tiles:      len(tiles),
+		Levels:     levels,
+	}
+	w.mu.Unlock()
+	w.running.Set(1)
+	return tiles
+}
+
+// Outcomes of a warmup tile
+const (
+	warmupCached = iota
+	warmupRendered
+	warmupFailed
+)
+
+// done records the outcome of a tile in the status and counters
+func (w *Warmer) done(tile warmupTile, outcome int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	switch outcome {
+	case warmupCached:
+		w.cached.Inc()
+		w.status.Cached++
+	case warmupRendered:
+		w.rendered.Inc()
+		w.status.Rendered++
+	case warmupFailed:
+		w.failed.Inc()
+		w.status.Failed++
+	}
+	w.status.Levels[tile.z].Done++
+	tile.image.remaining--
+	if tile.image.remaining == 0 {
+		w.status.ImagesDone++
+	}
+}
@@ -0,0 +1,181 @@
+// Package library sets up what serving a library of images takes: libvips,
+// the storage backends, the scanner, the tile cache and the renderer
+package library
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/cshum/vipsgen/vips"
+	"go.uber.org/zap"
+
+	"gigaview/internal/cache"
+	"gigaview/internal/config"
+	"gigaview/internal/image_list"
+	"gigaview/internal/image_renderer"
+	"gigaview/internal/render"
+	"gigaview/internal/storage"
+)
+
+// Library is the scanner, cache and renderer of a data directory
+type Library struct {
+	Scanner  *image_list.Scanner
+	Cache    cache.Cache
+	Renderer render.TileRenderer
+
+	// closers run in reverse order on Close
+	closers []func()
+}
+
+// Open starts libvips and sets up the library described by cfg. libvips is
+// process-wide, so there can only be one open Library at a time.
+func Open(cfg *config.Config, log *zap.Logger) (*Library, error) {
+	padding, err := render.ParseHexColor(cfg.PaddingColor)
+	if err != nil {
+		return nil, fmt.Errorf("invalid PADDING_COLOR: %w", err)
+	}
+	paddingColor := []float64{float64(padding.R), float64(padding.G), float64(padding.B)}
+
+	originals, err := storage.NewStorage(storage.Options{
+		Backend:     cfg.StorageBackend,
+		Dir:         cfg.StorageDir,
+		S3Endpoint:  cfg.S3Endpoint,
+		S3Region:    cfg.S3Region,
+		S3Bucket:    cfg.S3Bucket,
+		S3Prefix:    cfg.S3Prefix,
+		S3AccessKey: cfg.S3AccessKey,
+		S3SecretKey: cfg.S3SecretKey,
+	}, log)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize storage: %w", err)
+	}
+
+	tileCache, err := OpenCache(cfg, log)
+	if err != nil {
+		return nil, err
+	}
+	l := &Library{Cache: tileCache}
+	if closer, ok := tileCache.(io.Closer); ok {
+		l.closers = append(l.closers, func() { closer.Close() })
+	}
+
+	vipsConfig := &vips.Config{
+		ConcurrencyLevel: cfg.VipsConcurrency,
+		MaxCacheMem:      cfg.VipsMaxCacheMB * 1024 * 1024, // Convert MB to bytes
+		MaxCacheFiles:    0,                                // Disable disk cache
+		MaxCacheSize:     0,                                // Disable disk cache
+		ReportLeaks:      false,
+		CacheTrace:       false,
+		VectorEnabled:    true,
+	}
+
+	// Set up logging
+	vips.SetLogging(func(domain string, level vips.LogLevel, message string) {
+		// Map vips log levels to zap levels
+		if level >= vips.LogLevelError {
+			log.Error("vips", zap.String("domain", domain), zap.Int("level", int(level)), zap.String("message", message))
+		} else if level >= vips.LogLevelWarning {
+			log.Warn("vips", zap.String("domain", domain), zap.Int("level", int(level)), zap.String("message", message))
+		}
+		// Ignore info/debug messages to keep logs clean
+	}, vips.LogLevelError)
+
+	vips.Startup(vipsConfig)
+	l.closers = append(l.closers, vips.Shutdown)
+
+	log.Info("VIPS initialized",
+		zap.Int("max_cache_mb", cfg.VipsMaxCacheMB),
+		zap.Int("concurrency", cfg.VipsConcurrency),
+	)
+
+	var remote *storage.Remote
+	if len(cfg.RemoteURLPrefixes) > 0 {
+		remote = storage.NewRemote(cfg.RemoteURLPrefixes, cfg.MaxUploadSize, cfg.RemoteTimeout)
+	}
+
+	l.Scanner = image_list.New(cfg.DataDir, image_list.Options{
+		PDFDPI: cfg.PDFDPI,
+		HEIF:   cfg.EnableHEIF,
+		JXL:    cfg.EnableJXL,
+
+		SVGTargetSize: cfg.SVGTargetSize,
+		RAW:           cfg.EnableRAW,
+		ThumbnailSize: cfg.ThumbnailSize,
+		ThumbnailCrop: cfg.ThumbnailCrop,
+		Recursive:     cfg.ScanRecursive,
+		ExcludeDirs:   []string{cfg.CacheFileDir, cfg.PyramidDir, cfg.MetadataDir},
+		IndexPath:     cfg.IndexFile,
+		Workers:       cfg.ScanWorkers,
+		AlbumsPath:    cfg.AlbumsFile,
+		MaxPixels:     cfg.MaxImagePixels,
+		MaxDimension:  cfg.MaxImageDimension,
+		Storage:       originals,
+		StagingBytes:  int64(cfg.StagingMaxSizeMB) << 20,
+		Remote:        remote,
+		PreserveNames: cfg.PreserveFilenames,
+		MetadataDir:   cfg.MetadataDir,
+		ReadOnly:      cfg.ReadOnly,
+	}, log)
+	l.closers = append(l.closers, func() { l.Scanner.Close() })
+
+	l.Renderer, err = image_renderer.NewTileRenderer(cfg.DataDir, l.Scanner, l.Cache, image_renderer.Options{
+		Backend:        cfg.RenderBackend,
+		RenderTimeout:  cfg.RenderTimeout,
+		OverzoomLevels: cfg.OverzoomLevels,
+		PaddingColor:   paddingColor,
+		PyramidDir:     cfg.PyramidDir,
+
+		AutoDisplayRange:  cfg.AutoDisplayRange,
+		SlowTileThreshold: cfg.SlowTileLog,
+		FailureTTL:        cfg.RenderErrorTTL,
+	}, log)
+	if err != nil {
+		l.Close()
+		return nil, err
+	}
+	l.Scanner.OnInvalidate(l.Renderer.InvalidateImage)
+
+	return l, nil
+}
+
+// OpenCache sets up the configured tile cache on its own, for maintenance
+// that doesn't render; the caller closes it if it is an io.Closer
+func OpenCache(cfg *config.Config, log *zap.Logger) (cache.Cache, error) {
+	tileCache, err := cache.NewCache(cache.Options{
+		Type:        cfg.CacheType,
+		FileDir:     cfg.CacheFileDir,
+		MemoryTiles: cfg.CacheMemoryTiles,
+		MemoryBytes: int64(cfg.CacheMemoryMB) << 20,
+		TTL:         cfg.CacheTTL,
+		FileBytes:   int64(cfg.CacheFileMaxMB) << 20,
+		BoltPath:    cfg.CacheBoltFile,
+		S3: storage.Options{
+			S3Endpoint:  cfg.S3Endpoint,
+			S3Region:    cfg.S3Region,
+			S3Bucket:    cfg.CacheS3Bucket,
+			S3Prefix:    cfg.CacheS3Prefix,
+			S3AccessKey: cfg.S3AccessKey,
+			S3SecretKey: cfg.S3SecretKey,
+		},
+	}, log)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize cache: %w", err)
+	}
+	return tileCache, nil
+}
+
+// Close releases the library, libvips last but one and the cache last
+func (l *Library) Close() {
+	for i := len(l.closers) - 1; i >= 0; i-- {
+		l.closers[i]()
+	}
+}
+
+// WarmupZoom returns the zoom range of WARMUP_ZOOM, or from the whole image
+// down WARMUP_LEVELS levels
+func WarmupZoom(cfg *config.Config) (image_renderer.WarmupZoom, error) {
+	if cfg.WarmupZoom == "" {
+		return image_renderer.WarmupLevels(cfg.WarmupLevels), nil
+	}
+	return image_renderer.ParseWarmupZoom(cfg.WarmupZoom)
+}
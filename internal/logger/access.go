@@ -0,0 +1,28 @@
+package logger
+
+import (
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// NewAccessLogger returns the logger used for per-request access lines.
+// With an empty path access lines go to the application logger, otherwise
+// they are written as JSON to a size-rotated file.
+func NewAccessLogger(path string, maxSizeMB, maxBackups int, appLogger *zap.Logger) *zap.Logger {
+	if path == "" {
+		return appLogger
+	}
+
+	writer := &lumberjack.Logger{
+		Filename:   path,
+		MaxSize:    maxSizeMB,
+		MaxBackups: maxBackups,
+	}
+
+	encoderConfig := zap.NewProductionEncoderConfig()
+	encoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
+
+	core := zapcore.NewCore(zapcore.NewJSONEncoder(encoderConfig), zapcore.AddSync(writer), zapcore.InfoLevel)
+	return zap.New(core)
+}
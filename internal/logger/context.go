@@ -0,0 +1,30 @@
+package logger
+
+import (
+	"context"
+
+	"go.uber.org/zap"
+)
+
+type requestIDKey struct{}
+
+// WithRequestID stores the request ID in the context
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, requestID)
+}
+
+// RequestID returns the request ID stored in the context, or "" if there is none
+func RequestID(ctx context.Context) string {
+	if requestID, ok := ctx.Value(requestIDKey{}).(string); ok {
+		return requestID
+	}
+	return ""
+}
+
+// FromContext returns log annotated with the request ID from ctx, if any
+func FromContext(ctx context.Context, log *zap.Logger) *zap.Logger {
+	if requestID := RequestID(ctx); requestID != "" {
+		return log.With(zap.String("request_id", requestID))
+	}
+	return log
+}
@@ -5,26 +5,62 @@ import (
 	"go.uber.org/zap/zapcore"
 )
 
-func New(level string) (*zap.Logger, error) {
-	var zapLevel zapcore.Level
+// Options configures the application logger
+type Options struct {
+	// Level can be changed while the logger runs
+	Level zap.AtomicLevel
+	// Format is "json" (default) or "console", human-readable lines for
+	// local development
+	Format string
+	// Output lists "stdout", "stderr" or file paths to write to (default
+	// stdout)
+	Output []string
+}
+
+// ParseLevel maps a LOG_LEVEL value to a zap level, info for unknown names
+func ParseLevel(level string) zapcore.Level {
 	switch level {
 	case "debug":
-		zapLevel = zapcore.DebugLevel
+		return zapcore.DebugLevel
 	case "warn":
-		zapLevel = zapcore.WarnLevel
+		return zapcore.WarnLevel
 	case "error":
-		zapLevel = zapcore.ErrorLevel
+		return zapcore.ErrorLevel
 	default:
-		zapLevel = zapcore.InfoLevel
+		return zapcore.InfoLevel
 	}
+}
 
+// New builds the application logger
+func New(options Options) (*zap.Logger, error) {
 	config := zap.NewProductionConfig()
-	config.Level = zap.NewAtomicLevelAt(zapLevel)
+	config.Level = options.Level
 	config.Encoding = "json"
 	config.OutputPaths = []string{"stdout"}
+	if len(options.Output) > 0 {
+		config.OutputPaths = options.Output
+	}
 	config.ErrorOutputPaths = []string{"stderr"}
 
 	config.EncoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
 
+	if options.Format == "console" {
+		config.Encoding = "console"
+		config.EncoderConfig = zap.NewDevelopmentEncoderConfig()
+		// Colors only help on a terminal and garble files
+		if onlyStandardStreams(config.OutputPaths) {
+			config.EncoderConfig.EncodeLevel = zapcore.CapitalColorLevelEncoder
+		}
+	}
+
 	return config.Build()
 }
+
+func onlyStandardStreams(paths []string) bool {
+	for _, path := range paths {
+		if path != "stdout" && path != "stderr" {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,98 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// Gauge is a value that goes up and down
+type Gauge struct {
+	bits atomic.Uint64
+}
+
+func (g *Gauge) Set(value float64) {
+	g.bits.Store(math.Float64bits(value))
+}
+
+func (g *Gauge) Value() float64 {
+	return math.Float64frombits(g.bits.Load())
+}
+
+// Counter is a value that only goes up
+type Counter struct {
+	value atomic.Uint64
+}
+
+func (c *Counter) Add(n uint64) {
+	c.value.Add(n)
+}
+
+func (c *Counter) Inc() {
+	c.value.Add(1)
+}
+
+func (c *Counter) Value() uint64 {
+	return c.value.Load()
+}
+
+// metric is one registered gauge or counter
+type metric struct {
+	name  string
+	help  string
+	kind  string
+	value func() string
+}
+
+// Registry holds the metrics of the server and writes them in the
+// Prometheus text format
+type Registry struct {
+	mu      sync.Mutex
+	metrics []metric
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Gauge registers a gauge; names follow Prometheus conventions, e.g.
+// gigaview_vips_memory_bytes
+func (r *Registry) Gauge(name, help string) *Gauge {
+	g := &Gauge{}
+	r.register(metric{name: name, help: help, kind: "gauge", value: func() string {
+		return strconv.FormatFloat(g.Value(), 'g', -1, 64)
+	}})
+	return g
+}
+
+// Counter registers a counter; its name should end in _total
+func (r *Registry) Counter(name, help string) *Counter {
+	c := &Counter{}
+	r.register(metric{name: name, help: help, kind: "counter", value: func() string {
+		return strconv.FormatUint(c.Value(), 10)
+	}})
+	return c
+}
+
+func (r *Registry) register(m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.metrics = append(r.metrics, m)
+}
+
+// Write writes all metrics in the Prometheus text exposition format
+func (r *Registry) Write(w io.Writer) error {
+	r.mu.Lock()
+	metrics := r.metrics
+	r.mu.Unlock()
+
+	for _, m := range metrics {
+		if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %s\n", m.name, m.help, m.name, m.kind, m.name, m.value()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
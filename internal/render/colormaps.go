@@ -0,0 +1,77 @@
+package render
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// colormapStops are evenly spaced sRGB stops of the supported colormaps,
+// interpolated linearly into a 256-entry lookup table. The perceptual maps are
+// sampled from matplotlib, the single-hue ramps suit fluorescence channels.
+var colormapStops = map[string][][3]float64{
+	"gray":    {{0, 0, 0}, {255, 255, 255}},
+	"red":     {{0, 0, 0}, {255, 0, 0}},
+	"green":   {{0, 0, 0}, {0, 255, 0}},
+	"blue":    {{0, 0, 0}, {0, 0, 255}},
+	"cyan":    {{0, 0, 0}, {0, 255, 255}},
+	"magenta": {{0, 0, 0}, {255, 0, 255}},
+	"yellow":  {{0, 0, 0}, {255, 255, 0}},
+	"viridis": {
+		{0x44, 0x01, 0x54}, {0x48, 0x24, 0x75}, {0x41, 0x44, 0x87}, {0x35, 0x5f, 0x8d},
+		{0x2a, 0x78, 0x8e}, {0x21, 0x91, 0x8c}, {0x22, 0xa8, 0x84}, {0x44, 0xbf, 0x70},
+		{0x7a, 0xd1, 0x51}, {0xbd, 0xdf, 0x26}, {0xfd, 0xe7, 0x25},
+	},
+	"magma": {
+		{0x00, 0x00, 0x04}, {0x14, 0x0e, 0x36}, {0x3b, 0x0f, 0x70}, {0x64, 0x1a, 0x80},
+		{0x8c, 0x29, 0x81}, {0xb7, 0x37, 0x79}, {0xde, 0x49, 0x68}, {0xf7, 0x70, 0x5c},
+		{0xfe, 0x9f, 0x6d}, {0xfe, 0xcf, 0x92}, {0xfc, 0xfd, 0xbf},
+	},
+	"inferno": {
+		{0x00, 0x00, 0x04}, {0x16, 0x0b, 0x39}, {0x42, 0x0a, 0x68}, {0x6a, 0x17, 0x6e},
+		{0x93, 0x26, 0x67}, {0xbc, 0x37, 0x54}, {0xdd, 0x51, 0x3a}, {0xf3, 0x78, 0x19},
+		{0xfc, 0xa5, 0x0a}, {0xf6, 0xd7, 0x46}, {0xfc, 0xff, 0xa4},
+	},
+	"plasma": {
+		{0x0d, 0x08, 0x87}, {0x41, 0x04, 0x9d}, {0x6a, 0x00, 0xa8}, {0x8f, 0x0d, 0xa4},
+		{0xb1, 0x2a, 0x90}, {0xcc, 0x47, 0x78}, {0xe1, 0x64, 0x62}, {0xf2, 0x84, 0x4b},
+		{0xfc, 0xa6, 0x36}, {0xfc, 0xce, 0x25}, {0xf0, 0xf9, 0x21},
+	},
+}
+
+// ColormapStops returns the stops of a colormap, nil for unknown names
+func ColormapStops(name string) [][3]float64 {
+	return colormapStops[name]
+}
+
+// Colormaps lists the supported colormap names, sorted
+func Colormaps() []string {
+	names := make([]string, 0, len(colormapStops))
+	for name := range colormapStops {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ValidateBands checks a band selection: one band (optionally colormapped)
+// or three bands composited as RGB. Band numbers are 1-based.
+func ValidateBands(bands []int, colormap string) error {
+	if len(bands) != 0 && len(bands) != 1 && len(bands) != 3 {
+		return fmt.Errorf("bands must list 1 or 3 bands")
+	}
+	for _, band := range bands {
+		if band < 1 {
+			return fmt.Errorf("bands are numbered from 1")
+		}
+	}
+	if colormap != "" {
+		if _, ok := colormapStops[colormap]; !ok {
+			return fmt.Errorf("unknown colormap, expected one of %s", strings.Join(Colormaps(), ", "))
+		}
+		if len(bands) == 3 {
+			return fmt.Errorf("colormap needs a single band")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,186 @@
+package render
+
+import (
+	"fmt"
+	"image/color"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// TileOptions are per-request render options
+type TileOptions struct {
+	// Format is the output encoding: "jpeg", "webp" or "png"
+	Format      string
+	Adjustments Adjustments
+	// Page selects the page of multi-page documents (0-based)
+	Page int
+	// Bands selects 1 or 3 source bands (1-based) for multichannel images
+	Bands []int
+	// Colormap renders the first selected band in false color
+	Colormap string
+}
+
+// DefaultTileOptions renders the tile as-is
+var DefaultTileOptions = TileOptions{Format: "jpeg", Adjustments: NoAdjustments}
+
+// Variant returns the cache key variant for these options on an image with
+// the given explicit display range (nil = none)
+func (o TileOptions) Variant(displayRange *DisplayRange) string {
+	var parts []string
+	if o.Page > 0 {
+		parts = append(parts, fmt.Sprintf("p%d", o.Page))
+	}
+	if key := bandsKey(o.Bands, o.Colormap); key != "" {
+		parts = append(parts, key)
+	}
+	if displayRange != nil {
+		parts = append(parts, "r"+formatAdjustment(displayRange.Min)+"_"+formatAdjustment(displayRange.Max))
+	}
+	if key := o.Adjustments.Key(); key != "" {
+		parts = append(parts, key)
+	}
+	return strings.Join(parts, "-")
+}
+
+// bandsKey encodes the band selection for cache keys
+func bandsKey(bands []int, colormap string) string {
+	var parts []string
+	if len(bands) > 0 {
+		numbers := make([]string, len(bands))
+		for i, band := range bands {
+			numbers[i] = strconv.Itoa(band)
+		}
+		parts = append(parts, "ch"+strings.Join(numbers, "."))
+	}
+	if colormap != "" {
+		parts = append(parts, "cm"+colormap)
+	}
+	return strings.Join(parts, "-")
+}
+
+// Adjustments are non-destructive visual tweaks applied per tile.
+// The zero value is not neutral, use NoAdjustments.
+type Adjustments struct {
+	Brightness float64 // additive offset, -1..1 (0 = unchanged)
+	Contrast   float64 // multiplier around mid-grey, 0..4 (1 = unchanged)
+	Gamma      float64 // 0.1..10 (1 = unchanged, >1 brightens midtones)
+	Saturation float64 // 0..4 (1 = unchanged, 0 = greyscale)
+	Grayscale  bool
+}
+
+// NoAdjustments leaves tiles untouched
+var NoAdjustments = Adjustments{Contrast: 1, Gamma: 1, Saturation: 1}
+
+// IsNeutral reports whether applying the adjustments would change nothing
+func (a Adjustments) IsNeutral() bool {
+	return a == NoAdjustments
+}
+
+// Validate checks that every value is within its supported range
+func (a Adjustments) Validate() error {
+	switch {
+	case a.Brightness < -1 || a.Brightness > 1:
+		return fmt.Errorf("brightness must be between -1 and 1")
+	case a.Contrast < 0 || a.Contrast > 4:
+		return fmt.Errorf("contrast must be between 0 and 4")
+	case a.Gamma < 0.1 || a.Gamma > 10:
+		return fmt.Errorf("gamma must be between 0.1 and 10")
+	case a.Saturation < 0 || a.Saturation > 4:
+		return fmt.Errorf("saturation must be between 0 and 4")
+	}
+	return nil
+}
+
+// Key returns a canonical, filesystem-safe encoding used in cache keys.
+// Neutral adjustments encode to "" so they share the plain tile cache.
+func (a Adjustments) Key() string {
+	var parts []string
+	if a.Brightness != NoAdjustments.Brightness {
+		parts = append(parts, "b"+formatAdjustment(a.Brightness))
+	}
+	if a.Contrast != NoAdjustments.Contrast {
+		parts = append(parts, "c"+formatAdjustment(a.Contrast))
+	}
+	if a.Gamma != NoAdjustments.Gamma {
+		parts = append(parts, "g"+formatAdjustment(a.Gamma))
+	}
+	if a.Saturation != NoAdjustments.Saturation {
+		parts = append(parts, "s"+formatAdjustment(a.Saturation))
+	}
+	if a.Grayscale {
+		parts = append(parts, "gray")
+	}
+	return strings.Join(parts, "-")
+}
+
+// RoundAdjustment quantizes a value to two decimals so near-identical
+// requests don't fragment the cache
+func RoundAdjustment(value float64) float64 {
+	return math.Round(value*100) / 100
+}
+
+func formatAdjustment(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+const (
+	// CompareDiff renders the per-channel absolute difference of two images
+	CompareDiff = "diff"
+	// CompareBlend renders the second image over the first at CompareOptions.Opacity
+	CompareBlend = "blend"
+)
+
+// CompareOptions selects how two images are combined into one tile
+type CompareOptions struct {
+	// Mode is CompareDiff or CompareBlend
+	Mode string
+	// Opacity is the weight of the second image in blend mode (0-1)
+	Opacity float64
+}
+
+// DefaultCompareOptions shows the difference; blend mode weighs both images evenly
+var DefaultCompareOptions = CompareOptions{Mode: CompareDiff, Opacity: 0.5}
+
+// Validate checks the mode and opacity
+func (o CompareOptions) Validate() error {
+	if o.Mode != CompareDiff && o.Mode != CompareBlend {
+		return fmt.Errorf("mode must be %s or %s", CompareDiff, CompareBlend)
+	}
+	if o.Opacity < 0 || o.Opacity > 1 {
+		return fmt.Errorf("opacity must be between 0 and 1")
+	}
+	return nil
+}
+
+// Key encodes the options for cache keys
+func (o CompareOptions) Key() string {
+	if o.Mode == CompareBlend {
+		return "blend" + formatAdjustment(o.Opacity)
+	}
+	return o.Mode
+}
+
+// DisplayRange is the source value range mapped linearly onto 0-255
+type DisplayRange struct {
+	Min float64 `json:"min"`
+	Max float64 `json:"max"`
+}
+
+// ParseHexColor parses #rgb or #rrggbb
+func ParseHexColor(value string) (color.RGBA, error) {
+	hex := strings.TrimPrefix(strings.TrimSpace(value), "#")
+	if len(hex) == 3 {
+		hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+	}
+	if len(hex) != 6 {
+		return color.RGBA{}, fmt.Errorf("invalid color: %s", value)
+	}
+
+	rgb, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return color.RGBA{}, fmt.Errorf("invalid color: %s", value)
+	}
+
+	return color.RGBA{R: uint8(rgb >> 16), G: uint8(rgb >> 8), B: uint8(rgb), A: 255}, nil
+}
@@ -0,0 +1,68 @@
+// Package render defines what the HTTP handlers need from a tile renderer:
+// the TileRenderer interface with its options, results and errors. It
+// doesn't depend on libvips, so renderers without cgo can implement it.
+package render
+
+import (
+	"context"
+	"errors"
+
+	"gigaview/internal/cache"
+)
+
+var (
+	// ErrImageNotFound is returned when the requested image ID is unknown
+	ErrImageNotFound = errors.New("image not found")
+	// ErrTileOutOfBounds is returned when the tile lies outside the image pyramid
+	ErrTileOutOfBounds = errors.New("tile out of bounds")
+	// ErrInvalidOptions is returned when render options don't fit the image,
+	// e.g. a band the image doesn't have
+	ErrInvalidOptions = errors.New("invalid render options")
+	// ErrRenderFailed is returned for tiles of an image that failed to render
+	// shortly before, without trying again
+	ErrRenderFailed = errors.New("image failed to render recently")
+)
+
+// TileRenderer turns the images of the scanner into tiles and metadata. The
+// libvips renderer of image_renderer is the only backend so far; others (a pure-Go decoder
+// for small images, a client of a render farm) implement what they support
+// and return ErrInvalidOptions for the rest.
+type TileRenderer interface {
+	// RenderTile returns tile z/x/y of an image, from the cache if possible
+	RenderTile(ctx context.Context, imageID string, z, x, y int, opts TileOptions) (*TileResult, error)
+	// RenderXYZTile returns a tile of a georeferenced image in Web Mercator
+	RenderXYZTile(ctx context.Context, imageID string, z, x, y int, opts TileOptions) (*TileResult, error)
+	// RenderCompareTile returns a tile comparing two images
+	RenderCompareTile(ctx context.Context, imageA, imageB string, z, x, y int, opts TileOptions, compare CompareOptions) (*TileResult, error)
+	// IsTileCached reports whether RenderTile would be served from the cache
+	IsTileCached(ctx context.Context, imageID string, z, x, y int, opts TileOptions) bool
+	// SeedTile stores a tile rendered elsewhere, e.g. by a cluster peer
+	SeedTile(ctx context.Context, key cache.TileKey, data []byte) error
+	// CacheStats reports the usage of the tile cache
+	CacheStats() cache.Stats
+	// IsCurrent reports whether tiles with this fingerprint are still valid
+	// for an image
+	IsCurrent(imageID, fingerprint string) bool
+	// InvalidateImage drops what the renderer keeps about a changed image
+	InvalidateImage(imageID string)
+	// Rendering counts the tiles being rendered for requests right now
+	Rendering() int64
+
+	// CalculateMaxZoom is the deepest native zoom level of an image
+	CalculateMaxZoom(width, height int) int
+	// XYZMaxZoom is the deepest Web Mercator zoom level of a georeferenced
+	// image, 0 for other images
+	XYZMaxZoom(imageID string) int
+	// TileRows counts the rows of tiles of a page at zoom level z
+	TileRows(imageID string, page, z int) (int, error)
+	GetImageMeta(imageID string, page int) (map[string]interface{}, error)
+	CompareMeta(imageA, imageB string, page int) (map[string]interface{}, error)
+	DisplayRange(ctx context.Context, imageID string, page int) (displayRange *DisplayRange, auto bool, err error)
+	ImageStats(ctx context.Context, imageID string, page int) (*ImageStats, error)
+	PixelValue(ctx context.Context, imageID string, page, x, y int) (*PixelValue, error)
+
+	// StaticTilePath is the file of a pre-generated tile ("" = none)
+	StaticTilePath(imageID string, z, x, y int) string
+	GeneratePyramid(ctx context.Context, imageID string) error
+	ExportPyramid(ctx context.Context, imageID, dir, layout, format string) error
+}
@@ -0,0 +1,48 @@
+package render
+
+import "time"
+
+// TileResult is a rendered or cached tile
+type TileResult struct {
+	Data []byte
+	ETag string
+	Size int
+	// Timing breaks down how the tile was served, for Server-Timing
+	Timing []TimingStage
+}
+
+// TimingStage is the time one step of serving a tile took. Renderers with a
+// lazy pipeline, like libvips, account most pixel work to the stage that
+// evaluates it.
+type TimingStage struct {
+	Name     string
+	Duration time.Duration
+}
+
+// ChannelStats describes one band of an image
+type ChannelStats struct {
+	Min       float64  `json:"min"`
+	Max       float64  `json:"max"`
+	Mean      float64  `json:"mean"`
+	StdDev    float64  `json:"stddev"`
+	Histogram []uint32 `json:"histogram"`
+}
+
+// ImageStats are per-channel statistics of an image in its native value range.
+// The histogram spans [RangeMin, RangeMax] in equal buckets.
+type ImageStats struct {
+	Format       string         `json:"format"`
+	RangeMin     float64        `json:"range_min"`
+	RangeMax     float64        `json:"range_max"`
+	SampleWidth  int            `json:"sample_width"`
+	SampleHeight int            `json:"sample_height"`
+	Channels     []ChannelStats `json:"channels"`
+}
+
+// PixelValue is the raw value of every band at one full-resolution pixel
+type PixelValue struct {
+	X      int       `json:"x"`
+	Y      int       `json:"y"`
+	Format string    `json:"format"`
+	Values []float64 `json:"values"`
+}
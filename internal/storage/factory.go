@@ -0,0 +1,44 @@
+package storage
+
+import (
+	"fmt"
+
+	"go.uber.org/zap"
+)
+
+// Options selects and configures a storage backend
+type Options struct {
+	// Backend is "none" (originals stay in the data directory), "local" or
+	// "s3"
+	Backend string
+	// Dir is the directory of the local backend
+	Dir string
+
+	S3Endpoint  string
+	S3Region    string
+	S3Bucket    string
+	S3Prefix    string
+	S3AccessKey string
+	S3SecretKey string
+}
+
+// NewStorage creates the configured backend, or returns nil for "none"
+func NewStorage(options Options, log *zap.Logger) (Storage, error) {
+	var storage Storage
+	var err error
+	switch options.Backend {
+	case "", "none":
+		return nil, nil
+	case "local":
+		storage, err = NewLocalStorage(options.Dir)
+	case "s3":
+		storage, err = NewS3Storage(options)
+	default:
+		return nil, fmt.Errorf("unknown storage backend: %s (supported: none, local, s3)", options.Backend)
+	}
+	if err != nil {
+		return nil, err
+	}
+	log.Info("Storing image originals in backend", zap.Stringer("storage", storage))
+	return storage, nil
+}
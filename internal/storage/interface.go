@@ -0,0 +1,24 @@
+package storage
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrNotFound is returned for keys the backend doesn't have
+var ErrNotFound = errors.New("object not found")
+
+// Storage keeps image originals outside the data directory. Objects are
+// whole files: libvips and OpenSlide need seekable local files, so images are
+// staged from the backend before they are rendered rather than read in ranges.
+type Storage interface {
+	// Put stores the local file at path under key, replacing any object
+	// already there
+	Put(ctx context.Context, key, path string) error
+	// Fetch writes the object at key to the local file at path
+	Fetch(ctx context.Context, key, path string) error
+	// Delete removes the object at key; missing objects are not an error
+	Delete(ctx context.Context, key string) error
+	// String describes the backend for logs, e.g. "s3://bucket/prefix"
+	String() string
+}
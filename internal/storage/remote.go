@@ -0,0 +1,215 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrRemoteNotAllowed is returned for URLs outside the allowed prefixes
+	ErrRemoteNotAllowed = errors.New("remote URL not allowed")
+	// ErrRemoteChanged is returned when a remote image no longer is the
+	// version it was registered with
+	ErrRemoteChanged = errors.New("remote image changed since it was registered")
+	// ErrRemoteTooLarge is returned for remote files over the size limit
+	ErrRemoteTooLarge = errors.New("remote file too large")
+)
+
+// Remote downloads images published on HTTP(S) servers, restricted to a list
+// of URL prefixes so registering an image can't be used to reach internal
+// services. It never writes to them.
+type Remote struct {
+	client   *http.Client
+	prefixes []*url.URL
+	maxBytes int64
+}
+
+// NewRemote allows URLs under one of prefixes, e.g.
+// "https://images.example.org/masters/"; prefixes that aren't a base URL
+// ending in "/" allow nothing. Downloads are limited to maxBytes
+// (0 = unlimited) and timeout (0 = none).
+func NewRemote(prefixes []string, maxBytes int64, timeout time.Duration) *Remote {
+	r := &Remote{maxBytes: maxBytes}
+	for _, prefix := range prefixes {
+		if parsed, err := parseRemotePrefix(prefix); err == nil {
+			r.prefixes = append(r.prefixes, parsed)
+		}
+	}
+	r.client = &http.Client{
+		Timeout: timeout,
+		// Redirects must stay within the allowed prefixes too
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 5 {
+				return errors.New("too many redirects")
+			}
+			if !r.Allowed(req.URL.String()) {
+				return fmt.Errorf("%w: redirect to %s", ErrRemoteNotAllowed, req.URL.Redacted())
+			}
+			return nil
+		},
+	}
+	return r
+}
+
+// parseRemotePrefix accepts http(s) URLs without user info whose path ends
+// in "/", so a prefix only ever matches whole path segments
+func parseRemotePrefix(prefix string) (*url.URL, error) {
+	parsed, err := url.Parse(prefix)
+	if err != nil {
+		return nil, err
+	}
+	if (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" || parsed.User != nil {
+		return nil, errors.New("not an http(s) URL with a host")
+	}
+	if !strings.HasSuffix(parsed.Path, "/") {
+		return nil, errors.New("path doesn't end in /")
+	}
+	return parsed, nil
+}
+
+// Allowed reports whether rawURL lies under one of the allowed prefixes:
+// same scheme and host, and a path inside the prefix once dot segments are
+// resolved. URLs with user info are never allowed.
+func (r *Remote) Allowed(rawURL string) bool {
+	parsed, err := url.Parse(rawURL)
+	if err != nil || parsed.User != nil || parsed.Host == "" {
+		return false
+	}
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return false
+	}
+	cleaned := path.Clean("/" + parsed.Path)
+	for _, prefix := range r.prefixes {
+		if parsed.Scheme == prefix.Scheme && strings.EqualFold(parsed.Host, prefix.Host) &&
+			strings.HasPrefix(cleaned, prefix.Path) {
+			return true
+		}
+	}
+	return false
+}
+
+// Fetch downloads url to the local file at path and returns the version of
+// what it got, its ETag or else its Last-Modified date. With a version, the
+// download only succeeds if the remote file still is that version, so a file
+// changed on the server never gets rendered with the dimensions of the old
+// one. Interrupted downloads are resumed with range requests.
+func (r *Remote) Fetch(ctx context.Context, url, version, path string) (string, error) {
+	if !r.Allowed(url) {
+		return "", fmt.Errorf("%w: %s", ErrRemoteNotAllowed, url)
+	}
+
+	partial := path + ".part"
+	defer os.Remove(partial)
+
+	var err error
+	for attempt := 0; attempt < 3; attempt++ {
+		var resumable bool
+		version, resumable, err = r.fetchRange(ctx, url, version, partial)
+		if err == nil {
+			return version, os.Rename(partial, path)
+		}
+		if !resumable || ctx.Err() != nil {
+			break
+		}
+	}
+	return "", err
+}
+
+// fetchRange appends the rest of url to partial. It reports whether a
+// failure happened mid-transfer, where another attempt can continue.
+func (r *Remote) fetchRange(ctx context.Context, url, version, partial string) (string, bool, error) {
+	file, err := os.OpenFile(partial, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return "", false, err
+	}
+	defer file.Close()
+	offset, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return "", false, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", false, err
+	}
+	// Resuming needs a version to make sure both parts are of one file
+	if offset > 0 && version != "" {
+		req.Header.Set("Range", "bytes="+strconv.FormatInt(offset, 10)+"-")
+	}
+	setVersionCondition(req, version)
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to fetch %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		// The server ignored the range, start over
+		if err := file.Truncate(0); err != nil {
+			return "", false, err
+		}
+		offset = 0
+	case http.StatusPartialContent:
+	case http.StatusPreconditionFailed:
+		return "", false, fmt.Errorf("%w: %s", ErrRemoteChanged, url)
+	default:
+		return "", false, fmt.Errorf("failed to fetch %s: %s", url, resp.Status)
+	}
+
+	current := responseVersion(resp)
+	if version != "" && current != "" && current != version {
+		return "", false, fmt.Errorf("%w: %s", ErrRemoteChanged, url)
+	}
+	if version == "" {
+		version = current
+	}
+	if r.maxBytes > 0 && offset+max(resp.ContentLength, 0) > r.maxBytes {
+		return "", false, fmt.Errorf("%w: %s", ErrRemoteTooLarge, url)
+	}
+
+	body := io.Reader(resp.Body)
+	if r.maxBytes > 0 {
+		// One byte more than allowed tells a file at the limit from a larger one
+		body = io.LimitReader(resp.Body, r.maxBytes-offset+1)
+	}
+	written, err := io.Copy(file, body)
+	if err != nil {
+		return version, version != "", fmt.Errorf("failed to fetch %s: %w", url, err)
+	}
+	if r.maxBytes > 0 && offset+written > r.maxBytes {
+		return "", false, fmt.Errorf("%w: %s", ErrRemoteTooLarge, url)
+	}
+	return version, false, nil
+}
+
+// setVersionCondition makes a request fail with 412 unless the remote file
+// still is the given version
+func setVersionCondition(req *http.Request, version string) {
+	switch {
+	case version == "":
+	case strings.HasPrefix(version, `"`) || strings.HasPrefix(version, `W/"`):
+		req.Header.Set("If-Match", version)
+	default:
+		req.Header.Set("If-Unmodified-Since", version)
+	}
+}
+
+// responseVersion identifies the file a response carries, by ETag or else
+// by modification date
+func responseVersion(resp *http.Response) string {
+	if etag := resp.Header.Get("ETag"); etag != "" {
+		return etag
+	}
+	return resp.Header.Get("Last-Modified")
+}
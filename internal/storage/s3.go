@@ -0,0 +1,114 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// S3Storage keeps objects in an S3 bucket, or any S3-compatible service
+// (MinIO, Ceph, Google Cloud Storage with HMAC keys, ...)
+// Structure: s3://{bucket}/{prefix}{key}
+type S3Storage struct {
+	client *minio.Client
+	bucket string
+	prefix string
+}
+
+// NewS3Storage connects to the bucket
+func NewS3Storage(options Options) (*S3Storage, error) {
+	if options.S3Bucket == "" {
+		return nil, errors.New("s3 storage needs a bucket")
+	}
+
+	client, err := NewS3Client(options)
+	if err != nil {
+		return nil, err
+	}
+	return &S3Storage{client: client, bucket: options.S3Bucket, prefix: S3Prefix(options.S3Prefix)}, nil
+}
+
+// NewS3Client creates a client for the endpoint of options. Without an
+// access key, credentials come from the usual AWS environment variables,
+// shared credentials file or instance role.
+func NewS3Client(options Options) (*minio.Client, error) {
+	endpoint := options.S3Endpoint
+	if endpoint == "" {
+		endpoint = "https://s3.amazonaws.com"
+	}
+	parsed, err := url.Parse(endpoint)
+	if err != nil || parsed.Host == "" {
+		return nil, fmt.Errorf("invalid s3 endpoint: %s", endpoint)
+	}
+
+	var creds *credentials.Credentials
+	if options.S3AccessKey != "" {
+		creds = credentials.NewStaticV4(options.S3AccessKey, options.S3SecretKey, "")
+	} else {
+		creds = credentials.NewChainCredentials([]credentials.Provider{
+			&credentials.EnvAWS{},
+			&credentials.FileAWSCredentials{},
+			&credentials.IAM{},
+		})
+	}
+
+	client, err := minio.New(parsed.Host, &minio.Options{
+		Creds:  creds,
+		Secure: parsed.Scheme != "http",
+		Region: options.S3Region,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create s3 client: %w", err)
+	}
+	return client, nil
+}
+
+// S3Prefix normalizes a key prefix to "" or "dir/"
+func S3Prefix(prefix string) string {
+	prefix = strings.TrimPrefix(prefix, "/")
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	return prefix
+}
+
+func (s *S3Storage) Put(ctx context.Context, key, path string) error {
+	_, err := s.client.FPutObject(ctx, s.bucket, s.prefix+key, path, minio.PutObjectOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to upload %s: %w", key, err)
+	}
+	return nil
+}
+
+func (s *S3Storage) Fetch(ctx context.Context, key, path string) error {
+	// FGetObject downloads to a temporary file next to path and renames it
+	err := s.client.FGetObject(ctx, s.bucket, s.prefix+key, filepath.Clean(path), minio.GetObjectOptions{})
+	if minio.ToErrorResponse(err).Code == minio.NoSuchKey {
+		return fmt.Errorf("%w: %s", ErrNotFound, key)
+	}
+	if err != nil {
+		os.Remove(path)
+		return fmt.Errorf("failed to download %s: %w", key, err)
+	}
+	return nil
+}
+
+func (s *S3Storage) Delete(ctx context.Context, key string) error {
+	// Deleting a missing key succeeds in S3
+	if err := s.client.RemoveObject(ctx, s.bucket, s.prefix+key, minio.RemoveObjectOptions{}); err != nil {
+		return fmt.Errorf("failed to delete %s: %w", key, err)
+	}
+	return nil
+}
+
+func (s *S3Storage) String() string {
+	return "s3://" + path.Join(s.bucket, s.prefix)
+}
@@ -0,0 +1,263 @@
+// Package gigaview embeds the Gigaview tile server in other Go applications.
+// A Server is an http.Handler with the whole API and the viewer, to be
+// mounted under their own router and middleware:
+//
+//	cfg := gigaview.LoadConfig()
+//	cfg.DataDir = "/srv/images"
+//	server, err := gigaview.New(cfg, gigaview.Options{Logger: log})
+//	if err != nil {
+//		return err
+//	}
+//	defer server.Close()
+//	mux.Handle("/gigaview/", http.StripPrefix("/gigaview", server))
+//
+// Set PublicBaseURL to the URL the handler is reachable under. libvips can
+// only be started once per process and Close shuts it down, so Servers
+// opened side by side must be closed together, and none can be opened
+// after one was closed.
+package gigaview
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"gigaview/internal/analytics"
+	"gigaview/internal/audit"
+	"gigaview/internal/cache"
+	"gigaview/internal/config"
+	httphandlers "gigaview/internal/http"
+	"gigaview/internal/image_renderer"
+	"gigaview/internal/library"
+	"gigaview/internal/logger"
+	"gigaview/internal/metrics"
+	"gigaview/internal/render"
+)
+
+// analyticsFlushInterval bounds the view counts lost when the process dies
+const analyticsFlushInterval = time.Minute
+
+// Config holds the settings, the same as the environment variables and
+// config file of the gigaview binary
+type Config = config.Config
+
+// Change is a setting that differs after Reload
+type Change = config.Change
+
+// LoadConfig returns the defaults, overridden by the environment
+func LoadConfig() *Config {
+	return config.Load()
+}
+
+// LoadConfigFile reads a YAML config file, with the environment taking
+// precedence
+func LoadConfigFile(path string) (*Config, error) {
+	return config.LoadFile(path)
+}
+
+// Options are the parts of a Server that don't come from the settings
+type Options struct {
+	// Logger receives the log of the server (nil = built from LOG_LEVEL,
+	// LOG_FORMAT and LOG_OUTPUT)
+	Logger *zap.Logger
+	// LogLevel is the level of Logger, which the admin API and Reload
+	// change; it is ignored without Logger
+	LogLevel *zap.AtomicLevel
+}
+
+// Server serves an image library over HTTP
+type Server struct {
+	cfg      *Config
+	log      *zap.Logger
+	logLevel *zap.AtomicLevel
+	lib      *library.Library
+	handlers *httphandlers.Handlers
+	handler  http.Handler
+	warmer   *image_renderer.Warmer
+	recorder *analytics.Recorder
+	auditLog *audit.Log
+
+	// stop cancels the background work: watching the data directory, cache
+	// maintenance and the analytics flushes
+	stop context.CancelFunc
+	// background tracks the initial scan, which Close waits for before
+	// libvips shuts down
+	background sync.WaitGroup
+	closeOnce  sync.Once
+	// closers run in reverse order on Close
+	closers []func()
+}
+
+// New validates cfg and sets up the library, then scans it in the
+// background; the handler answers right away with the indexed images.
+func New(cfg *Config, options Options) (*Server, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid configuration:\n%w", err)
+	}
+
+	s := &Server{cfg: cfg, log: options.Logger, logLevel: options.LogLevel}
+	if s.log == nil {
+		level := zap.NewAtomicLevelAt(logger.ParseLevel(cfg.LogLevel))
+		log, err := logger.New(logger.Options{
+			Level:  level,
+			Format: cfg.LogFormat,
+			Output: cfg.LogOutput,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize logger: %w", err)
+		}
+		s.log, s.logLevel = log, &level
+		s.closers = append(s.closers, func() { log.Sync() })
+	}
+	log := s.log
+
+	zoom, err := library.WarmupZoom(cfg)
+	if err != nil {
+		s.close()
+		return nil, fmt.Errorf("invalid WARMUP_ZOOM: %w", err)
+	}
+	auditLog, err := audit.New(cfg.AuditLogFile)
+	if err != nil {
+		s.close()
+		return nil, fmt.Errorf("failed to open audit log: %w", err)
+	}
+	s.auditLog = auditLog
+	s.closers = append(s.closers, func() { auditLog.Close() })
+	if cfg.AnalyticsFile != "" {
+		if s.recorder, err = analytics.New(cfg.AnalyticsFile, log); err != nil {
+			s.close()
+			return nil, fmt.Errorf("failed to load analytics: %w", err)
+		}
+	}
+
+	lib, err := library.Open(cfg, log)
+	if err != nil {
+		s.close()
+		return nil, err
+	}
+	s.lib = lib
+	s.closers = append(s.closers, lib.Close)
+
+	if cfg.CacheMemorySnapshot != "" {
+		loaded, err := cache.LoadSnapshot(lib.Cache, cfg.CacheMemorySnapshot)
+		if err != nil {
+			log.Warn("Failed to load cache snapshot", zap.Error(err))
+		} else if loaded > 0 {
+			log.Info("Loaded cache snapshot", zap.String("path", cfg.CacheMemorySnapshot), zap.Int("tiles", loaded))
+		}
+	}
+
+	ctx, stop := context.WithCancel(context.Background())
+	s.stop = stop
+	go cache.Maintain(ctx, lib.Cache, log)
+	if cfg.WatchDataDir {
+		go func() {
+			if err := lib.Scanner.Watch(ctx, cfg.WatchDebounce); err != nil {
+				log.Warn("Data directory watcher stopped, changes need a restart", zap.Error(err))
+			}
+		}()
+	}
+
+	accessLog := logger.NewAccessLogger(cfg.AccessLogFile, cfg.AccessLogMaxSizeMB, cfg.AccessLogMaxBackups, log)
+	s.closers = append(s.closers, func() { accessLog.Sync() })
+
+	s.handlers = httphandlers.New(cfg, log, accessLog, auditLog, lib.Scanner, lib.Renderer)
+	if s.logLevel != nil {
+		s.handlers.UseLogLevel(*s.logLevel)
+	}
+	registry := metrics.NewRegistry()
+	s.handlers.UseMetrics(registry)
+	s.warmer = image_renderer.NewWarmer(lib.Renderer, image_renderer.WarmupOptions{
+		Zoom:        zoom,
+		Images:      cfg.WarmupImages,
+		Workers:     cfg.WarmupWorkers,
+		Rate:        cfg.WarmupRate,
+		TileOptions: render.DefaultTileOptions,
+		Metrics:     registry,
+	}, log)
+	s.handlers.UseWarmup(s.warmer)
+	if s.recorder != nil {
+		s.handlers.UseAnalytics(s.recorder)
+		go s.recorder.Run(ctx, analyticsFlushInterval)
+	}
+	go image_renderer.SampleVips(ctx, cfg.VipsStatsInterval, registry, log)
+
+	s.handler = s.handlers.Routes()
+
+	// Large libraries take a while to scan, so serve the indexed images (or
+	// an empty list) meanwhile; /api/scan/status reports the progress
+	s.background.Add(1)
+	go func() {
+		defer s.background.Done()
+		if err := lib.Scanner.ScanContext(ctx); err != nil {
+			if ctx.Err() == nil {
+				log.Warn("Initial scan failed", zap.Error(err))
+			}
+		} else {
+			// Before the first scan, images missing from the index would
+			// look deleted
+			go cache.RunJanitor(ctx, lib.Cache, cfg.CacheJanitorInterval, lib.Renderer.IsCurrent, log)
+		}
+		if cfg.WarmupEnabled() && ctx.Err() == nil {
+			s.warmer.Enqueue(lib.Scanner.GetImages()...)
+		}
+	}()
+
+	return s, nil
+}
+
+// ServeHTTP serves the API and the viewer
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.handler.ServeHTTP(w, r)
+}
+
+// Scan rescans the data directory for files added or removed outside the
+// API, for when WatchDataDir is off. It waits for a scan in progress, the
+// initial one included.
+func (s *Server) Scan(ctx context.Context) error {
+	return s.lib.Scanner.ScanContext(ctx)
+}
+
+// Reload applies the settings of next that can change while serving, see
+// Config.Reload, and returns the ones that changed. Calls must not overlap.
+func (s *Server) Reload(next *Config) []Change {
+	updated, changes := s.cfg.Reload(next)
+	if s.logLevel != nil {
+		s.logLevel.SetLevel(logger.ParseLevel(updated.LogLevel))
+	}
+	s.handlers.Reload(updated)
+	cache.Resize(s.lib.Cache, updated.CacheMemoryTiles, int64(updated.CacheMemoryMB)<<20)
+	s.cfg = updated
+	return changes
+}
+
+// Close stops the background work and releases the library. Requests still
+// being served fail, so shut the HTTP server down first.
+func (s *Server) Close() {
+	s.closeOnce.Do(func() {
+		s.stop()
+		// Before libvips shuts down with the library, and the scan first
+		// so it doesn't enqueue a warmup after the warmer closed
+		s.background.Wait()
+		s.warmer.Close()
+		if err := s.recorder.Flush(); err != nil {
+			s.log.Warn("Failed to save analytics", zap.Error(err))
+		}
+		if s.cfg.CacheMemorySnapshot != "" {
+			if err := cache.SaveSnapshot(s.lib.Cache, s.cfg.CacheMemorySnapshot); err != nil {
+				s.log.Warn("Failed to save cache snapshot", zap.Error(err))
+			}
+		}
+		s.close()
+	})
+}
+
+func (s *Server) close() {
+	for i := len(s.closers) - 1; i >= 0; i-- {
+		s.closers[i]()
+	}
+}
@@ -56,7 +56,48 @@ async function loadImageList() {
   }
 }
 
-async function loadImage(imageId) {
+// Draw an annotation of the current image, e.g. the one a share link points at
+async function highlightAnnotation(annotationId) {
+  const response = await fetch(
+    `${getBaseUrl()}/api/images/${currentImageId}/annotations/${annotationId}`
+  );
+  if (!response.ok) return;
+  const annotation = await response.json();
+  const toLatLng = (x, y) => map.unproject([x, y], currentImageMeta.maxZoom);
+
+  const selector = annotation.target.selector;
+  let shape = null;
+  if (selector.type === "FragmentSelector") {
+    const [x, y, w, h] = selector.value
+      .replace(/^xywh=(pixel:)?/, "")
+      .split(",")
+      .map(Number);
+    shape =
+      w === 0 && h === 0
+        ? L.marker(toLatLng(x, y))
+        : L.rectangle([toLatLng(x, y), toLatLng(x + w, y + h)]);
+  } else if (selector.type === "SvgSelector") {
+    const points = selector.value.match(/points="([^"]*)"/)[1].trim().split(/\s+/);
+    shape = L.polygon(
+      points.map((point) => toLatLng(...point.split(",").map(Number)))
+    );
+  }
+  if (!shape) return;
+
+  const text = annotation.body.map((body) => body.value).join("\n");
+  shape.addTo(drawnItems);
+  if (text) {
+    // textContent keeps the comments from being read as markup
+    const content = document.createElement("div");
+    content.style.whiteSpace = "pre-line";
+    content.textContent = text;
+    shape.bindPopup(content).openPopup();
+  }
+}
+
+// view optionally opens the image at {x, y, zoom} in full-resolution pixels
+// and highlights view.annotation
+async function loadImage(imageId, view) {
   currentImageId = imageId;
   uniqueTiles.clear();
   downloadedBytes = 0;
@@ -85,7 +126,8 @@ async function loadImage(imageId) {
       // because we don't geographic projection
       crs: L.CRS.Simple,
       minZoom: 0, // Minimum zoom level (full image view)
-      maxZoom: currentImageMeta.maxZoom, // Maximum zoom level (highest detail)
+      // Allow zooming past native resolution when the server upscales tiles
+      maxZoom: currentImageMeta.maxOverzoom ?? currentImageMeta.maxZoom,
       zoomSnap: 1, // Snap to integer zoom levels only
       zoomDelta: 1, // Zoom increment/decrement amount
       wheelPxPerZoom: 60, // Pixels to scroll per zoom level (smoother wheel zoom)
@@ -112,6 +154,12 @@ async function loadImage(imageId) {
     // fitBounds automatically calculates center and zoom level to show entire image
     // padding: [0, 0] means no padding around the image edges
     map.fitBounds(bounds, { padding: [0, 0] });
+    if (view) {
+      map.setView(
+        map.unproject([view.x, view.y], currentImageMeta.maxZoom),
+        view.zoom
+      );
+    }
 
     // ----- Create tile layer for the image
     // Tile URL pattern: z/x/y.jpeg where:
@@ -123,7 +171,7 @@ async function loadImage(imageId) {
       {
         tileSize: currentImageMeta.tileSize, // Size of each tile in pixels
         minZoom: 0, // Minimum zoom level for tiles
-        maxZoom: currentImageMeta.maxZoom, // Maximum zoom level for tiles
+        maxZoom: currentImageMeta.maxOverzoom ?? currentImageMeta.maxZoom, // Maximum zoom level for tiles (incl. server overzoom)
         noWrap: true, // Don't wrap tiles horizontally (prevent requests outside bounds)
         bounds, // Only request tiles within these bounds
         // Error tile: 1x1 transparent GIF shown when a tile fails to load (404, etc.)
@@ -150,6 +198,9 @@ async function loadImage(imageId) {
     // This can be used for annotations or user-drawn overlays on the image
     drawnItems = new L.FeatureGroup();
     map.addLayer(drawnItems);
+    if (view && view.annotation) {
+      highlightAnnotation(view.annotation);
+    }
 
     // ----- Single marker for coordinate display
     // Helper function to update coordinates in HUD
@@ -315,3 +366,17 @@ window.addEventListener("resize", () => {
 });
 
 loadImageList();
+
+// Share links (/s/...) redirect here with the shared view as parameters
+const params = new URLSearchParams(window.location.search);
+if (params.get("image")) {
+  document.getElementById("about").classList.add("hidden");
+  document.getElementById("map").classList.remove("hidden");
+  loadImage(params.get("image"), {
+    x: Number(params.get("x")),
+    y: Number(params.get("y")),
+    zoom: Number(params.get("zoom")),
+    annotation: params.get("annotation"),
+  });
+  pageView(`/map/${params.get("image")}`);
+}
@@ -0,0 +1,10 @@
+// Package public holds the frontend, which is built into the binary
+package public
+
+import "embed"
+
+// Files are the frontend assets, served at the root of the server. They are
+// listed by name, since the directory also holds the README screenshot.
+//
+//go:embed index.html main.js hawk.png
+var Files embed.FS
@@ -0,0 +1,65 @@
+//go:build integration
+
+package integration
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/cshum/vipsgen/vips"
+)
+
+// writeGradientTIFF writes a tiled RGB gradient TIFF of the given size
+func writeGradientTIFF(t *testing.T, dir string, width, height int) string {
+	t.Helper()
+
+	image, err := vips.NewGrey(width, height, &vips.GreyOptions{Uchar: true})
+	if err != nil {
+		t.Fatalf("generate gradient: %v", err)
+	}
+	defer image.Close()
+
+	if err := image.BandjoinConst([]float64{128, 64}); err != nil {
+		t.Fatalf("bandjoin: %v", err)
+	}
+
+	path := filepath.Join(dir, "gradient.tif")
+	opts := vips.DefaultTiffsaveOptions()
+	opts.Tile = true
+	opts.TileWidth = 256
+	opts.TileHeight = 256
+	if err := image.Tiffsave(path, opts); err != nil {
+		t.Fatalf("save gradient: %v", err)
+	}
+
+	return path
+}
+
+// writeNoiseJPEG writes an RGB gaussian noise JPEG of the given size
+func writeNoiseJPEG(t *testing.T, dir string, width, height int) string {
+	t.Helper()
+
+	opts := vips.DefaultGaussnoiseOptions()
+	opts.Mean = 128
+	opts.Sigma = 40
+	image, err := vips.NewGaussnoise(width, height, opts)
+	if err != nil {
+		t.Fatalf("generate noise: %v", err)
+	}
+	defer image.Close()
+
+	if err := image.Cast(vips.BandFormatUchar, nil); err != nil {
+		t.Fatalf("cast: %v", err)
+	}
+
+	if err := image.BandjoinConst([]float64{128, 128}); err != nil {
+		t.Fatalf("bandjoin: %v", err)
+	}
+
+	path := filepath.Join(dir, "noise.jpg")
+	if err := image.Jpegsave(path, vips.DefaultJpegsaveOptions()); err != nil {
+		t.Fatalf("save noise: %v", err)
+	}
+
+	return path
+}
@@ -0,0 +1,300 @@
+//go:build integration
+
+// Package integration boots the full Gigaview stack in-process against
+// synthetic fixtures. Run with: go test -tags integration ./test/integration/
+package integration
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cshum/vipsgen/vips"
+	"go.uber.org/zap"
+
+	"gigaview/internal/cache"
+	"gigaview/internal/image_list"
+	"gigaview/pkg/gigaview"
+)
+
+// fixtureSize is the edge length of generated fixtures. Override with
+// GIGAVIEW_FIXTURE_SIZE to exercise truly large images locally.
+var fixtureSize = 4096
+
+// adminToken authorizes the admin API of test servers
+const adminToken = "integration-test-admin"
+
+// servers are closed after all tests, since closing one shuts libvips down
+// for the whole process
+var servers []*gigaview.Server
+
+func TestMain(m *testing.M) {
+	if value := os.Getenv("GIGAVIEW_FIXTURE_SIZE"); value != "" {
+		if size, err := strconv.Atoi(value); err == nil && size > 0 {
+			fixtureSize = size
+		}
+	}
+
+	vips.Startup(nil)
+	code := m.Run()
+	for _, server := range servers {
+		server.Close()
+	}
+	vips.Shutdown()
+	os.Exit(code)
+}
+
+// testServer is a gigaview.Server backed by a temp data dir
+type testServer struct {
+	*httptest.Server
+	// server serves the requests once wire has set it up
+	server   *gigaview.Server
+	dataDir  string
+	cacheDir string
+}
+
+func newTestServer(t *testing.T, cacheType string) *testServer {
+	t.Helper()
+
+	s := startTestServer(t)
+	s.wire(t, cacheType, nil)
+	return s
+}
+
+// newTestCluster starts nodes that forward tiles to each other and share
+// one data directory
+func newTestCluster(t *testing.T, size int) []*testServer {
+	t.Helper()
+
+	dataDir := t.TempDir()
+	nodes := make([]*testServer, size)
+	peers := make([]string, size)
+	for i := range nodes {
+		nodes[i] = startTestServer(t)
+		peers[i] = nodes[i].URL
+	}
+	for _, node := range nodes {
+		node.wire(t, "file", func(cfg *gigaview.Config) {
+			cfg.DataDir = dataDir
+			cfg.MetadataDir = dataDir
+			cfg.CachePeers = peers
+			cfg.CachePeerSelf = node.URL
+			cfg.CachePeerSecret = "integration-test-secret"
+		})
+	}
+	return nodes
+}
+
+// startTestServer listens before the Server is opened, so the URL is known
+// to the configuration of cluster nodes
+func startTestServer(t *testing.T) *testServer {
+	t.Helper()
+
+	s := &testServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.server.ServeHTTP(w, r)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// wire opens the Server behind s once its initial scan is done; configure
+// adjusts the settings
+func (s *testServer) wire(t *testing.T, cacheType string, configure func(*gigaview.Config)) {
+	t.Helper()
+
+	// Nothing outside the temp dirs, and no background work that renders
+	// or rescans on its own
+	cfg := gigaview.LoadConfig()
+	cfg.DataDir = t.TempDir()
+	cfg.MetadataDir = cfg.DataDir
+	cfg.CacheType = cacheType
+	cfg.CacheMemoryTiles = 100
+	cfg.CacheFileDir = filepath.Join(t.TempDir(), "cache")
+	cfg.IndexFile = ""
+	cfg.AlbumsFile = ""
+	cfg.AnalyticsFile = ""
+	cfg.PyramidDir = ""
+	cfg.WatchDataDir = false
+	cfg.WarmupLevels = 0
+	cfg.WarmupZoom = ""
+	cfg.AdminToken = adminToken
+	cfg.LogLevel = "error"
+	cfg.MaxUploadSize = 1 << 30
+	cfg.PublicBaseURL = "http://localhost"
+	cfg.MaxDeadlineMs = 30000
+	cfg.RenderTimeout = time.Minute
+	cfg.BatchMaxTiles = 64
+	cfg.BatchWorkers = 4
+	if configure != nil {
+		configure(cfg)
+	}
+
+	server, err := gigaview.New(cfg, gigaview.Options{Logger: zap.NewNop()})
+	if err != nil {
+		t.Fatalf("open server: %v", err)
+	}
+	servers = append(servers, server)
+	if err := server.Scan(context.Background()); err != nil {
+		t.Fatalf("initial scan: %v", err)
+	}
+
+	s.dataDir = cfg.DataDir
+	s.cacheDir = cfg.CacheFileDir
+	s.server = server
+}
+
+// uploadBody builds the multipart form of an upload of the file at path
+func uploadBody(t *testing.T, path string) (*bytes.Buffer, string) {
+	t.Helper()
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+
+	part, err := writer.CreateFormFile("file", filepath.Base(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write(data)
+	writer.WriteField("copyright_text", "Synthetic fixture")
+	writer.Close()
+
+	return &body, writer.FormDataContentType()
+}
+
+// upload posts a file to /api/upload and returns the new image ID
+func (s *testServer) upload(t *testing.T, path string) string {
+	t.Helper()
+
+	body, contentType := uploadBody(t, path)
+	resp, err := http.Post(s.URL+"/api/upload", contentType, body)
+	if err != nil {
+		t.Fatalf("upload: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(resp.Body)
+		t.Fatalf("upload status %d: %s", resp.StatusCode, msg)
+	}
+
+	var result struct {
+		ID    string `json:"id"`
+		Saved bool   `json:"saved"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		t.Fatalf("decode upload response: %v", err)
+	}
+	if !result.Saved || result.ID == "" {
+		t.Fatalf("unexpected upload response: %+v", result)
+	}
+
+	return result.ID
+}
+
+// do sends a request with an optional body and headers and returns its
+// status
+func (s *testServer) do(t *testing.T, method, path string, body io.Reader, header http.Header) int {
+	t.Helper()
+
+	req, err := http.NewRequest(method, s.URL+path, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	return resp.StatusCode
+}
+
+// tileDirs counts the file cache directories of an image
+func (s *testServer) tileDirs(t *testing.T, id string) int {
+	t.Helper()
+
+	entries, err := os.ReadDir(s.cacheDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	count := 0
+	for _, entry := range entries {
+		if entry.IsDir() && strings.HasPrefix(entry.Name(), id+"_") {
+			count++
+		}
+	}
+	return count
+}
+
+// images returns the listing of /api/images
+func (s *testServer) images(t *testing.T) []image_list.ImageInfo {
+	t.Helper()
+
+	status, _, body := s.get(t, "/api/images")
+	if status != http.StatusOK {
+		t.Fatalf("list status %d", status)
+	}
+	var images []image_list.ImageInfo
+	if err := json.Unmarshal(body, &images); err != nil {
+		t.Fatalf("decode listing: %v", err)
+	}
+	return images
+}
+
+// cacheStats returns the tile cache statistics of the admin API
+func (s *testServer) cacheStats(t *testing.T) cache.Stats {
+	t.Helper()
+
+	status, _, body := s.get(t, "/api/admin/cache?token="+adminToken)
+	if status != http.StatusOK {
+		t.Fatalf("cache stats status %d", status)
+	}
+	var stats cache.Stats
+	if err := json.Unmarshal(body, &stats); err != nil {
+		t.Fatalf("decode cache stats: %v", err)
+	}
+	return stats
+}
+
+// get fetches a path and returns status, headers and body
+func (s *testServer) get(t *testing.T, path string) (int, http.Header, []byte) {
+	t.Helper()
+
+	resp, err := http.Get(s.URL + path)
+	if err != nil {
+		t.Fatalf("GET %s: %v", path, err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("read %s: %v", path, err)
+	}
+
+	return resp.StatusCode, resp.Header, data
+}
+
+func tilePath(id string, z, x, y int) string {
+	return fmt.Sprintf("/api/images/%s/tiles/%d/%d/%d.jpg", id, z, x, y)
+}
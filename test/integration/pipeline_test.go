@@ -0,0 +1,214 @@
+//go:build integration
+
+package integration
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestUploadScanTilePipeline(t *testing.T) {
+	fixturesDir := t.TempDir()
+	fixtures := map[string]string{
+		"tiff": writeGradientTIFF(t, fixturesDir, fixtureSize, fixtureSize/2),
+		"jpeg": writeNoiseJPEG(t, fixturesDir, fixtureSize/2, fixtureSize/2),
+	}
+
+	for _, cacheType := range []string{"memory", "file", "disabled"} {
+		for name, fixture := range fixtures {
+			t.Run(cacheType+"/"+name, func(t *testing.T) {
+				srv := newTestServer(t, cacheType)
+				id := srv.upload(t, fixture)
+
+				images := srv.images(t)
+				if len(images) != 1 || images[0].ID != id {
+					t.Fatalf("unexpected listing: %+v", images)
+				}
+				info := images[0]
+
+				// Upload stores the file under its UUID next to a JSON sidecar
+				if _, err := os.Stat(filepath.Join(srv.dataDir, info.CurrentFilename)); err != nil {
+					t.Fatalf("uploaded file missing: %v", err)
+				}
+				if _, err := os.Stat(filepath.Join(srv.dataDir, id+".json")); err != nil {
+					t.Fatalf("metadata sidecar missing: %v", err)
+				}
+
+				status, _, body := srv.get(t, "/api/images/"+id+"/meta")
+				if status != http.StatusOK {
+					t.Fatalf("meta status %d", status)
+				}
+				var meta struct {
+					Width   int `json:"width"`
+					Height  int `json:"height"`
+					MaxZoom int `json:"maxZoom"`
+				}
+				if err := json.Unmarshal(body, &meta); err != nil {
+					t.Fatalf("decode meta: %v", err)
+				}
+				if meta.Width != info.Width || meta.Height != info.Height {
+					t.Fatalf("meta dimensions %dx%d, want %dx%d", meta.Width, meta.Height, info.Width, info.Height)
+				}
+
+				// Every zoom level renders its origin tile and the deepest level
+				// renders its last edge tile (padded)
+				for z := 0; z <= meta.MaxZoom; z++ {
+					status, header, body := srv.get(t, tilePath(id, z, 0, 0))
+					if status != http.StatusOK {
+						t.Fatalf("tile z=%d status %d: %s", z, status, body)
+					}
+					if header.Get("Content-Type") != "image/jpeg" || len(body) == 0 {
+						t.Fatalf("tile z=%d: bad response %q, %d bytes", z, header.Get("Content-Type"), len(body))
+					}
+				}
+
+				lastX := (meta.Width - 1) / 256
+				lastY := (meta.Height - 1) / 256
+				if status, _, _ := srv.get(t, tilePath(id, meta.MaxZoom, lastX, lastY)); status != http.StatusOK {
+					t.Fatalf("edge tile status %d", status)
+				}
+
+				// Second request is served from cache with a stable ETag
+				_, first, _ := srv.get(t, tilePath(id, 0, 0, 0))
+				_, second, _ := srv.get(t, tilePath(id, 0, 0, 0))
+				if first.Get("ETag") == "" || first.Get("ETag") != second.Get("ETag") {
+					t.Fatalf("ETag mismatch: %q vs %q", first.Get("ETag"), second.Get("ETag"))
+				}
+
+				if cached := srv.cacheStats(t).Bytes > 0; cached != (cacheType != "disabled") {
+					t.Fatalf("tiles cached = %v for %s cache", cached, cacheType)
+				}
+
+				// Out-of-bounds and unknown images are 404s, not render errors
+				if status, _, _ := srv.get(t, tilePath(id, meta.MaxZoom+1, 0, 0)); status != http.StatusNotFound {
+					t.Fatalf("overzoom status %d, want 404", status)
+				}
+				if status, _, _ := srv.get(t, tilePath(id, meta.MaxZoom, lastX+1, 0)); status != http.StatusNotFound {
+					t.Fatalf("out-of-bounds status %d, want 404", status)
+				}
+				if status, _, _ := srv.get(t, tilePath("missing", 0, 0, 0)); status != http.StatusNotFound {
+					t.Fatalf("missing image status %d, want 404", status)
+				}
+			})
+		}
+	}
+}
+
+func TestScanPicksUpDroppedFiles(t *testing.T) {
+	srv := newTestServer(t, "memory")
+
+	src := writeGradientTIFF(t, t.TempDir(), 1024, 768)
+	data, err := os.ReadFile(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(srv.dataDir, "dropped.tif"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := srv.server.Scan(context.Background()); err != nil {
+		t.Fatalf("scan: %v", err)
+	}
+
+	images := srv.images(t)
+	if len(images) != 1 {
+		t.Fatalf("scanned %d images, want 1", len(images))
+	}
+	if images[0].OriginalFilename != "dropped.tif" || images[0].Width != 1024 || images[0].Height != 768 {
+		t.Fatalf("unexpected image info: %+v", images[0])
+	}
+
+	// The original file is migrated to its UUID name
+	if _, err := os.Stat(filepath.Join(srv.dataDir, "dropped.tif")); !os.IsNotExist(err) {
+		t.Fatalf("dropped.tif still present after scan")
+	}
+
+	if status, _, _ := srv.get(t, tilePath(images[0].ID, 0, 0, 0)); status != http.StatusOK {
+		t.Fatalf("tile status %d", status)
+	}
+}
+
+func TestReplaceAndDeleteDropCachedTiles(t *testing.T) {
+	srv := newTestServer(t, "file")
+	fixturesDir := t.TempDir()
+	id := srv.upload(t, writeGradientTIFF(t, fixturesDir, 1024, 768))
+
+	if status, _, _ := srv.get(t, tilePath(id, 0, 0, 0)); status != http.StatusOK {
+		t.Fatalf("tile status %d", status)
+	}
+	if dirs := srv.tileDirs(t, id); dirs == 0 {
+		t.Fatalf("no cached tiles after rendering")
+	}
+
+	// Tiles of the old content are gone before any new one is rendered
+	body, contentType := uploadBody(t, writeNoiseJPEG(t, fixturesDir, 512, 512))
+	if status := srv.do(t, http.MethodPut, "/api/images/"+id+"/content", body, http.Header{"Content-Type": {contentType}}); status != http.StatusOK {
+		t.Fatalf("replace status %d", status)
+	}
+	if dirs := srv.tileDirs(t, id); dirs != 0 {
+		t.Fatalf("%d cache directories left after replace", dirs)
+	}
+
+	if status, _, _ := srv.get(t, tilePath(id, 0, 0, 0)); status != http.StatusOK {
+		t.Fatalf("tile status after replace %d", status)
+	}
+	if status := srv.do(t, http.MethodDelete, "/api/images/"+id, nil, nil); status != http.StatusNoContent {
+		t.Fatalf("delete status %d", status)
+	}
+	if dirs := srv.tileDirs(t, id); dirs != 0 {
+		t.Fatalf("%d cache directories left after delete", dirs)
+	}
+	if status, _, _ := srv.get(t, tilePath(id, 0, 0, 0)); status != http.StatusNotFound {
+		t.Fatalf("tile status after delete %d, want 404", status)
+	}
+}
+
+func TestClusterRendersTilesOnce(t *testing.T) {
+	nodes := newTestCluster(t, 2)
+	id := nodes[0].upload(t, writeGradientTIFF(t, t.TempDir(), 1024, 768))
+	if err := nodes[1].server.Scan(context.Background()); err != nil {
+		t.Fatalf("scan: %v", err)
+	}
+
+	// Both nodes serve the tile, only its owner renders and caches it
+	for _, node := range nodes {
+		if status, _, _ := node.get(t, tilePath(id, 0, 0, 0)); status != http.StatusOK {
+			t.Fatalf("tile status %d from %s", status, node.URL)
+		}
+	}
+	var owner, other *testServer
+	for _, node := range nodes {
+		if node.tileDirs(t, id) == 0 {
+			other = node
+		} else if owner == nil {
+			owner = node
+		} else {
+			t.Fatalf("tile cached by both nodes")
+		}
+	}
+	if owner == nil || other == nil {
+		t.Fatalf("tile cached by no node")
+	}
+
+	// A client can't pass for a peer to have the tile rendered again
+	header := http.Header{"X-Gigaview-Peer": {"guessed"}}
+	if status := other.do(t, http.MethodGet, tilePath(id, 0, 0, 0), nil, header); status != http.StatusOK {
+		t.Fatalf("tile status %d with a forged peer header", status)
+	}
+	if dirs := other.tileDirs(t, id); dirs != 0 {
+		t.Fatalf("forged peer header made the other node render the tile")
+	}
+
+	// Without its owner, the other node renders the tile itself
+	owner.Close()
+	if status, _, _ := other.get(t, tilePath(id, 0, 0, 0)); status != http.StatusOK {
+		t.Fatalf("tile status %d with the owner down", status)
+	}
+	if dirs := other.tileDirs(t, id); dirs == 0 {
+		t.Fatalf("tile not rendered locally with the owner down")
+	}
+}